@@ -0,0 +1,57 @@
+package apikey
+
+import (
+	"errors"
+	"time"
+)
+
+// APIKey is a long-lived credential a user creates for scripts and tools.
+// Only the SHA-256 of the key is stored; Key holds the plaintext in memory
+// once, when it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const (
+	ScopeInterviewsRead  = "interviews:read"
+	ScopeInterviewsWrite = "interviews:write"
+	ScopeBillingRead     = "billing:read"
+
+	// KeyPrefix starts every key so it can be told apart from a JWT and
+	// found by secret scanners.
+	KeyPrefix = "ivk_"
+
+	MaxActiveKeys = 20
+
+	// lastUsedResolution limits how often a busy key's last use is written.
+	lastUsedResolution = time.Minute
+)
+
+var Scopes = []string{ScopeInterviewsRead, ScopeInterviewsWrite, ScopeBillingRead}
+
+type APIKeyRepo interface {
+	CreateAPIKey(key *APIKey) error
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+	ListAPIKeys(userID int) ([]APIKey, error)
+	CountActiveAPIKeys(userID int, now time.Time) (int, error)
+	TouchAPIKey(id int, at time.Time) error
+	RevokeAPIKey(userID, id int, at time.Time) error
+}
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid, expired or revoked api key")
+	ErrInvalidScope   = errors.New("unknown api key scope")
+	ErrNameRequired   = errors.New("api key name is required")
+	ErrTooManyKeys    = errors.New("too many active api keys")
+)
@@ -0,0 +1,150 @@
+package apikey
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) CreateAPIKey(key *APIKey) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, key.UserID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.CreatedAt, key.ExpiresAt).Scan(&key.ID)
+	if err != nil {
+		log.Printf("CreateAPIKey failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	key, err := scanAPIKey(r.DB.QueryRow(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		log.Printf("GetAPIKeyByHash failed: %v", err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (r *Repository) ListAPIKeys(userID int) ([]APIKey, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		log.Printf("ListAPIKeys failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *Repository) CountActiveAPIKeys(userID int, now time.Time) (int, error) {
+	var count int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*) FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+	`, userID, now).Scan(&count)
+	if err != nil {
+		log.Printf("CountActiveAPIKeys failed: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Repository) TouchAPIKey(id int, at time.Time) error {
+	_, err := r.DB.Exec(`UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, at, id)
+	if err != nil {
+		log.Printf("TouchAPIKey failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeAPIKey(userID, id int, at time.Time) error {
+	result, err := r.DB.Exec(`
+		UPDATE api_keys
+		SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, at, id, userID)
+	if err != nil {
+		log.Printf("RevokeAPIKey failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var (
+		key    APIKey
+		scopes pq.StringArray
+	)
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = scopes
+	return &key, nil
+}
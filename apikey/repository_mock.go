@@ -0,0 +1,93 @@
+package apikey
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Keys     []APIKey
+	FailRepo bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{}
+}
+
+func (m *MockRepo) CreateAPIKey(key *APIKey) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	key.ID = len(m.Keys) + 1
+	m.Keys = append(m.Keys, *key)
+	return nil
+}
+
+func (m *MockRepo) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	for _, key := range m.Keys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (m *MockRepo) ListAPIKeys(userID int) ([]APIKey, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	keys := []APIKey{}
+	for _, key := range m.Keys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MockRepo) CountActiveAPIKeys(userID int, now time.Time) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+
+	count := 0
+	for _, key := range m.Keys {
+		if key.UserID == userID && key.RevokedAt == nil && (key.ExpiresAt == nil || key.ExpiresAt.After(now)) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockRepo) TouchAPIKey(id int, at time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i := range m.Keys {
+		if m.Keys[i].ID == id {
+			m.Keys[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *MockRepo) RevokeAPIKey(userID, id int, at time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i := range m.Keys {
+		if m.Keys[i].ID == id && m.Keys[i].UserID == userID && m.Keys[i].RevokedAt == nil {
+			m.Keys[i].RevokedAt = &at
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
@@ -0,0 +1,138 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxNameLength = 64
+
+// Create issues a new key for the user. The plaintext is returned in Key
+// and cannot be recovered later.
+func Create(repo APIKeyRepo, userID int, name string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	now := time.Now().UTC()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+	if len([]rune(name)) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	granted := []string{}
+	for _, scope := range Scopes {
+		if slices.Contains(scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	active, err := repo.CountActiveAPIKeys(userID, now)
+	if err != nil {
+		log.Printf("repo.CountActiveAPIKeys failed: %v", err)
+		return nil, err
+	}
+	if active >= MaxActiveKeys {
+		return nil, ErrTooManyKeys
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	plaintext := KeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plaintext[:len(KeyPrefix)+6],
+		KeyHash:   HashKey(plaintext),
+		Scopes:    granted,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := repo.CreateAPIKey(key); err != nil {
+		log.Printf("repo.CreateAPIKey failed: %v", err)
+		return nil, err
+	}
+
+	key.Key = plaintext
+	return key, nil
+}
+
+func List(repo APIKeyRepo, userID int) ([]APIKey, error) {
+	keys, err := repo.ListAPIKeys(userID)
+	if err != nil {
+		log.Printf("repo.ListAPIKeys failed: %v", err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func Revoke(repo APIKeyRepo, userID, id int) error {
+	return repo.RevokeAPIKey(userID, id, time.Now().UTC())
+}
+
+// Authenticate returns the live key matching the presented value and
+// records that it was used.
+func Authenticate(repo APIKeyRepo, presented string) (*APIKey, error) {
+	if !IsAPIKey(presented) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := repo.GetAPIKeyByHash(HashKey(presented))
+	if err == ErrAPIKeyNotFound {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if err := repo.TouchAPIKey(key.ID, now); err != nil {
+			log.Printf("repo.TouchAPIKey failed: %v", err)
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// IsAPIKey reports whether a bearer token looks like one of our keys rather
+// than a JWT.
+func IsAPIKey(bearer string) bool {
+	return strings.HasPrefix(bearer, KeyPrefix)
+}
+
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
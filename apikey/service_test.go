@@ -0,0 +1,188 @@
+package apikey
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		keyName        string
+		scopes         []string
+		expiresAt      *time.Time
+		existing       int
+		expectedErr    error
+		expectedScopes []string
+	}{
+		{
+			name:           "Create_Success",
+			keyName:        " CI ",
+			scopes:         []string{ScopeBillingRead, ScopeInterviewsRead, ScopeInterviewsRead},
+			expectedScopes: []string{ScopeInterviewsRead, ScopeBillingRead},
+		},
+		{
+			name:        "Create_MissingName",
+			scopes:      []string{ScopeInterviewsRead},
+			expectedErr: ErrNameRequired,
+		},
+		{
+			name:        "Create_NoScopes",
+			keyName:     "CI",
+			expectedErr: ErrInvalidScope,
+		},
+		{
+			name:        "Create_UnknownScope",
+			keyName:     "CI",
+			scopes:      []string{ScopeInterviewsRead, "admin"},
+			expectedErr: ErrInvalidScope,
+		},
+		{
+			name:        "Create_ExpiryInPast",
+			keyName:     "CI",
+			scopes:      []string{ScopeInterviewsRead},
+			expiresAt:   &past,
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "Create_TooManyKeys",
+			keyName:     "CI",
+			scopes:      []string{ScopeInterviewsRead},
+			existing:    MaxActiveKeys,
+			expectedErr: ErrTooManyKeys,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			for i := 0; i < tc.existing; i++ {
+				repo.Keys = append(repo.Keys, APIKey{ID: i + 1, UserID: 1})
+			}
+
+			key, err := Create(repo, 1, tc.keyName, tc.scopes, tc.expiresAt)
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil {
+				if key.Name != "CI" || !strings.HasPrefix(key.Key, KeyPrefix) || !strings.HasPrefix(key.Key, key.Prefix) {
+					t.Errorf("unexpected key %+v", key)
+				}
+				if fmt.Sprint(key.Scopes) != fmt.Sprint(tc.expectedScopes) {
+					t.Errorf("expected scopes %v but got %v", tc.expectedScopes, key.Scopes)
+				}
+				if stored := repo.Keys[0]; stored.Key != "" || stored.KeyHash != HashKey(key.Key) {
+					t.Errorf("expected only the hash to be stored, got %+v", stored)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(key *APIKey)
+		presented   func(plaintext string) string
+		expectedErr error
+	}{
+		{
+			name: "Authenticate_Success",
+		},
+		{
+			name:        "Authenticate_Revoked",
+			modify:      func(key *APIKey) { now := time.Now(); key.RevokedAt = &now },
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "Authenticate_Expired",
+			modify:      func(key *APIKey) { past := time.Now().Add(-time.Minute); key.ExpiresAt = &past },
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "Authenticate_UnknownKey",
+			presented:   func(plaintext string) string { return plaintext + "x" },
+			expectedErr: ErrInvalidAPIKey,
+		},
+		{
+			name:        "Authenticate_NotAnAPIKey",
+			presented:   func(plaintext string) string { return strings.TrimPrefix(plaintext, KeyPrefix) },
+			expectedErr: ErrInvalidAPIKey,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			created, err := Create(repo, 1, "CI", []string{ScopeInterviewsRead}, nil)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if tc.modify != nil {
+				tc.modify(&repo.Keys[0])
+			}
+			presented := created.Key
+			if tc.presented != nil {
+				presented = tc.presented(created.Key)
+			}
+
+			key, err := Authenticate(repo, presented)
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil {
+				if key.UserID != 1 || !key.HasScope(ScopeInterviewsRead) || key.HasScope(ScopeBillingRead) {
+					t.Errorf("unexpected key %+v", key)
+				}
+				if repo.Keys[0].LastUsedAt == nil {
+					t.Errorf("expected last use to be recorded")
+				}
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	repo := NewMockRepo()
+	created, _ := Create(repo, 1, "CI", []string{ScopeInterviewsRead}, nil)
+
+	if err := Revoke(repo, 2, created.ID); err != ErrAPIKeyNotFound {
+		t.Fatalf("expected another user's key to be hidden, got %v", err)
+	}
+	if err := Revoke(repo, 1, created.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := Authenticate(repo, created.Key); err != ErrInvalidAPIKey {
+		t.Fatalf("expected a revoked key to be refused, got %v", err)
+	}
+	if keys, _ := List(repo, 1); len(keys) != 0 {
+		t.Fatalf("expected revoked keys to be hidden, got %+v", keys)
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"time"
+)
+
+// Event records one security-relevant action. ActorID is the user who
+// acted and TargetUserID the account acted on; either is 0 when it does not
+// apply.
+type Event struct {
+	ID           int            `json:"id"`
+	ActorID      int            `json:"actor_id,omitempty"`
+	Action       string         `json:"action"`
+	TargetUserID int            `json:"target_user_id,omitempty"`
+	IPAddress    string         `json:"ip_address"`
+	UserAgent    string         `json:"user_agent"`
+	Metadata     map[string]any `json:"metadata"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+const (
+	ActionLoginSucceeded         = "auth.login.succeeded"
+	ActionLoginFailed            = "auth.login.failed"
+	ActionLoginLocked            = "auth.login.locked"
+	ActionTokenRefreshed         = "auth.token.refreshed"
+	ActionTokenReused            = "auth.token.reused"
+	ActionPasswordResetRequested = "auth.password_reset.requested"
+	ActionPasswordResetCompleted = "auth.password_reset.completed"
+
+	ActionAccountDeleted       = "account.deleted"
+	ActionAccountReactivated   = "account.reactivated"
+	ActionAccountPurged        = "account.purged"
+	ActionDataExported         = "account.data.exported"
+	ActionEmailChangeRequested = "account.email.change_requested"
+	ActionEmailChanged         = "account.email.changed"
+	ActionEmailChangeCancelled = "account.email.change_cancelled"
+	ActionMFAEnabled           = "account.mfa.enabled"
+	ActionMFADisabled          = "account.mfa.disabled"
+	ActionPasskeyAdded         = "account.passkey.added"
+	ActionPasskeyRemoved       = "account.passkey.removed"
+	ActionAPIKeyCreated        = "account.api_key.created"
+	ActionAPIKeyRevoked        = "account.api_key.revoked"
+
+	ActionSubscriptionChanged = "billing.subscription.changed"
+	ActionCreditsChanged      = "billing.credits.changed"
+
+	ActionAdminSearchUsers      = "admin.users.search"
+	ActionAdminViewUser         = "admin.user.view"
+	ActionAdminViewInterviews   = "admin.user.interviews.view"
+	ActionAdminViewTransactions = "admin.user.transactions.view"
+	ActionAdminAdjustCredits    = "admin.user.credits.adjust"
+	ActionAdminDeactivateUser   = "admin.user.deactivate"
+	ActionAdminReactivateUser   = "admin.user.reactivate"
+	ActionAdminChangeRole       = "admin.user.role.change"
+	ActionAdminImpersonate      = "admin.user.impersonate"
+	ActionAdminResetMFA         = "admin.user.mfa.reset"
+)
+
+// UserVisiblePrefixes select the events a user sees as their own security
+// activity. Staff actions under admin. are left out.
+var UserVisiblePrefixes = []string{"auth.", "account.", "billing."}
+
+// Origin is the client behind an event recorded outside the handlers.
+type Origin struct {
+	IPAddress string
+	UserAgent string
+}
+
+// Filter narrows ListEvents. Zero fields match everything; ActionPrefixes
+// matches events whose action starts with any of them.
+type Filter struct {
+	ActorID        int
+	TargetUserID   int
+	ActionPrefixes []string
+	Since          *time.Time
+	Until          *time.Time
+	Limit          int
+	Offset         int
+}
+
+// AuditRepo stores events. The audit_events table is append-only: a
+// trigger rejects updates and deletes.
+type AuditRepo interface {
+	CreateEvent(event *Event) error
+	ListEvents(filter Filter) ([]Event, error)
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) CreateEvent(event *Event) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		return err
+	}
+
+	err = r.DB.QueryRow(`
+		INSERT INTO audit_events (actor_id, action, target_user_id, ip_address, user_agent, metadata, created_at)
+		VALUES (NULLIF($1, 0), $2, NULLIF($3, 0), $4, $5, $6, $7)
+		RETURNING id
+	`,
+		event.ActorID,
+		event.Action,
+		event.TargetUserID,
+		event.IPAddress,
+		event.UserAgent,
+		metadata,
+		event.CreatedAt,
+	).Scan(&event.ID)
+	if err != nil {
+		log.Printf("CreateEvent failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListEvents returns matching events, newest first.
+func (r *Repository) ListEvents(filter Filter) ([]Event, error) {
+	patterns := []string{}
+	for _, prefix := range filter.ActionPrefixes {
+		patterns = append(patterns, likeEscaper.Replace(prefix)+"%")
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT id, COALESCE(actor_id, 0), action, COALESCE(target_user_id, 0), ip_address, user_agent, metadata, created_at
+		FROM audit_events
+		WHERE ($1 = 0 OR actor_id = $1)
+		  AND ($2 = 0 OR target_user_id = $2)
+		  AND (cardinality($3::text[]) = 0 OR action LIKE ANY($3))
+		  AND ($4::timestamp IS NULL OR created_at >= $4)
+		  AND ($5::timestamp IS NULL OR created_at < $5)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6 OFFSET $7
+	`,
+		filter.ActorID,
+		filter.TargetUserID,
+		pq.Array(patterns),
+		filter.Since,
+		filter.Until,
+		filter.Limit,
+		filter.Offset,
+	)
+	if err != nil {
+		log.Printf("ListEvents failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var (
+			event    Event
+			metadata []byte
+		)
+		err := rows.Scan(
+			&event.ID,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetUserID,
+			&event.IPAddress,
+			&event.UserAgent,
+			&metadata,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+			log.Printf("json.Unmarshal failed: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
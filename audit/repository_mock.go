@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+)
+
+type MockRepo struct {
+	Events   []Event
+	FailRepo bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{}
+}
+
+func (m *MockRepo) CreateEvent(event *Event) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	event.ID = len(m.Events) + 1
+	m.Events = append(m.Events, *event)
+	return nil
+}
+
+func (m *MockRepo) ListEvents(filter Filter) ([]Event, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	events := []Event{}
+	for i := len(m.Events) - 1; i >= 0; i-- {
+		event := m.Events[i]
+		if filter.ActorID != 0 && event.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.TargetUserID != 0 && event.TargetUserID != filter.TargetUserID {
+			continue
+		}
+		if len(filter.ActionPrefixes) > 0 && !hasAnyPrefix(event.Action, filter.ActionPrefixes) {
+			continue
+		}
+		if filter.Since != nil && event.CreatedAt.Before(*filter.Since) {
+			continue
+		}
+		if filter.Until != nil && !event.CreatedAt.Before(*filter.Until) {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if filter.Offset >= len(events) {
+		return []Event{}, nil
+	}
+	events = events[filter.Offset:]
+	if len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+func hasAnyPrefix(action string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(action, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"log"
+	"time"
+)
+
+// Record stores an event, stamping it with the current time. Failures are
+// logged and returned so callers can decide whether the action may go ahead
+// unrecorded.
+func Record(repo AuditRepo, event Event) error {
+	if event.Metadata == nil {
+		event.Metadata = map[string]any{}
+	}
+	event.CreatedAt = time.Now().UTC()
+
+	if err := repo.CreateEvent(&event); err != nil {
+		log.Printf("audit event %s by user %d on user %d not recorded: %v", event.Action, event.ActorID, event.TargetUserID, err)
+		return err
+	}
+
+	return nil
+}
+
+func List(repo AuditRepo, filter Filter) ([]Event, error) {
+	events, err := repo.ListEvents(filter)
+	if err != nil {
+		log.Printf("repo.ListEvents failed: %v", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// RecentActivity lists the user's own security events, newest first.
+func RecentActivity(repo AuditRepo, userID, limit, offset int) ([]Event, error) {
+	return List(repo, Filter{
+		TargetUserID:   userID,
+		ActionPrefixes: UserVisiblePrefixes,
+		Limit:          limit,
+		Offset:         offset,
+	})
+}
+
+// RecordUserAction records something a user did to their own account, for
+// packages that have no request at hand. Failures are logged by Record and
+// otherwise ignored, so auditing never blocks the action.
+func RecordUserAction(repo AuditRepo, origin Origin, action string, userID int, metadata map[string]any) {
+	_ = Record(repo, Event{
+		ActorID:      userID,
+		Action:       action,
+		TargetUserID: userID,
+		IPAddress:    origin.IPAddress,
+		UserAgent:    origin.UserAgent,
+		Metadata:     metadata,
+	})
+}
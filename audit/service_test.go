@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name           string
+		event          Event
+		failRepo       bool
+		expectError    bool
+		expectedEvents []Event
+	}{
+		{
+			name: "Record_Success",
+			event: Event{
+				ActorID:      1,
+				Action:       ActionAdminAdjustCredits,
+				TargetUserID: 2,
+				IPAddress:    "203.0.113.7",
+				Metadata:     map[string]any{"amount": 5},
+			},
+			expectedEvents: []Event{{
+				ID:           1,
+				ActorID:      1,
+				Action:       ActionAdminAdjustCredits,
+				TargetUserID: 2,
+				IPAddress:    "203.0.113.7",
+				Metadata:     map[string]any{"amount": 5},
+			}},
+		},
+		{
+			name:  "Record_DefaultsMetadata",
+			event: Event{ActorID: 1, Action: ActionAdminViewUser, TargetUserID: 2},
+			expectedEvents: []Event{{
+				ID:           1,
+				ActorID:      1,
+				Action:       ActionAdminViewUser,
+				TargetUserID: 2,
+				Metadata:     map[string]any{},
+			}},
+		},
+		{
+			name:        "Record_RepoError",
+			event:       Event{ActorID: 1, Action: ActionAdminViewUser, TargetUserID: 2},
+			failRepo:    true,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.FailRepo = tc.failRepo
+
+			err := Record(repo, tc.event)
+			if tc.expectError && err == nil {
+				t.Fatalf("expected error but got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+			if tc.expectError {
+				return
+			}
+
+			if diff := cmp.Diff(tc.expectedEvents, repo.Events, cmpopts.IgnoreFields(Event{}, "CreatedAt")); diff != "" {
+				t.Errorf("Events mismatch (-want +got):\n%s", diff)
+			}
+			if repo.Events[0].CreatedAt.IsZero() {
+				t.Errorf("expected CreatedAt to be set")
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	now := time.Now().UTC()
+	hourAgo := now.Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		filter      Filter
+		recent      int
+		expectedIDs []int
+	}{
+		{
+			name:        "List_ByTarget",
+			filter:      Filter{TargetUserID: 2, Limit: 50},
+			expectedIDs: []int{5, 4, 2, 1},
+		},
+		{
+			name:        "List_ByActionPrefix",
+			filter:      Filter{ActionPrefixes: []string{"auth.login"}, Limit: 50},
+			expectedIDs: []int{3, 2, 1},
+		},
+		{
+			name:        "List_ByActorAndTime",
+			filter:      Filter{ActorID: 1, Since: &hourAgo, Limit: 50},
+			expectedIDs: []int{5},
+		},
+		{
+			name:        "List_Paginated",
+			filter:      Filter{Limit: 2, Offset: 1},
+			expectedIDs: []int{4, 3},
+		},
+		{
+			name:        "RecentActivity_HidesStaffActions",
+			recent:      2,
+			expectedIDs: []int{4, 2, 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Events = []Event{
+				{ID: 1, ActorID: 2, Action: ActionLoginFailed, TargetUserID: 2, CreatedAt: now.Add(-3 * time.Hour)},
+				{ID: 2, ActorID: 2, Action: ActionLoginSucceeded, TargetUserID: 2, CreatedAt: now.Add(-2 * time.Hour)},
+				{ID: 3, ActorID: 3, Action: ActionLoginSucceeded, TargetUserID: 3, CreatedAt: now.Add(-2 * time.Hour)},
+				{ID: 4, Action: ActionSubscriptionChanged, TargetUserID: 2, CreatedAt: now.Add(-90 * time.Minute)},
+				{ID: 5, ActorID: 1, Action: ActionAdminViewUser, TargetUserID: 2, CreatedAt: now},
+			}
+
+			var (
+				events []Event
+				err    error
+			)
+			if tc.recent != 0 {
+				events, err = RecentActivity(repo, tc.recent, 50, 0)
+			} else {
+				events, err = List(repo, tc.filter)
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			ids := []int{}
+			for _, event := range events {
+				ids = append(ids, event.ID)
+			}
+			if diff := cmp.Diff(tc.expectedIDs, ids); diff != "" {
+				t.Errorf("IDs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
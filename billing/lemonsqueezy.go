@@ -0,0 +1,381 @@
+package billing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+type LemonSqueezy struct {
+	APIKey        string
+	StoreID       string
+	WebhookSecret string
+	BaseURL       string
+	Client        *http.Client
+}
+
+type CheckoutPayload struct {
+	Data CheckoutData `json:"data"`
+}
+
+type CheckoutData struct {
+	Type          string                `json:"type"`
+	Attributes    CheckoutAttributes    `json:"attributes"`
+	Relationships CheckoutRelationships `json:"relationships"`
+}
+
+type CheckoutAttributes struct {
+	CheckoutData CheckoutCustomerInfo `json:"checkout_data"`
+}
+
+type CheckoutCustomerInfo struct {
+	Email string `json:"email"`
+}
+
+type CheckoutRelationships struct {
+	Store   Relationship `json:"store"`
+	Variant Relationship `json:"variant"`
+}
+
+type Relationship struct {
+	Data RelationshipData `json:"data"`
+}
+
+type RelationshipData struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type CheckoutResponse struct {
+	Data struct {
+		Attributes struct {
+			URL string `json:"url"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type BillingWebhookPayload struct {
+	Meta struct {
+		EventName string `json:"event_name"`
+		WebhookID string `json:"webhook_id"`
+	} `json:"meta"`
+
+	Data struct {
+		SubscriptionID string          `json:"id"`
+		Attributes     json.RawMessage `json:"attributes"`
+	} `json:"data"`
+}
+
+type OrderAttributes struct {
+	UserEmail      string `json:"user_email"`
+	OrderNumber    int    `json:"order_number"`
+	Currency       string `json:"currency"`
+	Subtotal       int    `json:"subtotal"`
+	Tax            int    `json:"tax"`
+	TaxName        string `json:"tax_name"`
+	Total          int    `json:"total"`
+	RefundedAmount int    `json:"refunded_amount"`
+	FirstOrderItem struct {
+		VariantID   int    `json:"variant_id"`
+		ProductName string `json:"product_name"`
+		VariantName string `json:"variant_name"`
+		Price       int    `json:"price"`
+		Quantity    int    `json:"quantity"`
+	} `json:"first_order_item"`
+}
+
+type SubscriptionAttributes struct {
+	UserEmail string    `json:"user_email"`
+	Status    string    `json:"status"`
+	StartsAt  time.Time `json:"created_at"`
+	EndsAt    time.Time `json:"renews_at"`
+	VariantID int       `json:"variant_id"`
+}
+
+type SubscriptionRenewAttributes struct {
+	UserEmail     string `json:"user_email"`
+	Currency      string `json:"currency"`
+	Subtotal      int    `json:"subtotal"`
+	Tax           int    `json:"tax"`
+	Total         int    `json:"total"`
+	BillingReason string `json:"billing_reason"`
+}
+
+func NewLemonSqueezy() *LemonSqueezy {
+	return &LemonSqueezy{
+		APIKey:        os.Getenv("LEMON_API_KEY"),
+		StoreID:       os.Getenv("LEMON_STORE_ID"),
+		WebhookSecret: os.Getenv("LEMON_WEBHOOK_SECRET"),
+		BaseURL:       "https://api.lemonsqueezy.com/v1",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (l *LemonSqueezy) Name() string {
+	return "lemonsqueezy"
+}
+
+func (l *LemonSqueezy) CreateCheckout(checkout CheckoutRequest) (string, error) {
+	payload := CheckoutPayload{
+		Data: CheckoutData{
+			Type: "checkouts",
+			Attributes: CheckoutAttributes{
+				CheckoutData: CheckoutCustomerInfo{
+					Email: checkout.Email,
+				},
+			},
+			Relationships: CheckoutRelationships{
+				Store: Relationship{
+					Data: RelationshipData{
+						Type: "stores",
+						ID:   l.StoreID,
+					},
+				},
+				Variant: Relationship{
+					Data: RelationshipData{
+						Type: "variants",
+						ID:   checkout.VariantID,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", l.BaseURL+"/checkouts", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+l.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/vnd.api+json")
+
+	res, err := l.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return "", fmt.Errorf("lemonSqueezy API error: %s: %s", res.Status, string(bodyBytes))
+	}
+
+	var result CheckoutResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.Data.Attributes.URL, nil
+}
+
+func (l *LemonSqueezy) CancelSubscription(subscriptionID string) error {
+	req, err := http.NewRequest("DELETE", l.BaseURL+"/subscriptions/"+subscriptionID, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+l.APIKey)
+	req.Header.Set("Accept", "application/vnd.api+json")
+
+	res, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("cancel failed: %s", string(bodyBytes))
+	}
+
+	return nil
+}
+
+func (l *LemonSqueezy) ResumeSubscription(subscriptionID string) error {
+	return l.patchSubscription(subscriptionID, map[string]interface{}{
+		"cancelled": false,
+	})
+}
+
+func (l *LemonSqueezy) ChangePlan(subscriptionID, variantID string) error {
+	variant, err := strconv.Atoi(variantID)
+	if err != nil {
+		return fmt.Errorf("invalid lemon squeezy variant ID %q: %w", variantID, err)
+	}
+
+	return l.patchSubscription(subscriptionID, map[string]interface{}{
+		"variant_id": variant,
+	})
+}
+
+func (l *LemonSqueezy) patchSubscription(subscriptionID string, attributes map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "subscriptions",
+			"id":         subscriptionID,
+			"attributes": attributes,
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest("PATCH", l.BaseURL+"/subscriptions/"+subscriptionID, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+l.APIKey)
+	req.Header.Set("Accept", "application/vnd.api+json")
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+
+	res, err := l.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("patch failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("lemon error: %s", string(bodyBytes))
+	}
+
+	return nil
+}
+
+func (l *LemonSqueezy) VerifySignature(signature string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(l.WebhookSecret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (l *LemonSqueezy) VerifyWebhook(header http.Header, body []byte) (string, string, error) {
+	if !l.VerifySignature(header.Get("X-Signature"), body) {
+		return "", "", ErrInvalidSignature
+	}
+
+	var payload BillingWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", nil
+	}
+
+	return payload.Meta.WebhookID, payload.Meta.EventName, nil
+}
+
+func (l *LemonSqueezy) ParseEvent(body []byte) (*Event, error) {
+	var payload BillingWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	event := &Event{
+		ID:             payload.Meta.WebhookID,
+		Type:           payload.Meta.EventName,
+		SubscriptionID: payload.Data.SubscriptionID,
+	}
+	attributes := payload.Data.Attributes
+
+	switch event.Type {
+	case EventOrderCreated, EventOrderRefunded:
+		var orderAttrs OrderAttributes
+		if err := json.Unmarshal(attributes, &orderAttrs); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, event.Type, err)
+		}
+		event.UserEmail = orderAttrs.UserEmail
+		event.VariantID = strconv.Itoa(orderAttrs.FirstOrderItem.VariantID)
+		event.Receipt = orderReceipt(event.Type, orderAttrs)
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionPlanChanged:
+		var subAttrs SubscriptionAttributes
+		if err := json.Unmarshal(attributes, &subAttrs); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, event.Type, err)
+		}
+		event.UserEmail = subAttrs.UserEmail
+		event.Status = subAttrs.Status
+		event.StartsAt = subAttrs.StartsAt
+		event.EndsAt = subAttrs.EndsAt
+		event.VariantID = strconv.Itoa(subAttrs.VariantID)
+	case EventPaymentSucceeded:
+		var renewAttrs SubscriptionRenewAttributes
+		if err := json.Unmarshal(attributes, &renewAttrs); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, event.Type, err)
+		}
+		event.UserEmail = renewAttrs.UserEmail
+		event.Total = renewAttrs.Total
+		event.BillingReason = renewAttrs.BillingReason
+		// The first payment of a subscription is already receipted by its
+		// order_created event.
+		if event.BillingReason != BillingReasonInitial {
+			event.Receipt = &Receipt{
+				Kind:      InvoiceRenewal,
+				Reference: payload.Data.SubscriptionID,
+				Currency:  renewAttrs.Currency,
+				Subtotal:  renewAttrs.Subtotal,
+				Tax:       renewAttrs.Tax,
+				Total:     renewAttrs.Total,
+				LineItems: []LineItem{{Description: "Subscription renewal", Quantity: 1, Amount: renewAttrs.Subtotal}},
+			}
+		}
+	case EventSubscriptionCancelled, EventSubscriptionResumed, EventSubscriptionExpired,
+		EventPaymentFailed, EventPaymentRecovered:
+		var emailAttribute struct {
+			UserEmail string `json:"user_email"`
+		}
+		if err := json.Unmarshal(attributes, &emailAttribute); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, event.Type, err)
+		}
+		event.UserEmail = emailAttribute.UserEmail
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnhandledEvent, event.Type)
+	}
+
+	return event, nil
+}
+
+func orderReceipt(eventType string, order OrderAttributes) *Receipt {
+	item := order.FirstOrderItem
+	description := item.ProductName
+	if item.VariantName != "" && item.VariantName != "Default" {
+		description += " - " + item.VariantName
+	}
+	quantity := item.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+
+	receipt := &Receipt{
+		Kind:      InvoiceOrder,
+		Reference: fmt.Sprintf("#%d", order.OrderNumber),
+		Currency:  order.Currency,
+		Subtotal:  order.Subtotal,
+		Tax:       order.Tax,
+		TaxName:   order.TaxName,
+		Total:     order.Total,
+		LineItems: []LineItem{{Description: description, Quantity: quantity, Amount: order.Subtotal}},
+	}
+	if eventType == EventOrderRefunded {
+		receipt.Kind = InvoiceRefund
+		receipt.Subtotal = order.RefundedAmount
+		receipt.Tax = 0
+		receipt.TaxName = ""
+		receipt.Total = order.RefundedAmount
+		receipt.LineItems = []LineItem{{Description: "Refund: " + description, Quantity: 1, Amount: order.RefundedAmount}}
+	}
+
+	return receipt
+}
@@ -1,125 +1,318 @@
 package billing
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/michaelboegner/interviewer/ledger"
 )
 
 type Billing struct {
-	APIKey              string
-	VariantIDIndividual int
-	VariantIDPro        int
-	VariantIDPremium    int
-	Logger              *slog.Logger
-}
-type CheckoutPayload struct {
-	Data CheckoutData `json:"data"`
+	Provider PaymentProvider
+	Plans    *PlanCatalog
+	Logger   *slog.Logger
 }
 
-type CheckoutData struct {
-	Type          string                `json:"type"`
-	Attributes    CheckoutAttributes    `json:"attributes"`
-	Relationships CheckoutRelationships `json:"relationships"`
+// PaymentProvider is implemented by each payment backend. Webhooks are
+// verified on receipt and normalized into Events when processed so the credit
+// logic stays provider agnostic.
+type PaymentProvider interface {
+	Name() string
+	CreateCheckout(req CheckoutRequest) (string, error)
+	CancelSubscription(subscriptionID string) error
+	ResumeSubscription(subscriptionID string) error
+	ChangePlan(subscriptionID, variantID string) error
+	// VerifyWebhook checks the signature and returns the event's ID and raw
+	// type, which are empty when the body is not a recognisable envelope.
+	VerifyWebhook(header http.Header, body []byte) (eventID, eventType string, err error)
+	// ParseEvent normalizes a verified webhook body.
+	ParseEvent(body []byte) (*Event, error)
 }
 
-type CheckoutAttributes struct {
-	CheckoutData CheckoutCustomerInfo `json:"checkout_data"`
+type CheckoutRequest struct {
+	Email     string
+	VariantID string
+	Recurring bool
 }
 
-type CheckoutCustomerInfo struct {
-	Email string `json:"email"`
-}
+const (
+	EventOrderCreated            = "order_created"
+	EventOrderRefunded           = "order_refunded"
+	EventSubscriptionCreated     = "subscription_created"
+	EventSubscriptionUpdated     = "subscription_updated"
+	EventSubscriptionCancelled   = "subscription_cancelled"
+	EventSubscriptionResumed     = "subscription_resumed"
+	EventSubscriptionExpired     = "subscription_expired"
+	EventSubscriptionPlanChanged = "subscription_plan_changed"
+	EventPaymentSucceeded        = "subscription_payment_success"
+	EventPaymentFailed           = "subscription_payment_failed"
+	EventPaymentRecovered        = "subscription_payment_recovered"
+)
+
+// Billing reasons of a subscription payment, as Lemon Squeezy names them.
+// Only renewals grant a new period's credits; the initial payment is granted
+// by its order and a mid-period plan change by the plan change itself.
+const (
+	BillingReasonInitial = "initial"
+	BillingReasonRenewal = "renewal"
+	BillingReasonUpdated = "updated"
+)
+
+// SubscriptionExpiryGrace is how long subscription credits stay usable after
+// the subscription itself expires.
+const SubscriptionExpiryGrace = 7 * 24 * time.Hour
 
-type CheckoutRelationships struct {
-	Store   Relationship `json:"store"`
-	Variant Relationship `json:"variant"`
+// Event is a provider webhook normalized into the internal vocabulary.
+type Event struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	UserEmail      string    `json:"user_email"`
+	SubscriptionID string    `json:"subscription_id,omitempty"`
+	VariantID      string    `json:"variant_id,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	StartsAt       time.Time `json:"starts_at,omitempty"`
+	EndsAt         time.Time `json:"ends_at,omitempty"`
+	BillingReason  string    `json:"billing_reason,omitempty"`
+	Total          int       `json:"total,omitempty"`
+
+	// Receipt is set by the provider when the event moved money and should
+	// appear in the user's billing history.
+	Receipt *Receipt `json:"receipt,omitempty"`
 }
 
-type Relationship struct {
-	Data RelationshipData `json:"data"`
+const (
+	InvoiceOrder   = "order"
+	InvoiceRenewal = "renewal"
+	InvoiceRefund  = "refund"
+)
+
+// Receipt carries the amounts from a provider payload, in the smallest
+// currency unit.
+type Receipt struct {
+	Kind      string     `json:"kind"`
+	Reference string     `json:"reference"`
+	Currency  string     `json:"currency"`
+	Subtotal  int        `json:"subtotal"`
+	Tax       int        `json:"tax"`
+	TaxName   string     `json:"tax_name,omitempty"`
+	Total     int        `json:"total"`
+	LineItems []LineItem `json:"line_items"`
 }
 
-type RelationshipData struct {
-	Type string `json:"type"`
-	ID   string `json:"id"`
+type LineItem struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	Amount      int    `json:"amount"`
 }
 
-type CheckoutResponse struct {
-	Data struct {
-		Attributes struct {
-			URL string `json:"url"`
-		} `json:"attributes"`
-	} `json:"data"`
+// Invoice is a receipt recorded against a user once its webhook event has
+// been applied.
+type Invoice struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	EventID   string    `json:"event_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Receipt
 }
 
-type BillingWebhookPayload struct {
-	Meta struct {
-		EventName string `json:"event_name"`
-		WebhookID string `json:"webhook_id"`
-	} `json:"meta"`
+const (
+	HistoryPayment = "payment"
+	HistoryCredit  = "credit"
+)
 
-	Data struct {
-		SubscriptionID string          `json:"id"`
-		Attributes     json.RawMessage `json:"attributes"`
-	} `json:"data"`
+// HistoryItem is one row of a user's billing history: either a payment from
+// the invoices table or a credit movement from credit_transactions.
+type HistoryItem struct {
+	Type        string    `json:"type"`
+	ID          int       `json:"id"`
+	Kind        string    `json:"kind,omitempty"`
+	Description string    `json:"description"`
+	Credits     int       `json:"credits,omitempty"`
+	CreditType  string    `json:"credit_type,omitempty"`
+	Currency    string    `json:"currency,omitempty"`
+	Total       int       `json:"total,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
-type OrderAttributes struct {
-	UserEmail      string `json:"user_email"`
-	FirstOrderItem struct {
-		VariantID int `json:"variant_id"`
-	} `json:"first_order_item"`
+const (
+	WebhookPending    = "pending"
+	WebhookProcessing = "processing"
+	WebhookProcessed  = "processed"
+	WebhookFailed     = "failed"
+	WebhookDead       = "dead"
+)
+
+// MaxWebhookAttempts is how many times the processor tries an event before
+// moving it to the dead-letter state.
+const MaxWebhookAttempts = 5
+
+// WebhookEvent is a verified webhook persisted before processing. Payload is
+// the raw provider body kept for inspection; Event is what gets replayed, so
+// replays do not depend on signature timestamps still being fresh.
+type WebhookEvent struct {
+	ID            int        `json:"id"`
+	Provider      string     `json:"provider"`
+	EventID       string     `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Payload       string     `json:"payload,omitempty"`
+	Event         Event      `json:"event"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	ReceivedAt    time.Time  `json:"received_at"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
-type SubscriptionAttributes struct {
-	UserEmail string    `json:"user_email"`
-	Status    string    `json:"status"`
-	StartsAt  time.Time `json:"created_at"`
-	EndsAt    time.Time `json:"renews_at"`
-	VariantID int       `json:"variant_id"`
+// Plan maps a provider variant to the credits it grants. Inactive plans can
+// no longer be purchased but are still honoured for existing subscribers.
+type Plan struct {
+	ID          int       `json:"id"`
+	Provider    string    `json:"provider"`
+	Name        string    `json:"name"`
+	VariantID   string    `json:"variant_id"`
+	Credits     int       `json:"credits"`
+	CreditType  string    `json:"credit_type"`
+	PriceCents  int       `json:"price_cents"`
+	Rollover    bool      `json:"rollover"`
+	RolloverCap int       `json:"rollover_cap"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// CreditValidityDays is how long credits granted by this plan last
+	// before the expiry job forfeits them. Zero means they never expire.
+	CreditValidityDays int `json:"credit_validity_days"`
 }
 
-type SubscriptionRenewAttributes struct {
-	UserEmail     string `json:"user_email"`
-	Total         int    `json:"total"`
-	BillingReason string `json:"billing_reason"`
+type PlanCatalog struct {
+	mu    sync.RWMutex
+	plans []Plan
 }
 
+// CreditTransaction is a credit posting. Key, when set, makes it idempotent;
+// webhook-driven postings use the provider event ID so a retried or replayed
+// event cannot grant credits twice. Invoice, when set, is recorded in the
+// same database transaction as the posting. Clamp lets a deduction take only
+// what is left of the balance instead of failing with ErrInsufficientCredits.
 type CreditTransaction struct {
-	UserID     int
-	Amount     int
-	CreditType string
-	Reason     string
+	UserID       int
+	Amount       int
+	CreditType   string
+	Reason       string
+	Counterparty string
+	ExpiresAt    *time.Time
+	Key          string
+	Invoice      *Invoice
+	Clamp        bool
+}
+
+type CreditReservation struct {
+	ID          int
+	UserID      int
+	InterviewID int
+	CreditType  string
+	Amount      int
+	LotID       int
+	Status      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 type BillingRepo interface {
-	LogCreditTransaction(tx CreditTransaction) error
-	HasWebhookBeenProcessed(id string) (bool, error)
-	MarkWebhookProcessed(id string, event string) error
+	// ApplyCreditTransaction posts tx and returns the amount applied, which
+	// is less than asked only for a clamped deduction.
+	ApplyCreditTransaction(tx CreditTransaction) (int, error)
+	ReserveCredit(userID int, creditType string) (int, error)
+	CommitReservation(reservationID, interviewID int) error
+	ReleaseReservation(reservationID int, reason string) error
+	RefundInterviewReservation(interviewID int, reason string) error
+	// ListStaleReservations returns the IDs of reservations still pending
+	// since before, oldest first.
+	ListStaleReservations(before time.Time, limit int) ([]int, error)
+	ExpireCreditsAt(userID int, creditType string, at time.Time) error
+	StoreWebhookEvent(event *WebhookEvent) (bool, error)
+	ClaimWebhookEvents(limit int) ([]WebhookEvent, error)
+	CompleteWebhookEvent(id int) error
+	FailWebhookEvent(id int, status, lastError string, nextAttemptAt time.Time) error
+	GetWebhookEvent(id int) (*WebhookEvent, error)
+	ListWebhookEvents(status string, limit, offset int) ([]WebhookEvent, error)
+	ReplayWebhookEvent(id int) error
+	ListPlans(provider string) ([]Plan, error)
+	GetPlan(id int) (*Plan, error)
+	CreatePlan(plan *Plan) (int, error)
+	UpdatePlan(plan *Plan) error
+	RecordInvoice(invoice *Invoice) (bool, error)
+	GetInvoice(id int) (*Invoice, error)
+	ListBillingHistory(userID, limit, offset int) ([]HistoryItem, error)
 }
 
-func NewBilling(logger *slog.Logger) (*Billing, error) {
-	individualID, err := strconv.Atoi(os.Getenv("LEMON_VARIANT_ID_INDIVIDUAL"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid INDIVIDUAL ID: %w", err)
+var (
+	ErrInsufficientCredits = ledger.ErrInsufficientCredits
+	ErrReservationNotFound = errors.New("credit reservation not found")
+	ErrInvalidSignature    = errors.New("invalid webhook signature")
+	ErrInvalidPayload      = errors.New("invalid webhook payload")
+	ErrUnhandledEvent      = errors.New("unhandled webhook event")
+	ErrUnknownTier         = errors.New("unknown tier")
+	ErrUnknownVariant      = errors.New("unknown variant ID")
+	ErrPlanNotFound        = errors.New("plan not found")
+	ErrInvalidPlan         = errors.New("invalid plan")
+	ErrWebhookNotFound     = errors.New("webhook event not found")
+	ErrInvoiceNotFound     = errors.New("invoice not found")
+)
+
+// NewBilling selects the payment provider from BILLING_PROVIDER and loads its
+// plan catalog. When the provider has no plans yet the catalog is seeded from
+// the legacy per-tier variant environment variables.
+func NewBilling(logger *slog.Logger, billingRepo BillingRepo) (*Billing, error) {
+	var (
+		provider PaymentProvider
+		prefix   string
+	)
+	switch os.Getenv("BILLING_PROVIDER") {
+	case "", "lemonsqueezy":
+		provider = NewLemonSqueezy()
+		prefix = "LEMON_VARIANT_ID_"
+	case "stripe":
+		provider = NewStripe()
+		prefix = "STRIPE_PRICE_ID_"
+	default:
+		return nil, fmt.Errorf("unknown BILLING_PROVIDER: %s", os.Getenv("BILLING_PROVIDER"))
 	}
-	proID, err := strconv.Atoi(os.Getenv("LEMON_VARIANT_ID_PRO"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid PRO ID: %w", err)
+
+	b := &Billing{
+		Provider: provider,
+		Plans:    &PlanCatalog{},
+		Logger:   logger,
+	}
+	if err := b.LoadPlans(billingRepo); err != nil {
+		return nil, err
+	}
+	if len(b.Plans.All()) > 0 {
+		return b, nil
 	}
-	premiumID, err := strconv.Atoi(os.Getenv("LEMON_VARIANT_ID_PREMIUM"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid PREMIUM ID: %w", err)
+
+	for _, plan := range defaultPlans(provider.Name()) {
+		plan.VariantID = os.Getenv(prefix + strings.ToUpper(plan.Name))
+		if plan.VariantID == "" {
+			return nil, fmt.Errorf("no plans configured and missing %s%s", prefix, strings.ToUpper(plan.Name))
+		}
+		if _, err := billingRepo.CreatePlan(&plan); err != nil {
+			logger.Error("billingRepo.CreatePlan failed", "plan", plan.Name, "error", err)
+			return nil, err
+		}
+	}
+	if err := b.LoadPlans(billingRepo); err != nil {
+		return nil, err
 	}
-	return &Billing{
-		APIKey:              os.Getenv("LEMON_API_KEY"),
-		VariantIDIndividual: individualID,
-		VariantIDPro:        proID,
-		VariantIDPremium:    premiumID,
-		Logger:              logger,
-	}, nil
+
+	return b, nil
 }
@@ -0,0 +1,194 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultPlans mirrors the tiers that were hardcoded before plans moved into
+// the database. Variant IDs are filled in from the environment when seeding.
+func defaultPlans(provider string) []Plan {
+	return []Plan{
+		{Provider: provider, Name: "individual", Credits: 1, CreditType: "individual", Rollover: true, CreditValidityDays: 365, Active: true},
+		{Provider: provider, Name: "pro", Credits: 10, CreditType: "subscription", PriceCents: 1999, Rollover: true, RolloverCap: 10, CreditValidityDays: 90, Active: true},
+		{Provider: provider, Name: "premium", Credits: 20, CreditType: "subscription", PriceCents: 2999, Rollover: true, RolloverCap: 20, CreditValidityDays: 90, Active: true},
+	}
+}
+
+func (c *PlanCatalog) Set(plans []Plan) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.plans = plans
+}
+
+func (c *PlanCatalog) All() []Plan {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	plans := make([]Plan, len(c.plans))
+	copy(plans, c.plans)
+	return plans
+}
+
+// ByName returns the plan with the given name, including inactive plans.
+func (c *PlanCatalog) ByName(name string) (*Plan, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, plan := range c.plans {
+		if plan.Name == name {
+			p := plan
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+// ByVariantID returns the plan sold under the given provider variant,
+// including inactive plans so webhooks for legacy purchases still resolve.
+func (c *PlanCatalog) ByVariantID(variantID string) (*Plan, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, plan := range c.plans {
+		if plan.VariantID == variantID {
+			p := plan
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+func (b *Billing) LoadPlans(billingRepo BillingRepo) error {
+	plans, err := billingRepo.ListPlans(b.Provider.Name())
+	if err != nil {
+		b.Logger.Error("billingRepo.ListPlans failed", "error", err)
+		return err
+	}
+	b.Plans.Set(plans)
+
+	return nil
+}
+
+// PlanRefreshInterval is how often each instance reloads the plan catalog,
+// so an admin edit served by one instance reaches the others.
+const PlanRefreshInterval = time.Minute
+
+// RefreshPlans reloads the plan catalog every interval until ctx is done. A
+// failed reload keeps the plans already loaded.
+func (b *Billing) RefreshPlans(ctx context.Context, billingRepo BillingRepo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := b.LoadPlans(billingRepo); err != nil {
+			b.Logger.Error("Billing.RefreshPlans failed", "error", err)
+		}
+	}
+}
+
+// PlanForTier returns the active plan a user can check out under tier.
+func (b *Billing) PlanForTier(tier string) (*Plan, error) {
+	plan, ok := b.Plans.ByName(tier)
+	if !ok || !plan.Active {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTier, tier)
+	}
+	return plan, nil
+}
+
+func (b *Billing) planForVariant(variantID string) (*Plan, error) {
+	plan, ok := b.Plans.ByVariantID(variantID)
+	if !ok {
+		b.Logger.Error("unknown variantID", "variantID", variantID)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownVariant, variantID)
+	}
+	return plan, nil
+}
+
+func (b *Billing) CreatePlan(billingRepo BillingRepo, plan *Plan) (*Plan, error) {
+	plan.Provider = b.Provider.Name()
+	plan.Name = strings.ToLower(strings.TrimSpace(plan.Name))
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	id, err := billingRepo.CreatePlan(plan)
+	if err != nil {
+		b.Logger.Error("billingRepo.CreatePlan failed", "error", err)
+		return nil, err
+	}
+	plan.ID = id
+
+	if err := b.LoadPlans(billingRepo); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// UpdatePlan applies an admin edit. The plan name is immutable because it is
+// stored as the subscription tier on existing users.
+func (b *Billing) UpdatePlan(billingRepo BillingRepo, id int, update *Plan) (*Plan, error) {
+	plan, err := billingRepo.GetPlan(id)
+	if err != nil {
+		b.Logger.Error("billingRepo.GetPlan failed", "error", err)
+		return nil, err
+	}
+	if plan.Provider != b.Provider.Name() {
+		return nil, ErrPlanNotFound
+	}
+
+	plan.VariantID = update.VariantID
+	plan.Credits = update.Credits
+	plan.CreditType = update.CreditType
+	plan.PriceCents = update.PriceCents
+	plan.Rollover = update.Rollover
+	plan.RolloverCap = update.RolloverCap
+	plan.CreditValidityDays = update.CreditValidityDays
+	plan.Active = update.Active
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	if err := billingRepo.UpdatePlan(plan); err != nil {
+		b.Logger.Error("billingRepo.UpdatePlan failed", "error", err)
+		return nil, err
+	}
+
+	if err := b.LoadPlans(billingRepo); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func validatePlan(plan *Plan) error {
+	switch {
+	case plan.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidPlan)
+	case plan.VariantID == "":
+		return fmt.Errorf("%w: variant_id is required", ErrInvalidPlan)
+	case plan.Credits <= 0:
+		return fmt.Errorf("%w: credits must be positive", ErrInvalidPlan)
+	case plan.CreditType != "individual" && plan.CreditType != "subscription":
+		return fmt.Errorf("%w: credit_type must be individual or subscription", ErrInvalidPlan)
+	case plan.PriceCents < 0 || plan.RolloverCap < 0 || plan.CreditValidityDays < 0:
+		return fmt.Errorf("%w: price_cents, rollover_cap and credit_validity_days cannot be negative", ErrInvalidPlan)
+	}
+	return nil
+}
+
+// creditExpiry returns when credits granted now under the plan lapse, or nil
+// when they never do.
+func (p *Plan) creditExpiry(now time.Time) *time.Time {
+	if p.CreditValidityDays <= 0 {
+		return nil
+	}
+	expiresAt := now.AddDate(0, 0, p.CreditValidityDays)
+	return &expiresAt
+}
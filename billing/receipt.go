@@ -0,0 +1,213 @@
+package billing
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+const receiptIssuer = "Interviewer"
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"amount": formatAmount,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Invoice.Reference}}</title>
+<style>
+	body { font-family: sans-serif; color: #222; max-width: 640px; margin: 40px auto; }
+	table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+	th, td { padding: 8px 0; text-align: left; border-bottom: 1px solid #eee; }
+	.num { text-align: right; }
+	.totals td { border-bottom: none; }
+	.muted { color: gray; font-size: 14px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">
+	{{.Issuer}}<br>
+	Reference: {{.Invoice.Reference}}<br>
+	Date: {{.Invoice.CreatedAt.Format "January 2, 2006"}}<br>
+	Billed to: {{.Email}}
+</p>
+<table>
+	<tr><th>Description</th><th class="num">Qty</th><th class="num">Amount</th></tr>
+	{{- range .Invoice.LineItems}}
+	<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{amount .Amount $.Invoice.Currency}}</td></tr>
+	{{- end}}
+	<tr class="totals"><td colspan="2">Subtotal</td><td class="num">{{amount .Invoice.Subtotal .Invoice.Currency}}</td></tr>
+	<tr class="totals"><td colspan="2">{{.TaxLabel}}</td><td class="num">{{amount .Invoice.Tax .Invoice.Currency}}</td></tr>
+	<tr class="totals"><td colspan="2"><strong>Total</strong></td><td class="num"><strong>{{amount .Invoice.Total .Invoice.Currency}}</strong></td></tr>
+</table>
+</body>
+</html>
+`))
+
+type receiptView struct {
+	Title    string
+	Issuer   string
+	Email    string
+	TaxLabel string
+	Invoice  *Invoice
+}
+
+func newReceiptView(invoice *Invoice, email string) receiptView {
+	view := receiptView{
+		Title:    "Receipt",
+		Issuer:   receiptIssuer,
+		Email:    email,
+		TaxLabel: "Tax",
+		Invoice:  invoice,
+	}
+	if invoice.Kind == InvoiceRefund {
+		view.Title = "Refund receipt"
+	}
+	if invoice.TaxName != "" {
+		view.TaxLabel = invoice.TaxName
+	}
+	return view
+}
+
+func RenderReceiptHTML(w io.Writer, invoice *Invoice, email string) error {
+	return receiptTemplate.Execute(w, newReceiptView(invoice, email))
+}
+
+// RenderReceiptPDF lays the receipt out on a single A4 page.
+func RenderReceiptPDF(w io.Writer, invoice *Invoice, email string) error {
+	view := newReceiptView(invoice, email)
+	page := &pdfPage{}
+
+	y := 780.0
+	page.text(56, y, 22, true, view.Title)
+	y -= 30
+	for _, line := range []string{
+		view.Issuer,
+		"Reference: " + invoice.Reference,
+		"Date: " + invoice.CreatedAt.Format("January 2, 2006"),
+		"Billed to: " + email,
+	} {
+		page.text(56, y, 11, false, line)
+		y -= 16
+	}
+
+	y -= 20
+	page.text(56, y, 11, true, "Description")
+	page.text(400, y, 11, true, "Qty")
+	page.text(460, y, 11, true, "Amount")
+	y -= 6
+	page.line(56, y, 540, y)
+	y -= 16
+	for _, item := range invoice.LineItems {
+		page.text(56, y, 11, false, item.Description)
+		page.text(400, y, 11, false, fmt.Sprint(item.Quantity))
+		page.text(460, y, 11, false, formatAmount(item.Amount, invoice.Currency))
+		y -= 18
+	}
+
+	y -= 4
+	page.line(56, y, 540, y)
+	y -= 18
+	for _, row := range []struct {
+		label  string
+		amount int
+		bold   bool
+	}{
+		{"Subtotal", invoice.Subtotal, false},
+		{view.TaxLabel, invoice.Tax, false},
+		{"Total", invoice.Total, true},
+	} {
+		page.text(340, y, 11, row.bold, row.label)
+		page.text(460, y, 11, row.bold, formatAmount(row.amount, invoice.Currency))
+		y -= 18
+	}
+
+	return page.write(w)
+}
+
+// formatAmount renders an amount in the smallest currency unit, e.g.
+// 1999 USD as "19.99 USD".
+func formatAmount(amount int, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	formatted := fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+	if currency != "" {
+		formatted += " " + strings.ToUpper(currency)
+	}
+	return formatted
+}
+
+// pdfPage accumulates a single page content stream using the standard
+// Helvetica fonts, which every PDF reader provides without embedding.
+type pdfPage struct {
+	content strings.Builder
+}
+
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.0f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.8 G %.2f %.2f m %.2f %.2f l S 0 G\n", x1, y1, x2, y2)
+}
+
+func (p *pdfPage) write(w io.Writer) error {
+	content := p.content.String()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+
+	var buf strings.Builder
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// pdfEscape escapes a string for a PDF literal. Characters outside Latin-1
+// cannot be drawn with the standard fonts and are replaced.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package billing_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func testInvoice() *billing.Invoice {
+	return &billing.Invoice{
+		ID:        7,
+		UserID:    1,
+		Provider:  "lemonsqueezy",
+		EventID:   "wh_1",
+		CreatedAt: time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC),
+		Receipt: billing.Receipt{
+			Kind:      billing.InvoiceOrder,
+			Reference: "#1042",
+			Currency:  "USD",
+			Subtotal:  1999,
+			Tax:       400,
+			TaxName:   "VAT",
+			Total:     2399,
+			LineItems: []billing.LineItem{{Description: "Interviewer <Pro>", Quantity: 1, Amount: 1999}},
+		},
+	}
+}
+
+func TestLemonSqueezyReceipts(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectReceipt *billing.Receipt
+	}{
+		{
+			name: "Receipt_Order",
+			body: `{"meta":{"event_name":"order_created","webhook_id":"wh_1"},"data":{"id":"9","attributes":{"user_email":"test@example.com","order_number":1042,"currency":"USD","subtotal":1999,"tax":400,"tax_name":"VAT","total":2399,"first_order_item":{"variant_id":2,"product_name":"Interviewer","variant_name":"Pro","price":1999,"quantity":1}}}}`,
+			expectReceipt: &billing.Receipt{
+				Kind: billing.InvoiceOrder, Reference: "#1042", Currency: "USD", Subtotal: 1999, Tax: 400, TaxName: "VAT", Total: 2399,
+				LineItems: []billing.LineItem{{Description: "Interviewer - Pro", Quantity: 1, Amount: 1999}},
+			},
+		},
+		{
+			name: "Receipt_Refund",
+			body: `{"meta":{"event_name":"order_refunded","webhook_id":"wh_2"},"data":{"id":"9","attributes":{"user_email":"test@example.com","order_number":1042,"currency":"USD","subtotal":1999,"tax":400,"total":2399,"refunded_amount":2399,"first_order_item":{"variant_id":2,"product_name":"Interviewer","variant_name":"Default"}}}}`,
+			expectReceipt: &billing.Receipt{
+				Kind: billing.InvoiceRefund, Reference: "#1042", Currency: "USD", Subtotal: 2399, Total: 2399,
+				LineItems: []billing.LineItem{{Description: "Refund: Interviewer", Quantity: 1, Amount: 2399}},
+			},
+		},
+		{
+			name: "Receipt_Renewal",
+			body: `{"meta":{"event_name":"subscription_payment_success","webhook_id":"wh_3"},"data":{"id":"inv_5","attributes":{"user_email":"test@example.com","currency":"USD","subtotal":1999,"tax":0,"total":1999,"billing_reason":"renewal"}}}`,
+			expectReceipt: &billing.Receipt{
+				Kind: billing.InvoiceRenewal, Reference: "inv_5", Currency: "USD", Subtotal: 1999, Total: 1999,
+				LineItems: []billing.LineItem{{Description: "Subscription renewal", Quantity: 1, Amount: 1999}},
+			},
+		},
+		{
+			name: "Receipt_InitialPaymentSkipped",
+			body: `{"meta":{"event_name":"subscription_payment_success","webhook_id":"wh_4"},"data":{"id":"inv_6","attributes":{"user_email":"test@example.com","currency":"USD","subtotal":1999,"total":1999,"billing_reason":"initial"}}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := &billing.LemonSqueezy{WebhookSecret: "testsecret"}
+
+			event, err := ls.ParseEvent([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("ParseEvent failed: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectReceipt, event.Receipt); diff != "" {
+				t.Errorf("receipt mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStripeInvoiceReceipt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := `{"id":"evt_1","type":"invoice.paid","data":{"object":{"id":"in_1","number":"ABC-0001","customer_email":"test@example.com","subscription":"sub_1","billing_reason":"subscription_create","currency":"eur","subtotal":2999,"tax":570,"total":3569,"lines":{"data":[{"description":"1 x Premium","quantity":1,"amount":2999}]}}}}`
+
+	s := newTestStripe("", now)
+
+	event, err := s.ParseEvent([]byte(body))
+	if err != nil {
+		t.Fatalf("ParseEvent failed: %v", err)
+	}
+
+	expected := &billing.Receipt{
+		Kind: billing.InvoiceOrder, Reference: "ABC-0001", Currency: "EUR", Subtotal: 2999, Tax: 570, Total: 3569,
+		LineItems: []billing.LineItem{{Description: "1 x Premium", Quantity: 1, Amount: 2999}},
+	}
+	if diff := cmp.Diff(expected, event.Receipt); diff != "" {
+		t.Errorf("receipt mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandleEventRecordsInvoice(t *testing.T) {
+	billingRepo := billing.NewMockRepo()
+	b := NewTestBilling()
+	event := &billing.Event{
+		ID:        "wh_1",
+		Type:      billing.EventOrderCreated,
+		UserEmail: "test@example.com",
+		VariantID: "1",
+		Receipt:   &testInvoice().Receipt,
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.HandleEvent(user.NewMockRepo(), billingRepo, event); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+	}
+
+	if len(billingRepo.Invoices) != 1 {
+		t.Fatalf("expected 1 invoice, got %d", len(billingRepo.Invoices))
+	}
+	invoice := billingRepo.Invoices[0]
+	if invoice.UserID != 1 || invoice.EventID != "wh_1" || invoice.Total != 2399 {
+		t.Fatalf("unexpected invoice: %+v", invoice)
+	}
+
+	history, err := billingRepo.ListBillingHistory(1, 50, 0)
+	if err != nil {
+		t.Fatalf("ListBillingHistory failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Type != billing.HistoryPayment || history[1].Type != billing.HistoryCredit {
+		t.Fatalf("expected one payment and one credit grant, got %+v", history)
+	}
+}
+
+func TestHandleEventInvoiceFailureRetries(t *testing.T) {
+	billingRepo := billing.NewMockRepo()
+	billingRepo.FailInvoices = true
+	b := NewTestBilling()
+	event := &billing.Event{
+		ID:        "wh_1",
+		Type:      billing.EventOrderCreated,
+		UserEmail: "test@example.com",
+		VariantID: "1",
+		Receipt:   &testInvoice().Receipt,
+	}
+
+	if err := b.HandleEvent(user.NewMockRepo(), billingRepo, event); err == nil {
+		t.Fatal("expected the invoice failure to fail the event")
+	}
+	if len(billingRepo.Transactions) != 0 {
+		t.Fatalf("expected the grant to roll back with the invoice, got %d transactions", len(billingRepo.Transactions))
+	}
+
+	billingRepo.FailInvoices = false
+	for i := 0; i < 2; i++ {
+		if err := b.HandleEvent(user.NewMockRepo(), billingRepo, event); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+	}
+	if len(billingRepo.Transactions) != 1 || len(billingRepo.Invoices) != 1 {
+		t.Fatalf("expected one grant and one invoice after retries, got %d and %d", len(billingRepo.Transactions), len(billingRepo.Invoices))
+	}
+}
+
+func TestRenderReceiptHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := billing.RenderReceiptHTML(&buf, testInvoice(), "test@example.com"); err != nil {
+		t.Fatalf("RenderReceiptHTML failed: %v", err)
+	}
+
+	html := buf.String()
+	for _, want := range []string{"#1042", "Interviewer &lt;Pro&gt;", "19.99 USD", "VAT", "4.00 USD", "23.99 USD", "test@example.com", "March 4, 2025"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected receipt to contain %q", want)
+		}
+	}
+}
+
+func TestRenderReceiptPDF(t *testing.T) {
+	invoice := testInvoice()
+	invoice.Kind = billing.InvoiceRefund
+	invoice.LineItems[0].Description = "Refund (Pro)"
+
+	var buf bytes.Buffer
+	if err := billing.RenderReceiptPDF(&buf, invoice, "test@example.com"); err != nil {
+		t.Fatalf("RenderReceiptPDF failed: %v", err)
+	}
+
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("expected a complete PDF document")
+	}
+	for _, want := range []string{"(Refund receipt)", `(Refund \(Pro\))`, "(23.99 USD)", "(VAT)"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("expected PDF to contain %q", want)
+		}
+	}
+
+	xref := strings.LastIndex(pdf, "startxref\n")
+	var offset int
+	if _, err := fmt.Sscan(pdf[xref+len("startxref\n"):], &offset); err != nil || !strings.HasPrefix(pdf[offset:], "xref") {
+		t.Fatalf("startxref does not point at the xref table (offset %d, err %v)", offset, err)
+	}
+}
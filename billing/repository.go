@@ -2,8 +2,12 @@ package billing
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
 	"time"
+
+	"github.com/michaelboegner/interviewer/ledger"
 )
 
 type Repository struct {
@@ -16,40 +20,691 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
-func (r *Repository) LogCreditTransaction(tx CreditTransaction) error {
-	query := `
-		INSERT INTO credit_transactions (user_id, amount, credit_type, reason, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`
+// ApplyCreditTransaction posts tx to the ledger. A transaction whose Key was
+// already posted is skipped.
+func (r *Repository) ApplyCreditTransaction(credit CreditTransaction) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applied, err := ledger.Post(tx, ledger.Posting{
+		UserID:       credit.UserID,
+		Amount:       credit.Amount,
+		CreditType:   credit.CreditType,
+		Reason:       credit.Reason,
+		Counterparty: credit.Counterparty,
+		ExpiresAt:    credit.ExpiresAt,
+		Key:          credit.Key,
+		Clamp:        credit.Clamp,
+	})
+	if errors.Is(err, ledger.ErrAlreadyPosted) {
+		return 0, nil
+	}
+	if errors.Is(err, ledger.ErrInsufficientCredits) {
+		return 0, err
+	}
+	if err != nil {
+		log.Printf("ApplyCreditTransaction failed: %v", err)
+		return 0, err
+	}
+
+	if credit.Invoice != nil {
+		if _, err := recordInvoice(tx, credit.Invoice); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return 0, err
+	}
+
+	return applied, nil
+}
+
+func (r *Repository) ReserveCredit(userID int, creditType string) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	lotID, err := ledger.NextLot(tx, userID, creditType)
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := ledger.Post(tx, ledger.Posting{
+		UserID:       userID,
+		Amount:       -1,
+		CreditType:   creditType,
+		Reason:       "Interview credit reserved",
+		Counterparty: ledger.AccountReservations,
+		LotID:        lotID,
+	})
+	if errors.Is(err, ledger.ErrInsufficientCredits) {
+		return 0, err
+	}
+	if err != nil {
+		log.Printf("ledger.Post failed: %v", err)
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO credit_reservations (user_id, credit_type, amount, lot_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), 'pending', $5, $5)
+		RETURNING id
+	`, userID, creditType, -applied, lotID, now).Scan(&id)
+	if err != nil {
+		log.Printf("insert credit reservation failed: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *Repository) CommitReservation(reservationID, interviewID int) error {
+	result, err := r.DB.Exec(`
+		UPDATE credit_reservations
+		SET status = 'committed', interview_id = $1, updated_at = $2
+		WHERE id = $3 AND status = 'pending'
+	`, interviewID, time.Now().UTC(), reservationID)
+	if err != nil {
+		log.Printf("CommitReservation failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
+
+func (r *Repository) ReleaseReservation(reservationID int, reason string) error {
+	return r.returnReservation(`
+		SELECT id, user_id, credit_type, amount, COALESCE(lot_id, 0)
+		FROM credit_reservations
+		WHERE id = $1 AND status = 'pending'
+		FOR UPDATE
+	`, reservationID, "released", reason, ledger.AccountReservations)
+}
+
+func (r *Repository) RefundInterviewReservation(interviewID int, reason string) error {
+	return r.returnReservation(`
+		SELECT id, user_id, credit_type, amount, COALESCE(lot_id, 0)
+		FROM credit_reservations
+		WHERE interview_id = $1 AND status = 'committed'
+		FOR UPDATE
+	`, interviewID, "refunded", reason, ledger.AccountRefunds)
+}
+
+func (r *Repository) ListStaleReservations(before time.Time, limit int) ([]int, error) {
+	rows, err := r.DB.Query(`
+		SELECT id
+		FROM credit_reservations
+		WHERE status = 'pending' AND created_at < $1
+		ORDER BY created_at, id
+		LIMIT $2
+	`, before, limit)
+	if err != nil {
+		log.Printf("ListStaleReservations failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	reservationIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		reservationIDs = append(reservationIDs, id)
+	}
+
+	return reservationIDs, rows.Err()
+}
+
+func (r *Repository) returnReservation(selectQuery string, arg int, status, reason, counterparty string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var reservation CreditReservation
+	err = tx.QueryRow(selectQuery, arg).Scan(
+		&reservation.ID,
+		&reservation.UserID,
+		&reservation.CreditType,
+		&reservation.Amount,
+		&reservation.LotID,
+	)
+	if err == sql.ErrNoRows {
+		return ErrReservationNotFound
+	} else if err != nil {
+		log.Printf("select credit reservation failed: %v", err)
+		return err
+	}
+
+	_, err = ledger.Post(tx, ledger.Posting{
+		UserID:       reservation.UserID,
+		Amount:       reservation.Amount,
+		CreditType:   reservation.CreditType,
+		Reason:       reason,
+		Counterparty: counterparty,
+		LotID:        reservation.LotID,
+	})
+	if err != nil {
+		log.Printf("ledger.Post failed: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE credit_reservations
+		SET status = $1, updated_at = $2
+		WHERE id = $3
+	`, status, time.Now().UTC(), reservation.ID)
+	if err != nil {
+		log.Printf("update credit reservation failed: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ExpireCreditsAt schedules the user's remaining credits of creditType to
+// lapse no later than at.
+func (r *Repository) ExpireCreditsAt(userID int, creditType string, at time.Time) error {
+	return ledger.CapLotExpiry(r.DB, userID, creditType, at)
+}
+
+// StoreWebhookEvent persists a verified webhook as pending. It reports false
+// when the provider already delivered an event with the same ID.
+func (r *Repository) StoreWebhookEvent(event *WebhookEvent) (bool, error) {
+	eventJSON, err := json.Marshal(event.Event)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	err = r.DB.QueryRow(`
+		INSERT INTO webhook_events (provider, event_id, event_type, payload, event, status,
+		                            attempts, next_attempt_at, received_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 'pending', 0, $6, $6, $6)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING id
+	`,
+		event.Provider,
+		event.EventID,
+		event.EventType,
+		event.Payload,
+		eventJSON,
+		now,
+	).Scan(&event.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("StoreWebhookEvent failed: %v", err)
+		return false, err
+	}
+
+	event.Status = WebhookPending
+	event.ReceivedAt = now
+	event.NextAttemptAt = now
+	event.UpdatedAt = now
+
+	return true, nil
+}
+
+// ClaimWebhookEvents moves up to limit due events to processing and returns
+// them with their attempt count already incremented. Rows left in processing
+// by a crashed worker are reclaimed after five minutes.
+func (r *Repository) ClaimWebhookEvents(limit int) ([]WebhookEvent, error) {
+	now := time.Now().UTC()
+	rows, err := r.DB.Query(`
+		UPDATE webhook_events
+		SET status = 'processing', attempts = attempts + 1, updated_at = $1
+		WHERE id IN (
+			SELECT id FROM webhook_events
+			WHERE (status IN ('pending', 'failed') AND next_attempt_at <= $1)
+			   OR (status = 'processing' AND updated_at <= $2)
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookEventColumns,
+		now, now.Add(-5*time.Minute), limit)
+	if err != nil {
+		log.Printf("ClaimWebhookEvents failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookEvents(rows)
+}
+
+func (r *Repository) CompleteWebhookEvent(id int) error {
+	now := time.Now().UTC()
+	_, err := r.DB.Exec(`
+		UPDATE webhook_events
+		SET status = 'processed', last_error = NULL, processed_at = $1, updated_at = $1
+		WHERE id = $2
+	`, now, id)
+	if err != nil {
+		log.Printf("CompleteWebhookEvent failed: %v", err)
+		return err
+	}
 
-	_, err := r.DB.Exec(query,
-		tx.UserID,
-		tx.Amount,
-		tx.CreditType,
-		tx.Reason,
+	return nil
+}
+
+func (r *Repository) FailWebhookEvent(id int, status, lastError string, nextAttemptAt time.Time) error {
+	_, err := r.DB.Exec(`
+		UPDATE webhook_events
+		SET status = $1, last_error = $2, next_attempt_at = $3, updated_at = $4
+		WHERE id = $5
+	`, status, lastError, nextAttemptAt, time.Now().UTC(), id)
+	if err != nil {
+		log.Printf("FailWebhookEvent failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetWebhookEvent(id int) (*WebhookEvent, error) {
+	rows, err := r.DB.Query(`SELECT `+webhookEventColumns+` FROM webhook_events WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("GetWebhookEvent failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := scanWebhookEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrWebhookNotFound
+	}
+
+	return &events[0], nil
+}
+
+func (r *Repository) ListWebhookEvents(status string, limit, offset int) ([]WebhookEvent, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+webhookEventColumns+`
+		FROM webhook_events
+		WHERE $1 = '' OR status = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		log.Printf("ListWebhookEvents failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookEvents(rows)
+}
+
+// ReplayWebhookEvent queues a failed or dead-lettered event for immediate
+// reprocessing with a fresh attempt budget. Processed events cannot be
+// replayed.
+func (r *Repository) ReplayWebhookEvent(id int) error {
+	now := time.Now().UTC()
+	result, err := r.DB.Exec(`
+		UPDATE webhook_events
+		SET status = 'pending', attempts = 0, next_attempt_at = $1, updated_at = $1
+		WHERE id = $2 AND status IN ('failed', 'dead')
+	`, now, id)
+	if err != nil {
+		log.Printf("ReplayWebhookEvent failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+const webhookEventColumns = `id, provider, event_id, event_type, payload, event, status, attempts,
+	COALESCE(last_error, ''), next_attempt_at, received_at, processed_at, updated_at`
+
+func scanWebhookEvents(rows *sql.Rows) ([]WebhookEvent, error) {
+	events := []WebhookEvent{}
+	for rows.Next() {
+		var (
+			event     WebhookEvent
+			eventJSON []byte
+		)
+		err := rows.Scan(
+			&event.ID,
+			&event.Provider,
+			&event.EventID,
+			&event.EventType,
+			&event.Payload,
+			&eventJSON,
+			&event.Status,
+			&event.Attempts,
+			&event.LastError,
+			&event.NextAttemptAt,
+			&event.ReceivedAt,
+			&event.ProcessedAt,
+			&event.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(eventJSON, &event.Event); err != nil {
+			log.Printf("json.Unmarshal webhook event failed: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *Repository) ListPlans(provider string) ([]Plan, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, provider, name, variant_id, credits, credit_type, price_cents,
+		       rollover, rollover_cap, credit_validity_days, active, created_at, updated_at
+		FROM plans
+		WHERE provider = $1
+		ORDER BY id
+	`, provider)
+	if err != nil {
+		log.Printf("ListPlans failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		var plan Plan
+		if err := scanPlan(rows, &plan); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
+
+func (r *Repository) GetPlan(id int) (*Plan, error) {
+	var plan Plan
+	row := r.DB.QueryRow(`
+		SELECT id, provider, name, variant_id, credits, credit_type, price_cents,
+		       rollover, rollover_cap, credit_validity_days, active, created_at, updated_at
+		FROM plans
+		WHERE id = $1
+	`, id)
+	err := scanPlan(row, &plan)
+	if err == sql.ErrNoRows {
+		return nil, ErrPlanNotFound
+	} else if err != nil {
+		log.Printf("GetPlan failed: %v", err)
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+func (r *Repository) CreatePlan(plan *Plan) (int, error) {
+	now := time.Now().UTC()
+	var id int
+	err := r.DB.QueryRow(`
+		INSERT INTO plans (provider, name, variant_id, credits, credit_type, price_cents,
+		                   rollover, rollover_cap, credit_validity_days, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+		RETURNING id
+	`,
+		plan.Provider,
+		plan.Name,
+		plan.VariantID,
+		plan.Credits,
+		plan.CreditType,
+		plan.PriceCents,
+		plan.Rollover,
+		plan.RolloverCap,
+		plan.CreditValidityDays,
+		plan.Active,
+		now,
+	).Scan(&id)
+	if err != nil {
+		log.Printf("CreatePlan failed: %v", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *Repository) UpdatePlan(plan *Plan) error {
+	result, err := r.DB.Exec(`
+		UPDATE plans
+		SET variant_id = $1,
+		    credits = $2,
+		    credit_type = $3,
+		    price_cents = $4,
+		    rollover = $5,
+		    rollover_cap = $6,
+		    credit_validity_days = $7,
+		    active = $8,
+		    updated_at = $9
+		WHERE id = $10
+	`,
+		plan.VariantID,
+		plan.Credits,
+		plan.CreditType,
+		plan.PriceCents,
+		plan.Rollover,
+		plan.RolloverCap,
+		plan.CreditValidityDays,
+		plan.Active,
 		time.Now().UTC(),
+		plan.ID,
 	)
 	if err != nil {
-		log.Printf("LogCreditTransaction failed: %v", err)
+		log.Printf("UpdatePlan failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
+	if rowsAffected == 0 {
+		return ErrPlanNotFound
+	}
 
 	return nil
 }
 
-func (r *Repository) HasWebhookBeenProcessed(webhookID string) (bool, error) {
-	var exists bool
+type planScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPlan(row planScanner, plan *Plan) error {
+	return row.Scan(
+		&plan.ID,
+		&plan.Provider,
+		&plan.Name,
+		&plan.VariantID,
+		&plan.Credits,
+		&plan.CreditType,
+		&plan.PriceCents,
+		&plan.Rollover,
+		&plan.RolloverCap,
+		&plan.CreditValidityDays,
+		&plan.Active,
+		&plan.CreatedAt,
+		&plan.UpdatedAt,
+	)
+}
+
+// RecordInvoice stores a receipt for a processed webhook event. It reports
+// false when the event was already recorded.
+func (r *Repository) RecordInvoice(invoice *Invoice) (bool, error) {
+	return recordInvoice(r.DB, invoice)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so an invoice can be
+// recorded inside the transaction that posts its credits.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func recordInvoice(q querier, invoice *Invoice) (bool, error) {
+	lineItems, err := json.Marshal(invoice.LineItems)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	err = q.QueryRow(`
+		INSERT INTO invoices (user_id, provider, event_id, kind, reference, currency,
+		                      subtotal, tax, tax_name, total, line_items, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING id
+	`,
+		invoice.UserID,
+		invoice.Provider,
+		invoice.EventID,
+		invoice.Kind,
+		invoice.Reference,
+		invoice.Currency,
+		invoice.Subtotal,
+		invoice.Tax,
+		invoice.TaxName,
+		invoice.Total,
+		lineItems,
+		now,
+	).Scan(&invoice.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("RecordInvoice failed: %v", err)
+		return false, err
+	}
+
+	invoice.CreatedAt = now
+	return true, nil
+}
+
+func (r *Repository) GetInvoice(id int) (*Invoice, error) {
+	var (
+		invoice   Invoice
+		lineItems []byte
+	)
 	err := r.DB.QueryRow(`
-        SELECT EXISTS(SELECT 1 FROM processed_webhooks WHERE webhook_id = $1)
-    `, webhookID).Scan(&exists)
-	return exists, err
+		SELECT id, user_id, provider, event_id, kind, reference, currency,
+		       subtotal, tax, tax_name, total, line_items, created_at
+		FROM invoices
+		WHERE id = $1
+	`, id).Scan(
+		&invoice.ID,
+		&invoice.UserID,
+		&invoice.Provider,
+		&invoice.EventID,
+		&invoice.Kind,
+		&invoice.Reference,
+		&invoice.Currency,
+		&invoice.Subtotal,
+		&invoice.Tax,
+		&invoice.TaxName,
+		&invoice.Total,
+		&lineItems,
+		&invoice.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvoiceNotFound
+	} else if err != nil {
+		log.Printf("GetInvoice failed: %v", err)
+		return nil, err
+	}
+
+	if err := json.Unmarshal(lineItems, &invoice.LineItems); err != nil {
+		log.Printf("unmarshal invoice line items failed: %v", err)
+		return nil, err
+	}
+
+	return &invoice, nil
 }
 
-func (r *Repository) MarkWebhookProcessed(webhookID string, event string) error {
-	_, err := r.DB.Exec(`
-        INSERT INTO processed_webhooks (webhook_id, event_name, processed_at)
-        VALUES ($1, $2, $3)
-    `, webhookID, event, time.Now().UTC())
+// ListBillingHistory merges the user's payments with their credit
+// transactions, newest first.
+func (r *Repository) ListBillingHistory(userID, limit, offset int) ([]HistoryItem, error) {
+	rows, err := r.DB.Query(`
+		SELECT type, id, kind, description, credits, credit_type, currency, total, created_at
+		FROM (
+			SELECT 'payment' AS type, id, kind,
+			       COALESCE(line_items->0->>'description', kind) AS description,
+			       0 AS credits, '' AS credit_type, currency, total, created_at
+			FROM invoices
+			WHERE user_id = $1
+			UNION ALL
+			SELECT 'credit' AS type, id, '' AS kind, reason AS description,
+			       amount AS credits, credit_type, '' AS currency, 0 AS total, created_at
+			FROM credit_transactions
+			WHERE user_id = $1
+		) history
+		ORDER BY created_at DESC, type, id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		log.Printf("ListBillingHistory failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []HistoryItem{}
+	for rows.Next() {
+		var item HistoryItem
+		err := rows.Scan(
+			&item.Type,
+			&item.ID,
+			&item.Kind,
+			&item.Description,
+			&item.Credits,
+			&item.CreditType,
+			&item.Currency,
+			&item.Total,
+			&item.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
 
-	return err
+	return items, rows.Err()
 }
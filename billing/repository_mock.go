@@ -1,26 +1,347 @@
 package billing
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type MockRepo struct {
-	FailLogCreditTransaction bool
+	FailApplyCreditTransaction bool
+	FailReserveCredit          bool
+	FailCommitReservation      bool
+	NoCredits                  bool
+	Reserved                   []int
+	Committed                  []int
+	Released                   []int
+	FailRelease                bool
+	ReservedAt                 map[int]time.Time
+	RefundedInterviews         []int
+	Transactions               []CreditTransaction
+	Plans                      []Plan
+	FailPlans                  bool
+	WebhookEvents              []WebhookEvent
+	FailWebhooks               bool
+	CreditExpiries             map[string]time.Time
+	Invoices                   []Invoice
+	FailInvoices               bool
+
+	// Balance, when set, is the balance postings are checked against and
+	// applied to.
+	Balance *int
 }
 
 func NewMockRepo() *MockRepo {
 	return &MockRepo{}
 }
 
-func (m *MockRepo) LogCreditTransaction(tx CreditTransaction) error {
-	if m.FailLogCreditTransaction {
-		return errors.New("mocked LogCreditTransaction failure")
+func (m *MockRepo) ApplyCreditTransaction(tx CreditTransaction) (int, error) {
+	if m.FailApplyCreditTransaction {
+		return 0, errors.New("mocked ApplyCreditTransaction failure")
+	}
+	if tx.Key != "" {
+		for _, posted := range m.Transactions {
+			if posted.Key == tx.Key {
+				return 0, nil
+			}
+		}
+	}
+	if m.Balance != nil && tx.Amount < 0 && *m.Balance < -tx.Amount {
+		if !tx.Clamp {
+			return 0, ErrInsufficientCredits
+		}
+		tx.Amount = -*m.Balance
+	}
+	if tx.Invoice != nil {
+		if _, err := m.RecordInvoice(tx.Invoice); err != nil {
+			return 0, err
+		}
+	}
+	if m.Balance != nil {
+		*m.Balance += tx.Amount
+	}
+	m.Transactions = append(m.Transactions, tx)
+	return tx.Amount, nil
+}
+
+func (m *MockRepo) ReserveCredit(userID int, creditType string) (int, error) {
+	if m.FailReserveCredit {
+		return 0, errors.New("mocked ReserveCredit failure")
+	}
+	if m.NoCredits {
+		return 0, ErrInsufficientCredits
+	}
+
+	id := len(m.Reserved) + 1
+	m.Reserved = append(m.Reserved, id)
+	if m.ReservedAt == nil {
+		m.ReservedAt = map[int]time.Time{}
+	}
+	m.ReservedAt[id] = time.Now().UTC()
+	return id, nil
+}
+
+func (m *MockRepo) CommitReservation(reservationID, interviewID int) error {
+	if m.FailCommitReservation {
+		return errors.New("mocked DB failure")
+	}
+	m.Committed = append(m.Committed, reservationID)
+	return nil
+}
+
+func (m *MockRepo) ReleaseReservation(reservationID int, reason string) error {
+	if m.FailRelease {
+		return errors.New("mocked DB failure")
+	}
+	if !m.pending(reservationID) {
+		return ErrReservationNotFound
+	}
+	m.Released = append(m.Released, reservationID)
+	return nil
+}
+
+func (m *MockRepo) ListStaleReservations(before time.Time, limit int) ([]int, error) {
+	reservationIDs := []int{}
+	for _, id := range m.Reserved {
+		if m.pending(id) && m.ReservedAt[id].Before(before) && len(reservationIDs) < limit {
+			reservationIDs = append(reservationIDs, id)
+		}
+	}
+	return reservationIDs, nil
+}
+
+func (m *MockRepo) pending(reservationID int) bool {
+	for _, ids := range [][]int{m.Committed, m.Released} {
+		for _, id := range ids {
+			if id == reservationID {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *MockRepo) RefundInterviewReservation(interviewID int, reason string) error {
+	m.RefundedInterviews = append(m.RefundedInterviews, interviewID)
+	return nil
+}
+
+func (m *MockRepo) ExpireCreditsAt(userID int, creditType string, at time.Time) error {
+	if m.CreditExpiries == nil {
+		m.CreditExpiries = map[string]time.Time{}
+	}
+	m.CreditExpiries[fmt.Sprintf("%d:%s", userID, creditType)] = at
+	return nil
+}
+
+func (m *MockRepo) StoreWebhookEvent(event *WebhookEvent) (bool, error) {
+	if m.FailWebhooks {
+		return false, errors.New("mocked StoreWebhookEvent failure")
+	}
+	for _, stored := range m.WebhookEvents {
+		if stored.Provider == event.Provider && stored.EventID == event.EventID {
+			return false, nil
+		}
+	}
+
+	event.ID = len(m.WebhookEvents) + 1
+	event.Status = WebhookPending
+	event.NextAttemptAt = time.Now().UTC()
+	m.WebhookEvents = append(m.WebhookEvents, *event)
+	return true, nil
+}
+
+func (m *MockRepo) ClaimWebhookEvents(limit int) ([]WebhookEvent, error) {
+	if m.FailWebhooks {
+		return nil, errors.New("mocked ClaimWebhookEvents failure")
+	}
+
+	now := time.Now().UTC()
+	claimed := []WebhookEvent{}
+	for i := range m.WebhookEvents {
+		event := &m.WebhookEvents[i]
+		if len(claimed) == limit {
+			break
+		}
+		if (event.Status == WebhookPending || event.Status == WebhookFailed) && !event.NextAttemptAt.After(now) {
+			event.Status = WebhookProcessing
+			event.Attempts++
+			claimed = append(claimed, *event)
+		}
+	}
+	return claimed, nil
+}
+
+func (m *MockRepo) CompleteWebhookEvent(id int) error {
+	event, err := m.webhookEvent(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	event.Status = WebhookProcessed
+	event.LastError = ""
+	event.ProcessedAt = &now
+	return nil
+}
+
+func (m *MockRepo) FailWebhookEvent(id int, status, lastError string, nextAttemptAt time.Time) error {
+	event, err := m.webhookEvent(id)
+	if err != nil {
+		return err
 	}
+	event.Status = status
+	event.LastError = lastError
+	event.NextAttemptAt = nextAttemptAt
 	return nil
 }
 
-func (m *MockRepo) HasWebhookBeenProcessed(id string) (bool, error) {
-	return false, nil
+func (m *MockRepo) GetWebhookEvent(id int) (*WebhookEvent, error) {
+	event, err := m.webhookEvent(id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *event
+	return &copied, nil
+}
+
+func (m *MockRepo) ListWebhookEvents(status string, limit, offset int) ([]WebhookEvent, error) {
+	events := []WebhookEvent{}
+	for _, event := range m.WebhookEvents {
+		if status == "" || event.Status == status {
+			events = append(events, event)
+		}
+	}
+	if offset >= len(events) {
+		return []WebhookEvent{}, nil
+	}
+	events = events[offset:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
 }
 
-func (m *MockRepo) MarkWebhookProcessed(id string, event string) error {
+func (m *MockRepo) ReplayWebhookEvent(id int) error {
+	event, err := m.webhookEvent(id)
+	if err != nil || (event.Status != WebhookFailed && event.Status != WebhookDead) {
+		return ErrWebhookNotFound
+	}
+	event.Status = WebhookPending
+	event.Attempts = 0
+	event.NextAttemptAt = time.Now().UTC()
 	return nil
 }
+
+func (m *MockRepo) webhookEvent(id int) (*WebhookEvent, error) {
+	for i := range m.WebhookEvents {
+		if m.WebhookEvents[i].ID == id {
+			return &m.WebhookEvents[i], nil
+		}
+	}
+	return nil, ErrWebhookNotFound
+}
+
+func (m *MockRepo) ListPlans(provider string) ([]Plan, error) {
+	if m.FailPlans {
+		return nil, errors.New("mocked ListPlans failure")
+	}
+	plans := []Plan{}
+	for _, plan := range m.Plans {
+		if plan.Provider == provider {
+			plans = append(plans, plan)
+		}
+	}
+	return plans, nil
+}
+
+func (m *MockRepo) GetPlan(id int) (*Plan, error) {
+	for _, plan := range m.Plans {
+		if plan.ID == id {
+			return &plan, nil
+		}
+	}
+	return nil, ErrPlanNotFound
+}
+
+func (m *MockRepo) CreatePlan(plan *Plan) (int, error) {
+	if m.FailPlans {
+		return 0, errors.New("mocked CreatePlan failure")
+	}
+	plan.ID = len(m.Plans) + 1
+	m.Plans = append(m.Plans, *plan)
+	return plan.ID, nil
+}
+
+func (m *MockRepo) UpdatePlan(plan *Plan) error {
+	if m.FailPlans {
+		return errors.New("mocked UpdatePlan failure")
+	}
+	for i := range m.Plans {
+		if m.Plans[i].ID == plan.ID {
+			m.Plans[i] = *plan
+			return nil
+		}
+	}
+	return ErrPlanNotFound
+}
+
+func (m *MockRepo) RecordInvoice(invoice *Invoice) (bool, error) {
+	if m.FailInvoices {
+		return false, errors.New("mocked RecordInvoice failure")
+	}
+	for _, existing := range m.Invoices {
+		if existing.Provider == invoice.Provider && existing.EventID == invoice.EventID {
+			return false, nil
+		}
+	}
+	invoice.ID = len(m.Invoices) + 1
+	invoice.CreatedAt = time.Now().UTC()
+	m.Invoices = append(m.Invoices, *invoice)
+	return true, nil
+}
+
+func (m *MockRepo) GetInvoice(id int) (*Invoice, error) {
+	if m.FailInvoices {
+		return nil, errors.New("mocked GetInvoice failure")
+	}
+	for _, invoice := range m.Invoices {
+		if invoice.ID == id {
+			return &invoice, nil
+		}
+	}
+	return nil, ErrInvoiceNotFound
+}
+
+func (m *MockRepo) ListBillingHistory(userID, limit, offset int) ([]HistoryItem, error) {
+	if m.FailInvoices {
+		return nil, errors.New("mocked ListBillingHistory failure")
+	}
+	items := []HistoryItem{}
+	for _, invoice := range m.Invoices {
+		if invoice.UserID == userID {
+			items = append(items, HistoryItem{
+				Type:      HistoryPayment,
+				ID:        invoice.ID,
+				Kind:      invoice.Kind,
+				Currency:  invoice.Currency,
+				Total:     invoice.Total,
+				CreatedAt: invoice.CreatedAt,
+			})
+		}
+	}
+	for i, tx := range m.Transactions {
+		if tx.UserID == userID {
+			items = append(items, HistoryItem{
+				Type:        HistoryCredit,
+				ID:          i + 1,
+				Description: tx.Reason,
+				Credits:     tx.Amount,
+				CreditType:  tx.CreditType,
+			})
+		}
+	}
+	if offset >= len(items) {
+		return []HistoryItem{}, nil
+	}
+	return items[offset:min(offset+limit, len(items))], nil
+}
@@ -0,0 +1,74 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ReservationJob returns credits held by reservations that were never
+// committed or released, such as when the server stopped while an interview
+// was being started or its release failed. StaleAfter is well beyond the
+// time starting an interview takes.
+type ReservationJob struct {
+	Repo       BillingRepo
+	Interval   time.Duration
+	StaleAfter time.Duration
+	BatchSize  int
+}
+
+func NewReservationJob(repo BillingRepo) *ReservationJob {
+	return &ReservationJob{
+		Repo:       repo,
+		Interval:   10 * time.Minute,
+		StaleAfter: time.Hour,
+		BatchSize:  100,
+	}
+}
+
+func (j *ReservationJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Run(time.Now().UTC()); err != nil {
+			log.Printf("ReservationJob.Run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run releases every reservation pending since before now minus
+// StaleAfter and returns how many it released. A reservation committed or
+// released in the meantime is skipped.
+func (j *ReservationJob) Run(now time.Time) (int, error) {
+	released := 0
+	for {
+		reservationIDs, err := j.Repo.ListStaleReservations(now.Add(-j.StaleAfter), j.BatchSize)
+		if err != nil {
+			log.Printf("repo.ListStaleReservations failed: %v", err)
+			return released, err
+		}
+
+		for _, id := range reservationIDs {
+			err := j.Repo.ReleaseReservation(id, "Interview credit released: interview was never started")
+			if errors.Is(err, ErrReservationNotFound) {
+				continue
+			} else if err != nil {
+				log.Printf("repo.ReleaseReservation failed for reservation %d: %v", id, err)
+				return released, err
+			}
+			released++
+		}
+
+		if len(reservationIDs) < j.BatchSize {
+			return released, nil
+		}
+	}
+}
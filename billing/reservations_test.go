@@ -0,0 +1,91 @@
+package billing_test
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/michaelboegner/interviewer/billing"
+)
+
+func TestReservationJob(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name             string
+		reservedAgo      []time.Duration
+		committed        []int
+		released         []int
+		batchSize        int
+		failRelease      bool
+		expectErr        bool
+		expectedReleased []int
+	}{
+		{
+			name:             "ReservationJob_ReleasesStalePending",
+			reservedAgo:      []time.Duration{2 * time.Hour, 5 * time.Minute, 3 * time.Hour},
+			expectedReleased: []int{1, 3},
+		},
+		{
+			name:             "ReservationJob_SkipsCommittedAndReleased",
+			reservedAgo:      []time.Duration{2 * time.Hour, 2 * time.Hour, 2 * time.Hour},
+			committed:        []int{1},
+			released:         []int{2},
+			expectedReleased: []int{2, 3},
+		},
+		{
+			name:             "ReservationJob_WorksThroughBatches",
+			reservedAgo:      []time.Duration{2 * time.Hour, 2 * time.Hour, 2 * time.Hour},
+			batchSize:        2,
+			expectedReleased: []int{1, 2, 3},
+		},
+		{
+			name:        "ReservationJob_ReleaseError",
+			reservedAgo: []time.Duration{2 * time.Hour},
+			failRelease: true,
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			billingRepo := billing.NewMockRepo()
+			billingRepo.ReservedAt = map[int]time.Time{}
+			for i, ago := range tc.reservedAgo {
+				billingRepo.Reserved = append(billingRepo.Reserved, i+1)
+				billingRepo.ReservedAt[i+1] = now.Add(-ago)
+			}
+			billingRepo.Committed = tc.committed
+			billingRepo.Released = tc.released
+			billingRepo.FailRelease = tc.failRelease
+
+			job := billing.NewReservationJob(billingRepo)
+			if tc.batchSize > 0 {
+				job.BatchSize = tc.batchSize
+			}
+
+			released, err := job.Run(now)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+			if released != len(tc.expectedReleased)-len(tc.released) {
+				t.Errorf("expected %d released, got %d", len(tc.expectedReleased)-len(tc.released), released)
+			}
+			if diff := cmp.Diff(tc.expectedReleased, billingRepo.Released); diff != "" {
+				t.Errorf("released mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -1,316 +1,224 @@
 package billing
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strconv"
 	"time"
 
+	"github.com/michaelboegner/interviewer/ledger"
 	"github.com/michaelboegner/interviewer/user"
 )
 
-func (b *Billing) RequestCheckoutSession(userEmail string, variantID int) (string, error) {
-	payload := CheckoutPayload{
-		Data: CheckoutData{
-			Type: "checkouts",
-			Attributes: CheckoutAttributes{
-				CheckoutData: CheckoutCustomerInfo{
-					Email: userEmail,
-				},
-			},
-			Relationships: CheckoutRelationships{
-				Store: Relationship{
-					Data: RelationshipData{
-						Type: "stores",
-						ID:   os.Getenv("LEMON_STORE_ID"),
-					},
-				},
-				Variant: Relationship{
-					Data: RelationshipData{
-						Type: "variants",
-						ID:   strconv.Itoa(variantID),
-					},
-				},
-			},
-		},
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		b.Logger.Error("json.Marshal failed", "error", err)
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", "https://api.lemonsqueezy.com/v1/checkouts", bytes.NewBuffer(body))
-	if err != nil {
-		b.Logger.Error("http.NewRequest failed", "error", err)
-		return "", err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+b.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/vnd.api+json")
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		b.Logger.Error("client.Do failed", "error", err)
-		return "", err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		b.Logger.Error("LemonSqueezy returned error", "error", string(bodyBytes))
-		return "", fmt.Errorf("lemonSqueezy API error: %s", res.Status)
+// HandleEvent applies a normalized webhook event to the user's subscription
+// state and credit balance, and records its receipt, if any, in the user's
+// billing history. Receipts of events that post credits are recorded with the
+// posting, so a retry cannot find the credits granted but the receipt missing;
+// the RecordInvoice call afterwards covers events that post nothing and is a
+// no-op for the rest.
+func (b *Billing) HandleEvent(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	if err := b.applyEvent(userRepo, billingRepo, event); err != nil {
+		return err
 	}
-
-	var result CheckoutResponse
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		b.Logger.Error("NewDecoder failed", "error", err)
-		return "", err
+	if event.Receipt == nil {
+		return nil
 	}
 
-	return result.Data.Attributes.URL, nil
+	return b.RecordInvoice(userRepo, billingRepo, event)
 }
 
-func (b *Billing) RequestDeleteSubscription(subscriptionID string) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	req, err := http.NewRequest("DELETE", "https://api.lemonsqueezy.com/v1/subscriptions/"+subscriptionID, nil)
+// RecordInvoice stores the event's receipt against the user. Replays of the
+// same event are ignored.
+func (b *Billing) RecordInvoice(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
+		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+b.APIKey)
-	req.Header.Set("Accept", "application/vnd.api+json")
-
-	res, err := client.Do(req)
-	if err != nil {
+	if _, err := billingRepo.RecordInvoice(b.invoice(user.ID, event)); err != nil {
+		b.Logger.Error("billingRepo.RecordInvoice failed", "error", err)
 		return err
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("cancel failed: %s", string(bodyBytes))
-	}
 
 	return nil
 }
 
-func (b *Billing) RequestResumeSubscription(subscriptionID string) error {
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	payload := map[string]interface{}{
-		"data": map[string]interface{}{
-			"type": "subscriptions",
-			"id":   subscriptionID,
-			"attributes": map[string]bool{
-				"cancelled": false,
-			},
-		},
+// invoice returns the event's receipt as an invoice for userID, or nil when
+// the event has no receipt.
+func (b *Billing) invoice(userID int, event *Event) *Invoice {
+	if event.Receipt == nil {
+		return nil
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+	return &Invoice{
+		UserID:   userID,
+		Provider: b.Provider.Name(),
+		EventID:  event.ID,
+		Receipt:  *event.Receipt,
 	}
+}
 
-	req, err := http.NewRequest("PATCH", "https://api.lemonsqueezy.com/v1/subscriptions/"+subscriptionID, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+func (b *Billing) applyEvent(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	switch event.Type {
+	case EventOrderCreated:
+		return b.ApplyCredits(userRepo, billingRepo, event)
+	case EventOrderRefunded:
+		return b.DeductCredits(userRepo, billingRepo, event)
+	case EventSubscriptionCreated:
+		exists, err := userRepo.HasActiveOrCancelledSubscription(event.UserEmail)
+		if err != nil {
+			b.Logger.Error("repo.HasActiveOrCancelledSubscription failed", "error", err)
+			return err
+		}
+		if exists {
+			return nil
+		}
+		return b.CreateSubscription(userRepo, event)
+	case EventSubscriptionCancelled:
+		return b.CancelSubscription(userRepo, event.UserEmail)
+	case EventSubscriptionResumed:
+		return b.ResumeSubscription(userRepo, event.UserEmail)
+	case EventSubscriptionExpired:
+		return b.ExpireSubscription(userRepo, billingRepo, event.UserEmail)
+	case EventPaymentSucceeded:
+		if event.BillingReason == BillingReasonInitial {
+			return nil
+		}
+		return b.RenewSubscription(userRepo, billingRepo, event)
+	case EventSubscriptionPlanChanged:
+		return b.ChangeSubscription(userRepo, billingRepo, event)
+	case EventSubscriptionUpdated:
+		return b.UpdateSubscription(userRepo, event)
+	case EventPaymentFailed, EventPaymentRecovered:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnhandledEvent, event.Type)
 	}
+}
 
-	req.Header.Set("Authorization", "Bearer "+b.APIKey)
-	req.Header.Set("Accept", "application/vnd.api+json")
-	req.Header.Set("Content-Type", "application/vnd.api+json")
-
-	res, err := client.Do(req)
+func (b *Billing) RequestCheckoutSession(userEmail, tier string) (string, error) {
+	plan, err := b.PlanForTier(tier)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("cancel failed: %s", string(bodyBytes))
+	url, err := b.Provider.CreateCheckout(CheckoutRequest{
+		Email:     userEmail,
+		VariantID: plan.VariantID,
+		Recurring: plan.CreditType == "subscription",
+	})
+	if err != nil {
+		b.Logger.Error("Provider.CreateCheckout failed", "provider", b.Provider.Name(), "error", err)
+		return "", err
 	}
 
-	return nil
+	return url, nil
 }
 
-func (b *Billing) RequestUpdateSubscriptionVariant(subscriptionID string, newVariantID int) error {
-	payload := map[string]interface{}{
-		"data": map[string]interface{}{
-			"type": "subscriptions",
-			"id":   subscriptionID,
-			"attributes": map[string]interface{}{
-				"variant_id": newVariantID,
-			},
-		},
-	}
-	body, _ := json.Marshal(payload)
-
-	req, _ := http.NewRequest("PATCH", "https://api.lemonsqueezy.com/v1/subscriptions/"+subscriptionID, bytes.NewBuffer(body))
-	req.Header.Set("Authorization", "Bearer "+b.APIKey)
-	req.Header.Set("Content-Type", "application/vnd.api+json")
-	req.Header.Set("Accept", "application/vnd.api+json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	res, err := client.Do(req)
+func (b *Billing) RequestChangePlan(subscriptionID, tier string) error {
+	plan, err := b.PlanForTier(tier)
 	if err != nil {
-		return fmt.Errorf("patch failed: %w", err)
+		return err
+	}
+	if plan.CreditType != "subscription" {
+		return fmt.Errorf("%w: %s is not a subscription plan", ErrUnknownTier, tier)
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("lemon error: %s", string(bodyBytes))
+	if err := b.Provider.ChangePlan(subscriptionID, plan.VariantID); err != nil {
+		b.Logger.Error("Provider.ChangePlan failed", "provider", b.Provider.Name(), "error", err)
+		return err
 	}
-	return nil
-}
 
-func (b *Billing) VerifyBillingSignature(signature string, body []byte, secret string) bool {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(body)
-	expected := hex.EncodeToString(mac.Sum(nil))
-	return hmac.Equal([]byte(expected), []byte(signature))
+	return nil
 }
 
-func (b *Billing) ApplyCredits(userRepo user.UserRepo, billingRepo BillingRepo, email string, variantID int) error {
-	user, err := userRepo.GetUserByEmail(email)
+// ApplyCredits grants the credits of the plan the event bought, together with
+// its receipt. Events with an ID are granted only once.
+func (b *Billing) ApplyCredits(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	var (
-		credits    int
-		creditType string
-		reason     string
-	)
-	switch variantID {
-	case b.VariantIDIndividual:
-		credits = 1
-		creditType = "individual"
-		reason = "Individual interview purchase"
-	case b.VariantIDPro:
-		credits = 10
-		creditType = "subscription"
-		reason = "Pro subscription monthly credit grant"
-	case b.VariantIDPremium:
-		credits = 20
-		creditType = "subscription"
-		reason = "Premium subscription monthly credit grant"
-	default:
-		b.Logger.Error("ERROR: unknown variantID", "variantID", variantID)
-		return fmt.Errorf("unknown variant ID: %d", variantID)
-	}
-
-	if err := userRepo.AddCredits(user.ID, credits, creditType); err != nil {
-		b.Logger.Error("repo.AddCredits failed", "error", err)
+	plan, err := b.planForVariant(event.VariantID)
+	if err != nil {
 		return err
 	}
 
 	tx := CreditTransaction{
-		UserID:     user.ID,
-		Amount:     credits,
-		CreditType: creditType,
-		Reason:     reason,
-	}
-	if err := billingRepo.LogCreditTransaction(tx); err != nil {
-		b.Logger.Error("Warning: credit granted but failed to log transaction", "error", err)
+		UserID:       user.ID,
+		Amount:       plan.Credits,
+		CreditType:   plan.CreditType,
+		Reason:       fmt.Sprintf("%s plan credit grant", plan.Name),
+		Counterparty: ledger.AccountPurchases,
+		ExpiresAt:    plan.creditExpiry(time.Now().UTC()),
+		Key:          b.eventKey(event, "grant"),
+		Invoice:      b.invoice(user.ID, event),
+	}
+	if _, err := billingRepo.ApplyCreditTransaction(tx); err != nil {
+		b.Logger.Error("billingRepo.ApplyCreditTransaction failed", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (b *Billing) DeductCredits(userRepo user.UserRepo, billingRepo BillingRepo, orderAttrs OrderAttributes) error {
-	user, err := userRepo.GetUserByEmail(orderAttrs.UserEmail)
+// DeductCredits takes back the credits of the plan the event refunded,
+// together with its receipt. Credits already spent cannot be taken back, so
+// the deduction stops at the remaining balance. Events with an ID are
+// deducted only once.
+func (b *Billing) DeductCredits(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	var (
-		credits    int
-		creditType string
-		reason     string
-	)
-
-	variantID := orderAttrs.FirstOrderItem.VariantID
-	switch variantID {
-	case b.VariantIDIndividual:
-		credits = 1
-		creditType = "individual"
-		reason = "Individual interview refund"
-	case b.VariantIDPro:
-		credits = 10
-		creditType = "subscription"
-		reason = "Pro subscription monthly credit refund"
-	case b.VariantIDPremium:
-		credits = 20
-		creditType = "subscription"
-		reason = "Premium subscription monthly credit refund"
-	default:
-		b.Logger.Error("unknown variantID", "variantID", variantID)
-		return fmt.Errorf("unknown variant ID: %d", variantID)
-	}
-
-	if err := userRepo.AddCredits(user.ID, -credits, creditType); err != nil {
-		b.Logger.Error("repo.DeductCredits failed", "error", err)
+	plan, err := b.planForVariant(event.VariantID)
+	if err != nil {
 		return err
 	}
 
 	tx := CreditTransaction{
-		UserID:     user.ID,
-		Amount:     -credits,
-		CreditType: creditType,
-		Reason:     reason,
-	}
-	if err := billingRepo.LogCreditTransaction(tx); err != nil {
-		b.Logger.Warn("Refund deduction succeeded but failed to log transaction", "error", err)
+		UserID:       user.ID,
+		Amount:       -plan.Credits,
+		CreditType:   plan.CreditType,
+		Reason:       fmt.Sprintf("%s plan credit refund", plan.Name),
+		Counterparty: ledger.AccountRefunds,
+		Key:          b.eventKey(event, "refund"),
+		Invoice:      b.invoice(user.ID, event),
+		Clamp:        true,
+	}
+	applied, err := billingRepo.ApplyCreditTransaction(tx)
+	if err != nil {
+		b.Logger.Error("billingRepo.ApplyCreditTransaction failed", "error", err)
 		return err
 	}
+	if applied != 0 && applied != tx.Amount {
+		b.Logger.Warn("refund exceeded remaining credits", "userID", user.ID, "refunded", plan.Credits, "deducted", -applied)
+	}
 
 	return nil
 }
 
-func (b *Billing) CreateSubscription(userRepo user.UserRepo, subCreatedAttrs SubscriptionAttributes, subscriptionID string) error {
-	user, err := userRepo.GetUserByEmail(subCreatedAttrs.UserEmail)
+func (b *Billing) CreateSubscription(userRepo user.UserRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	var tier string
-	switch subCreatedAttrs.VariantID {
-	case b.VariantIDPro:
-		tier = "pro"
-	case b.VariantIDPremium:
-		tier = "premium"
-	default:
-		b.Logger.Error("unknown variantID", "variantID", subCreatedAttrs.VariantID)
-		return fmt.Errorf("unknown variant ID: %d", subCreatedAttrs.VariantID)
+	plan, err := b.subscriptionPlanForVariant(event.VariantID)
+	if err != nil {
+		return err
 	}
 
 	err = userRepo.UpdateSubscriptionData(
 		user.ID,
 		"active",
-		tier,
-		subscriptionID,
-		subCreatedAttrs.StartsAt,
-		subCreatedAttrs.EndsAt,
+		plan.Name,
+		event.SubscriptionID,
+		event.StartsAt,
+		event.EndsAt,
 	)
 	if err != nil {
 		b.Logger.Error("CreateSubscriptionData failed", "error", err)
@@ -358,6 +266,9 @@ func (b *Billing) ResumeSubscription(userRepo user.UserRepo, email string) error
 	return nil
 }
 
+// ExpireSubscription marks the subscription expired and gives any remaining
+// subscription credits SubscriptionExpiryGrace to be used before the expiry
+// job forfeits them.
 func (b *Billing) ExpireSubscription(userRepo user.UserRepo, billingRepo BillingRepo, email string) error {
 	user, err := userRepo.GetUserByEmail(email)
 	if err != nil {
@@ -375,146 +286,170 @@ func (b *Billing) ExpireSubscription(userRepo user.UserRepo, billingRepo Billing
 	}
 
 	if user.SubscriptionCredits > 0 {
-		err = userRepo.AddCredits(user.ID, -user.SubscriptionCredits, "subscription")
-		if err != nil {
-			b.Logger.Error("repo.AddCredits failed", "error", err)
+		expiresAt := time.Now().UTC().Add(SubscriptionExpiryGrace)
+		if err := billingRepo.ExpireCreditsAt(user.ID, "subscription", expiresAt); err != nil {
+			b.Logger.Error("billingRepo.ExpireCreditsAt failed", "error", err)
 			return err
 		}
-
-		tx := CreditTransaction{
-			UserID:     user.ID,
-			Amount:     -user.SubscriptionCredits,
-			CreditType: "subscription",
-			Reason:     "Zeroed out credits on subscription expiration",
-		}
-		if err := billingRepo.LogCreditTransaction(tx); err != nil {
-			b.Logger.Warn("Zero-out succeeded but failed to log transaction", "error", err)
-		}
 	}
 
 	return nil
 }
 
-func (b *Billing) RenewSubscription(userRepo user.UserRepo, billingRepo BillingRepo, subRenewAttrs SubscriptionRenewAttributes) error {
-	user, err := userRepo.GetUserByEmail(subRenewAttrs.UserEmail)
+// RenewSubscription grants the plan's monthly credits after applying its
+// rollover rules to the credits left over from the previous period. The plan
+// is the one the payment was for when the provider names it, and the user's
+// tier otherwise.
+func (b *Billing) RenewSubscription(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	if event.BillingReason != BillingReasonRenewal {
+		b.Logger.Info("subscription payment is not a renewal, no credits granted", "eventID", event.ID, "billingReason", event.BillingReason)
+		return nil
+	}
+
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	if subRenewAttrs.Total != 1999 && subRenewAttrs.Total != 2999 {
-		return nil
+	var plan *Plan
+	if event.VariantID != "" {
+		plan, err = b.planForVariant(event.VariantID)
+		if err != nil {
+			return err
+		}
+		if plan.Name != user.SubscriptionTier {
+			b.Logger.Warn("renewal is for a different plan than the user's tier", "eventID", event.ID, "plan", plan.Name, "subscriptionTier", user.SubscriptionTier)
+		}
+	} else {
+		var ok bool
+		plan, ok = b.Plans.ByName(user.SubscriptionTier)
+		if !ok {
+			b.Logger.Error("unknown user.SubscriptionTier", "subscriptionTier", user.SubscriptionTier)
+			return fmt.Errorf("%w: %s", ErrUnknownTier, user.SubscriptionTier)
+		}
+	}
+	if plan.CreditType != "subscription" {
+		b.Logger.Error("renewal for a plan that is not a subscription", "plan", plan.Name)
+		return fmt.Errorf("%w: %s", ErrUnknownTier, plan.Name)
 	}
 
-	var (
-		credits int
-		reason  string
-	)
-	switch user.SubscriptionTier {
-	case "pro":
-		credits = 10
-		reason = "Pro subscription monthly credit"
-	case "premium":
-		credits = 20
-		reason = "Premium subscription monthly credit"
-	default:
-		b.Logger.Error("unknown user.SubscriptionTier", "subscriptionTier", user.SubscriptionTier)
-		return fmt.Errorf("unknown user.SubscriptionTier: %s", user.SubscriptionTier)
+	// Discounts and tax make the amount charged differ from the list price;
+	// the renewal is granted either way.
+	if plan.PriceCents > 0 && event.Total != plan.PriceCents {
+		b.Logger.Warn("renewal total differs from the plan price", "eventID", event.ID, "plan", plan.Name, "total", event.Total, "priceCents", plan.PriceCents)
 	}
 
-	if err := userRepo.AddCredits(user.ID, credits, "subscription"); err != nil {
-		b.Logger.Error("repo.AddCredits failed", "error", err)
-		return err
+	if forfeited := user.SubscriptionCredits - rolloverCredits(plan, user.SubscriptionCredits); forfeited > 0 {
+		tx := CreditTransaction{
+			UserID:       user.ID,
+			Amount:       -forfeited,
+			CreditType:   "subscription",
+			Reason:       fmt.Sprintf("%s plan rollover limit", plan.Name),
+			Counterparty: ledger.AccountExpirations,
+			Key:          b.eventKey(event, "rollover"),
+			Clamp:        true,
+		}
+		if _, err := billingRepo.ApplyCreditTransaction(tx); err != nil {
+			b.Logger.Error("billingRepo.ApplyCreditTransaction failed", "error", err)
+			return err
+		}
 	}
 
 	tx := CreditTransaction{
-		UserID:     user.ID,
-		Amount:     credits,
-		CreditType: "subscription",
-		Reason:     reason,
-	}
-	if err := billingRepo.LogCreditTransaction(tx); err != nil {
-		b.Logger.Warn("credit granted but failed to log transaction", "error", err)
+		UserID:       user.ID,
+		Amount:       plan.Credits,
+		CreditType:   "subscription",
+		Reason:       fmt.Sprintf("%s plan monthly credit", plan.Name),
+		Counterparty: ledger.AccountPurchases,
+		ExpiresAt:    plan.creditExpiry(time.Now().UTC()),
+		Key:          b.eventKey(event, "grant"),
+		Invoice:      b.invoice(user.ID, event),
+	}
+	if _, err := billingRepo.ApplyCreditTransaction(tx); err != nil {
+		b.Logger.Error("billingRepo.ApplyCreditTransaction failed", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (b *Billing) ChangeSubscription(userRepo user.UserRepo, billingRepo BillingRepo, subChangedAttrs SubscriptionAttributes) error {
-	user, err := userRepo.GetUserByEmail(subChangedAttrs.UserEmail)
+// ChangeSubscription adjusts the balance by the difference in credits between
+// the user's current plan and the one they moved to, then records the new tier.
+func (b *Billing) ChangeSubscription(userRepo user.UserRepo, billingRepo BillingRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	var (
-		credits int
-		reason  string
-	)
-
-	switch subChangedAttrs.VariantID {
-	case b.VariantIDPro:
-		currentCredits := user.SubscriptionCredits
-		if currentCredits >= 10 {
-			credits = -10
-		} else {
-			credits = -currentCredits
-		}
-
-		reason = "Premium downgraded to Pro subscription monthly credit"
-	case b.VariantIDPremium:
-		credits = 10
-		reason = "Pro upgraded to Premium subscription monthly credit"
-	default:
+	current, ok := b.Plans.ByName(user.SubscriptionTier)
+	if !ok {
 		b.Logger.Error("unknown user.SubscriptionTier", "subscriptionTier", user.SubscriptionTier)
-		return fmt.Errorf("unknown user.SubscriptionTier: %s", user.SubscriptionTier)
+		return fmt.Errorf("%w: %s", ErrUnknownTier, user.SubscriptionTier)
 	}
-
-	if err := userRepo.AddCredits(user.ID, credits, "subscription"); err != nil {
-		b.Logger.Error("repo.AddCredits failed", "error", err)
+	plan, err := b.subscriptionPlanForVariant(event.VariantID)
+	if err != nil {
 		return err
 	}
 
-	tx := CreditTransaction{
-		UserID:     user.ID,
-		Amount:     credits,
-		CreditType: "subscription",
-		Reason:     reason,
+	credits := plan.Credits - current.Credits
+	if credits < 0 && -credits > user.SubscriptionCredits {
+		credits = -user.SubscriptionCredits
+	}
+
+	if credits != 0 {
+		tx := CreditTransaction{
+			UserID:       user.ID,
+			Amount:       credits,
+			CreditType:   "subscription",
+			Reason:       fmt.Sprintf("%s changed to %s plan credit adjustment", current.Name, plan.Name),
+			Counterparty: ledger.AccountPlanChanges,
+			ExpiresAt:    plan.creditExpiry(time.Now().UTC()),
+			Key:          b.eventKey(event, "plan_change"),
+			Clamp:        true,
+		}
+		if _, err := billingRepo.ApplyCreditTransaction(tx); err != nil {
+			b.Logger.Error("billingRepo.ApplyCreditTransaction failed", "error", err)
+			return err
+		}
 	}
-	if err := billingRepo.LogCreditTransaction(tx); err != nil {
-		b.Logger.Warn("credit granted but failed to log transaction", "error", err)
+
+	err = userRepo.UpdateSubscriptionData(
+		user.ID,
+		event.Status,
+		plan.Name,
+		event.SubscriptionID,
+		event.StartsAt,
+		event.EndsAt,
+	)
+	if err != nil {
+		b.Logger.Error("UpdateSubscriptionData failed", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (b *Billing) UpdateSubscription(userRepo user.UserRepo, subUpdatedAttrs SubscriptionAttributes, subscriptionID string) error {
-	user, err := userRepo.GetUserByEmail(subUpdatedAttrs.UserEmail)
+func (b *Billing) UpdateSubscription(userRepo user.UserRepo, event *Event) error {
+	user, err := userRepo.GetUserByEmail(event.UserEmail)
 	if err != nil {
 		b.Logger.Error("repo.GetUserByEmail failed", "error", err)
 		return err
 	}
 
-	var tier string
-	switch subUpdatedAttrs.VariantID {
-	case b.VariantIDPro:
-		tier = "pro"
-	case b.VariantIDPremium:
-		tier = "premium"
-	default:
-		b.Logger.Error("unknown variantID", "variantID", subUpdatedAttrs.VariantID)
-		return fmt.Errorf("unknown variant ID: %d", subUpdatedAttrs.VariantID)
+	plan, err := b.subscriptionPlanForVariant(event.VariantID)
+	if err != nil {
+		return err
 	}
 
 	err = userRepo.UpdateSubscriptionData(
 		user.ID,
-		subUpdatedAttrs.Status,
-		tier,
-		subscriptionID,
-		subUpdatedAttrs.StartsAt,
-		subUpdatedAttrs.EndsAt,
+		event.Status,
+		plan.Name,
+		event.SubscriptionID,
+		event.StartsAt,
+		event.EndsAt,
 	)
 	if err != nil {
 		b.Logger.Error("UpdateSubscriptionData failed", "error", err)
@@ -523,3 +458,35 @@ func (b *Billing) UpdateSubscription(userRepo user.UserRepo, subUpdatedAttrs Sub
 
 	return nil
 }
+
+func (b *Billing) subscriptionPlanForVariant(variantID string) (*Plan, error) {
+	plan, err := b.planForVariant(variantID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.CreditType != "subscription" {
+		b.Logger.Error("variant is not a subscription plan", "variantID", variantID, "plan", plan.Name)
+		return nil, fmt.Errorf("%w: %s is not a subscription plan", ErrUnknownVariant, variantID)
+	}
+	return plan, nil
+}
+
+// eventKey names one credit posting made for a webhook event, so applying
+// the event again skips postings that already succeeded.
+func (b *Billing) eventKey(event *Event, step string) string {
+	if event.ID == "" {
+		return ""
+	}
+	return b.Provider.Name() + ":" + event.ID + ":" + step
+}
+
+// rolloverCredits returns how many of balance carry into the next period.
+func rolloverCredits(plan *Plan, balance int) int {
+	if !plan.Rollover {
+		return 0
+	}
+	if plan.RolloverCap > 0 && balance > plan.RolloverCap {
+		return plan.RolloverCap
+	}
+	return balance
+}
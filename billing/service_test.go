@@ -1,14 +1,17 @@
 package billing_test
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/michaelboegner/interviewer/billing"
 	"github.com/michaelboegner/interviewer/user"
@@ -20,70 +23,126 @@ func NewTestBilling() *billing.Billing {
 	})
 	logger := slog.New(handler)
 
+	plans := &billing.PlanCatalog{}
+	plans.Set([]billing.Plan{
+		{ID: 1, Provider: "lemonsqueezy", Name: "individual", VariantID: "1", Credits: 1, CreditType: "individual", Rollover: true, CreditValidityDays: 365, Active: true},
+		{ID: 2, Provider: "lemonsqueezy", Name: "pro", VariantID: "2", Credits: 10, CreditType: "subscription", PriceCents: 1999, Rollover: true, Active: true},
+		{ID: 3, Provider: "lemonsqueezy", Name: "premium", VariantID: "3", Credits: 20, CreditType: "subscription", PriceCents: 2999, Rollover: true, RolloverCap: 5, Active: true},
+		{ID: 4, Provider: "lemonsqueezy", Name: "legacy", VariantID: "4", Credits: 5, CreditType: "subscription", PriceCents: 999, Rollover: false, Active: false},
+	})
+
 	return &billing.Billing{
-		VariantIDIndividual: 1,
-		VariantIDPro:        2,
-		VariantIDPremium:    3,
-		Logger:              logger,
+		Provider: &billing.LemonSqueezy{},
+		Plans:    plans,
+		Logger:   logger,
 	}
 }
 
 func TestApplyCredits(t *testing.T) {
 	tests := []struct {
-		name       string
-		variantID  int
-		expectErr  bool
-		failUser   bool
-		failCredit bool
-		failLog    bool
+		name      string
+		variantID string
+		expectErr bool
+		failUser  bool
+		failApply bool
 	}{
 		{
-			name:       "ApplyCredits_Individual_Success",
-			variantID:  1,
-			expectErr:  false,
-			failUser:   false,
-			failCredit: false,
-			failLog:    false,
+			name:      "ApplyCredits_Individual_Success",
+			variantID: "1",
+			expectErr: false,
+			failUser:  false,
+			failApply: false,
 		},
 		{
-			name:       "ApplyCredits_Pro_Success",
-			variantID:  2,
-			expectErr:  false,
-			failUser:   false,
-			failCredit: false,
-			failLog:    false,
+			name:      "ApplyCredits_Pro_Success",
+			variantID: "2",
+			expectErr: false,
+			failUser:  false,
+			failApply: false,
 		},
 		{
-			name:       "ApplyCredits_UnknownVariant",
-			variantID:  999,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: false,
-			failLog:    false,
+			name:      "ApplyCredits_UnknownVariant",
+			variantID: "999",
+			expectErr: true,
+			failUser:  false,
+			failApply: false,
 		},
 		{
-			name:       "ApplyCredits_UserRepoFail",
-			variantID:  1,
-			expectErr:  true,
-			failUser:   true,
-			failCredit: false,
-			failLog:    false,
+			name:      "ApplyCredits_UserRepoFail",
+			variantID: "1",
+			expectErr: true,
+			failUser:  true,
+			failApply: false,
 		},
 		{
-			name:       "ApplyCredits_AddCreditsFail",
-			variantID:  1,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: true,
-			failLog:    false,
+			name:      "ApplyCredits_ApplyCreditTransactionFail",
+			variantID: "1",
+			expectErr: true,
+			failUser:  false,
+			failApply: true,
 		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			userRepo := user.NewMockRepo()
+			billingRepo := billing.NewMockRepo()
+
+			userRepo.FailGetUserByEmail = tc.failUser
+			billingRepo.FailApplyCreditTransaction = tc.failApply
+
+			b := NewTestBilling()
+
+			err := b.ApplyCredits(userRepo, billingRepo, &billing.Event{UserEmail: "test@example.com", VariantID: tc.variantID})
+			if tc.expectErr && err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeductCredits(t *testing.T) {
+	tests := []struct {
+		name      string
+		variantID string
+		expectErr bool
+		failUser  bool
+		failApply bool
+	}{
 		{
-			name:       "ApplyCredits_LogFail",
-			variantID:  1,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: false,
-			failLog:    true,
+			name:      "DeductCredits_Pro_Success",
+			variantID: "2",
+			expectErr: false,
+			failUser:  false,
+			failApply: false,
+		},
+		{
+			name:      "DeductCredits_UnknownVariant",
+			variantID: "999",
+			expectErr: true,
+			failUser:  false,
+			failApply: false,
+		},
+		{
+			name:      "DeductCredits_UserRepoFail",
+			variantID: "2",
+			expectErr: true,
+			failUser:  true,
+			failApply: false,
+		},
+		{
+			name:      "DeductCredits_ApplyCreditTransactionFail",
+			variantID: "2",
+			expectErr: true,
+			failUser:  false,
+			failApply: true,
 		},
 	}
 
@@ -97,12 +156,11 @@ func TestApplyCredits(t *testing.T) {
 			billingRepo := billing.NewMockRepo()
 
 			userRepo.FailGetUserByEmail = tc.failUser
-			userRepo.FailAddCredits = tc.failCredit
-			billingRepo.FailLogCreditTransaction = tc.failLog
+			billingRepo.FailApplyCreditTransaction = tc.failApply
 
 			b := NewTestBilling()
 
-			err := b.ApplyCredits(userRepo, billingRepo, "test@example.com", tc.variantID)
+			err := b.DeductCredits(userRepo, billingRepo, &billing.Event{UserEmail: "test@example.com", VariantID: tc.variantID})
 			if tc.expectErr && err == nil {
 				t.Fatal("expected error but got nil")
 			}
@@ -113,54 +171,173 @@ func TestApplyCredits(t *testing.T) {
 	}
 }
 
-func TestDeductCredits(t *testing.T) {
+func TestDeductCreditsStopsAtBalance(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	balance := 3
+	billingRepo := billing.NewMockRepo()
+	billingRepo.Balance = &balance
+
+	err := NewTestBilling().DeductCredits(user.NewMockRepo(), billingRepo, &billing.Event{UserEmail: "test@example.com", VariantID: "2"})
+	if err != nil {
+		t.Fatalf("DeductCredits failed: %v", err)
+	}
+	if len(billingRepo.Transactions) != 1 || billingRepo.Transactions[0].Amount != -3 || balance != 0 {
+		t.Fatalf("expected the refund to take the remaining 3 credits, got %+v with balance %d", billingRepo.Transactions, balance)
+	}
+
+	_, err = billingRepo.ApplyCreditTransaction(billing.CreditTransaction{UserID: 1, Amount: -1, CreditType: "subscription"})
+	if !errors.Is(err, billing.ErrInsufficientCredits) {
+		t.Fatalf("expected an unclamped deduction to fail, got %v", err)
+	}
+}
+
+func TestCreditGrantExpiry(t *testing.T) {
+	tests := []struct {
+		name         string
+		variantID    string
+		expectExpiry bool
+	}{
+		{
+			name:         "CreditGrantExpiry_ValidityDays",
+			variantID:    "1",
+			expectExpiry: true,
+		},
+		{
+			name:         "CreditGrantExpiry_NeverExpires",
+			variantID:    "2",
+			expectExpiry: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			billingRepo := billing.NewMockRepo()
+			b := NewTestBilling()
+
+			before := time.Now().UTC()
+			if err := b.ApplyCredits(user.NewMockRepo(), billingRepo, &billing.Event{UserEmail: "test@example.com", VariantID: tc.variantID}); err != nil {
+				t.Fatalf("ApplyCredits failed: %v", err)
+			}
+
+			expiresAt := billingRepo.Transactions[0].ExpiresAt
+			if !tc.expectExpiry {
+				if expiresAt != nil {
+					t.Fatalf("expected credits without expiry, got %v", *expiresAt)
+				}
+				return
+			}
+			if expiresAt == nil {
+				t.Fatal("expected credits to carry an expiry")
+			}
+			if got := expiresAt.Sub(before); got < 364*24*time.Hour || got > 366*24*time.Hour {
+				t.Fatalf("expected expiry about a year out, got %v", got)
+			}
+		})
+	}
+}
+
+func TestExpireSubscription(t *testing.T) {
+	userRepo := user.NewMockRepo()
+	userRepo.Users[1] = user.User{
+		ID:                  1,
+		Email:               "test@example.com",
+		SubscriptionTier:    "pro",
+		SubscriptionCredits: 6,
+	}
+	billingRepo := billing.NewMockRepo()
+	b := NewTestBilling()
+
+	before := time.Now().UTC()
+	if err := b.ExpireSubscription(userRepo, billingRepo, "test@example.com"); err != nil {
+		t.Fatalf("ExpireSubscription failed: %v", err)
+	}
+
+	if len(billingRepo.Transactions) != 0 {
+		t.Fatalf("expected credits not to be forfeited immediately, got %v", billingRepo.Transactions)
+	}
+	expiresAt, ok := billingRepo.CreditExpiries["1:subscription"]
+	if !ok {
+		t.Fatal("expected subscription credits to be scheduled to expire")
+	}
+	if expiresAt.Before(before.Add(billing.SubscriptionExpiryGrace)) {
+		t.Fatalf("expected credits to last the grace period, got expiry %v", expiresAt)
+	}
+}
+
+func TestRenewSubscription(t *testing.T) {
 	tests := []struct {
-		name       string
-		variantID  int
-		expectErr  bool
-		failUser   bool
-		failCredit bool
-		failLog    bool
+		name         string
+		tier         string
+		balance      int
+		total        int
+		reason       string
+		variantID    string
+		expectErr    bool
+		expectAmount []int
 	}{
 		{
-			name:       "DeductCredits_Pro_Success",
-			variantID:  2,
-			expectErr:  false,
-			failUser:   false,
-			failCredit: false,
-			failLog:    false,
+			name:         "RenewSubscription_Pro_UnlimitedRollover",
+			tier:         "pro",
+			balance:      7,
+			total:        1999,
+			expectAmount: []int{10},
+		},
+		{
+			name:         "RenewSubscription_Premium_RolloverCapped",
+			tier:         "premium",
+			balance:      8,
+			total:        2999,
+			expectAmount: []int{-3, 20},
 		},
 		{
-			name:       "DeductCredits_UnknownVariant",
-			variantID:  999,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: false,
-			failLog:    false,
+			name:         "RenewSubscription_Legacy_NoRollover",
+			tier:         "legacy",
+			balance:      4,
+			total:        999,
+			expectAmount: []int{-4, 5},
 		},
 		{
-			name:       "DeductCredits_UserRepoFail",
-			variantID:  2,
-			expectErr:  true,
-			failUser:   true,
-			failCredit: false,
-			failLog:    false,
+			name:         "RenewSubscription_DiscountedTotalGranted",
+			tier:         "pro",
+			balance:      0,
+			total:        1599,
+			expectAmount: []int{10},
 		},
 		{
-			name:       "DeductCredits_AddCreditsFail",
-			variantID:  2,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: true,
-			failLog:    false,
+			name:         "RenewSubscription_VariantSelectsPlan",
+			tier:         "pro",
+			balance:      0,
+			total:        2999,
+			variantID:    "3",
+			expectAmount: []int{20},
 		},
 		{
-			name:       "DeductCredits_LogFail",
-			variantID:  2,
-			expectErr:  true,
-			failUser:   false,
-			failCredit: false,
-			failLog:    true,
+			name:         "RenewSubscription_ProrationIgnored",
+			tier:         "pro",
+			balance:      0,
+			total:        500,
+			reason:       billing.BillingReasonUpdated,
+			expectAmount: []int{},
+		},
+		{
+			name:      "RenewSubscription_UnknownVariant",
+			tier:      "pro",
+			total:     1999,
+			variantID: "99",
+			expectErr: true,
+		},
+		{
+			name:      "RenewSubscription_UnknownTier",
+			tier:      "free",
+			total:     1999,
+			expectErr: true,
 		},
 	}
 
@@ -171,43 +348,207 @@ func TestDeductCredits(t *testing.T) {
 			defer showLogsIfFail(t, tc.name, buf)
 
 			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{
+				ID:                  1,
+				Email:               "test@example.com",
+				SubscriptionTier:    tc.tier,
+				SubscriptionCredits: tc.balance,
+			}
 			billingRepo := billing.NewMockRepo()
+			b := NewTestBilling()
+			reason := tc.reason
+			if reason == "" {
+				reason = billing.BillingReasonRenewal
+			}
 
-			userRepo.FailGetUserByEmail = tc.failUser
-			userRepo.FailAddCredits = tc.failCredit
-			billingRepo.FailLogCreditTransaction = tc.failLog
+			err := b.RenewSubscription(userRepo, billingRepo, &billing.Event{
+				UserEmail:     "test@example.com",
+				Total:         tc.total,
+				BillingReason: reason,
+				VariantID:     tc.variantID,
+			})
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			amounts := []int{}
+			for _, tx := range billingRepo.Transactions {
+				amounts = append(amounts, tx.Amount)
+			}
+			if fmt.Sprint(amounts) != fmt.Sprint(tc.expectAmount) {
+				t.Fatalf("expected transactions %v, got %v", tc.expectAmount, amounts)
+			}
+		})
+	}
+}
+
+func TestChangeSubscription(t *testing.T) {
+	tests := []struct {
+		name         string
+		tier         string
+		balance      int
+		variantID    string
+		expectErr    bool
+		expectAmount []int
+	}{
+		{
+			name:         "ChangeSubscription_Upgrade",
+			tier:         "pro",
+			balance:      3,
+			variantID:    "3",
+			expectAmount: []int{10},
+		},
+		{
+			name:         "ChangeSubscription_DowngradeClampedToBalance",
+			tier:         "premium",
+			balance:      4,
+			variantID:    "2",
+			expectAmount: []int{-4},
+		},
+		{
+			name:         "ChangeSubscription_SamePlan",
+			tier:         "pro",
+			balance:      4,
+			variantID:    "2",
+			expectAmount: []int{},
+		},
+		{
+			name:      "ChangeSubscription_ToOneTimePlan",
+			tier:      "pro",
+			variantID: "1",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
 
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{
+				ID:                  1,
+				Email:               "test@example.com",
+				SubscriptionTier:    tc.tier,
+				SubscriptionCredits: tc.balance,
+			}
+			billingRepo := billing.NewMockRepo()
 			b := NewTestBilling()
 
-			attrs := billing.OrderAttributes{
+			err := b.ChangeSubscription(userRepo, billingRepo, &billing.Event{
 				UserEmail: "test@example.com",
-				FirstOrderItem: struct {
-					VariantID int `json:"variant_id"`
-				}{
-					VariantID: tc.variantID,
-				},
+				VariantID: tc.variantID,
+				Status:    "active",
+			})
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
 			}
 
-			err := b.DeductCredits(userRepo, billingRepo, attrs)
-			if tc.expectErr && err == nil {
-				t.Fatal("expected error but got nil")
+			amounts := []int{}
+			for _, tx := range billingRepo.Transactions {
+				amounts = append(amounts, tx.Amount)
 			}
-			if !tc.expectErr && err != nil {
-				t.Fatalf("did not expect error but got: %v", err)
+			if fmt.Sprint(amounts) != fmt.Sprint(tc.expectAmount) {
+				t.Fatalf("expected transactions %v, got %v", tc.expectAmount, amounts)
 			}
 		})
 	}
 }
 
-func TestVerifyBillingSignature(t *testing.T) {
+func TestPlanForTier(t *testing.T) {
+	b := NewTestBilling()
+
+	plan, err := b.PlanForTier("premium")
+	if err != nil || plan.VariantID != "3" {
+		t.Fatalf("expected premium plan, got %v, %v", plan, err)
+	}
+	if _, err := b.PlanForTier("legacy"); !errors.Is(err, billing.ErrUnknownTier) {
+		t.Fatalf("expected inactive plan to be rejected, got %v", err)
+	}
+	if _, err := b.PlanForTier("enterprise"); !errors.Is(err, billing.ErrUnknownTier) {
+		t.Fatalf("expected unknown tier to be rejected, got %v", err)
+	}
+}
+
+func TestCreateAndUpdatePlan(t *testing.T) {
 	b := NewTestBilling()
+	billingRepo := billing.NewMockRepo()
+
+	_, err := b.CreatePlan(billingRepo, &billing.Plan{Name: "Team", VariantID: "9", Credits: 0, CreditType: "subscription"})
+	if !errors.Is(err, billing.ErrInvalidPlan) {
+		t.Fatalf("expected ErrInvalidPlan for zero credits, got %v", err)
+	}
+
+	created, err := b.CreatePlan(billingRepo, &billing.Plan{Name: " Team ", VariantID: "9", Credits: 50, CreditType: "subscription", Active: true})
+	if err != nil {
+		t.Fatalf("CreatePlan failed: %v", err)
+	}
+	if created.Name != "team" || created.Provider != "lemonsqueezy" {
+		t.Fatalf("expected normalized name and provider, got %+v", created)
+	}
+	if _, ok := b.Plans.ByVariantID("9"); !ok {
+		t.Fatal("expected catalog to be reloaded after create")
+	}
+
+	updated, err := b.UpdatePlan(billingRepo, created.ID, &billing.Plan{Name: "renamed", VariantID: "9", Credits: 40, CreditType: "subscription", Active: false})
+	if err != nil {
+		t.Fatalf("UpdatePlan failed: %v", err)
+	}
+	if updated.Name != "team" || updated.Credits != 40 || updated.Active {
+		t.Fatalf("unexpected updated plan: %+v", updated)
+	}
+	if _, err := b.PlanForTier("team"); !errors.Is(err, billing.ErrUnknownTier) {
+		t.Fatalf("expected deactivated plan to be unavailable for checkout, got %v", err)
+	}
+
+	if _, err := b.UpdatePlan(billingRepo, 99, &billing.Plan{}); !errors.Is(err, billing.ErrPlanNotFound) {
+		t.Fatalf("expected ErrPlanNotFound, got %v", err)
+	}
+}
+
+func TestRefreshPlans(t *testing.T) {
+	b := NewTestBilling()
+	billingRepo := billing.NewMockRepo()
+	billingRepo.Plans = []billing.Plan{{ID: 9, Provider: "lemonsqueezy", Name: "team", VariantID: "9", Credits: 50, CreditType: "subscription", Active: true}}
+
+	// Another instance's edit is only in the database until the next refresh.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.RefreshPlans(ctx, billingRepo, 10*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := b.Plans.ByVariantID("9"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the catalog to be reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestVerifyBillingSignature(t *testing.T) {
+	l := &billing.LemonSqueezy{WebhookSecret: "testsecret"}
 	body := []byte(`{"key":"value"}`)
-	secret := "testsecret"
-	mac := hmacSha256(body, secret)
-	if !b.VerifyBillingSignature(mac, body, secret) {
+	mac := hmacSha256(body, l.WebhookSecret)
+	if !l.VerifySignature(mac, body) {
 		t.Fatal("expected signature to be valid")
 	}
-	if b.VerifyBillingSignature("invalid", body, secret) {
+	if l.VerifySignature("invalid", body) {
 		t.Fatal("expected signature to be invalid")
 	}
 }
@@ -0,0 +1,476 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeSignatureTolerance = 5 * time.Minute
+
+type Stripe struct {
+	SecretKey     string
+	WebhookSecret string
+	BaseURL       string
+	SuccessURL    string
+	CancelURL     string
+	Client        *http.Client
+	Now           func() time.Time
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object             json.RawMessage `json:"object"`
+		PreviousAttributes json.RawMessage `json:"previous_attributes"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	ID              string            `json:"id"`
+	URL             string            `json:"url"`
+	Mode            string            `json:"mode"`
+	CustomerEmail   string            `json:"customer_email"`
+	Metadata        map[string]string `json:"metadata"`
+	CustomerDetails struct {
+		Email string `json:"email"`
+	} `json:"customer_details"`
+	Currency       string `json:"currency"`
+	AmountSubtotal int    `json:"amount_subtotal"`
+	AmountTotal    int    `json:"amount_total"`
+	TotalDetails   struct {
+		AmountTax int `json:"amount_tax"`
+	} `json:"total_details"`
+}
+
+type stripeSubscription struct {
+	ID                 string            `json:"id"`
+	Status             string            `json:"status"`
+	CancelAtPeriodEnd  bool              `json:"cancel_at_period_end"`
+	CurrentPeriodStart int64             `json:"current_period_start"`
+	CurrentPeriodEnd   int64             `json:"current_period_end"`
+	Metadata           map[string]string `json:"metadata"`
+	Items              struct {
+		Data []struct {
+			ID    string `json:"id"`
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+type stripeInvoice struct {
+	ID            string `json:"id"`
+	Number        string `json:"number"`
+	CustomerEmail string `json:"customer_email"`
+	Subscription  string `json:"subscription"`
+	BillingReason string `json:"billing_reason"`
+	Currency      string `json:"currency"`
+	Subtotal      int    `json:"subtotal"`
+	Tax           int    `json:"tax"`
+	Total         int    `json:"total"`
+	Lines         struct {
+		Data []struct {
+			Description string `json:"description"`
+			Quantity    int    `json:"quantity"`
+			Amount      int    `json:"amount"`
+			Price       struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"lines"`
+	SubscriptionDetails struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"subscription_details"`
+}
+
+type stripeCharge struct {
+	ID             string            `json:"id"`
+	Currency       string            `json:"currency"`
+	AmountRefunded int               `json:"amount_refunded"`
+	Invoice        string            `json:"invoice"`
+	Metadata       map[string]string `json:"metadata"`
+	BillingDetails struct {
+		Email string `json:"email"`
+	} `json:"billing_details"`
+}
+
+func NewStripe() *Stripe {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	return &Stripe{
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		BaseURL:       "https://api.stripe.com",
+		SuccessURL:    frontendURL + "dashboard?checkout=success",
+		CancelURL:     frontendURL + "pricing",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Now:           time.Now,
+	}
+}
+
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+// CreateCheckout stores the user's email and price on the resulting
+// subscription or payment intent so later webhooks can be attributed without
+// a customer lookup.
+func (s *Stripe) CreateCheckout(checkout CheckoutRequest) (string, error) {
+	form := url.Values{
+		"customer_email":          {checkout.Email},
+		"line_items[0][price]":    {checkout.VariantID},
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {s.SuccessURL},
+		"cancel_url":              {s.CancelURL},
+		"metadata[email]":         {checkout.Email},
+		"metadata[variant_id]":    {checkout.VariantID},
+	}
+	if checkout.Recurring {
+		form.Set("mode", "subscription")
+		form.Set("subscription_data[metadata][email]", checkout.Email)
+		form.Set("subscription_data[metadata][variant_id]", checkout.VariantID)
+	} else {
+		form.Set("mode", "payment")
+		form.Set("payment_intent_data[metadata][email]", checkout.Email)
+		form.Set("payment_intent_data[metadata][variant_id]", checkout.VariantID)
+	}
+
+	var session stripeCheckoutSession
+	if err := s.do("POST", "/v1/checkout/sessions", form, &session); err != nil {
+		return "", err
+	}
+
+	return session.URL, nil
+}
+
+func (s *Stripe) CancelSubscription(subscriptionID string) error {
+	return s.do("POST", "/v1/subscriptions/"+subscriptionID, url.Values{
+		"cancel_at_period_end": {"true"},
+	}, nil)
+}
+
+func (s *Stripe) ResumeSubscription(subscriptionID string) error {
+	return s.do("POST", "/v1/subscriptions/"+subscriptionID, url.Values{
+		"cancel_at_period_end": {"false"},
+	}, nil)
+}
+
+func (s *Stripe) ChangePlan(subscriptionID, variantID string) error {
+	var subscription stripeSubscription
+	if err := s.do("GET", "/v1/subscriptions/"+subscriptionID, nil, &subscription); err != nil {
+		return err
+	}
+	if len(subscription.Items.Data) == 0 {
+		return fmt.Errorf("stripe subscription %s has no items", subscriptionID)
+	}
+
+	return s.do("POST", "/v1/subscriptions/"+subscriptionID, url.Values{
+		"items[0][id]":         {subscription.Items.Data[0].ID},
+		"items[0][price]":      {variantID},
+		"proration_behavior":   {"create_prorations"},
+		"metadata[variant_id]": {variantID},
+	}, nil)
+}
+
+func (s *Stripe) do(method, path string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, s.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.SecretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("stripe API error: %s: %s", res.Status, string(bodyBytes))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// VerifySignature checks a Stripe-Signature header of the form
+// "t=<unix>,v1=<hex hmac>" against the raw request body.
+func (s *Stripe) VerifySignature(header string, body []byte) bool {
+	var (
+		timestamp  string
+		signatures []string
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return false
+	}
+
+	age := s.Now().Sub(time.Unix(ts, 0))
+	if age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Stripe) VerifyWebhook(header http.Header, body []byte) (string, string, error) {
+	if !s.VerifySignature(header.Get("Stripe-Signature"), body) {
+		return "", "", ErrInvalidSignature
+	}
+
+	var stripeEvt stripeEvent
+	if err := json.Unmarshal(body, &stripeEvt); err != nil {
+		return "", "", nil
+	}
+
+	return stripeEvt.ID, stripeEvt.Type, nil
+}
+
+func (s *Stripe) ParseEvent(body []byte) (*Event, error) {
+	var stripeEvt stripeEvent
+	if err := json.Unmarshal(body, &stripeEvt); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	event := &Event{ID: stripeEvt.ID}
+	object := stripeEvt.Data.Object
+
+	switch stripeEvt.Type {
+	case "checkout.session.completed":
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(object, &session); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, stripeEvt.Type, err)
+		}
+		if session.Mode != "payment" {
+			return nil, fmt.Errorf("%w: %s mode %s", ErrUnhandledEvent, stripeEvt.Type, session.Mode)
+		}
+		event.Type = EventOrderCreated
+		event.UserEmail = session.CustomerDetails.Email
+		if event.UserEmail == "" {
+			event.UserEmail = session.CustomerEmail
+		}
+		event.VariantID = session.Metadata["variant_id"]
+		event.Receipt = &Receipt{
+			Kind:      InvoiceOrder,
+			Reference: session.ID,
+			Currency:  strings.ToUpper(session.Currency),
+			Subtotal:  session.AmountSubtotal,
+			Tax:       session.TotalDetails.AmountTax,
+			Total:     session.AmountTotal,
+			LineItems: []LineItem{{Description: "Interview credits", Quantity: 1, Amount: session.AmountSubtotal}},
+		}
+	case "charge.refunded":
+		var charge stripeCharge
+		if err := json.Unmarshal(object, &charge); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, stripeEvt.Type, err)
+		}
+		event.Type = EventOrderRefunded
+		event.UserEmail = charge.Metadata["email"]
+		if event.UserEmail == "" {
+			event.UserEmail = charge.BillingDetails.Email
+		}
+		event.VariantID = charge.Metadata["variant_id"]
+		if event.VariantID == "" && charge.Invoice != "" {
+			// Subscription charges are created by Stripe from an invoice and
+			// carry no metadata of their own.
+			var invoice stripeInvoice
+			if err := s.do("GET", "/v1/invoices/"+charge.Invoice, nil, &invoice); err != nil {
+				return nil, err
+			}
+			event.VariantID = invoiceVariant(invoice)
+			if event.UserEmail == "" {
+				event.UserEmail = invoice.CustomerEmail
+			}
+		}
+		event.Receipt = &Receipt{
+			Kind:      InvoiceRefund,
+			Reference: charge.ID,
+			Currency:  strings.ToUpper(charge.Currency),
+			Subtotal:  charge.AmountRefunded,
+			Total:     charge.AmountRefunded,
+			LineItems: []LineItem{{Description: "Refund", Quantity: 1, Amount: charge.AmountRefunded}},
+		}
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripeSubscription
+		if err := json.Unmarshal(object, &subscription); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, stripeEvt.Type, err)
+		}
+		event.SubscriptionID = subscription.ID
+		event.UserEmail = subscription.Metadata["email"]
+		event.Status = normalizeStripeStatus(subscription)
+		event.StartsAt = time.Unix(subscription.CurrentPeriodStart, 0).UTC()
+		event.EndsAt = time.Unix(subscription.CurrentPeriodEnd, 0).UTC()
+		if len(subscription.Items.Data) > 0 {
+			event.VariantID = subscription.Items.Data[0].Price.ID
+		}
+
+		switch stripeEvt.Type {
+		case "customer.subscription.created":
+			event.Type = EventSubscriptionCreated
+		case "customer.subscription.deleted":
+			event.Type = EventSubscriptionExpired
+		default:
+			event.Type = subscriptionUpdateType(stripeEvt.Data.PreviousAttributes, subscription)
+		}
+	case "invoice.paid", "invoice.payment_failed":
+		var invoice stripeInvoice
+		if err := json.Unmarshal(object, &invoice); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPayload, stripeEvt.Type, err)
+		}
+		event.UserEmail = invoice.CustomerEmail
+		if event.UserEmail == "" {
+			event.UserEmail = invoice.SubscriptionDetails.Metadata["email"]
+		}
+		event.SubscriptionID = invoice.Subscription
+		event.Total = invoice.Total
+		event.VariantID = invoiceVariant(invoice)
+		switch invoice.BillingReason {
+		case "subscription_create":
+			event.BillingReason = BillingReasonInitial
+		case "subscription_cycle":
+			event.BillingReason = BillingReasonRenewal
+		case "subscription_update":
+			event.BillingReason = BillingReasonUpdated
+		default:
+			event.BillingReason = invoice.BillingReason
+		}
+
+		switch {
+		case stripeEvt.Type == "invoice.payment_failed":
+			event.Type = EventPaymentFailed
+		case event.BillingReason == BillingReasonInitial:
+			// The first invoice of a subscription is its purchase: it grants
+			// the first period's credits, as Lemon Squeezy's order_created does.
+			event.Type = EventOrderCreated
+			event.Receipt = invoiceReceipt(invoice)
+		default:
+			event.Type = EventPaymentSucceeded
+			event.Receipt = invoiceReceipt(invoice)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnhandledEvent, stripeEvt.Type)
+	}
+
+	return event, nil
+}
+
+func normalizeStripeStatus(subscription stripeSubscription) string {
+	switch {
+	case subscription.Status == "canceled":
+		return "expired"
+	case subscription.CancelAtPeriodEnd:
+		return "cancelled"
+	case subscription.Status == "trialing":
+		return "active"
+	default:
+		return subscription.Status
+	}
+}
+
+func subscriptionUpdateType(previousAttributes json.RawMessage, subscription stripeSubscription) string {
+	var previous map[string]json.RawMessage
+	if err := json.Unmarshal(previousAttributes, &previous); err != nil {
+		return EventSubscriptionUpdated
+	}
+
+	if _, ok := previous["items"]; ok {
+		return EventSubscriptionPlanChanged
+	}
+	if _, ok := previous["cancel_at_period_end"]; ok {
+		if subscription.CancelAtPeriodEnd {
+			return EventSubscriptionCancelled
+		}
+		return EventSubscriptionResumed
+	}
+
+	return EventSubscriptionUpdated
+}
+
+// invoiceVariant returns the price the invoice charged for, falling back to
+// the variant stored on the subscription at checkout or plan change. The last
+// priced line wins: proration invoices list the old plan's credit first.
+func invoiceVariant(invoice stripeInvoice) string {
+	lines := invoice.Lines.Data
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i].Price.ID != "" {
+			return lines[i].Price.ID
+		}
+	}
+	return invoice.SubscriptionDetails.Metadata["variant_id"]
+}
+
+// invoiceReceipt covers both the first and renewal invoices, since Stripe
+// subscription checkouts do not produce a separate one-time order.
+func invoiceReceipt(invoice stripeInvoice) *Receipt {
+	receipt := &Receipt{
+		Kind:      InvoiceRenewal,
+		Reference: invoice.Number,
+		Currency:  strings.ToUpper(invoice.Currency),
+		Subtotal:  invoice.Subtotal,
+		Tax:       invoice.Tax,
+		Total:     invoice.Total,
+		LineItems: []LineItem{},
+	}
+	if invoice.BillingReason == "subscription_create" {
+		receipt.Kind = InvoiceOrder
+	}
+	if receipt.Reference == "" {
+		receipt.Reference = invoice.ID
+	}
+	for _, line := range invoice.Lines.Data {
+		receipt.LineItems = append(receipt.LineItems, LineItem{
+			Description: line.Description,
+			Quantity:    line.Quantity,
+			Amount:      line.Amount,
+		})
+	}
+
+	return receipt
+}
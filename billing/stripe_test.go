@@ -0,0 +1,322 @@
+package billing_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/billing"
+)
+
+func newTestStripe(serverURL string, now time.Time) *billing.Stripe {
+	return &billing.Stripe{
+		SecretKey:     "sk_test",
+		WebhookSecret: "whsec_test",
+		BaseURL:       serverURL,
+		SuccessURL:    "http://localhost/success",
+		CancelURL:     "http://localhost/cancel",
+		Client:        http.DefaultClient,
+		Now:           func() time.Time { return now },
+	}
+}
+
+func stripeSignature(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hmacSha256([]byte(ts+"."+string(body)), secret))
+}
+
+func TestStripeCreateCheckout(t *testing.T) {
+	tests := []struct {
+		name      string
+		recurring bool
+		mode      string
+		metaKey   string
+	}{
+		{
+			name:      "CreateCheckout_OneTime",
+			recurring: false,
+			mode:      "payment",
+			metaKey:   "payment_intent_data[metadata][email]",
+		},
+		{
+			name:      "CreateCheckout_Subscription",
+			recurring: true,
+			mode:      "subscription",
+			metaKey:   "subscription_data[metadata][email]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" || r.URL.Path != "/v1/checkout/sessions" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+				if user, _, _ := r.BasicAuth(); user != "sk_test" {
+					t.Errorf("expected secret key as basic auth user, got %q", user)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("ParseForm failed: %v", err)
+				}
+				if got := r.PostForm.Get("mode"); got != tc.mode {
+					t.Errorf("expected mode %s, got %s", tc.mode, got)
+				}
+				if got := r.PostForm.Get("line_items[0][price]"); got != "price_pro" {
+					t.Errorf("expected price_pro, got %s", got)
+				}
+				if got := r.PostForm.Get(tc.metaKey); got != "test@example.com" {
+					t.Errorf("expected %s to carry email, got %q", tc.metaKey, got)
+				}
+				fmt.Fprint(w, `{"id":"cs_1","url":"https://checkout.stripe.test/cs_1"}`)
+			}))
+			defer server.Close()
+
+			s := newTestStripe(server.URL, time.Now())
+			url, err := s.CreateCheckout(billing.CheckoutRequest{
+				Email:     "test@example.com",
+				VariantID: "price_pro",
+				Recurring: tc.recurring,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url != "https://checkout.stripe.test/cs_1" {
+				t.Fatalf("unexpected checkout URL: %s", url)
+			}
+		})
+	}
+}
+
+func TestStripeChangePlan(t *testing.T) {
+	var updated bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/subscriptions/sub_1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"id":"sub_1","items":{"data":[{"id":"si_1","price":{"id":"price_pro"}}]}}`)
+		case "POST":
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("ParseForm failed: %v", err)
+			}
+			if r.PostForm.Get("items[0][id]") != "si_1" || r.PostForm.Get("items[0][price]") != "price_premium" ||
+				r.PostForm.Get("metadata[variant_id]") != "price_premium" {
+				t.Errorf("unexpected plan change form: %v", r.PostForm)
+			}
+			updated = true
+			fmt.Fprint(w, `{"id":"sub_1"}`)
+		}
+	}))
+	defer server.Close()
+
+	s := newTestStripe(server.URL, time.Now())
+	if err := s.ChangePlan("sub_1", "price_premium"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !updated {
+		t.Fatal("expected subscription item to be updated")
+	}
+}
+
+func TestStripeAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"No such subscription"}}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s := newTestStripe(server.URL, time.Now())
+	if err := s.CancelSubscription("sub_missing"); err == nil {
+		t.Fatal("expected error for non-2xx response")
+	}
+}
+
+func TestStripeVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	s := newTestStripe("", now)
+
+	tests := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{
+			name:   "VerifySignature_Valid",
+			header: stripeSignature("whsec_test", now, body),
+			valid:  true,
+		},
+		{
+			name:   "VerifySignature_WrongSecret",
+			header: stripeSignature("whsec_other", now, body),
+			valid:  false,
+		},
+		{
+			name:   "VerifySignature_StaleTimestamp",
+			header: stripeSignature("whsec_test", now.Add(-10*time.Minute), body),
+			valid:  false,
+		},
+		{
+			name:   "VerifySignature_Malformed",
+			header: "garbage",
+			valid:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.VerifySignature(tc.header, body); got != tc.valid {
+				t.Fatalf("expected valid=%v, got %v", tc.valid, got)
+			}
+		})
+	}
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectErr    error
+		expectType   string
+		expectEmail  string
+		expectVar    string
+		expectReason string
+	}{
+		{
+			name:        "ParseWebhook_OneTimeCheckout",
+			body:        `{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"mode":"payment","customer_details":{"email":"test@example.com"},"metadata":{"variant_id":"price_individual"}}}}`,
+			expectType:  billing.EventOrderCreated,
+			expectEmail: "test@example.com",
+			expectVar:   "price_individual",
+		},
+		{
+			name:        "ParseWebhook_SubscriptionCreated",
+			body:        `{"id":"evt_2","type":"customer.subscription.created","data":{"object":{"id":"sub_1","status":"active","current_period_start":1700000000,"current_period_end":1702592000,"metadata":{"email":"test@example.com"},"items":{"data":[{"id":"si_1","price":{"id":"price_pro"}}]}}}}`,
+			expectType:  billing.EventSubscriptionCreated,
+			expectEmail: "test@example.com",
+			expectVar:   "price_pro",
+		},
+		{
+			name:        "ParseWebhook_PlanChanged",
+			body:        `{"id":"evt_3","type":"customer.subscription.updated","data":{"object":{"id":"sub_1","status":"active","metadata":{"email":"test@example.com"},"items":{"data":[{"id":"si_1","price":{"id":"price_premium"}}]}},"previous_attributes":{"items":{}}}}`,
+			expectType:  billing.EventSubscriptionPlanChanged,
+			expectEmail: "test@example.com",
+			expectVar:   "price_premium",
+		},
+		{
+			name:        "ParseWebhook_Cancelled",
+			body:        `{"id":"evt_4","type":"customer.subscription.updated","data":{"object":{"id":"sub_1","status":"active","cancel_at_period_end":true,"metadata":{"email":"test@example.com"},"items":{"data":[{"id":"si_1","price":{"id":"price_pro"}}]}},"previous_attributes":{"cancel_at_period_end":false}}}`,
+			expectType:  billing.EventSubscriptionCancelled,
+			expectEmail: "test@example.com",
+			expectVar:   "price_pro",
+		},
+		{
+			name:         "ParseWebhook_Renewal",
+			body:         `{"id":"evt_5","type":"invoice.paid","data":{"object":{"customer_email":"test@example.com","subscription":"sub_1","billing_reason":"subscription_cycle","total":1999}}}`,
+			expectType:   billing.EventPaymentSucceeded,
+			expectEmail:  "test@example.com",
+			expectReason: billing.BillingReasonRenewal,
+		},
+		{
+			name:         "ParseWebhook_ProrationIsNotRenewal",
+			body:         `{"id":"evt_9","type":"invoice.paid","data":{"object":{"customer_email":"test@example.com","subscription":"sub_1","billing_reason":"subscription_update","total":500,"lines":{"data":[{"amount":500,"quantity":1,"price":{"id":"price_premium"}}]}}}}`,
+			expectType:   billing.EventPaymentSucceeded,
+			expectEmail:  "test@example.com",
+			expectVar:    "price_premium",
+			expectReason: billing.BillingReasonUpdated,
+		},
+		{
+			name:        "ParseWebhook_InitialInvoiceGrantsCredits",
+			body:        `{"id":"evt_7","type":"invoice.paid","data":{"object":{"customer_email":"test@example.com","subscription":"sub_1","billing_reason":"subscription_create","total":1999,"lines":{"data":[{"amount":1999,"quantity":1,"price":{"id":"price_pro"}}]}}}}`,
+			expectType:  billing.EventOrderCreated,
+			expectEmail: "test@example.com",
+			expectVar:   "price_pro",
+		},
+		{
+			name:      "ParseWebhook_SubscriptionCheckoutIgnored",
+			body:      `{"id":"evt_8","type":"checkout.session.completed","data":{"object":{"mode":"subscription"}}}`,
+			expectErr: billing.ErrUnhandledEvent,
+		},
+		{
+			name:      "ParseWebhook_Unhandled",
+			body:      `{"id":"evt_6","type":"customer.created","data":{"object":{}}}`,
+			expectErr: billing.ErrUnhandledEvent,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStripe("", now)
+			header := http.Header{}
+			header.Set("Stripe-Signature", stripeSignature(s.WebhookSecret, now, []byte(tc.body)))
+
+			eventID, _, err := s.VerifyWebhook(header, []byte(tc.body))
+			if err != nil {
+				t.Fatalf("VerifyWebhook failed: %v", err)
+			}
+			event, err := s.ParseEvent([]byte(tc.body))
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if event.ID != eventID {
+				t.Errorf("expected ID %s, got %s", eventID, event.ID)
+			}
+			if event.Type != tc.expectType {
+				t.Errorf("expected type %s, got %s", tc.expectType, event.Type)
+			}
+			if event.UserEmail != tc.expectEmail {
+				t.Errorf("expected email %s, got %s", tc.expectEmail, event.UserEmail)
+			}
+			if event.VariantID != tc.expectVar {
+				t.Errorf("expected variant %s, got %s", tc.expectVar, event.VariantID)
+			}
+			if tc.expectReason != "" && event.BillingReason != tc.expectReason {
+				t.Errorf("expected billing reason %s, got %s", tc.expectReason, event.BillingReason)
+			}
+		})
+	}
+
+	t.Run("ParseWebhook_InvalidSignature", func(t *testing.T) {
+		s := newTestStripe("", now)
+		header := http.Header{}
+		header.Set("Stripe-Signature", "t=1700000000,v1=deadbeef")
+		_, _, err := s.VerifyWebhook(header, []byte(`{}`))
+		if !errors.Is(err, billing.ErrInvalidSignature) {
+			t.Fatalf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+}
+
+func TestStripeParseWebhookSubscriptionRefund(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/invoices/in_1" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, `{"id":"in_1","customer_email":"test@example.com","lines":{"data":[{"price":{"id":"price_pro"}}]}}`)
+	}))
+	defer server.Close()
+
+	s := newTestStripe(server.URL, now)
+	body := []byte(`{"id":"evt_9","type":"charge.refunded","data":{"object":{"id":"ch_1","invoice":"in_1","amount_refunded":1999,"currency":"usd"}}}`)
+
+	event, err := s.ParseEvent(body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.Type != billing.EventOrderRefunded || event.VariantID != "price_pro" || event.UserEmail != "test@example.com" {
+		t.Fatalf("expected the refund to resolve through the invoice, got %+v", event)
+	}
+}
@@ -0,0 +1,208 @@
+package billing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+const webhookRetryBaseDelay = 30 * time.Second
+
+// ReceiveWebhook verifies a provider webhook and stores its raw body for the
+// processor, which normalizes it. Verified events are stored even when they
+// cannot be parsed so nothing the provider sent is lost. It reports false for
+// duplicate deliveries.
+func (b *Billing) ReceiveWebhook(billingRepo BillingRepo, header http.Header, body []byte) (*WebhookEvent, bool, error) {
+	eventID, eventType, err := b.Provider.VerifyWebhook(header, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if eventID == "" {
+		sum := sha256.Sum256(body)
+		eventID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	webhookEvent := &WebhookEvent{
+		Provider:  b.Provider.Name(),
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   string(body),
+	}
+	created, err := billingRepo.StoreWebhookEvent(webhookEvent)
+	if err != nil {
+		b.Logger.Error("billingRepo.StoreWebhookEvent failed", "error", err)
+		return nil, false, err
+	}
+
+	return webhookEvent, created, nil
+}
+
+// WebhookProcessor applies stored webhook events in the background, retrying
+// failures with exponential backoff until MaxWebhookAttempts is reached.
+type WebhookProcessor struct {
+	Billing     *Billing
+	UserRepo    user.UserRepo
+	BillingRepo BillingRepo
+	AuditRepo   audit.AuditRepo
+	Interval    time.Duration
+	BatchSize   int
+
+	// Apply, when set, runs after the billing logic inside processing, letting
+	// other subsystems react to purchases. An error fails the event so it is
+	// retried, so Apply must be safe to run more than once.
+	Apply func(event *Event) error
+
+	// OnProcessed, when set, runs after an event has been applied
+	// successfully, for reactions whose failure should not retry the event.
+	OnProcessed func(event *Event)
+}
+
+func NewWebhookProcessor(billing *Billing, userRepo user.UserRepo, billingRepo BillingRepo, auditRepo audit.AuditRepo) *WebhookProcessor {
+	return &WebhookProcessor{
+		Billing:     billing,
+		UserRepo:    userRepo,
+		BillingRepo: billingRepo,
+		AuditRepo:   auditRepo,
+		Interval:    5 * time.Second,
+		BatchSize:   20,
+	}
+}
+
+func (p *WebhookProcessor) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.ProcessBatch(); err != nil {
+			p.Billing.Logger.Error("WebhookProcessor.ProcessBatch failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch claims due events and applies them, returning how many were
+// claimed.
+func (p *WebhookProcessor) ProcessBatch() (int, error) {
+	events, err := p.BillingRepo.ClaimWebhookEvents(p.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		p.Process(&events[i])
+	}
+
+	return len(events), nil
+}
+
+// Process normalizes and applies a claimed event and records the outcome.
+// Events that cannot be parsed or that the billing logic does not understand
+// go straight to the dead-letter state.
+func (p *WebhookProcessor) Process(event *WebhookEvent) {
+	logger := p.Billing.Logger.With("webhookEventID", event.ID, "eventType", event.EventType, "attempt", event.Attempts)
+
+	err := p.parse(event)
+	if err == nil {
+		err = p.Billing.HandleEvent(p.UserRepo, p.BillingRepo, &event.Event)
+	}
+	if err == nil && p.Apply != nil {
+		err = p.Apply(&event.Event)
+	}
+	if err == nil {
+		if err := p.BillingRepo.CompleteWebhookEvent(event.ID); err != nil {
+			logger.Error("billingRepo.CompleteWebhookEvent failed", "error", err)
+		}
+		p.recordAudit(event)
+		if p.OnProcessed != nil {
+			p.OnProcessed(&event.Event)
+		}
+		return
+	}
+
+	status := WebhookFailed
+	nextAttemptAt := time.Now().UTC().Add(webhookRetryDelay(event.Attempts))
+	if event.Attempts >= MaxWebhookAttempts || errors.Is(err, ErrUnhandledEvent) || errors.Is(err, ErrInvalidPayload) {
+		status = WebhookDead
+		logger.Error("webhook event moved to dead-letter", "error", err)
+	} else {
+		logger.Warn("webhook event failed, will retry", "error", err, "nextAttemptAt", nextAttemptAt)
+	}
+
+	if err := p.BillingRepo.FailWebhookEvent(event.ID, status, err.Error(), nextAttemptAt); err != nil {
+		logger.Error("billingRepo.FailWebhookEvent failed", "error", err)
+	}
+}
+
+// parse normalizes the stored payload into event.Event. Events stored before
+// parsing moved to the processor already carry their normalized form.
+func (p *WebhookProcessor) parse(event *WebhookEvent) error {
+	if event.Event.Type != "" {
+		return nil
+	}
+	if event.Provider != p.Billing.Provider.Name() {
+		return fmt.Errorf("%w: stored for provider %s", ErrUnhandledEvent, event.Provider)
+	}
+
+	parsed, err := p.Billing.Provider.ParseEvent([]byte(event.Payload))
+	if err != nil {
+		return err
+	}
+	if parsed.ID == "" {
+		parsed.ID = event.EventID
+	}
+	event.Event = *parsed
+
+	return nil
+}
+
+// recordAudit logs a processed event that changed the user's subscription
+// or credits. The provider acted, so the event has no actor.
+func (p *WebhookProcessor) recordAudit(event *WebhookEvent) {
+	action := ""
+	switch event.Event.Type {
+	case EventOrderCreated, EventOrderRefunded, EventPaymentSucceeded:
+		action = audit.ActionCreditsChanged
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionCancelled, EventSubscriptionResumed,
+		EventSubscriptionExpired, EventSubscriptionPlanChanged, EventPaymentFailed, EventPaymentRecovered:
+		action = audit.ActionSubscriptionChanged
+	default:
+		return
+	}
+
+	account, err := p.UserRepo.GetUserByEmail(event.Event.UserEmail)
+	if err != nil {
+		p.Billing.Logger.Error("repo.GetUserByEmail failed", "error", err)
+		return
+	}
+
+	_ = audit.Record(p.AuditRepo, audit.Event{
+		Action:       action,
+		TargetUserID: account.ID,
+		Metadata: map[string]any{
+			"event_type":       event.Event.Type,
+			"webhook_event_id": event.ID,
+			"subscription_id":  event.Event.SubscriptionID,
+			"variant_id":       event.Event.VariantID,
+			"status":           event.Event.Status,
+		},
+	})
+}
+
+func webhookRetryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return webhookRetryBaseDelay << (attempts - 1)
+}
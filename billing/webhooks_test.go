@@ -0,0 +1,252 @@
+package billing_test
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestReceiveWebhook(t *testing.T) {
+	b := NewTestBilling()
+	b.Provider = &billing.LemonSqueezy{WebhookSecret: "testsecret"}
+	billingRepo := billing.NewMockRepo()
+
+	body := []byte(`{"meta":{"event_name":"order_created","webhook_id":"wh_1"},"data":{"id":"1","attributes":{"user_email":"test@example.com","first_order_item":{"variant_id":1}}}}`)
+	header := http.Header{}
+	header.Set("X-Signature", hmacSha256(body, "testsecret"))
+
+	event, created, err := b.ReceiveWebhook(billingRepo, header, body)
+	if err != nil || !created {
+		t.Fatalf("expected webhook to be stored, got created=%v err=%v", created, err)
+	}
+	if event.Status != billing.WebhookPending || event.EventID != "wh_1" || event.EventType != billing.EventOrderCreated || event.Payload != string(body) {
+		t.Fatalf("unexpected stored event: %+v", event)
+	}
+
+	_, created, err = b.ReceiveWebhook(billingRepo, header, body)
+	if err != nil || created {
+		t.Fatalf("expected duplicate delivery to be ignored, got created=%v err=%v", created, err)
+	}
+	if len(billingRepo.WebhookEvents) != 1 {
+		t.Fatalf("expected 1 stored event, got %d", len(billingRepo.WebhookEvents))
+	}
+
+	header.Set("X-Signature", "invalid")
+	if _, _, err := b.ReceiveWebhook(billingRepo, header, body); !errors.Is(err, billing.ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	for _, unparsable := range [][]byte{
+		[]byte(`{"meta":{"event_name":"mystery_event","webhook_id":"wh_2"},"data":{}}`),
+		[]byte(`not json`),
+	} {
+		header.Set("X-Signature", hmacSha256(unparsable, "testsecret"))
+		if _, created, err := b.ReceiveWebhook(billingRepo, header, unparsable); err != nil || !created {
+			t.Fatalf("expected verified event %s to be stored, got created=%v err=%v", unparsable, created, err)
+		}
+	}
+	if !strings.HasPrefix(billingRepo.WebhookEvents[2].EventID, "sha256:") {
+		t.Fatalf("expected a body without an ID to be keyed by its hash, got %s", billingRepo.WebhookEvents[2].EventID)
+	}
+
+	processor := billing.NewWebhookProcessor(b, user.NewMockRepo(), billingRepo, audit.NewMockRepo())
+	if claimed, err := processor.ProcessBatch(); err != nil || claimed != 3 {
+		t.Fatalf("expected 3 claimed events, got %d, err=%v", claimed, err)
+	}
+	for i, expectStatus := range []string{billing.WebhookProcessed, billing.WebhookDead, billing.WebhookDead} {
+		if status := billingRepo.WebhookEvents[i].Status; status != expectStatus {
+			t.Fatalf("expected event %d to be %s, got %s", i+1, expectStatus, status)
+		}
+	}
+	if len(billingRepo.Transactions) != 1 {
+		t.Fatalf("expected credits to be applied once, got %d transactions", len(billingRepo.Transactions))
+	}
+}
+
+func TestWebhookProcessor(t *testing.T) {
+	tests := []struct {
+		name           string
+		event          billing.Event
+		attempts       int
+		failApply      bool
+		failHook       bool
+		expectStatus   string
+		expectAttempts int
+		expectAction   string
+	}{
+		{
+			name:           "Process_Success",
+			event:          billing.Event{ID: "wh_1", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"},
+			expectStatus:   billing.WebhookProcessed,
+			expectAttempts: 1,
+			expectAction:   audit.ActionCreditsChanged,
+		},
+		{
+			name:           "Process_SubscriptionChangeAudited",
+			event:          billing.Event{ID: "wh_5", Type: billing.EventSubscriptionCancelled, UserEmail: "test@example.com", SubscriptionID: "sub_1"},
+			expectStatus:   billing.WebhookProcessed,
+			expectAttempts: 1,
+			expectAction:   audit.ActionSubscriptionChanged,
+		},
+		{
+			name:           "Process_FailureRetries",
+			event:          billing.Event{ID: "wh_2", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"},
+			failApply:      true,
+			expectStatus:   billing.WebhookFailed,
+			expectAttempts: 1,
+		},
+		{
+			name:           "Process_HookFailureRetries",
+			event:          billing.Event{ID: "wh_6", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"},
+			failHook:       true,
+			expectStatus:   billing.WebhookFailed,
+			expectAttempts: 1,
+		},
+		{
+			name:           "Process_FinalAttemptDeadLetters",
+			event:          billing.Event{ID: "wh_3", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"},
+			attempts:       billing.MaxWebhookAttempts - 1,
+			failApply:      true,
+			expectStatus:   billing.WebhookDead,
+			expectAttempts: billing.MaxWebhookAttempts,
+		},
+		{
+			name:           "Process_UnhandledDeadLetters",
+			event:          billing.Event{ID: "wh_4", Type: "mystery_event"},
+			expectStatus:   billing.WebhookDead,
+			expectAttempts: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			billingRepo := billing.NewMockRepo()
+			billingRepo.FailApplyCreditTransaction = tc.failApply
+			billingRepo.WebhookEvents = []billing.WebhookEvent{{
+				ID:        1,
+				Provider:  "lemonsqueezy",
+				EventID:   tc.event.ID,
+				EventType: tc.event.Type,
+				Event:     tc.event,
+				Status:    billing.WebhookPending,
+				Attempts:  tc.attempts,
+			}}
+
+			auditRepo := audit.NewMockRepo()
+			processor := billing.NewWebhookProcessor(NewTestBilling(), user.NewMockRepo(), billingRepo, auditRepo)
+			processor.Apply = func(event *billing.Event) error {
+				if tc.failHook {
+					return errors.New("mocked hook failure")
+				}
+				return nil
+			}
+			claimed, err := processor.ProcessBatch()
+			if err != nil || claimed != 1 {
+				t.Fatalf("expected 1 claimed event, got %d, err=%v", claimed, err)
+			}
+
+			event := billingRepo.WebhookEvents[0]
+			if event.Status != tc.expectStatus {
+				t.Fatalf("expected status %s, got %s", tc.expectStatus, event.Status)
+			}
+			if event.Attempts != tc.expectAttempts {
+				t.Fatalf("expected %d attempts, got %d", tc.expectAttempts, event.Attempts)
+			}
+			if tc.expectStatus == billing.WebhookFailed && !event.NextAttemptAt.After(time.Now()) {
+				t.Fatal("expected retry to be scheduled in the future")
+			}
+			if tc.expectStatus != billing.WebhookProcessed && event.LastError == "" {
+				t.Fatal("expected last error to be recorded")
+			}
+			if tc.expectAction == "" && len(auditRepo.Events) != 0 {
+				t.Fatalf("expected no audit events, got %+v", auditRepo.Events)
+			}
+			if tc.expectAction != "" && (len(auditRepo.Events) != 1 || auditRepo.Events[0].Action != tc.expectAction) {
+				t.Fatalf("expected one %s audit event, got %+v", tc.expectAction, auditRepo.Events)
+			}
+		})
+	}
+}
+
+func TestReplayWebhookEvent(t *testing.T) {
+	billingRepo := billing.NewMockRepo()
+	billingRepo.WebhookEvents = []billing.WebhookEvent{{
+		ID:        1,
+		Provider:  "lemonsqueezy",
+		EventID:   "wh_1",
+		EventType: billing.EventOrderCreated,
+		Event:     billing.Event{ID: "wh_1", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"},
+		Status:    billing.WebhookDead,
+		Attempts:  billing.MaxWebhookAttempts,
+		LastError: "mocked failure",
+	}}
+
+	if err := billingRepo.ReplayWebhookEvent(1); err != nil {
+		t.Fatalf("ReplayWebhookEvent failed: %v", err)
+	}
+
+	processor := billing.NewWebhookProcessor(NewTestBilling(), user.NewMockRepo(), billingRepo, audit.NewMockRepo())
+	if _, err := processor.ProcessBatch(); err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+
+	event := billingRepo.WebhookEvents[0]
+	if event.Status != billing.WebhookProcessed || event.Attempts != 1 || event.LastError != "" {
+		t.Fatalf("expected replayed event to be processed on a fresh attempt, got %+v", event)
+	}
+	if len(billingRepo.Transactions) != 1 {
+		t.Fatalf("expected credits to be applied once, got %d transactions", len(billingRepo.Transactions))
+	}
+}
+
+func TestReplayWebhookEventRefusesProcessed(t *testing.T) {
+	billingRepo := billing.NewMockRepo()
+	billingRepo.WebhookEvents = []billing.WebhookEvent{
+		{ID: 1, Provider: "lemonsqueezy", EventID: "wh_1", Status: billing.WebhookProcessed},
+		{ID: 2, Provider: "lemonsqueezy", EventID: "wh_2", Status: billing.WebhookPending},
+	}
+
+	for _, id := range []int{1, 2} {
+		if err := billingRepo.ReplayWebhookEvent(id); !errors.Is(err, billing.ErrWebhookNotFound) {
+			t.Fatalf("expected event %d not to be replayable, got %v", id, err)
+		}
+	}
+}
+
+func TestWebhookProcessorReclaimDoesNotDoubleGrant(t *testing.T) {
+	billingRepo := billing.NewMockRepo()
+	event := billing.Event{ID: "wh_1", Type: billing.EventOrderCreated, UserEmail: "test@example.com", VariantID: "1"}
+	billingRepo.WebhookEvents = []billing.WebhookEvent{{
+		ID: 1, Provider: "lemonsqueezy", EventID: "wh_1", EventType: event.Type, Event: event, Status: billing.WebhookPending,
+	}}
+
+	processor := billing.NewWebhookProcessor(NewTestBilling(), user.NewMockRepo(), billingRepo, audit.NewMockRepo())
+	if _, err := processor.ProcessBatch(); err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+
+	// A worker that crashed after posting credits leaves the event to be
+	// reclaimed and processed again.
+	billingRepo.WebhookEvents[0].Status = billing.WebhookPending
+	if _, err := processor.ProcessBatch(); err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+
+	if status := billingRepo.WebhookEvents[0].Status; status != billing.WebhookProcessed {
+		t.Fatalf("expected reprocessed event to complete, got %s", status)
+	}
+	if len(billingRepo.Transactions) != 1 {
+		t.Fatalf("expected credits to be applied once, got %d transactions", len(billingRepo.Transactions))
+	}
+}
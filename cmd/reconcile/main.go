@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/michaelboegner/interviewer/database"
+	"github.com/michaelboegner/interviewer/ledger"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "overwrite drifted user balances with the ledger balance")
+	flag.Parse()
+
+	if os.Getenv("ENV") != "production" {
+		_ = godotenv.Load(".env.dev")
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	db, err := database.StartDB()
+	if err != nil {
+		logger.Error("database.StartDB failed", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	report, err := ledger.Reconcile(ledger.NewRepository(db), *repair)
+	if err != nil {
+		logger.Error("ledger.Reconcile failed", "error", err)
+		os.Exit(1)
+	}
+
+	for _, drift := range report.Drifts {
+		logger.Warn("balance drift",
+			"userID", drift.UserID,
+			"creditType", drift.CreditType,
+			"stored", drift.Stored,
+			"ledger", drift.Ledger,
+		)
+	}
+	for _, transactionID := range report.UnbalancedTransactions {
+		logger.Warn("unbalanced ledger transaction", "transactionID", transactionID)
+	}
+
+	logger.Info("reconciliation finished",
+		"drifts", len(report.Drifts),
+		"unbalancedTransactions", len(report.UnbalancedTransactions),
+		"repaired", report.Repaired,
+	)
+
+	if len(report.UnbalancedTransactions) > 0 || len(report.Drifts) > report.Repaired {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/database"
+)
+
+const usage = `usage:
+  webhooks list [-status pending|processing|processed|failed|dead] [-limit n]
+  webhooks show <id>
+  webhooks replay <id>`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if os.Getenv("ENV") != "production" {
+		_ = godotenv.Load(".env.dev")
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	db, err := database.StartDB()
+	if err != nil {
+		logger.Error("database.StartDB failed", "error", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	repo := billing.NewRepository(db)
+
+	switch os.Args[1] {
+	case "list":
+		flags := flag.NewFlagSet("list", flag.ExitOnError)
+		status := flags.String("status", "", "only show events with this status")
+		limit := flags.Int("limit", 50, "maximum number of events to show")
+		_ = flags.Parse(os.Args[2:])
+
+		events, err := repo.ListWebhookEvents(*status, *limit, 0)
+		if err != nil {
+			logger.Error("repo.ListWebhookEvents failed", "error", err)
+			os.Exit(1)
+		}
+		for _, event := range events {
+			fmt.Printf("%d\t%s\t%s\t%s\t%d\t%s\n",
+				event.ID,
+				event.Status,
+				event.EventType,
+				event.ReceivedAt.Format("2006-01-02T15:04:05Z"),
+				event.Attempts,
+				event.LastError,
+			)
+		}
+	case "show":
+		event, err := repo.GetWebhookEvent(eventID())
+		if err != nil {
+			logger.Error("repo.GetWebhookEvent failed", "error", err)
+			os.Exit(1)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(event)
+	case "replay":
+		id := eventID()
+		if err := repo.ReplayWebhookEvent(id); err != nil {
+			logger.Error("repo.ReplayWebhookEvent failed", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("webhook event queued for replay; the server's processor will pick it up", "id", id)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func eventID() int {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	id, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid event id %q\n", os.Args[2])
+		os.Exit(2)
+	}
+	return id
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/referral"
 )
 
 type DashboardData struct {
@@ -15,4 +16,5 @@ type DashboardData struct {
 	IndividualCredits     int                 `json:"individual_credits"`
 	SubscriptionCredits   int                 `json:"subscription_credits"`
 	PastInterviews        []interview.Summary `json:"past_interviews"`
+	Referrals             *referral.Summary   `json:"referrals"`
 }
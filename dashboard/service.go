@@ -4,10 +4,11 @@ import (
 	"log"
 
 	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/referral"
 	"github.com/michaelboegner/interviewer/user"
 )
 
-func GetDashboardData(userID int, userRepo user.UserRepo, interviewRepo interview.InterviewRepo) (*DashboardData, error) {
+func GetDashboardData(userID int, userRepo user.UserRepo, interviewRepo interview.InterviewRepo, referralRepo referral.ReferralRepo) (*DashboardData, error) {
 	user, err := userRepo.GetUser(userID)
 	if err != nil {
 		log.Printf("GetUser failed for userID %d: %v", userID, err)
@@ -20,6 +21,12 @@ func GetDashboardData(userID int, userRepo user.UserRepo, interviewRepo intervie
 		return nil, err
 	}
 
+	referrals, err := referral.GetSummary(referralRepo, userID)
+	if err != nil {
+		log.Printf("referral.GetSummary failed for userID %d: %v", userID, err)
+		return nil, err
+	}
+
 	return &DashboardData{
 		Email:                 user.Email,
 		Plan:                  user.SubscriptionTier,
@@ -29,5 +36,6 @@ func GetDashboardData(userID int, userRepo user.UserRepo, interviewRepo intervie
 		IndividualCredits:     user.IndividualCredits,
 		SubscriptionCredits:   user.SubscriptionCredits,
 		PastInterviews:        interviews,
+		Referrals:             referrals,
 	}, nil
 }
@@ -12,6 +12,18 @@ import (
 func StartDB() (*sql.DB, error) {
 	fmt.Println("StartDB firing")
 
+	db, err := Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Connected to the database successfully!")
+
+	return db, nil
+}
+
+// Open connects to the database named by the environment and checks the
+// connection, returning any error instead of exiting.
+func Open() (*sql.DB, error) {
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbUser := os.Getenv("DB_USER")
@@ -35,14 +47,13 @@ func StartDB() (*sql.DB, error) {
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	err = db.Ping()
-	if err != nil {
-		log.Fatal(err)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
-	fmt.Println("Connected to the database successfully!")
 
 	return db, nil
 }
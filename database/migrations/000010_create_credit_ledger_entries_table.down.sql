@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS credit_ledger_entries_append_only ON credit_ledger_entries;
+DROP FUNCTION IF EXISTS reject_credit_ledger_mutation();
+DROP TABLE IF EXISTS credit_ledger_entries;
+DELETE FROM credit_transactions WHERE reason = 'Opening balance';
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS credit_ledger_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL REFERENCES credit_transactions(id),
+    account TEXT NOT NULL,
+    user_id INT REFERENCES users(id),
+    credit_type TEXT NOT NULL,
+    amount INT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_ledger_entries_user_id ON credit_ledger_entries(user_id);
+CREATE INDEX IF NOT EXISTS idx_credit_ledger_entries_transaction_id ON credit_ledger_entries(transaction_id);
+
+CREATE OR REPLACE FUNCTION reject_credit_ledger_mutation() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'credit_ledger_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER credit_ledger_entries_append_only
+    BEFORE UPDATE OR DELETE ON credit_ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION reject_credit_ledger_mutation();
+
+-- Opening balances so that existing users reconcile against the ledger.
+WITH opening AS (
+    INSERT INTO credit_transactions (user_id, amount, credit_type, reason, created_at)
+    SELECT id, individual_credits, 'individual', 'Opening balance', NOW()
+    FROM users WHERE individual_credits <> 0
+    UNION ALL
+    SELECT id, subscription_credits, 'subscription', 'Opening balance', NOW()
+    FROM users WHERE subscription_credits <> 0
+    RETURNING id, user_id, amount, credit_type
+)
+INSERT INTO credit_ledger_entries (transaction_id, account, user_id, credit_type, amount, created_at)
+SELECT id, 'user:' || user_id || ':' || credit_type, user_id, credit_type, amount, NOW() FROM opening
+UNION ALL
+SELECT id, 'system:opening_balance', NULL, credit_type, -amount, NOW() FROM opening;
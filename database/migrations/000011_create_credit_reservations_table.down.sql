@@ -0,0 +1,2 @@
+ALTER TABLE interviews DROP COLUMN provider_failures;
+DROP TABLE IF EXISTS credit_reservations;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS credit_reservations (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    interview_id INT REFERENCES interviews(id),
+    credit_type TEXT NOT NULL,
+    amount INT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_reservations_interview_id ON credit_reservations(interview_id);
+
+ALTER TABLE interviews ADD COLUMN provider_failures INT NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    provider TEXT NOT NULL,
+    name TEXT NOT NULL,
+    variant_id TEXT NOT NULL,
+    credits INT NOT NULL CHECK (credits > 0),
+    credit_type TEXT NOT NULL CHECK (credit_type IN ('individual', 'subscription')),
+    price_cents INT NOT NULL DEFAULT 0,
+    rollover BOOLEAN NOT NULL DEFAULT TRUE,
+    rollover_cap INT NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (provider, name),
+    UNIQUE (provider, variant_id)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS processed_webhooks (
+    webhook_id TEXT PRIMARY KEY NOT NULL,
+    event_name TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO processed_webhooks (webhook_id, event_name, processed_at)
+SELECT event_id, event_type, processed_at
+FROM webhook_events
+WHERE status = 'processed'
+ON CONFLICT (webhook_id) DO NOTHING;
+
+DROP TABLE IF EXISTS webhook_events;
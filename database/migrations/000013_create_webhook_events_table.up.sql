@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id SERIAL PRIMARY KEY,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    event JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_due ON webhook_events(status, next_attempt_at);
+
+-- Carry over idempotency keys for webhooks handled before events were stored.
+INSERT INTO webhook_events (provider, event_id, event_type, payload, event, status, attempts,
+                            next_attempt_at, received_at, processed_at, updated_at)
+SELECT 'lemonsqueezy', webhook_id, event_name, '', '{}', 'processed', 1,
+       processed_at, processed_at, processed_at, processed_at
+FROM processed_webhooks;
+
+DROP TABLE IF EXISTS processed_webhooks;
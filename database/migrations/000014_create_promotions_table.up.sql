@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    credits INT NOT NULL CHECK (credits > 0),
+    credit_type TEXT NOT NULL CHECK (credit_type IN ('individual', 'subscription')),
+    expires_at TIMESTAMP,
+    max_redemptions INT NOT NULL DEFAULT 0,
+    per_user_limit INT NOT NULL DEFAULT 1,
+    allowed_plans TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+    id SERIAL PRIMARY KEY,
+    promotion_id INT NOT NULL REFERENCES promotions(id),
+    user_id INT NOT NULL REFERENCES users(id),
+    credits INT NOT NULL,
+    credit_type TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_user ON promotion_redemptions(promotion_id, user_id);
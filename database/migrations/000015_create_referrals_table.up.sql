@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS referral_codes (
+    user_id INT PRIMARY KEY REFERENCES users(id),
+    code TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS referrals (
+    id SERIAL PRIMARY KEY,
+    referrer_id INT NOT NULL REFERENCES users(id),
+    referred_id INT NOT NULL UNIQUE REFERENCES users(id),
+    code TEXT NOT NULL,
+    referred_domain TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    rejection_reason TEXT NOT NULL DEFAULT '',
+    qualified_by TEXT NOT NULL DEFAULT '',
+    referrer_bonus INT NOT NULL DEFAULT 0,
+    referred_bonus INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    rewarded_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer_domain ON referrals(referrer_id, referred_domain);
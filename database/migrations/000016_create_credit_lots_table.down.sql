@@ -0,0 +1,5 @@
+ALTER TABLE plans DROP COLUMN IF EXISTS credit_validity_days;
+
+ALTER TABLE credit_reservations DROP COLUMN IF EXISTS lot_id;
+
+DROP TABLE IF EXISTS credit_lots;
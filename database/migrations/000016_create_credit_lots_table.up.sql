@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS credit_lots (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    credit_type TEXT NOT NULL,
+    transaction_id INT REFERENCES credit_transactions(id),
+    amount INT NOT NULL,
+    remaining INT NOT NULL CHECK (remaining >= 0),
+    expires_at TIMESTAMP,
+    warned_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_lots_open ON credit_lots(user_id, credit_type, created_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS idx_credit_lots_expires_at ON credit_lots(expires_at) WHERE remaining > 0;
+
+-- Existing balances become a single non-expiring opening lot per credit type.
+INSERT INTO credit_lots (user_id, credit_type, amount, remaining, created_at)
+SELECT id, 'individual', individual_credits, individual_credits, NOW()
+FROM users
+WHERE individual_credits > 0;
+
+INSERT INTO credit_lots (user_id, credit_type, amount, remaining, created_at)
+SELECT id, 'subscription', subscription_credits, subscription_credits, NOW()
+FROM users
+WHERE subscription_credits > 0;
+
+ALTER TABLE credit_reservations ADD COLUMN lot_id INT REFERENCES credit_lots(id);
+
+ALTER TABLE plans ADD COLUMN credit_validity_days INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_credit_transactions_user_created_at;
+
+DROP TABLE IF EXISTS invoices;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('order', 'renewal', 'refund')),
+    reference TEXT NOT NULL DEFAULT '',
+    currency TEXT NOT NULL DEFAULT '',
+    subtotal INT NOT NULL DEFAULT 0,
+    tax INT NOT NULL DEFAULT 0,
+    tax_name TEXT NOT NULL DEFAULT '',
+    total INT NOT NULL DEFAULT 0,
+    line_items JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_user_created_at ON invoices(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_user_created_at ON credit_transactions(user_id, created_at);
@@ -0,0 +1,12 @@
+-- Plaintext tokens cannot be recovered from their hashes, so rolling back
+-- signs everyone out.
+DELETE FROM refresh_tokens;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+DROP INDEX IF EXISTS idx_refresh_tokens_token_hash;
+
+ALTER TABLE refresh_tokens ADD COLUMN refresh_token VARCHAR(255);
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_expires_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
+ALTER TABLE refresh_tokens DROP COLUMN token_hash;
@@ -0,0 +1,22 @@
+ALTER TABLE refresh_tokens ADD COLUMN token_hash TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN family_id INT;
+ALTER TABLE refresh_tokens ADD COLUMN family_expires_at TIMESTAMP;
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP;
+
+-- Existing tokens each start their own family and keep working until they
+-- are next rotated, but no session outlives the new 90 day default.
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(refresh_token, 'UTF8')), 'hex'),
+    family_id = id,
+    family_expires_at = LEAST(expires_at, created_at + INTERVAL '90 days'),
+    expires_at = LEAST(expires_at, created_at + INTERVAL '90 days');
+
+DELETE FROM refresh_tokens WHERE token_hash IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_expires_at SET NOT NULL;
+ALTER TABLE refresh_tokens DROP COLUMN refresh_token;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens(token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
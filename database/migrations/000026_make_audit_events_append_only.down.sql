@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS reject_audit_event_mutation();
+
+DROP INDEX IF EXISTS idx_audit_events_action;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_pending_purge;
+ALTER TABLE users DROP COLUMN IF EXISTS purged_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN purged_at TIMESTAMP;
+
+-- Accounts deleted before this migration start their grace period now.
+UPDATE users SET deleted_at = NOW() WHERE account_status = 'deleted';
+
+CREATE INDEX IF NOT EXISTS idx_users_pending_purge ON users(deleted_at) WHERE purged_at IS NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS pre_deletion_subscription_status;
+ALTER TABLE users DROP COLUMN IF EXISTS pre_deletion_subscription_id;
+
+DROP TABLE IF EXISTS email_change_requests;
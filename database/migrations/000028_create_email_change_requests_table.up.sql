@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    old_email TEXT NOT NULL,
+    new_email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    confirmed_at TIMESTAMP,
+    cancelled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_change_requests_user_id ON email_change_requests(user_id);
+
+-- Deleting an account keeps what is needed to restore it until it is purged.
+ALTER TABLE users ADD COLUMN pre_deletion_subscription_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN pre_deletion_subscription_status TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE credit_transactions DROP COLUMN IF EXISTS idempotency_key;
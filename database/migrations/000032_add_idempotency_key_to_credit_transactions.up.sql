@@ -0,0 +1 @@
+ALTER TABLE credit_transactions ADD COLUMN idempotency_key TEXT UNIQUE;
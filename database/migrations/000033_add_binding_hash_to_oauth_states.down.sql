@@ -0,0 +1 @@
+ALTER TABLE oauth_states DROP COLUMN binding_hash;
@@ -0,0 +1 @@
+ALTER TABLE oauth_states ADD COLUMN binding_hash TEXT NOT NULL DEFAULT '';
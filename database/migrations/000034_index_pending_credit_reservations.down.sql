@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_credit_reservations_pending;
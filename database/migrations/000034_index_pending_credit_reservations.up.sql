@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_credit_reservations_pending ON credit_reservations(created_at) WHERE status = 'pending';
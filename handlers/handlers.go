@@ -1,22 +1,37 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/michaelboegner/interviewer/apikey"
+	"github.com/michaelboegner/interviewer/audit"
 	"github.com/michaelboegner/interviewer/billing"
 	"github.com/michaelboegner/interviewer/chatgpt"
 	"github.com/michaelboegner/interviewer/conversation"
 	"github.com/michaelboegner/interviewer/dashboard"
+	"github.com/michaelboegner/interviewer/identity"
 	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/ledger"
+	"github.com/michaelboegner/interviewer/mailer"
+	"github.com/michaelboegner/interviewer/mfa"
 	"github.com/michaelboegner/interviewer/middleware"
+	"github.com/michaelboegner/interviewer/notification"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/passkey"
+	"github.com/michaelboegner/interviewer/privacy"
+	"github.com/michaelboegner/interviewer/promotion"
+	"github.com/michaelboegner/interviewer/referral"
+	"github.com/michaelboegner/interviewer/throttle"
 	"github.com/michaelboegner/interviewer/token"
 	"github.com/michaelboegner/interviewer/user"
 )
@@ -31,6 +46,23 @@ func (h *Handler) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("healthy"))
 }
 
+// JWKSHandler publishes the public keys that verify tokens we issue.
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	keys, err := token.Keys()
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	RespondWithJSON(w, http.StatusOK, keys.JWKS())
+}
+
 func (h *Handler) RequestVerificationHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -38,9 +70,11 @@ func (h *Handler) RequestVerificationHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	var req struct {
-		Email    string `json:"email"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		ReferralCode string `json:"referral_code"`
+		Locale       string `json:"locale"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
@@ -52,7 +86,13 @@ func (h *Handler) RequestVerificationHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	verificationJWT, err := user.VerificationToken(req.Email, req.Username, req.Password)
+	if wait, err := throttle.Allow(h.Throttle, throttle.Verification, req.Email, ClientIP(r)); err != nil {
+		RespondThrottled(w, wait, err)
+		return
+	}
+
+	locale := RequestLocale(r, req.Locale)
+	verificationJWT, err := user.VerificationToken(req.Email, req.Username, req.Password, req.ReferralCode, locale)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to create token")
 		return
@@ -60,10 +100,17 @@ func (h *Handler) RequestVerificationHandler(w http.ResponseWriter, r *http.Requ
 
 	verifyURL := os.Getenv("FRONTEND_URL") + "verify-email?token=" + verificationJWT
 
-	go func(email, url string) {
-		if err := h.Mailer.SendVerificationEmail(email, url); err != nil {
-		}
-	}(req.Email, verifyURL)
+	_, err = h.OutboxRepo.Enqueue(&outbox.Email{
+		Key:       tokenKey("verification", verificationJWT),
+		Recipient: req.Email,
+		Locale:    locale,
+		Template:  "verification",
+		Data:      map[string]any{"VerifyURL": verifyURL},
+	})
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
 
 	payload := &ReturnVals{
 		Message: "Verification email sent",
@@ -130,10 +177,9 @@ func (h *Handler) CreateUsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func(email string) {
-		if err := h.Mailer.SendWelcome(email); err != nil {
-		}
-	}(userCreated.Email)
+	if userCreated.ReferredByCode != "" {
+		_ = referral.Attribute(h.ReferralRepo, h.UserRepo, userCreated.ReferredByCode, userCreated)
+	}
 
 	payload := &ReturnVals{
 		UserID:   userCreated.ID,
@@ -176,11 +222,109 @@ func (h *Handler) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
 		UserID:   userReturned.ID,
 		Username: userReturned.Username,
 		Email:    userReturned.Email,
+		Locale:   userReturned.Locale,
 	}
 
 	RespondWithJSON(w, http.StatusOK, payload)
 }
 
+// UpdateLocaleHandler sets the language the user's emails are sent in.
+func (h *Handler) UpdateLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var body struct {
+		Locale string `json:"locale"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	locale, ok := mailer.NormalizeLocale(body.Locale)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Unsupported locale, expected one of: "+strings.Join(mailer.Locales(), ", "))
+		return
+	}
+
+	if err := h.UserRepo.UpdateLocale(userID, locale); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to update locale")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, &ReturnVals{UserID: userID, Locale: locale})
+}
+
+// NotificationPreferencesHandler reads and updates which lifecycle email
+// categories the user receives. PUT takes a partial map of category to
+// enabled.
+func (h *Handler) NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		preferences, err := notification.GetPreferences(h.NotificationRepo, userID)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to load notification preferences")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, preferences)
+	case http.MethodPut:
+		var changes map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		preferences, err := notification.UpdatePreferences(h.NotificationRepo, userID, changes)
+		if err != nil {
+			if errors.Is(err, notification.ErrUnknownCategory) {
+				RespondWithError(w, http.StatusBadRequest, "Unknown category, expected one of: "+strings.Join(notification.Categories, ", "))
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to update notification preferences")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, preferences)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// UnsubscribeHandler turns off the category named by a signed unsubscribe
+// link. The token is read from the query string so mail clients can POST to
+// the List-Unsubscribe URL as is (RFC 8058).
+func (h *Handler) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	category, err := notification.Unsubscribe(h.NotificationRepo, r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, notification.ErrInvalidUnsubscribe) {
+			RespondWithError(w, http.StatusBadRequest, "Invalid unsubscribe link")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, &ReturnVals{Status: "unsubscribed", Message: category})
+}
+
 func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -204,31 +348,21 @@ func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userReturned, err := user.GetUser(h.UserRepo, userID)
-	if err != nil {
+	if _, err := user.GetUser(h.UserRepo, userID); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to find user")
 		return
 	}
 
-	err = h.Billing.CancelSubscription(h.UserRepo, userReturned.Email)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
-		return
-	}
-
-	err = user.MarkUserDeleted(h.UserRepo, userID)
+	// MarkUserDeleted also cancels the subscription, remembering it so the
+	// account can be restored within the grace period, and queues the
+	// deletion confirmation.
+	err = user.MarkUserDeleted(h.UserRepo, h.AuditRepo, userID, AuditOrigin(r))
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to delete user")
 		return
 	}
 
-	err = token.DeleteRefreshToken(h.TokenRepo, userID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
-		return
-	}
-
-	err = h.Mailer.SendDeletionConfirmation(userReturned.Email)
+	err = token.RevokeAllSessions(h.TokenRepo, userID)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
 		return
@@ -237,541 +371,727 @@ func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 }
 
-func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+func (h *Handler) ExportDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	params := &middleware.AcceptedVals{}
-	err := json.NewDecoder(r.Body).Decode(params)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-
-	if params.Email == "" || params.Password == "" {
-		RespondWithError(w, http.StatusBadRequest, "Authentication failed.")
+	if _, impersonated := r.Context().Value(middleware.ContextKeyActorID).(int); impersonated {
+		RespondWithError(w, http.StatusForbidden, "Data exports are not available to impersonated sessions")
 		return
-
 	}
 
-	jwToken, username, userID, err := user.LoginUser(h.UserRepo, params.Email, params.Password)
+	export, err := privacy.BuildExport(h.PrivacyRepo, h.UserRepo, h.BillingRepo, userID)
 	if err != nil {
-		if errors.Is(err, user.ErrAccountDeleted) {
-			RespondWithError(w, http.StatusUnauthorized, user.ErrAccountDeleted.Error())
-			return
-		}
-		RespondWithError(w, http.StatusUnauthorized, "Authentication failed.")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to export data")
 		return
 	}
 
-	refreshToken, err := token.CreateRefreshToken(h.TokenRepo, userID)
-	if err != nil {
-		RespondWithError(w, http.StatusUnauthorized, "")
+	// The archive is built in memory so a failure can still be reported as
+	// JSON rather than a truncated download.
+	var archive bytes.Buffer
+	if err := privacy.WriteArchive(&archive, export); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to export data")
 		return
 	}
 
-	payload := ReturnVals{
-		UserID:       userID,
-		Username:     username,
-		JWToken:      jwToken,
-		RefreshToken: refreshToken,
-	}
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionDataExported, userID, nil)
 
-	RespondWithJSON(w, http.StatusOK, payload)
+	filename := fmt.Sprintf("interviewer-export-%d-%s.zip", userID, export.GeneratedAt.Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive.Bytes())
 }
 
-func (h *Handler) GithubLoginHandler(w http.ResponseWriter, r *http.Request) {
+// ChangeEmailHandler starts an email change. Nothing changes until the
+// link sent to the new address is followed; the old address is told and
+// can cancel.
+func (h *Handler) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	var body struct {
-		Code string `json:"code"`
+		NewEmail string `json:"new_email"`
+		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	data := url.Values{
-		"client_id":     {os.Getenv("GITHUB_CLIENT_ID")},
-		"client_secret": {os.Getenv("GITHUB_CLIENT_SECRET")},
-		"code":          {body.Code},
+	if wait, err := throttle.Allow(h.Throttle, throttle.Verification, body.NewEmail, ClientIP(r)); err != nil {
+		RespondThrottled(w, wait, err)
+		return
+	}
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	notices := func(change *user.EmailChange, confirmToken, cancelToken string) []*outbox.Email {
+		return []*outbox.Email{
+			{
+				Key:       fmt.Sprintf("email_change_confirmation:%d", change.ID),
+				Recipient: change.NewEmail,
+				Template:  "email_change_confirmation",
+				Data:      map[string]any{"ConfirmURL": frontendURL + "confirm-email-change?token=" + confirmToken},
+			},
+			{
+				Key:       fmt.Sprintf("email_change_notice:%d", change.ID),
+				Recipient: change.OldEmail,
+				Template:  "email_change_notice",
+				Data:      map[string]any{"NewEmail": change.NewEmail, "CancelURL": frontendURL + "cancel-email-change?token=" + cancelToken},
+			},
+		}
 	}
 
-	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
+	_, _, _, err := user.RequestEmailChange(h.UserRepo, h.AuditRepo, userID, body.NewEmail, body.Password, AuditOrigin(r), notices)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to create request")
+		switch {
+		case errors.Is(err, user.ErrInvalidEmail):
+			RespondWithError(w, http.StatusBadRequest, "Invalid email address")
+		case errors.Is(err, user.ErrSameEmail):
+			RespondWithError(w, http.StatusBadRequest, "New email matches the current one")
+		case errors.Is(err, user.ErrReauthenticateFail):
+			RespondWithError(w, http.StatusUnauthorized, "Re-authentication failed")
+		case errors.Is(err, user.ErrDuplicateEmail):
+			RespondWithError(w, http.StatusConflict, "Email already in use")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to start email change")
+		}
 		return
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "GitHub token exchange failed")
+	RespondWithJSON(w, http.StatusAccepted, map[string]string{"message": "Confirmation sent to the new address"})
+}
+
+func (h *Handler) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	defer resp.Body.Close()
 
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
+	var body struct {
+		Token string `json:"token"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Token parse failed")
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	client = &http.Client{}
-	req, err = http.NewRequest("GET", "https://api.github.com/user", nil)
+	change, err := user.ConfirmEmailChange(h.UserRepo, h.AuditRepo, body.Token, AuditOrigin(r))
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		switch {
+		case errors.Is(err, user.ErrEmailChangeInvalid):
+			RespondWithError(w, http.StatusBadRequest, "Invalid or expired link")
+		case errors.Is(err, user.ErrDuplicateEmail):
+			RespondWithError(w, http.StatusConflict, "Email already in use")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to change email")
+		}
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+tokenResp.AccessToken)
-	githubResp, err := client.Do(req)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+
+	RespondWithJSON(w, http.StatusOK, ReturnVals{UserID: change.UserID, Email: change.NewEmail})
+}
+
+func (h *Handler) CancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	defer githubResp.Body.Close()
 
-	var githubUser struct {
-		Email string `json:"email"`
-		Login string `json:"login"`
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
-	json.NewDecoder(githubResp.Body).Decode(&githubUser)
 
-	if githubUser.Email == "" {
-		req, err := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Internal server error")
-			return
-		}
-		req.Header.Set("Authorization", "Bearer "+tokenResp.AccessToken)
-		emailResp, err := client.Do(req)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+	if _, err := user.CancelEmailChange(h.UserRepo, h.AuditRepo, body.Token, AuditOrigin(r)); err != nil {
+		if errors.Is(err, user.ErrEmailChangeInvalid) {
+			RespondWithError(w, http.StatusBadRequest, "Invalid link, or the change was already confirmed")
 			return
 		}
-		defer emailResp.Body.Close()
-
-		var emails []struct {
-			Email    string `json:"email"`
-			Primary  bool   `json:"primary"`
-			Verified bool   `json:"verified"`
-		}
-		json.NewDecoder(emailResp.Body).Decode(&emails)
-
-		for _, e := range emails {
-			if e.Primary && e.Verified {
-				githubUser.Email = e.Email
-				break
-			}
-		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to cancel email change")
+		return
 	}
 
-	if githubUser.Email == "" {
-		RespondWithError(w, http.StatusUnauthorized, "We couldn’t retrieve a valid email address from GitHub. Please check your GitHub email settings and try again.")
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Email change cancelled"})
+}
+
+// RequestReactivationHandler emails a restore link to a deleted account
+// that is still within its grace period. It answers the same way whether
+// or not such an account exists.
+func (h *Handler) RequestReactivationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	user, err := user.GetOrCreateByEmail(h.UserRepo, githubUser.Email, githubUser.Login)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "User creation failed")
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		RespondWithError(w, http.StatusBadRequest, "Email required")
 		return
 	}
 
-	jwt, err := token.CreateJWT(strconv.Itoa(user.ID), 0)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+	if wait, err := throttle.Allow(h.Throttle, throttle.PasswordReset, body.Email, ClientIP(r)); err != nil {
+		RespondThrottled(w, wait, err)
 		return
 	}
-	refreshToken, err := token.CreateRefreshToken(h.TokenRepo, user.ID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
-		return
+
+	account, reactivationJWT, err := user.RequestReactivation(h.UserRepo, body.Email)
+	if err == nil {
+		reactivateURL := os.Getenv("FRONTEND_URL") + "reactivate-account?token=" + reactivationJWT
+		_, _ = h.OutboxRepo.Enqueue(&outbox.Email{
+			Key:       tokenKey("reactivation_link", reactivationJWT),
+			UserID:    account.ID,
+			Recipient: body.Email,
+			Locale:    account.Locale,
+			Template:  "reactivation_link",
+			Data:      map[string]any{"ReactivateURL": reactivateURL},
+		})
 	}
 
-	RespondWithJSON(w, http.StatusOK, map[string]any{
-		"userID":       user.ID,
-		"username":     user.Username,
-		"jwt":          jwt,
-		"refreshToken": refreshToken,
-	})
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "If the account can be restored, a link has been sent"})
 }
 
-func (h *Handler) RefreshTokensHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ReactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-		RespondWithError(w, http.StatusUnauthorized, "Missing or invalid Authorization header")
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-	providedToken := strings.TrimPrefix(authHeader, "Bearer ")
-	params := &middleware.AcceptedVals{}
 
-	err := json.NewDecoder(r.Body).Decode(params)
+	restored, err := user.ReactivateAccount(h.UserRepo, h.AuditRepo, body.Token, AuditOrigin(r))
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		switch {
+		case errors.Is(err, user.ErrNotRestorable):
+			RespondWithError(w, http.StatusBadRequest, "Invalid or expired link")
+		case errors.Is(err, user.ErrDuplicateEmail):
+			RespondWithError(w, http.StatusConflict, "The account's email address now belongs to another account")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to restore account")
+		}
 		return
 	}
 
-	if params.UserID == 0 {
-		RespondWithError(w, http.StatusBadRequest, "Authentication failed")
+	RespondWithJSON(w, http.StatusOK, ReturnVals{UserID: restored.ID, Username: restored.Username, Email: restored.Email})
+}
+
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	storedToken, err := token.GetStoredRefreshToken(h.TokenRepo, params.UserID)
+	params := &middleware.AcceptedVals{}
+	err := json.NewDecoder(r.Body).Decode(params)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid user_id")
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	ok := token.VerifyRefreshToken(storedToken, providedToken)
-	if !ok {
-		RespondWithError(w, http.StatusUnauthorized, "Refresh token is invalid")
+	if params.Email == "" || params.Password == "" {
+		RespondWithError(w, http.StatusBadRequest, "Authentication failed.")
 		return
+
 	}
 
-	refreshToken, err := token.CreateRefreshToken(h.TokenRepo, params.UserID)
-	if err != nil {
-		RespondWithError(w, http.StatusUnauthorized, "")
+	ip := ClientIP(r)
+	if wait, err := throttle.Check(h.Throttle, throttle.Login, params.Email, ip); err != nil {
+		RespondThrottled(w, wait, err)
 		return
 	}
 
-	user, err := h.UserRepo.GetUser(params.UserID)
+	account, err := user.Authenticate(h.UserRepo, params.Email, params.Password)
 	if err != nil {
-		RespondWithError(w, http.StatusUnauthorized, "Account deactivated")
+		targetID := 0
+		if existing, err := h.UserRepo.GetUserByEmail(params.Email); err == nil {
+			targetID = existing.ID
+		}
+		if errors.Is(err, user.ErrAccountDeleted) {
+			h.recordLoginFailure(r, targetID, "password", "account_inactive")
+			RespondWithError(w, http.StatusUnauthorized, user.ErrAccountDeleted.Error())
+			return
+		}
+		h.recordLoginFailure(r, targetID, "password", "invalid_credentials")
+		locked, _ := throttle.Fail(h.Throttle, throttle.Login, params.Email, ip)
+		if locked {
+			if targetID != 0 {
+				audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionLoginLocked, targetID, map[string]any{"minutes": int(throttle.Login.Lockout.Minutes())})
+			}
+			go h.notifyLockout(params.Email)
+		}
+		RespondWithError(w, http.StatusUnauthorized, "Authentication failed.")
 		return
 	}
-	if user.AccountStatus == "deleted" {
-		RespondWithError(w, http.StatusUnauthorized, "Account deactivated")
+	_ = throttle.Succeed(h.Throttle, throttle.Login, params.Email)
+
+	if h.respondWithMFAChallenge(w, account.ID) {
 		return
 	}
 
-	jwToken, err := token.CreateJWT(strconv.Itoa(params.UserID), 0)
+	h.respondWithLogin(w, r, account.ID, account.Username, params.DeviceLabel, "password")
+}
+
+// notifyLockout tells the owner of a real account that sign-in was locked.
+func (h *Handler) notifyLockout(email string) {
+	account, err := h.UserRepo.GetUserByEmail(email)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "")
 		return
 	}
+	lockedUntil := time.Now().UTC().Add(throttle.Login.Lockout)
+	_, _ = h.OutboxRepo.Enqueue(&outbox.Email{
+		Key:       fmt.Sprintf("account_locked:%d:%d", account.ID, lockedUntil.Unix()),
+		UserID:    account.ID,
+		Recipient: account.Email,
+		Locale:    account.Locale,
+		Template:  "account_locked",
+		Data:      map[string]any{"LockedUntil": lockedUntil},
+	})
+}
 
-	payload := &ReturnVals{
-		ID:           params.UserID,
-		JWToken:      jwToken,
-		RefreshToken: refreshToken,
-	}
-	RespondWithJSON(w, http.StatusOK, payload)
+// recordLoginFailure audits a failed login. userID is the account that was
+// targeted, or 0 when it is unknown.
+func (h *Handler) recordLoginFailure(r *http.Request, userID int, method, reason string) {
+	_ = audit.Record(h.AuditRepo, audit.Event{
+		Action:       audit.ActionLoginFailed,
+		TargetUserID: userID,
+		IPAddress:    ClientIP(r),
+		UserAgent:    r.UserAgent(),
+		Metadata:     map[string]any{"method": method, "reason": reason},
+	})
 }
 
-func (h *Handler) InterviewsHandler(w http.ResponseWriter, r *http.Request) {
+// MFALoginHandler completes a login that needed a second factor, exchanging
+// the challenge token from the first step and a TOTP or recovery code for a
+// session.
+func (h *Handler) MFALoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
-	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid userID")
+	var body struct {
+		MFAToken    string `json:"mfa_token"`
+		Code        string `json:"code"`
+		DeviceLabel string `json:"device_label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	params := &middleware.AcceptedVals{}
-	err := json.NewDecoder(r.Body).Decode(params)
+	userID, err := mfa.CompleteChallenge(h.MFARepo, body.MFAToken, body.Code)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		if userID != 0 {
+			h.recordLoginFailure(r, userID, "mfa", "invalid_code")
+		}
+		switch {
+		case errors.Is(err, mfa.ErrInvalidChallenge):
+			RespondWithError(w, http.StatusUnauthorized, "Login expired; please sign in again")
+		case errors.Is(err, mfa.ErrLocked):
+			RespondWithError(w, http.StatusTooManyRequests, "Too many incorrect codes; try again later")
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+			RespondWithError(w, http.StatusUnauthorized, "Invalid verification code")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
 		return
 	}
 
-	userReturned, err := user.GetUser(h.UserRepo, userID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to find user")
+	account, err := h.UserRepo.GetUser(userID)
+	if err != nil || account.AccountStatus != "active" {
+		RespondWithError(w, http.StatusUnauthorized, user.ErrAccountDeleted.Error())
 		return
 	}
 
-	interviewStarted, err := interview.StartInterview(
-		h.InterviewRepo,
-		h.UserRepo,
-		h.BillingRepo,
-		h.OpenAI,
-		userReturned,
-		30,
-		3,
-		"easy",
-		params.JD)
+	h.respondWithLogin(w, r, userID, account.Username, body.DeviceLabel, "mfa")
+}
+
+// respondWithMFAChallenge answers the first login step with a challenge
+// token when the user has a second factor enabled. It reports whether it
+// wrote a response.
+func (h *Handler) respondWithMFAChallenge(w http.ResponseWriter, userID int) bool {
+	enabled, err := mfa.Enabled(h.MFARepo, userID)
 	if err != nil {
-		var openaiErr *chatgpt.OpenAIError
-		if errors.As(err, &openaiErr) {
-			RespondWithError(w, openaiErr.StatusCode, openaiErr.Message)
-			return
-		}
-		if errors.Is(err, interview.ErrNoValidCredits) {
-			RespondWithError(w, http.StatusPaymentRequired, "You do not have enough credits to start a new interview or your subscription has expired.")
-			return
-		}
-		RespondWithError(w, http.StatusInternalServerError, "Failed to start interview.")
-		return
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return true
+	}
+	if !enabled {
+		return false
 	}
 
-	conversationID, err := conversation.CreateEmptyConversation(h.ConversationRepo, interviewStarted.Id, interviewStarted.Subtopic)
+	challenge, err := mfa.CreateChallenge(userID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return true
+	}
+
+	RespondWithJSON(w, http.StatusOK, ReturnVals{
+		MFARequired: true,
+		MFAToken:    challenge,
+	})
+	return true
+}
+
+// respondWithLogin starts a session and records the successful login. method
+// is the step that completed it: password, mfa, passkey or an OAuth provider.
+func (h *Handler) respondWithLogin(w http.ResponseWriter, r *http.Request, userID int, username, deviceLabel, method string) {
+	jwToken, err := token.CreateJWT(strconv.Itoa(userID), 0)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	err = interview.LinkConversation(h.InterviewRepo, interviewStarted.Id, conversationID)
+	session, err := token.CreateRefreshToken(h.TokenRepo, userID, GetSessionInfo(r, deviceLabel))
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		RespondWithError(w, http.StatusUnauthorized, "")
 		return
 	}
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionLoginSucceeded, userID, map[string]any{
+		"method":     method,
+		"session_id": session.FamilyID,
+	})
 
 	payload := ReturnVals{
-		InterviewID:    interviewStarted.Id,
-		FirstQuestion:  interviewStarted.FirstQuestion,
-		ConversationID: conversationID,
+		UserID:       userID,
+		Username:     username,
+		JWToken:      jwToken,
+		RefreshToken: session.RefreshToken,
+		SessionID:    session.FamilyID,
 	}
 
-	RespondWithJSON(w, http.StatusCreated, payload)
+	RespondWithJSON(w, http.StatusOK, payload)
 }
 
-func (h *Handler) GetInterviewHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// PasskeyLoginBeginHandler returns the options for a passkey login.
+func (h *Handler) PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
-	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
-		return
-	}
-
-	interviewID, err := GetPathID(r, "/api/interviews/")
+	rp, err := passkey.LoadRelyingParty()
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid interview ID")
+		RespondWithError(w, http.StatusServiceUnavailable, "Passkeys are not configured")
 		return
 	}
 
-	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	options, err := passkey.BeginLogin(h.PasskeyRepo, rp)
 	if err != nil {
-		RespondWithError(w, http.StatusNotFound, "Interview not found")
-		return
-	}
-	if interviewReturned.UserId != userID {
-		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	payload := ReturnVals{
-		Interview: interviewReturned,
-	}
-
-	RespondWithJSON(w, http.StatusOK, payload)
+	RespondWithJSON(w, http.StatusOK, map[string]any{"publicKey": options})
 }
 
-func (h *Handler) UpdateInterviewStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPatch {
+// PasskeyLoginFinishHandler verifies a passkey assertion and logs the user
+// in. A passkey that did not verify the user (no PIN or biometric) still
+// needs the account's second factor.
+func (h *Handler) PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
-	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
-		return
+	var body struct {
+		Credential  passkey.AssertionResponse `json:"credential"`
+		DeviceLabel string                    `json:"device_label"`
 	}
-
-	interviewID, err := GetPathID(r, "/api/interviews/")
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid interview ID")
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	var payload struct {
-		Status string `json:"status"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || (payload.Status != "paused" && payload.Status != "active") {
-		RespondWithError(w, http.StatusBadRequest, "Invalid status")
+	rp, err := passkey.LoadRelyingParty()
+	if err != nil {
+		RespondWithError(w, http.StatusServiceUnavailable, "Passkeys are not configured")
 		return
 	}
 
-	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	assertion, err := passkey.FinishLogin(h.PasskeyRepo, rp, &body.Credential)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		if !errors.Is(err, passkey.ErrChallengeInvalid) {
+			h.recordLoginFailure(r, 0, "passkey", err.Error())
+		}
+		switch {
+		case errors.Is(err, passkey.ErrChallengeInvalid):
+			RespondWithError(w, http.StatusUnauthorized, "Passkey login expired; please try again")
+		case errors.Is(err, passkey.ErrCredentialNotFound),
+			errors.Is(err, passkey.ErrVerificationFailed),
+			errors.Is(err, passkey.ErrUnsupportedAlgorithm),
+			errors.Is(err, passkey.ErrCloneDetected):
+			RespondWithError(w, http.StatusUnauthorized, "Passkey could not be verified")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
 		return
 	}
 
-	err = ValidateInterviewStatusTransition(interviewReturned.Status, payload.Status)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid status transition")
+	account, err := h.UserRepo.GetUser(assertion.UserID)
+	if err != nil || account.AccountStatus != "active" {
+		RespondWithError(w, http.StatusUnauthorized, user.ErrAccountDeleted.Error())
 		return
 	}
 
-	err = h.InterviewRepo.UpdateStatus(interviewID, userID, payload.Status)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not update status")
+	if !assertion.UserVerified && h.respondWithMFAChallenge(w, account.ID) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	h.respondWithLogin(w, r, account.ID, account.Username, body.DeviceLabel, "passkey")
 }
 
-func (h *Handler) CreateConversationsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+func (h *Handler) PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
 	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	params := &middleware.AcceptedVals{}
-	err := json.NewDecoder(r.Body).Decode(params)
+	credentials, err := passkey.ListCredentials(h.PasskeyRepo, userID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to list passkeys")
 		return
 	}
 
-	interviewID, err := GetPathID(r, "/api/conversations/create/")
+	RespondWithJSON(w, http.StatusOK, credentials)
+}
 
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+// PasskeyHandler registers a passkey (POST /api/passkeys/register/begin and
+// /api/passkeys/register/finish) or removes one (DELETE /api/passkeys/{id}).
+func (h *Handler) PasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+	switch r.URL.Path {
+	case "/api/passkeys/register/begin":
+		if r.Method != http.MethodPost {
+			RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.beginPasskeyRegistration(w, userID)
 		return
-	}
-	if interviewReturned.UserId != userID {
-		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	case "/api/passkeys/register/finish":
+		if r.Method != http.MethodPost {
+			RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.finishPasskeyRegistration(w, r, userID)
 		return
 	}
-	if interviewReturned.Status != "active" {
-		RespondWithError(w, http.StatusConflict, "Interview is not active")
+
+	if r.Method != http.MethodDelete {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
+	credentialID, err := GetPathID(r, "/api/passkeys/")
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		RespondWithError(w, http.StatusBadRequest, "Invalid passkey ID")
 		return
 	}
 
-	conversationCreated, err := conversation.CreateConversation(
-		h.ConversationRepo,
-		h.InterviewRepo,
-		h.OpenAI,
-		conversationReturned,
-		interviewID,
-		interviewReturned.Prompt,
-		interviewReturned.FirstQuestion,
-		interviewReturned.Subtopic,
-		params.Message)
-	if err != nil {
-		var openaiErr *chatgpt.OpenAIError
-		if errors.As(err, &openaiErr) {
-			RespondWithError(w, openaiErr.StatusCode, openaiErr.Message)
+	if err := passkey.DeleteCredential(h.PasskeyRepo, userID, credentialID); err != nil {
+		if errors.Is(err, passkey.ErrCredentialNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Passkey not found")
 			return
 		}
-		RespondWithError(w, http.StatusBadRequest, "Invalid interview_id")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to remove passkey")
 		return
 	}
 
-	payload := &ReturnVals{
-		Conversation: conversationCreated,
-	}
-	RespondWithJSON(w, http.StatusCreated, payload)
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionPasskeyRemoved, userID, map[string]any{"passkey_id": credentialID})
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Passkey removed"})
 }
 
-func (h *Handler) AppendConversationsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+func (h *Handler) beginPasskeyRegistration(w http.ResponseWriter, userID int) {
+	rp, err := passkey.LoadRelyingParty()
+	if err != nil {
+		RespondWithError(w, http.StatusServiceUnavailable, "Passkeys are not configured")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
-	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+	account, err := h.UserRepo.GetUser(userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	params := &middleware.AcceptedVals{}
-	err := json.NewDecoder(r.Body).Decode(params)
+	options, err := passkey.BeginRegistration(h.PasskeyRepo, rp, account)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to start passkey registration")
 		return
 	}
 
-	if params.Message == "" {
-		RespondWithError(w, http.StatusBadRequest, "Missing message")
+	RespondWithJSON(w, http.StatusOK, map[string]any{"publicKey": options})
+}
+
+func (h *Handler) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request, userID int) {
+	var body struct {
+		Name       string                       `json:"name"`
+		Credential passkey.RegistrationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	interviewID, err := GetPathID(r, "/api/conversations/append/")
+	rp, err := passkey.LoadRelyingParty()
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+		RespondWithError(w, http.StatusServiceUnavailable, "Passkeys are not configured")
 		return
 	}
 
-	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	credential, err := passkey.FinishRegistration(h.PasskeyRepo, rp, userID, body.Name, &body.Credential)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		switch {
+		case errors.Is(err, passkey.ErrChallengeInvalid):
+			RespondWithError(w, http.StatusBadRequest, "Passkey registration expired; please try again")
+		case errors.Is(err, passkey.ErrDuplicateCredential):
+			RespondWithError(w, http.StatusConflict, "This passkey is already registered")
+		case errors.Is(err, passkey.ErrUnsupportedAlgorithm):
+			RespondWithError(w, http.StatusBadRequest, "This authenticator is not supported")
+		case errors.Is(err, passkey.ErrVerificationFailed):
+			RespondWithError(w, http.StatusBadRequest, "Passkey could not be verified")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to register passkey")
+		}
 		return
 	}
-	if interviewReturned.UserId != userID {
+
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionPasskeyAdded, userID, map[string]any{
+		"passkey_id": credential.ID,
+		"name":       credential.Name,
+	})
+	RespondWithJSON(w, http.StatusCreated, credential)
+}
+
+// APIKeysHandler lists the user's API keys (GET) or creates one (POST).
+// The key itself is only in the creation response.
+func (h *Handler) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
 		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
-	if interviewReturned.Status != "active" {
-		RespondWithError(w, http.StatusConflict, "Interview is not active")
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := apikey.List(h.APIKeyRepo, userID)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to list API keys")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, keys)
+	case http.MethodPost:
+		var body struct {
+			Name          string   `json:"name"`
+			Scopes        []string `json:"scopes"`
+			ExpiresInDays int      `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ExpiresInDays < 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		var expiresAt *time.Time
+		if body.ExpiresInDays > 0 {
+			at := time.Now().UTC().AddDate(0, 0, body.ExpiresInDays)
+			expiresAt = &at
+		}
+
+		key, err := apikey.Create(h.APIKeyRepo, userID, body.Name, body.Scopes, expiresAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, apikey.ErrNameRequired):
+				RespondWithError(w, http.StatusBadRequest, "Name is required")
+			case errors.Is(err, apikey.ErrInvalidScope):
+				RespondWithError(w, http.StatusBadRequest, "Choose at least one of: "+strings.Join(apikey.Scopes, ", "))
+			case errors.Is(err, apikey.ErrTooManyKeys):
+				RespondWithError(w, http.StatusConflict, "Revoke an existing API key before creating another")
+			default:
+				RespondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+			}
+			return
+		}
+		audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionAPIKeyCreated, userID, map[string]any{
+			"api_key_id": key.ID,
+			"prefix":     key.Prefix,
+			"scopes":     key.Scopes,
+		})
+		RespondWithJSON(w, http.StatusCreated, key)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// APIKeyHandler revokes one API key (DELETE /api/apikeys/{id}).
+func (h *Handler) APIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	conversationReturned, err = conversation.AppendConversation(
-		h.ConversationRepo,
-		h.InterviewRepo,
-		h.OpenAI,
-		interviewID,
-		userID,
-		conversationReturned,
-		params.Message,
-		interviewReturned.Prompt)
+	keyID, err := GetPathID(r, "/api/apikeys/")
 	if err != nil {
-		var openaiErr *chatgpt.OpenAIError
-		if errors.As(err, &openaiErr) {
-			RespondWithError(w, openaiErr.StatusCode, openaiErr.Message)
+		RespondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := apikey.Revoke(h.APIKeyRepo, userID, keyID); err != nil {
+		if errors.Is(err, apikey.ErrAPIKeyNotFound) {
+			RespondWithError(w, http.StatusNotFound, "API key not found")
 			return
 		}
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
 		return
 	}
 
-	payload := &ReturnVals{
-		Conversation: conversationReturned,
-	}
-	RespondWithJSON(w, http.StatusCreated, payload)
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionAPIKeyRevoked, userID, map[string]any{"api_key_id": keyID})
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "API key revoked"})
 }
 
-func (h *Handler) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) MFAStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
@@ -779,432 +1099,1921 @@ func (h *Handler) GetConversationHandler(w http.ResponseWriter, r *http.Request)
 
 	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
 	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	interviewID, err := GetPathID(r, "/api/conversations/")
+	status, err := mfa.GetStatus(h.MFARepo, userID)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load two-factor status")
 		return
 	}
 
-	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+	RespondWithJSON(w, http.StatusOK, status)
+}
+
+// EnrollTOTPHandler starts authenticator app setup and returns the secret
+// and otpauth:// provisioning URI to show as a QR code.
+func (h *Handler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	if interviewReturned.UserId != userID {
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
 		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
+	account, err := h.UserRepo.GetUser(userID)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
 
-	payload := &ReturnVals{
-		Conversation: conversationReturned,
+	enrollment, err := mfa.Enroll(h.MFARepo, userID, account.Email)
+	if err != nil {
+		if errors.Is(err, mfa.ErrAlreadyEnabled) {
+			RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to start two-factor setup")
+		return
 	}
-	RespondWithJSON(w, http.StatusOK, payload)
+
+	RespondWithJSON(w, http.StatusOK, enrollment)
 }
 
-func (h *Handler) RequestResetHandler(w http.ResponseWriter, r *http.Request) {
+// ConfirmTOTPHandler enables TOTP after the user enters a code from their
+// app and returns the one-time recovery codes.
+func (h *Handler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	var params PasswordResetRequest
-	err := json.NewDecoder(r.Body).Decode(&params)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	resetJWT, err := user.RequestPasswordReset(h.UserRepo, params.Email)
-	if err != nil {
-		w.WriteHeader(http.StatusOK)
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	resetURL := frontendURL + "reset-password?token=" + resetJWT
-
-	go func(email, resetURL string) {
-		err := h.Mailer.SendPasswordReset(email, resetURL)
-		if err != nil {
-			return
+	codes, err := mfa.ConfirmEnrollment(h.MFARepo, userID, body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrNotEnrolled):
+			RespondWithError(w, http.StatusBadRequest, "Start two-factor setup first")
+		case errors.Is(err, mfa.ErrAlreadyEnabled):
+			RespondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		case errors.Is(err, mfa.ErrInvalidCode):
+			RespondWithError(w, http.StatusBadRequest, "Invalid verification code")
+		case errors.Is(err, mfa.ErrLocked):
+			RespondWithError(w, http.StatusTooManyRequests, "Too many incorrect codes; try again later")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
 		}
-	}(params.Email, resetURL)
+		return
+	}
 
-	payload := ReturnVals{}
-	RespondWithJSON(w, http.StatusOK, payload)
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionMFAEnabled, userID, nil)
+	RespondWithJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
 }
 
-func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// DisableTOTPHandler turns TOTP off. The user must re-enter their password
+// (if they have one) and a current code.
+func (h *Handler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	var params PasswordResetPayload
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-	err := user.ResetPassword(h.UserRepo, params.NewPassword, params.Token)
-	if err != nil {
-		RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+
+	if err := mfa.Disable(h.MFARepo, h.UserRepo, userID, body.Password, body.Code); err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrNotEnrolled):
+			RespondWithError(w, http.StatusNotFound, "Two-factor authentication is not enabled")
+		case errors.Is(err, mfa.ErrReauthenticateFail), errors.Is(err, mfa.ErrInvalidCode):
+			RespondWithError(w, http.StatusUnauthorized, "Re-authentication failed")
+		case errors.Is(err, mfa.ErrLocked):
+			RespondWithError(w, http.StatusTooManyRequests, "Too many incorrect codes; try again later")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		}
 		return
 	}
 
-	payload := ReturnVals{}
-	RespondWithJSON(w, http.StatusOK, payload)
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionMFADisabled, userID, nil)
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
 }
 
-func (h *Handler) CreateCheckoutSessionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// AdminDisableMFAHandler removes a user's second factor, e.g. after they
+// lose their device. The admin confirms with their own password.
+func (h *Handler) AdminDisableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	adminID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
 	if !ok {
 		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	var params CheckoutRequest
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Tier == "" {
-		RespondWithError(w, http.StatusBadRequest, "Missing or invalid tier")
+	userID, err := GetPathID(r, "/api/admin/mfa/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := user.GetUser(h.UserRepo, userID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not find user")
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	var priceID string
-	switch params.Tier {
-	case "individual":
-		priceID = os.Getenv("LEMON_VARIANT_ID_INDIVIDUAL")
-	case "pro":
-		priceID = os.Getenv("LEMON_VARIANT_ID_PRO")
-	case "premium":
-		priceID = os.Getenv("LEMON_VARIANT_ID_PREMIUM")
-	default:
-		RespondWithError(w, http.StatusBadRequest, "Invalid tier selected")
+	if err := mfa.AdminDisable(h.MFARepo, h.UserRepo, adminID, body.Password, userID); err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrNotEnrolled):
+			RespondWithError(w, http.StatusNotFound, "Two-factor authentication is not set up for this user")
+		case errors.Is(err, mfa.ErrReauthenticateFail):
+			RespondWithError(w, http.StatusUnauthorized, "Re-authentication failed")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		}
 		return
 	}
 
-	priceIDInt, err := strconv.Atoi(priceID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+	h.recordAdminAction(r, audit.ActionAdminResetMFA, userID, nil)
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// GithubLoginHandler completes GitHub's OAuth flow for clients that obtain
+// the code themselves. New clients should use the provider-agnostic
+// /api/auth/oauth endpoints, which add state and PKCE.
+func (h *Handler) GithubLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		Code        string `json:"code"`
+		DeviceLabel string `json:"device_label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	provider, ok := h.Providers["github"]
+	if !ok {
+		RespondWithError(w, http.StatusNotFound, "GitHub login is not configured")
 		return
 	}
 
-	url, err := h.Billing.RequestCheckoutSession(user.Email, priceIDInt)
+	claims, err := identity.Authenticate(provider, body.Code, "", "", "")
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not start checkout")
+		RespondWithError(w, http.StatusInternalServerError, "GitHub token exchange failed")
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, CheckoutResponse{CheckoutURL: url})
+	h.completeExternalLogin(w, r, provider, claims, body.DeviceLabel)
 }
 
-func (h *Handler) CancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+func (h *Handler) OAuthProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
-	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+	providers := []map[string]string{}
+	for _, provider := range h.Providers.List() {
+		providers = append(providers, map[string]string{
+			"name":         provider.Name,
+			"display_name": provider.DisplayName,
+		})
+	}
+
+	RespondWithJSON(w, http.StatusOK, providers)
+}
+
+// OAuthStartHandler returns the provider URL to send the browser to for a
+// login.
+func (h *Handler) OAuthStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userReturned, err := user.GetUser(h.UserRepo, userID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user")
+	h.startOAuth(w, r, 0)
+}
+
+// OAuthCallbackHandler receives the code and state the provider redirected
+// back with, plus the binding the start call returned, and logs in. Links
+// are completed at /api/identities/link/callback by the user who started
+// them.
+func (h *Handler) OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	err = h.Billing.RequestDeleteSubscription(userReturned.SubscriptionID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not cancel subscription")
+	authState, claims, body, ok := h.completeOAuth(w, r, 0)
+	if !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	h.completeExternalLogin(w, r, h.Providers[authState.Provider], claims, body.DeviceLabel)
 }
 
-func (h *Handler) ResumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// IdentitiesHandler lists the providers linked to the user's account.
+func (h *Handler) IdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
 	if !ok {
-		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	userReturned, err := user.GetUser(h.UserRepo, userID)
+	identities, err := identity.ListIdentities(h.IdentityRepo, userID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to list linked accounts")
 		return
 	}
 
-	err = h.Billing.RequestResumeSubscription(userReturned.SubscriptionID)
+	RespondWithJSON(w, http.StatusOK, identities)
+}
+
+// IdentityHandler starts linking a provider (POST /api/identities/link),
+// completes the link (POST /api/identities/link/callback) or unlinks one
+// (DELETE /api/identities/{id}).
+func (h *Handler) IdentityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if r.URL.Path == "/api/identities/link" {
+		if r.Method != http.MethodPost {
+			RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.startOAuth(w, r, userID)
+		return
+	}
+
+	if r.URL.Path == "/api/identities/link/callback" {
+		if r.Method != http.MethodPost {
+			RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		authState, claims, _, ok := h.completeOAuth(w, r, userID)
+		if !ok {
+			return
+		}
+		linked, err := identity.Link(h.IdentityRepo, userID, authState.Provider, claims)
+		if err != nil {
+			if errors.Is(err, identity.ErrIdentityLinked) {
+				RespondWithError(w, http.StatusConflict, "This account is already linked to another user")
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to link account")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, linked)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	identityID, err := GetPathID(r, "/api/identities/")
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not cancel subscription")
+		RespondWithError(w, http.StatusBadRequest, "Invalid identity ID")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if err := identity.Unlink(h.IdentityRepo, h.UserRepo, userID, identityID); err != nil {
+		switch {
+		case errors.Is(err, identity.ErrIdentityNotFound):
+			RespondWithError(w, http.StatusNotFound, "Linked account not found")
+		case errors.Is(err, identity.ErrLastLoginMethod):
+			RespondWithError(w, http.StatusConflict, "Set a password before unlinking your only sign-in method")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to unlink account")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Account unlinked"})
 }
 
-func (h *Handler) ChangePlanHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+func (h *Handler) startOAuth(w http.ResponseWriter, r *http.Request, userID int) {
+	var body struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	provider, ok := h.Providers[body.Provider]
 	if !ok {
-		RespondWithError(w, http.StatusUnauthorized, "Invalid user ID")
+		RespondWithError(w, http.StatusNotFound, "Unknown login provider")
 		return
 	}
 
-	var params CheckoutRequest
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Tier == "" {
-		RespondWithError(w, http.StatusBadRequest, "Missing or invalid tier")
+	authURL, binding, err := identity.StartAuth(h.IdentityRepo, provider, userID, identity.RedirectURL())
+	if err != nil {
+		RespondWithError(w, http.StatusBadGateway, "Login provider is unavailable")
 		return
 	}
 
-	user, err := user.GetUser(h.UserRepo, userID)
+	RespondWithJSON(w, http.StatusOK, map[string]string{"authorization_url": authURL, "binding": binding})
+}
+
+type oauthCallbackRequest struct {
+	State       string `json:"state"`
+	Code        string `json:"code"`
+	Binding     string `json:"binding"`
+	DeviceLabel string `json:"device_label"`
+}
+
+// completeOAuth finishes the authorization request in the body for userID,
+// or for a login when userID is 0, and responds itself when it fails.
+func (h *Handler) completeOAuth(w http.ResponseWriter, r *http.Request, userID int) (*identity.AuthState, *identity.Claims, *oauthCallbackRequest, bool) {
+	var body oauthCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, nil, nil, false
+	}
+
+	authState, claims, err := identity.CompleteAuth(h.IdentityRepo, h.Providers, body.State, body.Code, body.Binding, userID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Could not find user")
+		switch {
+		case errors.Is(err, identity.ErrStateInvalid):
+			RespondWithError(w, http.StatusBadRequest, "Login request expired or was already used; please try again")
+		case errors.Is(err, identity.ErrStateUserMismatch):
+			RespondWithError(w, http.StatusForbidden, "This request was not started by the signed-in account")
+		case errors.Is(err, identity.ErrProviderNotFound):
+			RespondWithError(w, http.StatusNotFound, "Unknown login provider")
+		case errors.Is(err, identity.ErrProviderExchange), errors.Is(err, identity.ErrInvalidIDToken):
+			RespondWithError(w, http.StatusUnauthorized, "The provider did not confirm your identity")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return nil, nil, nil, false
+	}
+
+	return authState, claims, &body, true
+}
+
+func (h *Handler) completeExternalLogin(w http.ResponseWriter, r *http.Request, provider *identity.Provider, claims *identity.Claims, deviceLabel string) {
+	account, err := identity.Login(h.IdentityRepo, h.UserRepo, provider.Name, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, identity.ErrEmailRequired):
+			RespondWithError(w, http.StatusUnauthorized, fmt.Sprintf("We couldn’t retrieve a valid email address from %s. Please check your %s email settings and try again.", provider.DisplayName, provider.DisplayName))
+		case errors.Is(err, identity.ErrEmailNotVerified):
+			RespondWithError(w, http.StatusConflict, provider.DisplayName+" has not verified this email address. Verify it with "+provider.DisplayName+", or log in and link "+provider.DisplayName+" from your account settings.")
+		case errors.Is(err, user.ErrAccountDeleted):
+			RespondWithError(w, http.StatusUnauthorized, "Account deactivated")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "User creation failed")
+		}
 		return
 	}
 
-	var priceID string
-	switch params.Tier {
-	case "pro":
-		priceID = os.Getenv("LEMON_VARIANT_ID_PRO")
-	case "premium":
-		priceID = os.Getenv("LEMON_VARIANT_ID_PREMIUM")
-	default:
-		RespondWithError(w, http.StatusBadRequest, "Invalid tier")
+	if h.respondWithMFAChallenge(w, account.ID) {
 		return
 	}
 
-	priceIDInt, err := strconv.Atoi(priceID)
+	jwt, err := token.CreateJWT(strconv.Itoa(account.ID), 0)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-
-	if err := h.Billing.RequestUpdateSubscriptionVariant(user.SubscriptionID, priceIDInt); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update subscription")
+	session, err := token.CreateRefreshToken(h.TokenRepo, account.ID, GetSessionInfo(r, deviceLabel))
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	audit.RecordUserAction(h.AuditRepo, AuditOrigin(r), audit.ActionLoginSucceeded, account.ID, map[string]any{
+		"method":     provider.Name,
+		"session_id": session.FamilyID,
+	})
 
-	w.WriteHeader(http.StatusOK)
+	RespondWithJSON(w, http.StatusOK, map[string]any{
+		"userID":       account.ID,
+		"username":     account.Username,
+		"jwt":          jwt,
+		"refreshToken": session.RefreshToken,
+		"sessionID":    session.FamilyID,
+	})
 }
 
-func (h *Handler) BillingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RefreshTokensHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		RespondWithError(w, http.StatusUnauthorized, "Missing or invalid Authorization header")
+		return
+	}
+	providedToken := strings.TrimPrefix(authHeader, "Bearer ")
+	params := &middleware.AcceptedVals{}
+
+	err := json.NewDecoder(r.Body).Decode(params)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Bad Request")
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
-	defer r.Body.Close()
 
-	signature := r.Header.Get("X-Signature")
-	if !h.Billing.VerifyBillingSignature(signature, body, os.Getenv("LEMON_WEBHOOK_SECRET")) {
-		RespondWithError(w, http.StatusUnauthorized, "Invalid signature")
+	if params.UserID == 0 {
+		RespondWithError(w, http.StatusBadRequest, "Authentication failed")
 		return
 	}
 
-	var webhookPayload billing.BillingWebhookPayload
-	err = json.Unmarshal(body, &webhookPayload)
+	session, err := token.RefreshSession(h.TokenRepo, h.AuditRepo, params.UserID, providedToken, GetSessionInfo(r, ""))
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		if errors.Is(err, token.ErrSessionNotFound) {
+			RespondWithError(w, http.StatusUnauthorized, "Refresh token is invalid")
+			return
+		}
+		if errors.Is(err, token.ErrTokenReused) {
+			RespondWithError(w, http.StatusUnauthorized, "Refresh token was already used; please log in again")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
-	subscriptionID := webhookPayload.Data.SubscriptionID
-	webhookID := webhookPayload.Meta.WebhookID
-	exists, err := h.BillingRepo.HasWebhookBeenProcessed(webhookID)
+
+	user, err := h.UserRepo.GetUser(params.UserID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Error checking webhook")
+		RespondWithError(w, http.StatusUnauthorized, "Account deactivated")
 		return
 	}
-	if exists {
-		w.WriteHeader(http.StatusOK)
+	if user.AccountStatus != "active" {
+		RespondWithError(w, http.StatusUnauthorized, "Account deactivated")
 		return
 	}
 
-	var emailAttribute struct {
-		UserEmail string `json:"user_email"`
+	jwToken, err := token.CreateJWT(strconv.Itoa(params.UserID), 0)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "")
+		return
 	}
 
-	eventType := webhookPayload.Meta.EventName
+	payload := &ReturnVals{
+		ID:           params.UserID,
+		JWToken:      jwToken,
+		RefreshToken: session.RefreshToken,
+		SessionID:    session.FamilyID,
+	}
+	RespondWithJSON(w, http.StatusOK, payload)
+}
 
-	switch eventType {
-	case "order_created":
-		var orderAttrs billing.OrderAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &orderAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid order_created payload")
-			return
-		}
+func (h *Handler) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
 
-		err = h.Billing.ApplyCredits(h.UserRepo, h.BillingRepo, orderAttrs.UserEmail, orderAttrs.FirstOrderItem.VariantID)
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := token.ListSessions(h.TokenRepo, userID)
 		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			RespondWithError(w, http.StatusInternalServerError, "Failed to list sessions")
 			return
 		}
-	case "subscription_created":
-		var SubCreatedAttrs billing.SubscriptionAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &SubCreatedAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_created payload")
+		RespondWithJSON(w, http.StatusOK, sessions)
+	case http.MethodDelete:
+		if err := token.RevokeAllSessions(h.TokenRepo, userID); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to log out of all sessions")
 			return
 		}
+		RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out of all sessions"})
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
 
-		exists, err := h.UserRepo.HasActiveOrCancelledSubscription(SubCreatedAttrs.UserEmail)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Subscription check failed")
-			return
-		}
-		if exists {
-			return
-		}
+func (h *Handler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessionID, err := GetPathID(r, "/api/sessions/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	if err := token.RevokeSession(h.TokenRepo, userID, sessionID); err != nil {
+		if errors.Is(err, token.ErrSessionNotFound) {
+			RespondWithError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+func (h *Handler) InterviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid userID")
+		return
+	}
+
+	params := &middleware.AcceptedVals{}
+	err := json.NewDecoder(r.Body).Decode(params)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	userReturned, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to find user")
+		return
+	}
+
+	interviewStarted, err := interview.StartInterview(
+		h.InterviewRepo,
+		h.UserRepo,
+		h.BillingRepo,
+		h.OpenAI,
+		userReturned,
+		30,
+		3,
+		"easy",
+		params.JD)
+	if err != nil {
+		var openaiErr *chatgpt.OpenAIError
+		if errors.As(err, &openaiErr) {
+			RespondWithError(w, openaiErr.StatusCode, openaiErr.Message)
+			return
+		}
+		if errors.Is(err, interview.ErrNoValidCredits) {
+			RespondWithError(w, http.StatusPaymentRequired, "You do not have enough credits to start a new interview or your subscription has expired.")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to start interview.")
+		return
+	}
+
+	conversationID, err := conversation.CreateEmptyConversation(h.ConversationRepo, interviewStarted.Id, interviewStarted.Subtopic)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	err = interview.LinkConversation(h.InterviewRepo, interviewStarted.Id, conversationID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if account, err := user.GetUser(h.UserRepo, userID); err == nil {
+		_ = notification.CreditsLow(h.NotificationRepo, h.OutboxRepo, account, time.Now().UTC())
+	}
+
+	payload := ReturnVals{
+		InterviewID:    interviewStarted.Id,
+		FirstQuestion:  interviewStarted.FirstQuestion,
+		ConversationID: conversationID,
+	}
+
+	RespondWithJSON(w, http.StatusCreated, payload)
+}
+
+func (h *Handler) GetInterviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	interviewID, err := GetPathID(r, "/api/interviews/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid interview ID")
+		return
+	}
+
+	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, "Interview not found")
+		return
+	}
+	if interviewReturned.UserId != userID {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	payload := ReturnVals{
+		Interview: interviewReturned,
+	}
+
+	RespondWithJSON(w, http.StatusOK, payload)
+}
+
+func (h *Handler) UpdateInterviewStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	interviewID, err := GetPathID(r, "/api/interviews/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid interview ID")
+		return
+	}
+
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || (payload.Status != "paused" && payload.Status != "active") {
+		RespondWithError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	err = ValidateInterviewStatusTransition(interviewReturned.Status, payload.Status)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid status transition")
+		return
+	}
+
+	err = h.InterviewRepo.UpdateStatus(interviewID, userID, payload.Status)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not update status")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CreateConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	params := &middleware.AcceptedVals{}
+	err := json.NewDecoder(r.Body).Decode(params)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	interviewID, err := GetPathID(r, "/api/conversations/create/")
+
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+		return
+	}
+
+	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	if interviewReturned.UserId != userID {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if interviewReturned.Status != "active" {
+		RespondWithError(w, http.StatusConflict, "Interview is not active")
+		return
+	}
+
+	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	conversationCreated, err := conversation.CreateConversation(
+		h.ConversationRepo,
+		h.InterviewRepo,
+		h.OpenAI,
+		conversationReturned,
+		interviewID,
+		interviewReturned.Prompt,
+		interviewReturned.FirstQuestion,
+		interviewReturned.Subtopic,
+		params.Message)
+	if err != nil {
+		var openaiErr *chatgpt.OpenAIError
+		if errors.As(err, &openaiErr) {
+			h.respondWithProviderError(w, openaiErr, interviewID, userID)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, "Invalid interview_id")
+		return
+	}
+
+	payload := &ReturnVals{
+		Conversation: conversationCreated,
+	}
+	RespondWithJSON(w, http.StatusCreated, payload)
+}
+
+func (h *Handler) AppendConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	params := &middleware.AcceptedVals{}
+	err := json.NewDecoder(r.Body).Decode(params)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	if params.Message == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing message")
+		return
+	}
+
+	interviewID, err := GetPathID(r, "/api/conversations/append/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+		return
+	}
+
+	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	if interviewReturned.UserId != userID {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if interviewReturned.Status != "active" {
+		RespondWithError(w, http.StatusConflict, "Interview is not active")
+		return
+	}
+
+	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+		return
+	}
+
+	conversationReturned, err = conversation.AppendConversation(
+		h.ConversationRepo,
+		h.InterviewRepo,
+		h.OpenAI,
+		interviewID,
+		userID,
+		conversationReturned,
+		params.Message,
+		interviewReturned.Prompt)
+	if err != nil {
+		var openaiErr *chatgpt.OpenAIError
+		if errors.As(err, &openaiErr) {
+			h.respondWithProviderError(w, openaiErr, interviewID, userID)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+		return
+	}
+
+	if conversationReturned.CurrentSubtopic == "finished" {
+		_ = referral.Qualify(h.ReferralRepo, userID, referral.QualifiedByInterview)
+		if account, err := user.GetUser(h.UserRepo, userID); err == nil {
+			_ = notification.InterviewReportReady(h.NotificationRepo, h.OutboxRepo, account, interviewID)
+		}
+	}
+
+	payload := &ReturnVals{
+		Conversation: conversationReturned,
+	}
+	RespondWithJSON(w, http.StatusCreated, payload)
+}
+
+func (h *Handler) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+
+	interviewID, err := GetPathID(r, "/api/conversations/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Missing ID")
+		return
+	}
+
+	interviewReturned, err := interview.GetInterview(h.InterviewRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	if interviewReturned.UserId != userID {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationReturned, err := conversation.GetConversation(h.ConversationRepo, interviewID)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid ID.")
+		return
+	}
+
+	payload := &ReturnVals{
+		Conversation: conversationReturned,
+	}
+	RespondWithJSON(w, http.StatusOK, payload)
+}
+
+func (h *Handler) RequestResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var params PasswordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if wait, err := throttle.Allow(h.Throttle, throttle.PasswordReset, params.Email, ClientIP(r)); err != nil {
+		RespondThrottled(w, wait, err)
+		return
+	}
+
+	resetJWT, err := user.RequestPasswordReset(h.UserRepo, h.AuditRepo, params.Email, AuditOrigin(r))
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	resetURL := frontendURL + "reset-password?token=" + resetJWT
+
+	account, err := h.UserRepo.GetUserByEmail(params.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	_, err = h.OutboxRepo.Enqueue(&outbox.Email{
+		Key:       tokenKey("password_reset", resetJWT),
+		UserID:    account.ID,
+		Recipient: params.Email,
+		Locale:    account.Locale,
+		Template:  "password_reset",
+		Data:      map[string]any{"ResetURL": resetURL},
+	})
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	payload := ReturnVals{}
+	RespondWithJSON(w, http.StatusOK, payload)
+}
+
+func (h *Handler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var params PasswordResetPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	err := user.ResetPassword(h.UserRepo, h.AuditRepo, params.NewPassword, params.Token, AuditOrigin(r))
+	if err != nil {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return
+	}
+
+	payload := ReturnVals{}
+	RespondWithJSON(w, http.StatusOK, payload)
+}
+
+func (h *Handler) CreateCheckoutSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var params CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Tier == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing or invalid tier")
+		return
+	}
+
+	user, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not find user")
+		return
+	}
+
+	url, err := h.Billing.RequestCheckoutSession(user.Email, params.Tier)
+	if err != nil {
+		if errors.Is(err, billing.ErrUnknownTier) {
+			RespondWithError(w, http.StatusBadRequest, "Invalid tier selected")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Could not start checkout")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, CheckoutResponse{CheckoutURL: url})
+}
+
+func (h *Handler) CancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	userReturned, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user")
+		return
+	}
+
+	err = h.Billing.Provider.CancelSubscription(userReturned.SubscriptionID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not cancel subscription")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ResumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	userReturned, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not retrieve user")
+		return
+	}
+
+	err = h.Billing.Provider.ResumeSubscription(userReturned.SubscriptionID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not cancel subscription")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ChangePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	var params CheckoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Tier == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing or invalid tier")
+		return
+	}
+
+	user, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not find user")
+		return
+	}
+
+	if err := h.Billing.RequestChangePlan(user.SubscriptionID, params.Tier); err != nil {
+		if errors.Is(err, billing.ErrUnknownTier) {
+			RespondWithError(w, http.StatusBadRequest, "Invalid tier")
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to update subscription")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) BillingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := GetPagination(r)
+	history, err := h.BillingRepo.ListBillingHistory(userID, limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load billing history")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	invoiceID, err := GetPathID(r, "/api/payment/receipts/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid receipt ID")
+		return
+	}
+
+	invoice, err := h.BillingRepo.GetInvoice(invoiceID)
+	if err != nil || invoice.UserID != userID {
+		RespondWithError(w, http.StatusNotFound, "Receipt not found")
+		return
+	}
+
+	user, err := user.GetUser(h.UserRepo, userID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Could not find user")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var buf bytes.Buffer
+	switch format {
+	case "", "html":
+		err = billing.RenderReceiptHTML(&buf, invoice, user.Email)
+	case "pdf":
+		err = billing.RenderReceiptPDF(&buf, invoice, user.Email)
+	default:
+		RespondWithError(w, http.StatusBadRequest, "Unsupported receipt format")
+		return
+	}
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to render receipt")
+		return
+	}
+
+	if format == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"receipt-%d.pdf\"", invoice.ID))
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = buf.WriteTo(w)
+}
+
+func (h *Handler) BillingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Bad Request")
+		return
+	}
+	defer r.Body.Close()
+
+	_, _, err = h.Billing.ReceiveWebhook(h.BillingRepo, r.Header, body)
+	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrInvalidSignature):
+			RespondWithError(w, http.StatusUnauthorized, "Invalid signature")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to store webhook")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) RedeemPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Invalid user ID")
+		return
+	}
+
+	var params RedeemPromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Code == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing or invalid code")
+		return
+	}
+
+	redemption, err := promotion.RedeemPromotion(h.PromotionRepo, h.UserRepo, userID, params.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, promotion.ErrPromotionNotFound):
+			RespondWithError(w, http.StatusNotFound, "Invalid promo code")
+		case errors.Is(err, promotion.ErrPromotionExpired),
+			errors.Is(err, promotion.ErrPlanNotEligible),
+			errors.Is(err, promotion.ErrMaxRedemptionsReached),
+			errors.Is(err, promotion.ErrUserLimitReached):
+			RespondWithError(w, http.StatusConflict, err.Error())
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to redeem promo code")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, redemption)
+}
+
+func (h *Handler) AdminPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		stats, err := h.PromotionRepo.ListPromotionStats()
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to load promotions")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, stats)
+	case http.MethodPost:
+		var params PromotionRequest
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		params.Promotion.Active = params.Active == nil || *params.Active
+
+		created, err := promotion.CreatePromotion(h.PromotionRepo, &params.Promotion)
+		if err != nil {
+			switch {
+			case errors.Is(err, promotion.ErrInvalidPromotion):
+				RespondWithError(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, promotion.ErrDuplicateCode):
+				RespondWithError(w, http.StatusConflict, "Promo code already exists")
+			default:
+				RespondWithError(w, http.StatusInternalServerError, "Failed to create promotion")
+			}
+			return
+		}
+
+		RespondWithJSON(w, http.StatusCreated, created)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (h *Handler) AdminUpdatePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	promotionID, err := GetPathID(r, "/api/admin/promotions/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid promotion ID")
+		return
+	}
+
+	var params PromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if params.Active == nil {
+		RespondWithError(w, http.StatusBadRequest, "active is required")
+		return
+	}
+	params.Promotion.Active = *params.Active
+
+	updated, err := promotion.UpdatePromotion(h.PromotionRepo, promotionID, &params.Promotion)
+	if err != nil {
+		switch {
+		case errors.Is(err, promotion.ErrPromotionNotFound):
+			RespondWithError(w, http.StatusNotFound, "Promotion not found")
+		case errors.Is(err, promotion.ErrInvalidPromotion):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to update promotion")
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, updated)
+}
 
-		err = h.Billing.CreateSubscription(h.UserRepo, SubCreatedAttrs, subscriptionID)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
-			return
-		}
-	case "subscription_cancelled":
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &emailAttribute); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_cancelled payload")
+func (h *Handler) AdminPlansHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		RespondWithJSON(w, http.StatusOK, h.Billing.Plans.All())
+	case http.MethodPost:
+		var plan billing.Plan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid request body")
 			return
 		}
 
-		err = h.Billing.CancelSubscription(h.UserRepo, emailAttribute.UserEmail)
+		created, err := h.Billing.CreatePlan(h.BillingRepo, &plan)
 		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+			if errors.Is(err, billing.ErrInvalidPlan) {
+				RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to create plan")
 			return
 		}
-	case "subscription_resumed":
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &emailAttribute); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_resumed payload")
-			return
+
+		RespondWithJSON(w, http.StatusCreated, created)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (h *Handler) AdminUpdatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	planID, err := GetPathID(r, "/api/admin/plans/")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid plan ID")
+		return
+	}
+
+	var update billing.Plan
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	plan, err := h.Billing.UpdatePlan(h.BillingRepo, planID, &update)
+	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrPlanNotFound):
+			RespondWithError(w, http.StatusNotFound, "Plan not found")
+		case errors.Is(err, billing.ErrInvalidPlan):
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to update plan")
 		}
+		return
+	}
 
-		err = h.Billing.ResumeSubscription(h.UserRepo, emailAttribute.UserEmail)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+	RespondWithJSON(w, http.StatusOK, plan)
+}
+
+func (h *Handler) AdminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit, offset := GetPagination(r)
+	events, err := h.BillingRepo.ListWebhookEvents(r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to list webhook events")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, events)
+}
+
+func (h *Handler) AdminWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	replay := strings.HasSuffix(r.URL.Path, "/replay")
+	eventID, err := GetPathID(r, "/api/admin/webhooks/")
+	if replay {
+		eventID, err = strconv.Atoi(strings.Trim(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks/"), "/replay"), "/"))
+	}
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid webhook event ID")
+		return
+	}
+
+	switch {
+	case replay && r.Method == http.MethodPost:
+		if err := h.BillingRepo.ReplayWebhookEvent(eventID); err != nil {
+			if errors.Is(err, billing.ErrWebhookNotFound) {
+				RespondWithError(w, http.StatusNotFound, "Webhook event not found or not failed")
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to replay webhook event")
 			return
 		}
-	case "subscription_expired":
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &emailAttribute); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_expired payload")
+		w.WriteHeader(http.StatusAccepted)
+	case !replay && r.Method == http.MethodGet:
+		event, err := h.BillingRepo.GetWebhookEvent(eventID)
+		if err != nil {
+			if errors.Is(err, billing.ErrWebhookNotFound) {
+				RespondWithError(w, http.StatusNotFound, "Webhook event not found")
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to load webhook event")
 			return
 		}
+		RespondWithJSON(w, http.StatusOK, event)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
 
-		err = h.Billing.ExpireSubscription(h.UserRepo, h.BillingRepo, emailAttribute.UserEmail)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+// AdminOutboxHandler lists queued emails, newest first; ?status=dead shows
+// the dead letters.
+func (h *Handler) AdminOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit, offset := GetPagination(r)
+	emails, err := h.OutboxRepo.ListEmails(r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to list outbox emails")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, emails)
+}
+
+func (h *Handler) AdminOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	retry := strings.HasSuffix(r.URL.Path, "/retry")
+	emailID, err := GetPathID(r, "/api/admin/outbox/")
+	if retry {
+		emailID, err = strconv.Atoi(strings.Trim(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/outbox/"), "/retry"), "/"))
+	}
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid outbox email ID")
+		return
+	}
+
+	switch {
+	case retry && r.Method == http.MethodPost:
+		if err := h.OutboxRepo.RetryEmail(emailID); err != nil {
+			if errors.Is(err, outbox.ErrEmailNotFound) {
+				RespondWithError(w, http.StatusNotFound, "Outbox email not found or not failed")
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to retry outbox email")
 			return
 		}
-	case "subscription_payment_success":
-		var SubRenewAttrs billing.SubscriptionRenewAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &SubRenewAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_payment_success payload")
+		w.WriteHeader(http.StatusAccepted)
+	case !retry && r.Method == http.MethodGet:
+		email, err := h.OutboxRepo.GetEmail(emailID)
+		if err != nil {
+			if errors.Is(err, outbox.ErrEmailNotFound) {
+				RespondWithError(w, http.StatusNotFound, "Outbox email not found")
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, "Failed to load outbox email")
 			return
 		}
+		RespondWithJSON(w, http.StatusOK, email)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// AdminEmailsHandler lists the email templates and the locales they are
+// translated into.
+func (h *Handler) AdminEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string][]string{
+		"templates": mailer.Templates(),
+		"locales":   mailer.Locales(),
+	})
+}
+
+// AdminEmailPreviewHandler renders an email template with sample data in
+// the locale given by the locale query parameter.
+func (h *Handler) AdminEmailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/emails/"), "/")
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = mailer.DefaultLocale
+	}
+	locale, ok := mailer.NormalizeLocale(locale)
+	if !ok {
+		RespondWithError(w, http.StatusBadRequest, "Unsupported locale")
+		return
+	}
 
-		if SubRenewAttrs.BillingReason == "initial" {
+	msg, err := mailer.Preview(name, locale)
+	if err != nil {
+		if errors.Is(err, mailer.ErrUnknownTemplate) {
+			RespondWithError(w, http.StatusNotFound, "Email template not found")
 			return
 		}
+		RespondWithError(w, http.StatusInternalServerError, "Failed to render email template")
+		return
+	}
 
-		err = h.Billing.RenewSubscription(h.UserRepo, h.BillingRepo, SubRenewAttrs)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+	RespondWithJSON(w, http.StatusOK, map[string]string{
+		"template": name,
+		"locale":   locale,
+		"subject":  msg.Subject,
+		"html":     msg.HTML,
+		"text":     msg.Text,
+	})
+}
+
+// AdminAuditHandler searches the audit log. actor_id and target_user_id
+// filter by user, action takes comma-separated action prefixes such as
+// "auth.login", and since/until take RFC 3339 times.
+func (h *Handler) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{}
+	filter.Limit, filter.Offset = GetPagination(r)
+
+	var err error
+	if value := query.Get("actor_id"); value != "" {
+		if filter.ActorID, err = strconv.Atoi(value); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid actor_id")
 			return
 		}
-	case "subscription_plan_changed":
-		var SubChangedAttrs billing.SubscriptionAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &SubChangedAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_plan_changed payload")
+	}
+	if value := query.Get("target_user_id"); value != "" {
+		if filter.TargetUserID, err = strconv.Atoi(value); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Invalid target_user_id")
 			return
 		}
+	}
+	for _, prefix := range strings.Split(query.Get("action"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			filter.ActionPrefixes = append(filter.ActionPrefixes, prefix)
+		}
+	}
+	if filter.Since, err = GetTimeParam(r, "since"); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid since; use RFC 3339")
+		return
+	}
+	if filter.Until, err = GetTimeParam(r, "until"); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid until; use RFC 3339")
+		return
+	}
 
-		err = h.Billing.ChangeSubscription(h.UserRepo, h.BillingRepo, SubChangedAttrs)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+	events, err := audit.List(h.AuditRepo, filter)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load audit events")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, events)
+}
+
+// impersonationTTL bounds a support session opened through the admin API.
+const impersonationTTL = 15 * time.Minute
+
+func (h *Handler) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	limit, offset := GetPagination(r)
+	users, err := user.SearchUsers(h.UserRepo, query, limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
+	h.recordAdminAction(r, audit.ActionAdminSearchUsers, 0, map[string]any{"query": query})
+
+	results := []AdminUser{}
+	for i := range users {
+		results = append(results, newAdminUser(&users[i]))
+	}
+	RespondWithJSON(w, http.StatusOK, results)
+}
+
+// AdminUserHandler serves /api/admin/users/{id} and the actions under it.
+// middleware.Authorize has already checked the permission each one needs.
+func (h *Handler) AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/"), "/")
+	targetID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	target, err := h.UserRepo.GetUser(targetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			RespondWithError(w, http.StatusNotFound, "User not found")
 			return
 		}
-	case "subscription_updated":
-		var SubChangedAttrs billing.SubscriptionAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &SubChangedAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid subscription_updated payload")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.recordAdminAction(r, audit.ActionAdminViewUser, targetID, nil)
+		RespondWithJSON(w, http.StatusOK, newAdminUser(target))
+	case action == "interviews" && r.Method == http.MethodGet:
+		h.adminListInterviews(w, r, targetID)
+	case action == "transactions" && r.Method == http.MethodGet:
+		h.adminListTransactions(w, r, targetID)
+	case action == "credits" && r.Method == http.MethodPost:
+		if targetID == actorID {
+			RespondWithError(w, http.StatusBadRequest, "You cannot adjust your own credits")
 			return
 		}
-
-		err = h.Billing.UpdateSubscription(h.UserRepo, SubChangedAttrs, subscriptionID)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+		h.adminAdjustCredits(w, r, targetID)
+	case (action == "deactivate" || action == "reactivate") && r.Method == http.MethodPost:
+		if targetID == actorID {
+			RespondWithError(w, http.StatusBadRequest, "You cannot change your own account status")
 			return
 		}
-	case "order_refunded":
-		var orderAttrs billing.OrderAttributes
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &orderAttrs); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid order_created payload")
+		h.adminSetAccountStatus(w, r, targetID, action == "deactivate")
+	case action == "role" && r.Method == http.MethodPut:
+		if targetID == actorID {
+			RespondWithError(w, http.StatusBadRequest, "You cannot change your own role")
 			return
 		}
+		h.adminChangeRole(w, r, target)
+	case action == "impersonate" && r.Method == http.MethodPost:
+		h.adminImpersonate(w, r, actorID, target)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
 
-		err = h.Billing.DeductCredits(h.UserRepo, h.BillingRepo, orderAttrs)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
-			return
+func (h *Handler) adminListInterviews(w http.ResponseWriter, r *http.Request, targetID int) {
+	interviews, err := h.InterviewRepo.GetInterviewSummariesByUserID(targetID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load interviews")
+		return
+	}
+
+	h.recordAdminAction(r, audit.ActionAdminViewInterviews, targetID, nil)
+	RespondWithJSON(w, http.StatusOK, interviews)
+}
+
+func (h *Handler) adminListTransactions(w http.ResponseWriter, r *http.Request, targetID int) {
+	limit, offset := GetPagination(r)
+	history, err := h.BillingRepo.ListBillingHistory(targetID, limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load billing history")
+		return
+	}
+
+	h.recordAdminAction(r, audit.ActionAdminViewTransactions, targetID, nil)
+	RespondWithJSON(w, http.StatusOK, history)
+}
+
+func (h *Handler) adminAdjustCredits(w http.ResponseWriter, r *http.Request, targetID int) {
+	var body struct {
+		Amount     int    `json:"amount"`
+		CreditType string `json:"credit_type"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Amount == 0 || body.Reason == "" {
+		RespondWithError(w, http.StatusBadRequest, "amount and reason are required")
+		return
+	}
+	if body.CreditType != "individual" && body.CreditType != "subscription" {
+		RespondWithError(w, http.StatusBadRequest, "credit_type must be individual or subscription")
+		return
+	}
+
+	_, err := h.BillingRepo.ApplyCreditTransaction(billing.CreditTransaction{
+		UserID:       targetID,
+		Amount:       body.Amount,
+		CreditType:   body.CreditType,
+		Reason:       "Adjustment: " + body.Reason,
+		Counterparty: ledger.AccountAdjustments,
+	})
+	if errors.Is(err, billing.ErrInsufficientCredits) {
+		RespondWithError(w, http.StatusConflict, "Deduction exceeds the user's balance")
+		return
+	} else if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to adjust credits")
+		return
+	}
+
+	h.recordAdminAction(r, audit.ActionAdminAdjustCredits, targetID, map[string]any{
+		"amount":      body.Amount,
+		"credit_type": body.CreditType,
+		"reason":      body.Reason,
+	})
+
+	updated, err := h.UserRepo.GetUser(targetID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, newAdminUser(updated))
+}
+
+func (h *Handler) adminSetAccountStatus(w http.ResponseWriter, r *http.Request, targetID int, deactivate bool) {
+	var err error
+	action := audit.ActionAdminReactivateUser
+	if deactivate {
+		action = audit.ActionAdminDeactivateUser
+		err = user.Deactivate(h.UserRepo, targetID)
+	} else {
+		err = user.Reactivate(h.UserRepo, targetID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrAccountDeleted), errors.Is(err, user.ErrUserNotFound):
+			RespondWithError(w, http.StatusConflict, "Deleted accounts cannot be changed")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to update account status")
 		}
-	case "subscription_payment_failed", "subscription_payment_recovered":
-		if err := json.Unmarshal(webhookPayload.Data.Attributes, &emailAttribute); err != nil {
-			RespondWithError(w, http.StatusBadRequest, "Invalid payment status payload")
+		return
+	}
+
+	// Existing access tokens stop working at ValidateUserActive; revoking
+	// the refresh tokens ends the sessions for good.
+	if deactivate {
+		if err := token.RevokeAllSessions(h.TokenRepo, targetID); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, "Account suspended but sessions could not be revoked")
 			return
 		}
+	}
 
-	default:
-		RespondWithError(w, http.StatusNotImplemented, "Unhandled event type")
+	h.recordAdminAction(r, action, targetID, nil)
+
+	updated, err := h.UserRepo.GetUser(targetID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, newAdminUser(updated))
+}
+
+func (h *Handler) adminChangeRole(w http.ResponseWriter, r *http.Request, target *user.User) {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := user.ChangeRole(h.UserRepo, target.ID, body.Role); err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidRole):
+			RespondWithError(w, http.StatusBadRequest, "role must be user, support or admin")
+		case errors.Is(err, user.ErrUserNotFound):
+			RespondWithError(w, http.StatusNotFound, "User not found")
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to change role")
+		}
+		return
+	}
+
+	h.recordAdminAction(r, audit.ActionAdminChangeRole, target.ID, map[string]any{
+		"from": target.Role,
+		"to":   body.Role,
+	})
+
+	target.Role = body.Role
+	RespondWithJSON(w, http.StatusOK, newAdminUser(target))
+}
+
+// adminImpersonate issues a short-lived, read-only access token for a
+// customer so support can see what they see. Staff accounts cannot be
+// impersonated, and the session is not started unless it is audited.
+func (h *Handler) adminImpersonate(w http.ResponseWriter, r *http.Request, actorID int, target *user.User) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		RespondWithError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	if target.Role != user.RoleUser {
+		RespondWithError(w, http.StatusForbidden, "Staff accounts cannot be impersonated")
+		return
+	}
+	if target.AccountStatus != user.AccountActive {
+		RespondWithError(w, http.StatusConflict, "Only active accounts can be impersonated")
 		return
 	}
 
+	err := h.recordAdminAction(r, audit.ActionAdminImpersonate, target.ID, map[string]any{"reason": body.Reason})
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+		RespondWithError(w, http.StatusInternalServerError, "Failed to start impersonation")
 		return
 	}
 
-	err = h.BillingRepo.MarkWebhookProcessed(webhookID, eventType)
+	accessToken, err := token.CreateImpersonationJWT(strconv.Itoa(target.ID), actorID, impersonationTTL)
 	if err != nil {
-		w.WriteHeader(http.StatusOK)
+		RespondWithError(w, http.StatusInternalServerError, "Failed to start impersonation")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	RespondWithJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"user_id":      target.ID,
+		"expires_at":   time.Now().UTC().Add(impersonationTTL),
+	})
+}
+
+// recordAdminAction writes an audit event for the signed-in staff member.
+// Audit failures are logged by audit.Record; callers only need the error
+// when the action must not go ahead unrecorded.
+func (h *Handler) recordAdminAction(r *http.Request, action string, targetID int, metadata map[string]any) error {
+	actorID, _ := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	return audit.Record(h.AuditRepo, audit.Event{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: targetID,
+		IPAddress:    ClientIP(r),
+		UserAgent:    r.UserAgent(),
+		Metadata:     metadata,
+	})
+}
+
+// SecurityActivityHandler lists the signed-in user's recent security
+// events: logins, token refreshes, password resets, second factors, API keys
+// and billing changes.
+func (h *Handler) SecurityActivityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.ContextKeyTokenParams).(int)
+	if !ok {
+		RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset := GetPagination(r)
+	events, err := audit.RecentActivity(h.AuditRepo, userID, limit, offset)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to load security activity")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, events)
 }
 
 func (h *Handler) DashboardHandler(w http.ResponseWriter, r *http.Request) {
@@ -1219,7 +3028,7 @@ func (h *Handler) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dashboardData, err := dashboard.GetDashboardData(userID, h.UserRepo, h.InterviewRepo)
+	dashboardData, err := dashboard.GetDashboardData(userID, h.UserRepo, h.InterviewRepo, h.ReferralRepo)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			RespondWithError(w, http.StatusUnauthorized, "User not found")
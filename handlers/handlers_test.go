@@ -227,7 +227,7 @@ func Test_CreateUsersHandler_Integration(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			verificationJWT, err := user.VerificationToken(tc.email, tc.username, tc.password)
+			verificationJWT, err := user.VerificationToken(tc.email, tc.username, tc.password, "", "")
 			if err != nil {
 				t.Fatalf("GenerateEmailVerificationToken failed: %v", err)
 			}
@@ -480,15 +480,15 @@ func Test_LoginHandler_Integration(t *testing.T) {
 
 			// Assert Database
 			if tc.DBCheck {
-				refreshToken, err := token.GetStoredRefreshToken(Handler.TokenRepo, respUnmarshalled.UserID)
+				session, err := token.GetSession(Handler.TokenRepo, respUnmarshalled.UserID, respUnmarshalled.RefreshToken)
 				if err != nil {
-					t.Fatalf("Assert Database: GetUser failed: %v", err)
+					t.Fatalf("Assert Database: GetSession failed: %v", err)
 				}
 
-				expectedDB := respUnmarshalled.RefreshToken
-				gotDB := refreshToken
+				expectedDB := respUnmarshalled.SessionID
+				gotDB := session.FamilyID
 
-				if diff := cmp.Diff(expectedDB, gotDB, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+				if diff := cmp.Diff(expectedDB, gotDB); diff != "" {
 					t.Errorf("DB Mismatch (-expected +got):\n%s", diff)
 				}
 			}
@@ -500,10 +500,11 @@ func Test_RefreshTokensHandler_Integration(t *testing.T) {
 	cleanDBOrFail(t)
 
 	_, userID := testutil.CreateTestUserAndJWT(logger)
-	refreshToken, err := token.GetStoredRefreshToken(Handler.TokenRepo, userID)
+	session, err := token.CreateRefreshToken(Handler.TokenRepo, userID, token.SessionInfo{DeviceLabel: "Integration test"})
 	if err != nil {
-		t.Fatalf("TC GetStoredRefreshToken failed: %v", err)
+		t.Fatalf("TC CreateRefreshToken failed: %v", err)
 	}
+	refreshToken := session.RefreshToken
 
 	tests := []TestCase{
 		{
@@ -519,6 +520,19 @@ func Test_RefreshTokensHandler_Integration(t *testing.T) {
 			DBCheck:        true,
 			TokensExpected: true,
 		},
+		{
+			name:           "RefreshToken_Reused",
+			method:         "POST",
+			url:            testutil.TestServerURL + "/api/auth/token",
+			expectedStatus: http.StatusUnauthorized,
+			headerKey:      "Authorization",
+			headerValue:    "Bearer " + refreshToken,
+			reqBody: fmt.Sprintf(`{
+				"user_id" : %d
+			}`, userID),
+			DBCheck:        false,
+			TokensExpected: false,
+		},
 		{
 			name:           "RefreshToken_IncorrectUserID",
 			method:         "POST",
@@ -611,15 +625,15 @@ func Test_RefreshTokensHandler_Integration(t *testing.T) {
 
 			// Assert Database
 			if tc.DBCheck {
-				refreshToken, err := token.GetStoredRefreshToken(Handler.TokenRepo, userID)
+				rotated, err := token.GetSession(Handler.TokenRepo, userID, respUnmarshalled.RefreshToken)
 				if err != nil {
-					t.Fatalf("Assert Database: GetUser failed: %v", err)
+					t.Fatalf("Assert Database: GetSession failed: %v", err)
 				}
 
-				expectedDB := respUnmarshalled.RefreshToken
-				gotDB := refreshToken
+				expectedDB := session.FamilyID
+				gotDB := rotated.FamilyID
 
-				if diff := cmp.Diff(expectedDB, gotDB, cmpopts.EquateApproxTime(time.Second)); diff != "" {
+				if diff := cmp.Diff(expectedDB, gotDB); diff != "" {
 					t.Errorf("DB Mismatch (-expected +got):\n%s", diff)
 				}
 			}
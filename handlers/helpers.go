@@ -1,13 +1,27 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/chatgpt"
+	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/mailer"
+	"github.com/michaelboegner/interviewer/throttle"
+	"github.com/michaelboegner/interviewer/token"
 )
 
 func ValidateInterviewStatusTransition(currentStatus, nextStatus string) error {
@@ -16,6 +30,7 @@ func ValidateInterviewStatusTransition(currentStatus, nextStatus string) error {
 		"active":      {"paused", "finished"},
 		"paused":      {"active"},
 		"finished":    {},
+		"failed":      {},
 	}
 
 	allowed, ok := validTransitions[currentStatus]
@@ -31,6 +46,20 @@ func ValidateInterviewStatusTransition(currentStatus, nextStatus string) error {
 	return fmt.Errorf("invalid state transition: %s → %s", currentStatus, nextStatus)
 }
 
+func (h *Handler) respondWithProviderError(w http.ResponseWriter, openaiErr *chatgpt.OpenAIError, interviewID, userID int) {
+	refunded, err := interview.HandleProviderFailure(h.InterviewRepo, h.BillingRepo, interviewID, userID)
+	if err != nil {
+		log.Printf("interview.HandleProviderFailure failed: %v", err)
+	}
+
+	if refunded {
+		RespondWithError(w, openaiErr.StatusCode, "This interview could not continue due to repeated AI provider errors. Your credit has been refunded.")
+		return
+	}
+
+	RespondWithError(w, openaiErr.StatusCode, openaiErr.Message)
+}
+
 func GetPathID(r *http.Request, prefix string) (int, error) {
 	path := strings.TrimPrefix(r.URL.Path, prefix)
 	path = strings.Trim(path, "/")
@@ -48,6 +77,41 @@ func GetPathID(r *http.Request, prefix string) (int, error) {
 	return id, nil
 }
 
+// GetPagination reads limit and offset query parameters, defaulting to the
+// first 50 rows and capping limit at 200.
+func GetPagination(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// GetTimeParam reads an optional RFC 3339 query parameter, returning nil
+// when it is absent.
+func GetTimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	if w.Header().Get("Content-Type") == "" {
 		w.Header().Set("Content-Type", "application/json")
@@ -88,3 +152,124 @@ func RespondWithError(w http.ResponseWriter, code int, msg string) {
 
 	w.Write(data)
 }
+
+// GetSessionInfo describes the client making the request for session
+// tracking.
+func GetSessionInfo(r *http.Request, deviceLabel string) token.SessionInfo {
+	return token.SessionInfo{
+		DeviceLabel: strings.TrimSpace(deviceLabel),
+		IPAddress:   ClientIP(r),
+		UserAgent:   r.UserAgent(),
+	}
+}
+
+// AuditOrigin describes the client making the request for the audit log.
+func AuditOrigin(r *http.Request) audit.Origin {
+	return audit.Origin{
+		IPAddress: ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// ClientIP returns the caller's address. Forwarding headers are only
+// believed when the connection comes from a proxy listed in TRUSTED_PROXIES:
+// Fly-Client-IP as Fly's edge sets it, otherwise the nearest X-Forwarded-For
+// hop that is not itself a trusted proxy. Anyone else can forge them, so
+// their RemoteAddr is used.
+func ClientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	proxies := trustedProxies()
+	if !isTrustedProxy(proxies, remote) {
+		return remote
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("Fly-Client-IP")); ip != "" {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop != "" && !isTrustedProxy(proxies, hop) {
+			return hop
+		}
+	}
+	return remote
+}
+
+// trustedProxies parses TRUSTED_PROXIES, a comma-separated list of
+// addresses and CIDR ranges. Invalid entries are skipped.
+func trustedProxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			log.Printf("ignoring invalid TRUSTED_PROXIES entry %q", entry)
+		}
+	}
+	return proxies
+}
+
+func isTrustedProxy(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RespondThrottled answers a request refused by throttle.Check or
+// throttle.Allow with 429 and a Retry-After header, or 500 if the store
+// failed.
+func RespondThrottled(w http.ResponseWriter, wait time.Duration, err error) {
+	if !errors.Is(err, throttle.ErrThrottled) && !errors.Is(err, throttle.ErrLocked) {
+		RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	if errors.Is(err, throttle.ErrLocked) {
+		RespondWithError(w, http.StatusTooManyRequests, "Too many failed attempts. Try again later.")
+		return
+	}
+	RespondWithError(w, http.StatusTooManyRequests, "Too many attempts. Please wait and try again.")
+}
+
+// RequestLocale is the email locale for someone who has no account yet: the
+// locale they asked for if it has translations, otherwise the best match
+// for their Accept-Language header.
+func RequestLocale(r *http.Request, requested string) string {
+	if locale, ok := mailer.NormalizeLocale(requested); ok {
+		return locale
+	}
+	return mailer.MatchLocale(r.Header.Get("Accept-Language"))
+}
+
+// tokenKey derives an outbox key for an email carrying a single-use token,
+// without putting the token itself in the key.
+func tokenKey(template, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return template + ":" + hex.EncodeToString(sum[:16])
+}
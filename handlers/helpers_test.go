@@ -0,0 +1,68 @@
+package handlers_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/michaelboegner/interviewer/handlers"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		remoteAddr     string
+		headers        map[string]string
+		expected       string
+	}{
+		{
+			name:       "ClientIP_NoProxiesIgnoresHeaders",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"Fly-Client-IP": "198.51.100.1", "X-Forwarded-For": "198.51.100.2"},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:           "ClientIP_UntrustedPeerIgnoresHeaders",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "203.0.113.7:5000",
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.2"},
+			expected:       "203.0.113.7",
+		},
+		{
+			name:           "ClientIP_TrustedProxyFlyHeader",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.1.2.3:5000",
+			headers:        map[string]string{"Fly-Client-IP": "198.51.100.1", "X-Forwarded-For": "198.51.100.2"},
+			expected:       "198.51.100.1",
+		},
+		{
+			name:           "ClientIP_TrustedProxySkipsForgedHops",
+			trustedProxies: "10.0.0.0/8, 192.0.2.10",
+			remoteAddr:     "10.1.2.3:5000",
+			headers:        map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.2, 192.0.2.10"},
+			expected:       "198.51.100.2",
+		},
+		{
+			name:           "ClientIP_TrustedProxyWithoutHeaders",
+			trustedProxies: "10.1.2.3",
+			remoteAddr:     "10.1.2.3:5000",
+			expected:       "10.1.2.3",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tc.trustedProxies)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+
+			if got := handlers.ClientIP(r); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
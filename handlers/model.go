@@ -2,12 +2,23 @@ package handlers
 
 import (
 	"database/sql"
+	"time"
 
+	"github.com/michaelboegner/interviewer/apikey"
+	"github.com/michaelboegner/interviewer/audit"
 	"github.com/michaelboegner/interviewer/billing"
 	"github.com/michaelboegner/interviewer/chatgpt"
 	"github.com/michaelboegner/interviewer/conversation"
+	"github.com/michaelboegner/interviewer/identity"
 	"github.com/michaelboegner/interviewer/interview"
-	"github.com/michaelboegner/interviewer/mailer"
+	"github.com/michaelboegner/interviewer/mfa"
+	"github.com/michaelboegner/interviewer/notification"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/passkey"
+	"github.com/michaelboegner/interviewer/privacy"
+	"github.com/michaelboegner/interviewer/promotion"
+	"github.com/michaelboegner/interviewer/referral"
+	"github.com/michaelboegner/interviewer/throttle"
 	"github.com/michaelboegner/interviewer/token"
 	"github.com/michaelboegner/interviewer/user"
 )
@@ -25,6 +36,18 @@ type CheckoutRequest struct {
 	Tier string `json:"tier"`
 }
 
+type RedeemPromotionRequest struct {
+	Code string `json:"code"`
+}
+
+// PromotionRequest creates or edits a promotion. Active is a pointer so an
+// omitted flag can be told apart from false: new promotions default to
+// active and edits must state it.
+type PromotionRequest struct {
+	promotion.Promotion
+	Active *bool `json:"active"`
+}
+
 type CheckoutResponse struct {
 	CheckoutURL string `json:"checkout_url"`
 }
@@ -37,10 +60,14 @@ type ReturnVals struct {
 	Body           string                     `json:"body,omitempty"`
 	Username       string                     `json:"username,omitempty"`
 	Email          string                     `json:"email,omitempty"`
+	Locale         string                     `json:"locale,omitempty"`
 	FirstQuestion  string                     `json:"first_question,omitempty"`
 	NextQuestion   string                     `json:"next_question,omitempty"`
 	JWToken        string                     `json:"jwtoken,omitempty"`
 	RefreshToken   string                     `json:"refresh_token,omitempty"`
+	SessionID      int                        `json:"session_id,omitempty"`
+	MFARequired    bool                       `json:"mfa_required,omitempty"`
+	MFAToken       string                     `json:"mfa_token,omitempty"`
 	Error          string                     `json:"error,omitempty"`
 	Users          map[int]user.User          `json:"users,omitempty"`
 	Conversation   *conversation.Conversation `json:"conversation,omitempty"`
@@ -51,14 +78,55 @@ type ReturnVals struct {
 	Message        string                     `json:"message,omitempty"`
 }
 
+// AdminUser is the account view returned by the admin API. It leaves out
+// the password hash and billing provider identifiers.
+type AdminUser struct {
+	ID                  int       `json:"id"`
+	Username            string    `json:"username"`
+	Email               string    `json:"email"`
+	Role                string    `json:"role"`
+	AccountStatus       string    `json:"account_status"`
+	SubscriptionTier    string    `json:"subscription_tier"`
+	SubscriptionStatus  string    `json:"subscription_status"`
+	IndividualCredits   int       `json:"individual_credits"`
+	SubscriptionCredits int       `json:"subscription_credits"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+func newAdminUser(u *user.User) AdminUser {
+	return AdminUser{
+		ID:                  u.ID,
+		Username:            u.Username,
+		Email:               u.Email,
+		Role:                u.Role,
+		AccountStatus:       u.AccountStatus,
+		SubscriptionTier:    u.SubscriptionTier,
+		SubscriptionStatus:  u.SubscriptionStatus,
+		IndividualCredits:   u.IndividualCredits,
+		SubscriptionCredits: u.SubscriptionCredits,
+		CreatedAt:           u.CreatedAt,
+	}
+}
+
 type Handler struct {
 	UserRepo         user.UserRepo
 	InterviewRepo    interview.InterviewRepo
 	ConversationRepo conversation.ConversationRepo
 	TokenRepo        token.TokenRepo
 	BillingRepo      billing.BillingRepo
+	PromotionRepo    promotion.PromotionRepo
+	ReferralRepo     referral.ReferralRepo
+	IdentityRepo     identity.IdentityRepo
+	Providers        identity.Registry
+	MFARepo          mfa.MFARepo
+	PasskeyRepo      passkey.PasskeyRepo
+	Throttle         throttle.Store
+	APIKeyRepo       apikey.APIKeyRepo
+	AuditRepo        audit.AuditRepo
+	PrivacyRepo      privacy.PrivacyRepo
+	OutboxRepo       outbox.OutboxRepo
+	NotificationRepo notification.NotificationRepo
 	Billing          *billing.Billing
-	Mailer           mailer.MailerClient
 	OpenAI           chatgpt.AIClient
 	DB               *sql.DB
 }
@@ -69,8 +137,19 @@ func NewHandler(
 	tokenRepo token.TokenRepo,
 	conversationRepo conversation.ConversationRepo,
 	billingRepo billing.BillingRepo,
+	promotionRepo promotion.PromotionRepo,
+	referralRepo referral.ReferralRepo,
+	identityRepo identity.IdentityRepo,
+	providers identity.Registry,
+	mfaRepo mfa.MFARepo,
+	passkeyRepo passkey.PasskeyRepo,
+	throttleStore throttle.Store,
+	apiKeyRepo apikey.APIKeyRepo,
+	auditRepo audit.AuditRepo,
+	privacyRepo privacy.PrivacyRepo,
+	outboxRepo outbox.OutboxRepo,
+	notificationRepo notification.NotificationRepo,
 	billing *billing.Billing,
-	mailer mailer.MailerClient,
 	openAI chatgpt.AIClient,
 	db *sql.DB) *Handler {
 	return &Handler{
@@ -79,8 +158,19 @@ func NewHandler(
 		TokenRepo:        tokenRepo,
 		ConversationRepo: conversationRepo,
 		BillingRepo:      billingRepo,
+		PromotionRepo:    promotionRepo,
+		ReferralRepo:     referralRepo,
+		IdentityRepo:     identityRepo,
+		Providers:        providers,
+		MFARepo:          mfaRepo,
+		PasskeyRepo:      passkeyRepo,
+		Throttle:         throttleStore,
+		APIKeyRepo:       apiKeyRepo,
+		AuditRepo:        auditRepo,
+		PrivacyRepo:      privacyRepo,
+		OutboxRepo:       outboxRepo,
+		NotificationRepo: notificationRepo,
 		Billing:          billing,
-		Mailer:           mailer,
 		OpenAI:           openAI,
 		DB:               db,
 	}
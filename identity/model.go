@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"errors"
+	"time"
+)
+
+// Identity links an account at an external provider, keyed by the
+// provider's stable subject ID, to a user.
+type Identity struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"-"`
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"-"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// AuthState is the server side of one authorization request. It is looked
+// up by the state parameter on callback and can be used once. A non-zero
+// UserID means the request links a provider to that user rather than
+// logging in. BindingHash ties the request to the client that started it:
+// the callback must present the binding returned by StartAuth.
+type AuthState struct {
+	State        string
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	UserID       int
+	RedirectURI  string
+	BindingHash  string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// Claims is what a provider told us about the authenticated account.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+const stateLifetime = 10 * time.Minute
+
+type IdentityRepo interface {
+	CreateAuthState(state *AuthState) error
+	ConsumeAuthState(state string) (*AuthState, error)
+	GetIdentity(provider, subject string) (*Identity, error)
+	ListIdentities(userID int) ([]Identity, error)
+	CreateIdentity(identity *Identity) error
+	TouchIdentity(identityID int, at time.Time) error
+	DeleteIdentity(userID, identityID int) error
+}
+
+var (
+	ErrProviderNotFound  = errors.New("unknown identity provider")
+	ErrStateInvalid      = errors.New("invalid or expired state")
+	ErrStateUserMismatch = errors.New("state was started by another user")
+	ErrIdentityNotFound  = errors.New("identity not found")
+	ErrIdentityLinked    = errors.New("identity is linked to another account")
+	ErrDuplicateIdentity = errors.New("duplicate identity")
+	ErrEmailRequired     = errors.New("provider did not return an email address")
+	ErrEmailNotVerified  = errors.New("provider has not verified the email address")
+	ErrLastLoginMethod   = errors.New("cannot remove the only way to sign in")
+	ErrInvalidIDToken    = errors.New("invalid id token")
+	ErrProviderExchange  = errors.New("provider token exchange failed")
+)
@@ -0,0 +1,467 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider is an OAuth2 authorization server we accept logins from. OIDC
+// providers only need an Issuer; their endpoints and signing keys come from
+// discovery. GitHub does not speak OIDC, so its identity is read from its
+// REST API instead of an ID token.
+type Provider struct {
+	Name         string
+	DisplayName  string
+	ClientID     string
+	ClientSecret string
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	APIURL       string
+	Scopes       []string
+	GitHub       bool
+	HTTPClient   *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	jwksURL    string
+	keys       map[string]any
+}
+
+// Registry holds the configured providers by name.
+type Registry map[string]*Provider
+
+// List returns the providers sorted by name.
+func (r Registry) List() []*Provider {
+	providers := make([]*Provider, 0, len(r))
+	for _, provider := range r {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// LoadProviders builds the registry from the environment. The built-in
+// providers are enabled by setting their client ID, e.g. GOOGLE_CLIENT_ID and
+// GOOGLE_CLIENT_SECRET. Any other OIDC issuer is added by listing a name in
+// OIDC_PROVIDERS and setting OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and
+// OIDC_<NAME>_CLIENT_SECRET.
+func LoadProviders() Registry {
+	registry := Registry{}
+
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		registry["github"] = &Provider{
+			Name:         "github",
+			DisplayName:  "GitHub",
+			ClientID:     id,
+			ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			AuthURL:      "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+			APIURL:       "https://api.github.com",
+			Scopes:       []string{"read:user", "user:email"},
+			GitHub:       true,
+		}
+	}
+	if id := os.Getenv("GOOGLE_CLIENT_ID"); id != "" {
+		registry["google"] = newOIDCProvider("google", "Google", "https://accounts.google.com", id, os.Getenv("GOOGLE_CLIENT_SECRET"))
+	}
+	if id := os.Getenv("GITLAB_CLIENT_ID"); id != "" {
+		issuer := os.Getenv("GITLAB_ISSUER")
+		if issuer == "" {
+			issuer = "https://gitlab.com"
+		}
+		registry["gitlab"] = newOIDCProvider("gitlab", "GitLab", issuer, id, os.Getenv("GITLAB_CLIENT_SECRET"))
+	}
+	if id := os.Getenv("MICROSOFT_CLIENT_ID"); id != "" {
+		tenant := os.Getenv("MICROSOFT_TENANT")
+		if tenant == "" {
+			tenant = "common"
+		}
+		issuer := "https://login.microsoftonline.com/" + tenant + "/v2.0"
+		registry["microsoft"] = newOIDCProvider("microsoft", "Microsoft", issuer, id, os.Getenv("MICROSOFT_CLIENT_SECRET"))
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			log.Printf("OIDC provider %q is missing %sISSUER or %sCLIENT_ID, skipping", name, prefix, prefix)
+			continue
+		}
+		displayName := os.Getenv(prefix + "DISPLAY_NAME")
+		if displayName == "" {
+			displayName = name
+		}
+		registry[name] = newOIDCProvider(name, displayName, issuer, clientID, os.Getenv(prefix+"CLIENT_SECRET"))
+	}
+
+	return registry
+}
+
+func newOIDCProvider(name, displayName, issuer, clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         name,
+		DisplayName:  displayName,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// RedirectURL is where providers send the browser back to. The frontend
+// posts the code and state from there to /api/auth/oauth/callback.
+func RedirectURL() string {
+	if redirect := os.Getenv("OAUTH_REDIRECT_URL"); redirect != "" {
+		return redirect
+	}
+	return os.Getenv("FRONTEND_URL") + "oauth/callback"
+}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// AuthCodeURL builds the authorization request, binding it to state, the
+// PKCE challenge and (for OIDC) the nonce.
+func (p *Provider) AuthCodeURL(state, codeChallenge, nonce, redirectURI string) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if !p.GitHub {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + params.Encode(), nil
+}
+
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades an authorization code for tokens. codeVerifier and
+// redirectURI are omitted when empty, for clients that started the flow
+// without PKCE.
+func (p *Provider) Exchange(code, codeVerifier, redirectURI string) (*TokenResponse, error) {
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+	if redirectURI != "" {
+		data.Set("redirect_uri", redirectURI)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		log.Printf("%s token exchange failed: %v", p.Name, err)
+		return nil, ErrProviderExchange
+	}
+	defer resp.Body.Close()
+
+	var tokens TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		log.Printf("%s token response decode failed: %v", p.Name, err)
+		return nil, ErrProviderExchange
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" || tokens.AccessToken == "" {
+		log.Printf("%s token exchange rejected: status %d, %s %s", p.Name, resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+		return nil, ErrProviderExchange
+	}
+
+	return &tokens, nil
+}
+
+// Identify returns the account the tokens belong to. For OIDC providers the
+// ID token's signature, issuer, audience, expiry and nonce are checked.
+func (p *Provider) Identify(tokens *TokenResponse, nonce string) (*Claims, error) {
+	if p.GitHub {
+		return p.githubClaims(tokens.AccessToken)
+	}
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+func (p *Provider) discover() error {
+	if p.GitHub {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered {
+		return nil
+	}
+
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", "", &config); err != nil {
+		log.Printf("%s discovery failed: %v", p.Name, err)
+		return err
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return fmt.Errorf("%s discovery document is incomplete", p.Name)
+	}
+
+	p.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	p.AuthURL = config.AuthorizationEndpoint
+	p.TokenURL = config.TokenEndpoint
+	p.jwksURL = config.JWKSURI
+	p.discovered = true
+	return nil
+}
+
+func (p *Provider) verifyIDToken(idToken, nonce string) (*Claims, error) {
+	if idToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, p.signingKey,
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		log.Printf("%s id token rejected: %v", p.Name, err)
+		return nil, ErrInvalidIDToken
+	}
+
+	// Multi-tenant Microsoft endpoints publish an issuer template that is
+	// completed with the tenant the user signed in to.
+	issuer := p.Issuer
+	if tid, _ := claims["tid"].(string); tid != "" {
+		issuer = strings.ReplaceAll(issuer, "{tenantid}", tid)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != issuer {
+		log.Printf("%s id token has issuer %q, want %q", p.Name, iss, issuer)
+		return nil, ErrInvalidIDToken
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		log.Printf("%s id token nonce mismatch", p.Name)
+		return nil, ErrInvalidIDToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	result := &Claims{Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	for _, key := range []string{"preferred_username", "nickname", "name"} {
+		if username, _ := claims[key].(string); username != "" {
+			result.Username = username
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// signingKey resolves an ID token's kid against the provider's JWKS,
+// refetching once so newly rotated keys are picked up.
+func (p *Provider) signingKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(p.jwksURL, "", &jwks); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]any{}
+	for _, k := range jwks.Keys {
+		switch k.KeyType {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			p.keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Curve != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			p.keys[k.KeyID] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) githubClaims(accessToken string) (*Claims, error) {
+	var githubUser struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}
+	if err := p.getJSON(p.APIURL+"/user", accessToken, &githubUser); err != nil {
+		log.Printf("github user lookup failed: %v", err)
+		return nil, ErrProviderExchange
+	}
+	if githubUser.ID == 0 {
+		return nil, ErrProviderExchange
+	}
+
+	claims := &Claims{
+		Subject:  strconv.FormatInt(githubUser.ID, 10),
+		Username: githubUser.Login,
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(p.APIURL+"/user/emails", accessToken, &emails); err != nil {
+		log.Printf("github email lookup failed: %v", err)
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			claims.Email = e.Email
+			claims.EmailVerified = true
+			break
+		}
+	}
+	if claims.Email == "" && githubUser.Email != "" {
+		// GitHub only lets users publish a verified address on their profile.
+		claims.Email = githubUser.Email
+		claims.EmailVerified = true
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) getJSON(endpoint, bearer string, target any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// newPKCE returns a code verifier and its S256 challenge.
+func newPKCE() (string, string, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
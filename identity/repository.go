@@ -0,0 +1,177 @@
+package identity
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) CreateAuthState(state *AuthState) error {
+	// Abandoned authorization requests are cleared as new ones start.
+	_, err := r.DB.Exec(`DELETE FROM oauth_states WHERE expires_at < $1`, state.CreatedAt)
+	if err != nil {
+		log.Printf("delete expired oauth states failed: %v", err)
+		return err
+	}
+
+	_, err = r.DB.Exec(`
+		INSERT INTO oauth_states (state, provider, code_verifier, nonce, user_id, redirect_uri, binding_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9)
+	`, state.State, state.Provider, state.CodeVerifier, state.Nonce, state.UserID, state.RedirectURI, state.BindingHash, state.ExpiresAt, state.CreatedAt)
+	if err != nil {
+		log.Printf("CreateAuthState failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeAuthState deletes and returns the state, so each one can complete
+// at most one authorization.
+func (r *Repository) ConsumeAuthState(state string) (*AuthState, error) {
+	var (
+		authState AuthState
+		userID    sql.NullInt64
+	)
+	err := r.DB.QueryRow(`
+		DELETE FROM oauth_states
+		WHERE state = $1
+		RETURNING state, provider, code_verifier, nonce, user_id, redirect_uri, binding_hash, expires_at, created_at
+	`, state).Scan(
+		&authState.State,
+		&authState.Provider,
+		&authState.CodeVerifier,
+		&authState.Nonce,
+		&userID,
+		&authState.RedirectURI,
+		&authState.BindingHash,
+		&authState.ExpiresAt,
+		&authState.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrStateInvalid
+	} else if err != nil {
+		log.Printf("ConsumeAuthState failed: %v", err)
+		return nil, err
+	}
+
+	authState.UserID = int(userID.Int64)
+	return &authState, nil
+}
+
+func (r *Repository) GetIdentity(provider, subject string) (*Identity, error) {
+	var identity Identity
+	err := r.DB.QueryRow(`
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM identities
+		WHERE provider = $1 AND subject = $2
+	`, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrIdentityNotFound
+	} else if err != nil {
+		log.Printf("GetIdentity failed: %v", err)
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (r *Repository) ListIdentities(userID int) ([]Identity, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		log.Printf("ListIdentities failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (r *Repository) CreateIdentity(identity *Identity) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO identities (user_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt, identity.LastLoginAt).Scan(&identity.ID)
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return ErrDuplicateIdentity
+		}
+		log.Printf("CreateIdentity failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) TouchIdentity(identityID int, at time.Time) error {
+	_, err := r.DB.Exec(`UPDATE identities SET last_login_at = $1 WHERE id = $2`, at, identityID)
+	if err != nil {
+		log.Printf("TouchIdentity failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteIdentity(userID, identityID int) error {
+	result, err := r.DB.Exec(`DELETE FROM identities WHERE id = $1 AND user_id = $2`, identityID, userID)
+	if err != nil {
+		log.Printf("DeleteIdentity failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package identity
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	States     map[string]AuthState
+	Identities []Identity
+	FailRepo   bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		States: map[string]AuthState{},
+	}
+}
+
+func (m *MockRepo) CreateAuthState(state *AuthState) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	m.States[state.State] = *state
+	return nil
+}
+
+func (m *MockRepo) ConsumeAuthState(state string) (*AuthState, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	authState, ok := m.States[state]
+	if !ok {
+		return nil, ErrStateInvalid
+	}
+	delete(m.States, state)
+	return &authState, nil
+}
+
+func (m *MockRepo) GetIdentity(provider, subject string) (*Identity, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	for _, identity := range m.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func (m *MockRepo) ListIdentities(userID int) ([]Identity, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	identities := []Identity{}
+	for _, identity := range m.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (m *MockRepo) CreateIdentity(identity *Identity) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for _, existing := range m.Identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return ErrDuplicateIdentity
+		}
+	}
+	identity.ID = len(m.Identities) + 1
+	m.Identities = append(m.Identities, *identity)
+	return nil
+}
+
+func (m *MockRepo) TouchIdentity(identityID int, at time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i := range m.Identities {
+		if m.Identities[i].ID == identityID {
+			m.Identities[i].LastLoginAt = at
+		}
+	}
+	return nil
+}
+
+func (m *MockRepo) DeleteIdentity(userID, identityID int) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i, identity := range m.Identities {
+		if identity.ID == identityID && identity.UserID == userID {
+			m.Identities = append(m.Identities[:i], m.Identities[i+1:]...)
+			return nil
+		}
+	}
+	return ErrIdentityNotFound
+}
@@ -0,0 +1,257 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+// StartAuth begins an authorization request at provider and returns the URL
+// to send the browser to, and a binding the client must keep and present
+// with the callback. userID is the account to link the provider to, or 0 to
+// log in.
+func StartAuth(repo IdentityRepo, provider *Provider, userID int, redirectURI string) (string, string, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := randomString(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := newPKCE()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(state, challenge, nonce, redirectURI)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().UTC()
+	err = repo.CreateAuthState(&AuthState{
+		State:        state,
+		Provider:     provider.Name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       userID,
+		RedirectURI:  redirectURI,
+		BindingHash:  hashBinding(binding),
+		ExpiresAt:    now.Add(stateLifetime),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		log.Printf("repo.CreateAuthState failed: %v", err)
+		return "", "", err
+	}
+
+	return authURL, binding, nil
+}
+
+// CompleteAuth finishes the authorization request identified by state,
+// exchanging code with the PKCE verifier and verifying who signed in. binding
+// must be the one StartAuth returned to the client, and userID the signed-in
+// user, or 0 when completing a login; a link started by another user is
+// refused with ErrStateUserMismatch before the code is exchanged.
+func CompleteAuth(repo IdentityRepo, providers Registry, state, code, binding string, userID int) (*AuthState, *Claims, error) {
+	if state == "" || code == "" || binding == "" {
+		return nil, nil, ErrStateInvalid
+	}
+
+	authState, err := repo.ConsumeAuthState(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().UTC().After(authState.ExpiresAt) {
+		return nil, nil, ErrStateInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(hashBinding(binding)), []byte(authState.BindingHash)) != 1 {
+		return nil, nil, ErrStateInvalid
+	}
+	if authState.UserID != userID {
+		return nil, nil, ErrStateUserMismatch
+	}
+
+	provider, ok := providers[authState.Provider]
+	if !ok {
+		return nil, nil, ErrProviderNotFound
+	}
+
+	claims, err := Authenticate(provider, code, authState.CodeVerifier, authState.Nonce, authState.RedirectURI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authState, claims, nil
+}
+
+// Authenticate exchanges code at provider and returns the verified claims.
+func Authenticate(provider *Provider, code, codeVerifier, nonce, redirectURI string) (*Claims, error) {
+	tokens, err := provider.Exchange(code, codeVerifier, redirectURI)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Identify(tokens, nonce)
+}
+
+// Login returns the user the provider account belongs to. An unknown
+// account is linked to an existing user, or a new user is created for it,
+// only when the provider vouches for the email address. Either way the
+// address becomes a sign-in for whoever controls the provider account.
+func Login(repo IdentityRepo, userRepo user.UserRepo, provider string, claims *Claims) (*user.User, error) {
+	now := time.Now().UTC()
+
+	identity, err := repo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		existing, err := userRepo.GetUser(identity.UserID)
+		if err != nil {
+			log.Printf("userRepo.GetUser failed: %v", err)
+			return nil, err
+		}
+		if existing.AccountStatus != "active" {
+			return nil, user.ErrAccountDeleted
+		}
+		if err := repo.TouchIdentity(identity.ID, now); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	} else if !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, ErrEmailRequired
+	}
+	if !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	account, err := userRepo.GetUserByEmail(claims.Email)
+	if err == nil {
+		if account.AccountStatus != "active" {
+			return nil, user.ErrAccountDeleted
+		}
+	} else {
+		account, err = user.CreateExternalUser(userRepo, claims.Email, username(claims))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = repo.CreateIdentity(&Identity{
+		UserID:      account.ID,
+		Provider:    provider,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	})
+	if err != nil {
+		log.Printf("repo.CreateIdentity failed: %v", err)
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// Link attaches the provider account to userID. Linking an account that is
+// already attached to the same user is a no-op.
+func Link(repo IdentityRepo, userID int, provider string, claims *Claims) (*Identity, error) {
+	existing, err := repo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityLinked
+		}
+		return existing, nil
+	} else if !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	identity := &Identity{
+		UserID:      userID,
+		Provider:    provider,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		CreatedAt:   now,
+		LastLoginAt: now,
+	}
+	if err := repo.CreateIdentity(identity); err != nil {
+		if errors.Is(err, ErrDuplicateIdentity) {
+			return nil, ErrIdentityLinked
+		}
+		log.Printf("repo.CreateIdentity failed: %v", err)
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func ListIdentities(repo IdentityRepo, userID int) ([]Identity, error) {
+	identities, err := repo.ListIdentities(userID)
+	if err != nil {
+		log.Printf("repo.ListIdentities failed: %v", err)
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// Unlink removes a linked provider, refusing to remove the last one from an
+// account that has no password.
+func Unlink(repo IdentityRepo, userRepo user.UserRepo, userID, identityID int) error {
+	identities, err := repo.ListIdentities(userID)
+	if err != nil {
+		log.Printf("repo.ListIdentities failed: %v", err)
+		return err
+	}
+
+	found := false
+	for _, identity := range identities {
+		if identity.ID == identityID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrIdentityNotFound
+	}
+
+	if len(identities) == 1 {
+		hasPassword, err := user.HasPassword(userRepo, userID)
+		if err != nil {
+			log.Printf("user.HasPassword failed: %v", err)
+			return err
+		}
+		if !hasPassword {
+			return ErrLastLoginMethod
+		}
+	}
+
+	return repo.DeleteIdentity(userID, identityID)
+}
+
+func username(claims *Claims) string {
+	if claims.Username != "" {
+		return claims.Username
+	}
+	local, _, _ := strings.Cut(claims.Email, "@")
+	return local
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
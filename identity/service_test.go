@@ -0,0 +1,364 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+// fakeIssuer is a minimal OIDC provider: discovery, a token endpoint that
+// enforces PKCE, and a JWKS with one RSA key.
+type fakeIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %v", err)
+	}
+
+	f := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+			"jwks_uri":               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":            f.server.URL,
+			"aud":            "client-id",
+			"sub":            "subject-1",
+			"email":          "new@example.com",
+			"email_verified": true,
+			"nonce":          f.nonce,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range f.claims {
+			claims[k] = v
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		idToken.Header["kid"] = "test-key"
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			t.Errorf("SignedString failed: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": signed})
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// start runs StartAuth and records what the browser would carry to the
+// provider's authorize endpoint. It returns the state and the client's
+// binding.
+func (f *fakeIssuer) start(t *testing.T, repo *MockRepo, provider *Provider, userID int) (string, string) {
+	authURL, binding, err := StartAuth(repo, provider, userID, "https://app.example.com/oauth/callback")
+	if err != nil {
+		t.Fatalf("StartAuth failed: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse failed: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "client-id" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	f.challenge = query.Get("code_challenge")
+	f.nonce = query.Get("nonce")
+	return query.Get("state"), binding
+}
+
+func TestCompleteAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		claims      jwt.MapClaims
+		code        string
+		reuseState  bool
+		expiredAt   time.Time
+		binding     string
+		linkUserID  int
+		userID      int
+		expectedErr error
+	}{
+		{
+			name: "CompleteAuth_Success",
+			code: "good-code",
+		},
+		{
+			name:        "CompleteAuth_WrongAudience",
+			code:        "good-code",
+			claims:      jwt.MapClaims{"aud": "someone-else"},
+			expectedErr: ErrInvalidIDToken,
+		},
+		{
+			name:        "CompleteAuth_WrongNonce",
+			code:        "good-code",
+			claims:      jwt.MapClaims{"nonce": "replayed"},
+			expectedErr: ErrInvalidIDToken,
+		},
+		{
+			name:        "CompleteAuth_WrongIssuer",
+			code:        "good-code",
+			claims:      jwt.MapClaims{"iss": "https://evil.example.com"},
+			expectedErr: ErrInvalidIDToken,
+		},
+		{
+			name:        "CompleteAuth_CodeRejected",
+			code:        "bad-code",
+			expectedErr: ErrProviderExchange,
+		},
+		{
+			name:        "CompleteAuth_StateReused",
+			code:        "good-code",
+			reuseState:  true,
+			expectedErr: ErrStateInvalid,
+		},
+		{
+			name:        "CompleteAuth_StateExpired",
+			code:        "good-code",
+			expiredAt:   time.Now().Add(-time.Minute),
+			expectedErr: ErrStateInvalid,
+		},
+		{
+			name:        "CompleteAuth_OtherClientsBinding",
+			code:        "good-code",
+			binding:     "stolen",
+			expectedErr: ErrStateInvalid,
+		},
+		{
+			name:       "CompleteAuth_Link",
+			code:       "good-code",
+			linkUserID: 5,
+			userID:     5,
+		},
+		{
+			name:        "CompleteAuth_LinkCompletedByAnotherUser",
+			code:        "good-code",
+			linkUserID:  5,
+			userID:      6,
+			expectedErr: ErrStateUserMismatch,
+		},
+		{
+			name:        "CompleteAuth_LinkCompletedAsLogin",
+			code:        "good-code",
+			linkUserID:  5,
+			expectedErr: ErrStateUserMismatch,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			issuer := newFakeIssuer(t)
+			issuer.claims = tc.claims
+			provider := newOIDCProvider("test", "Test", issuer.server.URL, "client-id", "secret")
+			registry := Registry{"test": provider}
+			repo := NewMockRepo()
+
+			state, binding := issuer.start(t, repo, provider, tc.linkUserID)
+			if tc.binding != "" {
+				binding = tc.binding
+			}
+			if !tc.expiredAt.IsZero() {
+				authState := repo.States[state]
+				authState.ExpiresAt = tc.expiredAt
+				repo.States[state] = authState
+			}
+			if tc.reuseState {
+				if _, _, err := CompleteAuth(repo, registry, state, tc.code, binding, tc.userID); err != nil {
+					t.Fatalf("first CompleteAuth failed: %v", err)
+				}
+			}
+
+			authState, claims, err := CompleteAuth(repo, registry, state, tc.code, binding, tc.userID)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil {
+				if authState.Provider != "test" || authState.UserID != tc.linkUserID {
+					t.Errorf("unexpected auth state %+v", authState)
+				}
+				if claims.Subject != "subject-1" || claims.Email != "new@example.com" || !claims.EmailVerified {
+					t.Errorf("unexpected claims %+v", claims)
+				}
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name             string
+		claims           Claims
+		existingIdentity bool
+		noExistingUser   bool
+		expectedErr      error
+		expectedUserID   int
+	}{
+		{
+			name:             "Login_KnownIdentity",
+			claims:           Claims{Subject: "sub", Email: "whatever@example.com"},
+			existingIdentity: true,
+			expectedUserID:   7,
+		},
+		{
+			name:           "Login_NewUser",
+			claims:         Claims{Subject: "sub", Email: "new@example.com", EmailVerified: true},
+			noExistingUser: true,
+			expectedUserID: 1,
+		},
+		{
+			name:           "Login_LinksVerifiedEmail",
+			claims:         Claims{Subject: "sub", Email: "test@test.com", EmailVerified: true},
+			expectedUserID: 1,
+		},
+		{
+			name:        "Login_UnverifiedEmailForExistingAccount",
+			claims:      Claims{Subject: "sub", Email: "test@test.com"},
+			expectedErr: ErrEmailNotVerified,
+		},
+		{
+			name:           "Login_UnverifiedEmailForNewAccount",
+			claims:         Claims{Subject: "sub", Email: "new@example.com"},
+			noExistingUser: true,
+			expectedErr:    ErrEmailNotVerified,
+		},
+		{
+			name:           "Login_NoEmail",
+			claims:         Claims{Subject: "sub"},
+			noExistingUser: true,
+			expectedErr:    ErrEmailRequired,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{ID: 1, Email: "test@test.com", AccountStatus: "active"}
+			userRepo.Users[7] = user.User{ID: 7, Email: "linked@example.com", AccountStatus: "active"}
+			userRepo.FailGetUserByEmail = tc.noExistingUser
+			if tc.existingIdentity {
+				repo.Identities = append(repo.Identities, Identity{ID: 1, UserID: 7, Provider: "test", Subject: "sub"})
+			}
+
+			account, err := Login(repo, userRepo, "test", &tc.claims)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr != nil {
+				if _, err := repo.GetIdentity("test", "sub"); !tc.existingIdentity && err == nil {
+					t.Errorf("expected no identity to be recorded")
+				}
+			}
+			if tc.expectedErr == nil {
+				if account.ID != tc.expectedUserID {
+					t.Errorf("expected user %d but got %d", tc.expectedUserID, account.ID)
+				}
+				identity, err := repo.GetIdentity("test", "sub")
+				if err != nil || identity.UserID != tc.expectedUserID {
+					t.Errorf("expected identity linked to user %d, got %+v, err=%v", tc.expectedUserID, identity, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkAndUnlink(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	repo := NewMockRepo()
+	userRepo := user.NewMockRepo()
+
+	linked, err := Link(repo, 1, "google", &Claims{Subject: "g-1", Email: "test@test.com"})
+	if err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if again, err := Link(repo, 1, "google", &Claims{Subject: "g-1"}); err != nil || again.ID != linked.ID {
+		t.Fatalf("expected relinking to be a no-op, got %+v, err=%v", again, err)
+	}
+	if _, err := Link(repo, 2, "google", &Claims{Subject: "g-1"}); err != ErrIdentityLinked {
+		t.Fatalf("expected ErrIdentityLinked for another user, got %v", err)
+	}
+
+	if err := Unlink(repo, userRepo, 2, linked.ID); err != ErrIdentityNotFound {
+		t.Fatalf("expected another user's identity to be hidden, got %v", err)
+	}
+
+	original := user.PasswordHashed
+	user.PasswordHashed = []byte("external_login")
+	err = Unlink(repo, userRepo, 1, linked.ID)
+	user.PasswordHashed = original
+	if err != ErrLastLoginMethod {
+		t.Fatalf("expected ErrLastLoginMethod without a password, got %v", err)
+	}
+
+	if err := Unlink(repo, userRepo, 1, linked.ID); err != nil {
+		t.Fatalf("Unlink failed: %v", err)
+	}
+	identities, _ := ListIdentities(repo, 1)
+	if len(identities) != 0 {
+		t.Fatalf("expected no linked identities, got %+v", identities)
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
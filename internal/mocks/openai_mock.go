@@ -75,6 +75,7 @@ var responseFixtures = map[string]*chatgpt.ChatGPTResponse{
 
 type MockOpenAIClient struct {
 	Scenario string
+	Err      error
 }
 
 func NewMockOpenAIClient() *MockOpenAIClient {
@@ -84,10 +85,16 @@ func NewMockOpenAIClient() *MockOpenAIClient {
 }
 
 func (m *MockOpenAIClient) GetChatGPTResponse(prompt string) (*chatgpt.ChatGPTResponse, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
 	return responseFixtures[ScenarioInterview], nil
 }
 
 func (m *MockOpenAIClient) GetChatGPTResponseConversation(_ []map[string]string) (*chatgpt.ChatGPTResponse, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
 	resp, ok := responseFixtures[m.Scenario]
 	if !ok {
 		return nil, fmt.Errorf("invalid scenario: %s", m.Scenario)
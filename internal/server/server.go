@@ -1,19 +1,33 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 
+	"github.com/michaelboegner/interviewer/apikey"
+	"github.com/michaelboegner/interviewer/audit"
 	"github.com/michaelboegner/interviewer/billing"
 	"github.com/michaelboegner/interviewer/chatgpt"
 	"github.com/michaelboegner/interviewer/conversation"
 	"github.com/michaelboegner/interviewer/database"
 	"github.com/michaelboegner/interviewer/handlers"
+	"github.com/michaelboegner/interviewer/identity"
 	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/ledger"
 	"github.com/michaelboegner/interviewer/mailer"
+	"github.com/michaelboegner/interviewer/mfa"
 	"github.com/michaelboegner/interviewer/middleware"
+	"github.com/michaelboegner/interviewer/notification"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/passkey"
+	"github.com/michaelboegner/interviewer/privacy"
+	"github.com/michaelboegner/interviewer/promotion"
+	"github.com/michaelboegner/interviewer/referral"
+	"github.com/michaelboegner/interviewer/throttle"
 	"github.com/michaelboegner/interviewer/token"
 	"github.com/michaelboegner/interviewer/user"
 )
@@ -35,15 +49,47 @@ func NewServer(logger *slog.Logger) (*Server, error) {
 	tokenRepo := token.NewRepository(db)
 	conversationRepo := conversation.NewRepository(db)
 	billingRepo := billing.NewRepository(db)
+	promotionRepo := promotion.NewRepository(db)
+	referralRepo := referral.NewRepository(db)
+	identityRepo := identity.NewRepository(db)
+	providers := identity.LoadProviders()
+	mfaRepo := mfa.NewRepository(db)
+	passkeyRepo := passkey.NewRepository(db)
+	throttleStore := throttle.NewStore(db)
+	apiKeyRepo := apikey.NewRepository(db)
+	auditRepo := audit.NewRepository(db)
+	privacyRepo := privacy.NewRepository(db)
+	outboxRepo := outbox.NewRepository(db)
+	notificationRepo := notification.NewRepository(db)
+	middleware.UseAPIKeys(apiKeyRepo)
 	openAI := chatgpt.NewOpenAI(logger)
-	mailer := mailer.NewMailer(logger)
-	billing, err := billing.NewBilling(logger)
+	mailClient, err := mailer.NewMailer(logger)
+	if err != nil {
+		logger.Error("mailer.NewMailer failed", "error", err)
+		return nil, err
+	}
+	billingService, err := billing.NewBilling(logger, billingRepo)
 	if err != nil {
 		logger.Error("billing.NewBilling failed", "error", err)
 		return nil, err
 	}
 
-	handler := handlers.NewHandler(interviewRepo, userRepo, tokenRepo, conversationRepo, billingRepo, billing, mailer, openAI, db)
+	webhookProcessor := billing.NewWebhookProcessor(billingService, userRepo, billingRepo, auditRepo)
+	webhookProcessor.Apply = func(event *billing.Event) error {
+		return referral.HandleBillingEvent(referralRepo, userRepo, event)
+	}
+	webhookProcessor.OnProcessed = func(event *billing.Event) {
+		_ = notification.HandleBillingEvent(notificationRepo, outboxRepo, userRepo, event)
+	}
+	go webhookProcessor.Start(context.Background())
+	go billingService.RefreshPlans(context.Background(), billingRepo, billing.PlanRefreshInterval)
+	go outbox.NewDispatcher(outboxRepo, mailClient, logger).Start(context.Background())
+	go ledger.NewExpiryJob(ledger.NewRepository(db)).Start(context.Background())
+	go billing.NewReservationJob(billingRepo).Start(context.Background())
+	go privacy.NewRetentionJob(privacyRepo, auditRepo).Start(context.Background())
+	go notification.NewReminderJob(notificationRepo, outboxRepo).Start(context.Background())
+
+	handler := handlers.NewHandler(interviewRepo, userRepo, tokenRepo, conversationRepo, billingRepo, promotionRepo, referralRepo, identityRepo, providers, mfaRepo, passkeyRepo, throttleStore, apiKeyRepo, auditRepo, privacyRepo, outboxRepo, notificationRepo, billingService, openAI, db)
 
 	mux.Handle("/api/users", http.HandlerFunc(handler.CreateUsersHandler))
 	mux.Handle("/api/auth/login", http.HandlerFunc(handler.LoginHandler))
@@ -52,10 +98,25 @@ func NewServer(logger *slog.Logger) (*Server, error) {
 	mux.Handle("/api/auth/check-email", http.HandlerFunc(handler.CheckEmailHandler))
 	mux.Handle("/api/auth/request-reset", http.HandlerFunc(handler.RequestResetHandler))
 	mux.Handle("/api/auth/reset-password", http.HandlerFunc(handler.ResetPasswordHandler))
+	mux.Handle("/api/auth/email/confirm", http.HandlerFunc(handler.ConfirmEmailChangeHandler))
+	mux.Handle("/api/auth/email/cancel", http.HandlerFunc(handler.CancelEmailChangeHandler))
+	mux.Handle("/api/auth/reactivate/request", http.HandlerFunc(handler.RequestReactivationHandler))
+	mux.Handle("/api/auth/reactivate", http.HandlerFunc(handler.ReactivateAccountHandler))
 	mux.Handle("/api/auth/token", http.HandlerFunc(handler.RefreshTokensHandler))
+	mux.Handle("/api/auth/mfa", http.HandlerFunc(handler.MFALoginHandler))
+	mux.Handle("/api/auth/passkey/begin", http.HandlerFunc(handler.PasskeyLoginBeginHandler))
+	mux.Handle("/api/auth/passkey/finish", http.HandlerFunc(handler.PasskeyLoginFinishHandler))
+	mux.Handle("/api/auth/oauth/providers", http.HandlerFunc(handler.OAuthProvidersHandler))
+	mux.Handle("/api/auth/oauth/start", http.HandlerFunc(handler.OAuthStartHandler))
+	mux.Handle("/api/auth/oauth/callback", http.HandlerFunc(handler.OAuthCallbackHandler))
 	mux.Handle("/api/webhooks/billing", http.HandlerFunc(handler.BillingWebhookHandler))
 	mux.Handle("/api/jd", http.HandlerFunc(handler.JDInputHandler))
+	mux.Handle("/api/notifications/unsubscribe", http.HandlerFunc(handler.UnsubscribeHandler))
 	mux.Handle("/health", http.HandlerFunc(handler.HealthCheckHandler))
+	mux.Handle("/.well-known/jwks.json", http.HandlerFunc(handler.JWKSHandler))
+	if capture, ok := mailClient.Transport.(*mailer.Capture); ok && os.Getenv("ENV") != "production" {
+		mux.Handle("/dev/mail/", capture.Handler("/dev/mail/"))
+	}
 
 	mux.Handle("/api/users/",
 		middleware.GetContext(
@@ -71,6 +132,34 @@ func NewServer(logger *slog.Logger) (*Server, error) {
 			),
 		),
 	)
+	mux.Handle("/api/users/export",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ExportDataHandler),
+			),
+		),
+	)
+	mux.Handle("/api/users/locale",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.UpdateLocaleHandler),
+			),
+		),
+	)
+	mux.Handle("/api/users/notifications",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.NotificationPreferencesHandler),
+			),
+		),
+	)
+	mux.Handle("/api/users/email",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ChangeEmailHandler),
+			),
+		),
+	)
 	mux.Handle("/api/interviews",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
@@ -115,6 +204,90 @@ func NewServer(logger *slog.Logger) (*Server, error) {
 			),
 		),
 	)
+	mux.Handle("/api/sessions",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.SessionsHandler),
+			),
+		),
+	)
+	mux.Handle("/api/sessions/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.RevokeSessionHandler),
+			),
+		),
+	)
+	mux.Handle("/api/identities",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.IdentitiesHandler),
+			),
+		),
+	)
+	mux.Handle("/api/identities/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.IdentityHandler),
+			),
+		),
+	)
+	mux.Handle("/api/apikeys",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.APIKeysHandler),
+			),
+		),
+	)
+	mux.Handle("/api/apikeys/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.APIKeyHandler),
+			),
+		),
+	)
+	mux.Handle("/api/passkeys",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.PasskeysHandler),
+			),
+		),
+	)
+	mux.Handle("/api/passkeys/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.PasskeyHandler),
+			),
+		),
+	)
+	mux.Handle("/api/mfa",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.MFAStatusHandler),
+			),
+		),
+	)
+	mux.Handle("/api/mfa/totp",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.DisableTOTPHandler),
+			),
+		),
+	)
+	mux.Handle("/api/mfa/totp/enroll",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.EnrollTOTPHandler),
+			),
+		),
+	)
+	mux.Handle("/api/mfa/totp/verify",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ConfirmTOTPHandler),
+			),
+		),
+	)
 	mux.Handle("/api/payment/checkout",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
@@ -143,6 +316,160 @@ func NewServer(logger *slog.Logger) (*Server, error) {
 			),
 		),
 	)
+	mux.Handle("/api/payment/history",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.BillingHistoryHandler),
+			),
+		),
+	)
+	mux.Handle("/api/payment/receipts/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ReceiptHandler),
+			),
+		),
+	)
+	mux.Handle("/api/promotions/redeem",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.RedeemPromotionHandler),
+			),
+		),
+	)
+	mux.Handle("/api/admin/audit",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminAuditHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/users",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUsersHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/users/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUserHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/mfa/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminDisableMFAHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/promotions",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminPromotionsHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/promotions/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUpdatePromotionHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/plans",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminPlansHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/plans/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUpdatePlanHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/emails",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminEmailsHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/emails/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminEmailPreviewHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/webhooks",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminWebhooksHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/webhooks/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminWebhookHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/outbox",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminOutboxHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/admin/outbox/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminOutboxEmailHandler),
+				),
+			),
+		),
+	)
+	mux.Handle("/api/security/activity",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.SecurityActivityHandler),
+			),
+		),
+	)
 	mux.Handle("/api/user/dashboard",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
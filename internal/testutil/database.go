@@ -0,0 +1,61 @@
+package testutil
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/michaelboegner/interviewer/database"
+)
+
+// OpenTestDB connects to the migrated test database from .env.test, as
+// `make test` provides it. Tests are skipped when it is not available.
+func OpenTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	if err := godotenv.Load("../.env.test"); err != nil {
+		t.Skipf("test database not configured: %v", err)
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		t.Skipf("test database not reachable: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// InsertTestUser creates a user with a unique email and removes it, and the
+// rows given in cleanup, when the test ends. Packages are tested in
+// parallel, so repository tests clean up their own rows instead of
+// truncating.
+func InsertTestUser(t *testing.T, db *sql.DB, cleanup ...string) int {
+	t.Helper()
+
+	now := time.Now().UTC()
+	var userID int
+	err := db.QueryRow(`
+		INSERT INTO users (username, email, password, created_at, updated_at)
+		VALUES ('repotest', $1, '', $2, $2)
+		RETURNING id
+	`, fmt.Sprintf("repotest-%d@test.com", now.UnixNano()), now).Scan(&userID)
+	if err != nil {
+		t.Fatalf("insert test user failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		for _, statement := range cleanup {
+			if _, err := db.Exec(statement, userID); err != nil {
+				t.Logf("cleanup %q failed: %v", statement, err)
+			}
+		}
+		if _, err := db.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+			t.Logf("delete test user failed: %v", err)
+		}
+	})
+
+	return userID
+}
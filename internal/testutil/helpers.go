@@ -7,7 +7,6 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -28,7 +27,7 @@ func CreateTestUserAndJWT(logger *slog.Logger) (string, int) {
 	email := "test@test.com"
 	password := "test"
 
-	verificationJWT, err := user.VerificationToken(email, username, password)
+	verificationJWT, err := user.VerificationToken(email, username, password, "", "")
 	if err != nil {
 		logger.Error("GenerateEmailVerificationToken failed", "error", err)
 	}
@@ -104,9 +103,6 @@ func CreateTestConversation(jwt string, interviewID int, logger *slog.Logger) in
 }
 
 func CreateTestExpiredJWT(id, expires int, logger *slog.Logger) string {
-	var token *jwt.Token
-	jwtSecret := os.Getenv("JWT_SECRET")
-	key := []byte(jwtSecret)
 	now := time.Now().UTC()
 
 	if expires == 0 {
@@ -120,11 +116,10 @@ func CreateTestExpiredJWT(id, expires int, logger *slog.Logger) string {
 		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		Subject:   strconv.Itoa(id),
 	}
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	s, err := token.SignedString(key)
+	s, err := token.Sign(token.PurposeAccess, claims)
 	if err != nil {
-		logger.Error("SignedString failed", "error", err)
+		logger.Error("token.Sign failed", "error", err)
 		return ""
 	}
 
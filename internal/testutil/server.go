@@ -5,13 +5,24 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/michaelboegner/interviewer/apikey"
+	"github.com/michaelboegner/interviewer/audit"
 	"github.com/michaelboegner/interviewer/billing"
 	"github.com/michaelboegner/interviewer/conversation"
 	"github.com/michaelboegner/interviewer/database"
 	"github.com/michaelboegner/interviewer/handlers"
+	"github.com/michaelboegner/interviewer/identity"
 	"github.com/michaelboegner/interviewer/internal/mocks"
 	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/mfa"
 	"github.com/michaelboegner/interviewer/middleware"
+	"github.com/michaelboegner/interviewer/notification"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/passkey"
+	"github.com/michaelboegner/interviewer/privacy"
+	"github.com/michaelboegner/interviewer/promotion"
+	"github.com/michaelboegner/interviewer/referral"
+	"github.com/michaelboegner/interviewer/throttle"
 	"github.com/michaelboegner/interviewer/token"
 	"github.com/michaelboegner/interviewer/user"
 )
@@ -37,15 +48,27 @@ func InitTestServer(logger *slog.Logger) (*handlers.Handler, error) {
 	tokenRepo := token.NewRepository(db)
 	conversationRepo := conversation.NewRepository(db)
 	billingRepo := billing.NewRepository(db)
+	promotionRepo := promotion.NewRepository(db)
+	referralRepo := referral.NewRepository(db)
+	identityRepo := identity.NewRepository(db)
+	providers := identity.LoadProviders()
+	mfaRepo := mfa.NewRepository(db)
+	passkeyRepo := passkey.NewRepository(db)
+	throttleStore := throttle.NewMemoryStore()
+	apiKeyRepo := apikey.NewRepository(db)
+	auditRepo := audit.NewRepository(db)
+	privacyRepo := privacy.NewRepository(db)
+	outboxRepo := outbox.NewRepository(db)
+	notificationRepo := notification.NewRepository(db)
+	middleware.UseAPIKeys(apiKeyRepo)
 	openAI := mocks.NewMockOpenAIClient()
-	mailer := mocks.NewMockMailer()
-	billing, err := billing.NewBilling(logger)
+	billing, err := billing.NewBilling(logger, billingRepo)
 	if err != nil {
 		logger.Error("billing.NewBilling failed", "error", err)
 		return nil, err
 	}
 
-	handler := handlers.NewHandler(interviewRepo, userRepo, tokenRepo, conversationRepo, billingRepo, billing, mailer, openAI, db)
+	handler := handlers.NewHandler(interviewRepo, userRepo, tokenRepo, conversationRepo, billingRepo, promotionRepo, referralRepo, identityRepo, providers, mfaRepo, passkeyRepo, throttleStore, apiKeyRepo, auditRepo, privacyRepo, outboxRepo, notificationRepo, billing, openAI, db)
 
 	TestMux = http.NewServeMux()
 	TestMux.Handle("/api/users", http.HandlerFunc(handler.CreateUsersHandler))
@@ -55,10 +78,22 @@ func InitTestServer(logger *slog.Logger) (*handlers.Handler, error) {
 	TestMux.Handle("/api/auth/check-email", http.HandlerFunc(handler.CheckEmailHandler))
 	TestMux.Handle("/api/auth/request-reset", http.HandlerFunc(handler.RequestResetHandler))
 	TestMux.Handle("/api/auth/reset-password", http.HandlerFunc(handler.ResetPasswordHandler))
+	TestMux.Handle("/api/auth/email/confirm", http.HandlerFunc(handler.ConfirmEmailChangeHandler))
+	TestMux.Handle("/api/auth/email/cancel", http.HandlerFunc(handler.CancelEmailChangeHandler))
+	TestMux.Handle("/api/auth/reactivate/request", http.HandlerFunc(handler.RequestReactivationHandler))
+	TestMux.Handle("/api/auth/reactivate", http.HandlerFunc(handler.ReactivateAccountHandler))
 	TestMux.Handle("/api/auth/token", http.HandlerFunc(handler.RefreshTokensHandler))
+	TestMux.Handle("/api/auth/mfa", http.HandlerFunc(handler.MFALoginHandler))
+	TestMux.Handle("/api/auth/passkey/begin", http.HandlerFunc(handler.PasskeyLoginBeginHandler))
+	TestMux.Handle("/api/auth/passkey/finish", http.HandlerFunc(handler.PasskeyLoginFinishHandler))
+	TestMux.Handle("/api/auth/oauth/providers", http.HandlerFunc(handler.OAuthProvidersHandler))
+	TestMux.Handle("/api/auth/oauth/start", http.HandlerFunc(handler.OAuthStartHandler))
+	TestMux.Handle("/api/auth/oauth/callback", http.HandlerFunc(handler.OAuthCallbackHandler))
 	TestMux.Handle("/api/webhooks/billing", http.HandlerFunc(handler.BillingWebhookHandler))
 	TestMux.Handle("/api/jd", http.HandlerFunc(handler.JDInputHandler))
+	TestMux.Handle("/api/notifications/unsubscribe", http.HandlerFunc(handler.UnsubscribeHandler))
 	TestMux.Handle("/health", http.HandlerFunc(handler.HealthCheckHandler))
+	TestMux.Handle("/.well-known/jwks.json", http.HandlerFunc(handler.JWKSHandler))
 
 	TestMux.Handle("/api/users/",
 		middleware.GetContext(
@@ -74,6 +109,34 @@ func InitTestServer(logger *slog.Logger) (*handlers.Handler, error) {
 			),
 		),
 	)
+	TestMux.Handle("/api/users/export",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ExportDataHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/users/locale",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.UpdateLocaleHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/users/notifications",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.NotificationPreferencesHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/users/email",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ChangeEmailHandler),
+			),
+		),
+	)
 	TestMux.Handle("/api/interviews",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
@@ -118,6 +181,90 @@ func InitTestServer(logger *slog.Logger) (*handlers.Handler, error) {
 			),
 		),
 	)
+	TestMux.Handle("/api/sessions",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.SessionsHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/sessions/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.RevokeSessionHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/identities",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.IdentitiesHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/identities/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.IdentityHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/apikeys",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.APIKeysHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/apikeys/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.APIKeyHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/passkeys",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.PasskeysHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/passkeys/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.PasskeyHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/mfa",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.MFAStatusHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/mfa/totp",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.DisableTOTPHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/mfa/totp/enroll",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.EnrollTOTPHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/mfa/totp/verify",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ConfirmTOTPHandler),
+			),
+		),
+	)
 	TestMux.Handle("/api/payment/checkout",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
@@ -146,6 +293,160 @@ func InitTestServer(logger *slog.Logger) (*handlers.Handler, error) {
 			),
 		),
 	)
+	TestMux.Handle("/api/payment/history",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.BillingHistoryHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/payment/receipts/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.ReceiptHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/promotions/redeem",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.RedeemPromotionHandler),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/audit",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminAuditHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/users",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUsersHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/users/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUserHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/mfa/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminDisableMFAHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/promotions",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminPromotionsHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/promotions/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUpdatePromotionHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/plans",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminPlansHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/plans/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminUpdatePlanHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/emails",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminEmailsHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/emails/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminEmailPreviewHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/webhooks",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminWebhooksHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/webhooks/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminWebhookHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/outbox",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminOutboxHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/admin/outbox/",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				middleware.Authorize(userRepo)(
+					http.HandlerFunc(handler.AdminOutboxEmailHandler),
+				),
+			),
+		),
+	)
+	TestMux.Handle("/api/security/activity",
+		middleware.GetContext(
+			middleware.ValidateUserActive(userRepo)(
+				http.HandlerFunc(handler.SecurityActivityHandler),
+			),
+		),
+	)
 	TestMux.Handle("/api/user/dashboard",
 		middleware.GetContext(
 			middleware.ValidateUserActive(userRepo)(
@@ -33,6 +33,10 @@ type Summary struct {
 
 var ErrNoValidCredits = errors.New("no valid credits")
 
+// MaxProviderFailures is the number of consecutive AI provider errors after
+// which an interview is marked failed and its credit refunded.
+const MaxProviderFailures = 3
+
 type InterviewRepo interface {
 	LinkConversation(interviewID, conversationID int) error
 	CreateInterview(interview *Interview) (int, error)
@@ -40,4 +44,5 @@ type InterviewRepo interface {
 	GetInterviewSummariesByUserID(userID int) ([]Summary, error)
 	UpdateScore(interviewID, pointsEarned int) error
 	UpdateStatus(interviewID, userID int, status string) error
+	RecordProviderFailure(interviewID int) (int, error)
 }
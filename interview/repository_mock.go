@@ -6,7 +6,10 @@ import (
 )
 
 type MockRepo struct {
-	FailRepo bool
+	FailRepo         bool
+	ProviderFailures int
+	Statuses         []string
+	Status           string
 }
 
 func NewMockRepo() *MockRepo {
@@ -40,6 +43,9 @@ func (m *MockRepo) GetInterview(interviewID int) (*Interview, error) {
 		CreatedAt:       time.Now().UTC(),
 		UpdatedAt:       time.Now().UTC(),
 	}
+	if m.Status != "" {
+		interview.Status = m.Status
+	}
 	return interview, nil
 }
 
@@ -74,9 +80,19 @@ func (m *MockRepo) UpdateStatus(interviewID, userID int, status string) error {
 		return errors.New("mocked DB failure")
 	}
 
+	m.Statuses = append(m.Statuses, status)
 	return nil
 }
 
+func (m *MockRepo) RecordProviderFailure(interviewID int) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+
+	m.ProviderFailures++
+	return m.ProviderFailures, nil
+}
+
 func (m *MockRepo) LinkConversation(interviewID, conversationID int) error {
 	if m.FailRepo {
 		return errors.New("mocked DB failure")
@@ -159,6 +159,7 @@ func (repo *Repository) UpdateScore(interviewID, pointsEarned int) error {
 			number_questions_answered = number_questions_answered + 1,
 			score_numerator = score_numerator + $1,
 			score = ROUND((score_numerator + $1)::decimal / ((number_questions_answered + 1) * 10) * 100),
+			provider_failures = 0,
 			updated_at = $2
 		WHERE id = $3
 	`
@@ -171,3 +172,21 @@ func (repo *Repository) UpdateStatus(interviewID, userID int, status string) err
 	_, err := repo.DB.Exec(query, status, time.Now().UTC(), interviewID, userID)
 	return err
 }
+
+func (repo *Repository) RecordProviderFailure(interviewID int) (int, error) {
+	query := `
+		UPDATE interviews
+		SET provider_failures = provider_failures + 1, updated_at = $1
+		WHERE id = $2
+		RETURNING provider_failures
+	`
+
+	var failures int
+	err := repo.DB.QueryRow(query, time.Now().UTC(), interviewID).Scan(&failures)
+	if err != nil {
+		log.Printf("RecordProviderFailure failed: %v", err)
+		return 0, err
+	}
+
+	return failures, nil
+}
@@ -1,6 +1,7 @@
 package interview
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -21,12 +22,44 @@ func StartInterview(
 	difficulty string,
 	jd string) (*Interview, error) {
 
-	err := deductAndLogCredit(user, userRepo, billingRepo)
+	reservationID, err := reserveCredit(user, billingRepo)
 	if err != nil {
-		log.Printf("checkCreditsLogTransaction failed: %v", err)
+		log.Printf("reserveCredit failed: %v", err)
 		return nil, err
 	}
 
+	interview, err := createInterview(interviewRepo, ai, user, length, numberQuestions, difficulty, jd)
+	if err != nil {
+		if releaseErr := billingRepo.ReleaseReservation(reservationID, "Interview credit released: interview could not be started"); releaseErr != nil {
+			log.Printf("billingRepo.ReleaseReservation failed: %v", releaseErr)
+		}
+		return nil, err
+	}
+
+	err = billingRepo.CommitReservation(reservationID, interview.Id)
+	if err != nil {
+		log.Printf("billingRepo.CommitReservation failed: %v", err)
+		if releaseErr := billingRepo.ReleaseReservation(reservationID, "Interview credit released: interview could not be started"); releaseErr != nil {
+			log.Printf("billingRepo.ReleaseReservation failed: %v", releaseErr)
+		}
+		if statusErr := interviewRepo.UpdateStatus(interview.Id, user.ID, "failed"); statusErr != nil {
+			log.Printf("interviewRepo.UpdateStatus failed: %v", statusErr)
+		}
+		return nil, err
+	}
+
+	return interview, nil
+}
+
+func createInterview(
+	interviewRepo InterviewRepo,
+	ai chatgpt.AIClient,
+	user *user.User,
+	length,
+	numberQuestions int,
+	difficulty string,
+	jd string) (*Interview, error) {
+
 	now := time.Now().UTC()
 	jdSummary := ""
 
@@ -77,6 +110,49 @@ func StartInterview(
 	return interview, nil
 }
 
+// HandleProviderFailure records an AI provider error for an interview. Once
+// MaxProviderFailures consecutive errors are reached the interview is marked
+// failed and its credit is refunded; the returned bool reports that case.
+func HandleProviderFailure(interviewRepo InterviewRepo, billingRepo billing.BillingRepo, interviewID, userID int) (bool, error) {
+	failures, err := interviewRepo.RecordProviderFailure(interviewID)
+	if err != nil {
+		log.Printf("interviewRepo.RecordProviderFailure failed: %v", err)
+		return false, err
+	}
+
+	if failures < MaxProviderFailures {
+		return false, nil
+	}
+
+	// Every request past the threshold lands here; only the first one fails
+	// the interview and refunds its credit.
+	interview, err := interviewRepo.GetInterview(interviewID)
+	if err != nil {
+		log.Printf("interviewRepo.GetInterview failed: %v", err)
+		return false, err
+	}
+	if interview.Status == "failed" {
+		return true, nil
+	}
+
+	err = interviewRepo.UpdateStatus(interviewID, userID, "failed")
+	if err != nil {
+		log.Printf("interviewRepo.UpdateStatus failed: %v", err)
+		return false, err
+	}
+
+	err = billingRepo.RefundInterviewReservation(interviewID, "Refund: interview failed due to AI provider errors")
+	if errors.Is(err, billing.ErrReservationNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		log.Printf("billingRepo.RefundInterviewReservation failed: %v", err)
+		return true, err
+	}
+
+	return true, nil
+}
+
 func LinkConversation(interviewRepo InterviewRepo, interviewID, conversationID int) error {
 	err := interviewRepo.LinkConversation(interviewID, conversationID)
 	if err != nil {
@@ -112,33 +188,20 @@ func canUseCredit(user *user.User) (string, error) {
 	}
 }
 
-func deductAndLogCredit(user *user.User, userRepo user.UserRepo, billingRepo billing.BillingRepo) error {
+func reserveCredit(user *user.User, billingRepo billing.BillingRepo) (int, error) {
 	creditType, err := canUseCredit(user)
 	if err != nil {
 		log.Print("canUseCredit failed", err)
-		return err
-	}
-	if creditType != "" {
-
-	}
-
-	err = userRepo.AddCredits(user.ID, -1, creditType)
-	if err != nil {
-		log.Printf("AddCredits failed: %v", err)
-		return err
+		return 0, err
 	}
 
-	reason := "Interview started"
-	tx := billing.CreditTransaction{
-		UserID:     user.ID,
-		Amount:     -1,
-		CreditType: creditType,
-		Reason:     reason,
-	}
-	if err := billingRepo.LogCreditTransaction(tx); err != nil {
-		log.Printf("billingRepo.LogCreditTransaction failed: %v", err)
-		return err
+	reservationID, err := billingRepo.ReserveCredit(user.ID, creditType)
+	if errors.Is(err, billing.ErrInsufficientCredits) {
+		return 0, ErrNoValidCredits
+	} else if err != nil {
+		log.Printf("billingRepo.ReserveCredit failed: %v", err)
+		return 0, err
 	}
 
-	return nil
+	return reservationID, nil
 }
@@ -1,6 +1,7 @@
 package interview_test
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/chatgpt"
 	"github.com/michaelboegner/interviewer/internal/mocks"
 	"github.com/michaelboegner/interviewer/interview"
 	"github.com/michaelboegner/interviewer/user"
@@ -26,8 +28,14 @@ func TestStartInterview(t *testing.T) {
 		difficulty   string
 		aiClient     *mocks.MockOpenAIClient
 		failRepo     bool
+		failCommit   bool
+		noCredits    bool
 		expected     *interview.Interview
 		expectError  bool
+		expectedErr  error
+		committed    int
+		released     int
+		statuses     []string
 		jdSummary    string
 	}{
 		{
@@ -54,6 +62,7 @@ func TestStartInterview(t *testing.T) {
 				Subtopic:        "None",
 			},
 			expectError: false,
+			committed:   1,
 			jdSummary:   "",
 		},
 		{
@@ -70,8 +79,67 @@ func TestStartInterview(t *testing.T) {
 			aiClient:     &mocks.MockOpenAIClient{},
 			failRepo:     true,
 			expectError:  true,
+			released:     1,
 			jdSummary:    "",
 		},
+		{
+			name: "StartInterview_AIErrorReleasesCredit",
+			user: &user.User{
+				ID:                1,
+				SubscriptionTier:  "free",
+				IndividualCredits: 1,
+			},
+			length:       30,
+			numQuestions: 3,
+			difficulty:   "easy",
+			aiClient:     &mocks.MockOpenAIClient{Err: &chatgpt.OpenAIError{StatusCode: 503, Message: "unavailable"}},
+			expectError:  true,
+			released:     1,
+		},
+		{
+			name: "StartInterview_CommitErrorReleasesCredit",
+			user: &user.User{
+				ID:                1,
+				SubscriptionTier:  "free",
+				IndividualCredits: 1,
+			},
+			length:       30,
+			numQuestions: 3,
+			difficulty:   "easy",
+			aiClient:     &mocks.MockOpenAIClient{},
+			failCommit:   true,
+			expectError:  true,
+			released:     1,
+			statuses:     []string{"failed"},
+		},
+		{
+			name: "StartInterview_NoCredits",
+			user: &user.User{
+				ID:               1,
+				SubscriptionTier: "free",
+			},
+			length:       30,
+			numQuestions: 3,
+			difficulty:   "easy",
+			aiClient:     &mocks.MockOpenAIClient{},
+			expectError:  true,
+			expectedErr:  interview.ErrNoValidCredits,
+		},
+		{
+			name: "StartInterview_ReservationRace",
+			user: &user.User{
+				ID:                1,
+				SubscriptionTier:  "free",
+				IndividualCredits: 1,
+			},
+			length:       30,
+			numQuestions: 3,
+			difficulty:   "easy",
+			aiClient:     &mocks.MockOpenAIClient{},
+			noCredits:    true,
+			expectError:  true,
+			expectedErr:  interview.ErrNoValidCredits,
+		},
 	}
 
 	for _, tc := range tests {
@@ -84,6 +152,8 @@ func TestStartInterview(t *testing.T) {
 			userRepo := user.NewMockRepo()
 			billingRepo := billing.NewMockRepo()
 			repo.FailRepo = tc.failRepo
+			billingRepo.NoCredits = tc.noCredits
+			billingRepo.FailCommitReservation = tc.failCommit
 
 			interviewStarted, err := interview.StartInterview(
 				repo,
@@ -103,6 +173,18 @@ func TestStartInterview(t *testing.T) {
 			if !tc.expectError && err != nil {
 				t.Fatalf("did not expect error but got: %v", err)
 			}
+			if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got: %v", tc.expectedErr, err)
+			}
+			if len(billingRepo.Committed) != tc.committed {
+				t.Errorf("expected %d committed reservations but got %d", tc.committed, len(billingRepo.Committed))
+			}
+			if len(billingRepo.Released) != tc.released {
+				t.Errorf("expected %d released reservations but got %d", tc.released, len(billingRepo.Released))
+			}
+			if diff := cmp.Diff(tc.statuses, repo.Statuses); diff != "" {
+				t.Errorf("Status mismatch (-want +got):\n%s", diff)
+			}
 
 			if !tc.expectError {
 				expected := tc.expected
@@ -204,6 +286,77 @@ func TestGetInterview(t *testing.T) {
 	}
 }
 
+func TestHandleProviderFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		priorFailures    int
+		status           string
+		failRepo         bool
+		expectedRefunded bool
+		expectedStatuses []string
+		expectError      bool
+	}{
+		{
+			name:          "HandleProviderFailure_BelowThreshold",
+			priorFailures: 0,
+		},
+		{
+			name:             "HandleProviderFailure_RefundsAtThreshold",
+			priorFailures:    interview.MaxProviderFailures - 1,
+			expectedRefunded: true,
+			expectedStatuses: []string{"failed"},
+		},
+		{
+			name:             "HandleProviderFailure_AlreadyFailedNotRefundedAgain",
+			priorFailures:    interview.MaxProviderFailures,
+			status:           "failed",
+			expectedRefunded: true,
+		},
+		{
+			name:        "HandleProviderFailure_RepoError",
+			failRepo:    true,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := interview.NewMockRepo()
+			billingRepo := billing.NewMockRepo()
+			repo.FailRepo = tc.failRepo
+			repo.ProviderFailures = tc.priorFailures
+			repo.Status = tc.status
+
+			refunded, err := interview.HandleProviderFailure(repo, billingRepo, 1, 1)
+
+			if tc.expectError && err == nil {
+				t.Fatalf("expected error but got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			if refunded != tc.expectedRefunded {
+				t.Errorf("expected refunded %v but got %v", tc.expectedRefunded, refunded)
+			}
+			if diff := cmp.Diff(tc.expectedStatuses, repo.Statuses); diff != "" {
+				t.Errorf("Status mismatch (-want +got):\n%s", diff)
+			}
+			expectedRefunds := 0
+			if tc.expectedRefunded && tc.status != "failed" {
+				expectedRefunds = 1
+			}
+			if len(billingRepo.RefundedInterviews) != expectedRefunds {
+				t.Errorf("expected %d refunds but got %d", expectedRefunds, len(billingRepo.RefundedInterviews))
+			}
+		})
+	}
+}
+
 func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
 	log.SetOutput(os.Stderr)
 	if t.Failed() {
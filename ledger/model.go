@@ -0,0 +1,129 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AccountPurchases      = "system:purchases"
+	AccountRefunds        = "system:refunds"
+	AccountUsage          = "system:usage"
+	AccountReservations   = "system:reservations"
+	AccountExpirations    = "system:expirations"
+	AccountPlanChanges    = "system:plan_changes"
+	AccountSignupBonus    = "system:signup_bonus"
+	AccountAdjustments    = "system:adjustments"
+	AccountPromotions     = "system:promotions"
+	AccountReferrals      = "system:referrals"
+	AccountOpeningBalance = "system:opening_balance"
+)
+
+type Entry struct {
+	ID            int
+	TransactionID int
+	Account       string
+	UserID        *int
+	CreditType    string
+	Amount        int
+	CreatedAt     time.Time
+}
+
+// Posting moves Amount credits between a user's balance and a system
+// counterparty account. A positive Amount credits the user.
+type Posting struct {
+	UserID       int
+	Amount       int
+	CreditType   string
+	Reason       string
+	Counterparty string
+
+	// ExpiresAt sets when credits granted by a positive posting lapse. Nil
+	// means they never expire.
+	ExpiresAt *time.Time
+
+	// LotID targets a specific lot: a deduction drains it before falling back
+	// to FIFO, and a credit is returned to it instead of opening a new lot.
+	LotID int
+
+	// Key, when set, makes the posting idempotent: a second posting with the
+	// same key writes nothing and returns ErrAlreadyPosted.
+	Key string
+
+	// Clamp lets a deduction larger than the balance take only what is left
+	// instead of failing with ErrInsufficientCredits.
+	Clamp bool
+}
+
+// Lot is a batch of credits granted together. Deductions consume open lots
+// oldest first, and the expiry job forfeits whatever remains once ExpiresAt
+// has passed.
+type Lot struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Email      string     `json:"email,omitempty"`
+	Locale     string     `json:"locale,omitempty"`
+	CreditType string     `json:"credit_type"`
+	Amount     int        `json:"amount"`
+	Remaining  int        `json:"remaining"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	WarnedAt   *time.Time `json:"warned_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ExpiryWarning groups a user's lots that lapse within the warning window.
+type ExpiryWarning struct {
+	UserID    int
+	Email     string
+	Locale    string
+	Credits   int
+	ExpiresAt time.Time
+	LotIDs    []int
+}
+
+type Drift struct {
+	UserID     int    `json:"user_id"`
+	CreditType string `json:"credit_type"`
+	Stored     int    `json:"stored"`
+	Ledger     int    `json:"ledger"`
+}
+
+type Report struct {
+	Drifts                 []Drift `json:"drifts"`
+	UnbalancedTransactions []int   `json:"unbalanced_transactions"`
+	Repaired               int     `json:"repaired"`
+}
+
+type LedgerRepo interface {
+	GetDrifts() ([]Drift, error)
+	GetUnbalancedTransactions() ([]int, error)
+	RepairDrift(drift Drift) error
+	ListExpiredLots(now time.Time, limit int) ([]Lot, error)
+	ExpireLot(lotID int) (int, error)
+	ListExpiringLots(now, before time.Time) ([]Lot, error)
+	// MarkLotsWarned records that the user was warned about the lots and
+	// queues the warning email in the same transaction.
+	MarkLotsWarned(warning ExpiryWarning) error
+}
+
+var (
+	ErrInvalidCreditType   = errors.New("invalid credit type")
+	ErrAlreadyPosted       = errors.New("posting already applied")
+	ErrInsufficientCredits = errors.New("insufficient credits")
+)
+
+func UserAccount(userID int, creditType string) string {
+	return fmt.Sprintf("user:%d:%s", userID, creditType)
+}
+
+func balanceColumn(creditType string) (string, error) {
+	switch creditType {
+	case "individual":
+		return "individual_credits", nil
+	case "subscription":
+		return "subscription_credits", nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidCreditType, creditType)
+	}
+}
@@ -0,0 +1,486 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/michaelboegner/interviewer/outbox"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+// Apply writes a posting in its own database transaction and returns the
+// amount actually applied.
+func Apply(db *sql.DB, posting Posting) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("db.Begin failed: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applied, err := Post(tx, posting)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return 0, err
+	}
+
+	return applied, nil
+}
+
+// Post updates the cached balance on users and records the matching journal
+// row and ledger entries inside the caller's transaction, returning the
+// amount applied. A deduction larger than the balance fails with
+// ErrInsufficientCredits unless the posting is clamped; a clamped amount of
+// zero writes nothing.
+func Post(tx *sql.Tx, posting Posting) (int, error) {
+	column, err := balanceColumn(posting.CreditType)
+	if err != nil {
+		return 0, err
+	}
+
+	counterparty := posting.Counterparty
+	if counterparty == "" {
+		counterparty = AccountAdjustments
+	}
+
+	var current int
+	err = tx.QueryRow(fmt.Sprintf("SELECT %s FROM users WHERE id = $1 FOR UPDATE", column), posting.UserID).Scan(&current)
+	if err != nil {
+		log.Printf("fetch current credit balance failed: %v", err)
+		return 0, err
+	}
+
+	// Checked after the balance row is locked, so a concurrent posting with
+	// the same key waits and then sees this one.
+	if posting.Key != "" {
+		var posted bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM credit_transactions WHERE idempotency_key = $1)", posting.Key).Scan(&posted)
+		if err != nil {
+			log.Printf("check posting key failed: %v", err)
+			return 0, err
+		}
+		if posted {
+			return 0, ErrAlreadyPosted
+		}
+	}
+
+	amount := posting.Amount
+	if amount < 0 && current < -amount {
+		if !posting.Clamp {
+			return 0, ErrInsufficientCredits
+		}
+		amount = -current
+	}
+	if amount == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE users
+		SET %s = %s + $1, updated_at = $2
+		WHERE id = $3
+	`, column, column), amount, now, posting.UserID)
+	if err != nil {
+		log.Printf("update credit balance failed: %v", err)
+		return 0, err
+	}
+
+	var transactionID int
+	err = tx.QueryRow(`
+		INSERT INTO credit_transactions (user_id, amount, credit_type, reason, idempotency_key, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING id
+	`, posting.UserID, amount, posting.CreditType, posting.Reason, posting.Key, now).Scan(&transactionID)
+	if err != nil {
+		log.Printf("insert credit transaction failed: %v", err)
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO credit_ledger_entries (transaction_id, account, user_id, credit_type, amount, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6), ($1, $7, NULL, $4, $8, $6)
+	`, transactionID, UserAccount(posting.UserID, posting.CreditType), posting.UserID, posting.CreditType, amount, now, counterparty, -amount)
+	if err != nil {
+		log.Printf("insert ledger entries failed: %v", err)
+		return 0, err
+	}
+
+	if amount > 0 {
+		err = creditLot(tx, posting, amount, transactionID, now)
+	} else {
+		err = consumeLots(tx, posting, -amount)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// NextLot returns the lot the next deduction for the user would draw from,
+// or 0 when they have no open lots.
+func NextLot(tx *sql.Tx, userID int, creditType string) (int, error) {
+	var lotID int
+	err := tx.QueryRow(`
+		SELECT id
+		FROM credit_lots
+		WHERE user_id = $1 AND credit_type = $2 AND remaining > 0
+		ORDER BY created_at, id
+		LIMIT 1
+	`, userID, creditType).Scan(&lotID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Printf("NextLot query failed: %v", err)
+		return 0, err
+	}
+
+	return lotID, nil
+}
+
+// CapLotExpiry brings forward the expiry of the user's open lots so none
+// outlives at.
+func CapLotExpiry(db *sql.DB, userID int, creditType string, at time.Time) error {
+	_, err := db.Exec(`
+		UPDATE credit_lots
+		SET expires_at = $1
+		WHERE user_id = $2 AND credit_type = $3 AND remaining > 0
+		  AND (expires_at IS NULL OR expires_at > $1)
+	`, at, userID, creditType)
+	if err != nil {
+		log.Printf("CapLotExpiry failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func creditLot(tx *sql.Tx, posting Posting, amount, transactionID int, now time.Time) error {
+	if posting.LotID != 0 {
+		result, err := tx.Exec(`
+			UPDATE credit_lots
+			SET remaining = remaining + $1
+			WHERE id = $2 AND user_id = $3 AND credit_type = $4
+		`, amount, posting.LotID, posting.UserID, posting.CreditType)
+		if err != nil {
+			log.Printf("restore credit lot failed: %v", err)
+			return err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil || rowsAffected > 0 {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO credit_lots (user_id, credit_type, transaction_id, amount, remaining, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $4, $5, $6)
+	`, posting.UserID, posting.CreditType, transactionID, amount, posting.ExpiresAt, now)
+	if err != nil {
+		log.Printf("insert credit lot failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// consumeLots drains amount from the user's open lots, starting with
+// posting.LotID when set and then oldest first. Lots that were already out of
+// step with the cached balance are drained as far as they go.
+func consumeLots(tx *sql.Tx, posting Posting, amount int) error {
+	rows, err := tx.Query(`
+		SELECT id, remaining
+		FROM credit_lots
+		WHERE user_id = $1 AND credit_type = $2 AND remaining > 0
+		ORDER BY id = $3 DESC, created_at, id
+		FOR UPDATE
+	`, posting.UserID, posting.CreditType, posting.LotID)
+	if err != nil {
+		log.Printf("select credit lots failed: %v", err)
+		return err
+	}
+
+	var lots []Lot
+	for rows.Next() {
+		var lot Lot
+		if err := rows.Scan(&lot.ID, &lot.Remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, lot)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		if amount == 0 {
+			break
+		}
+		take := min(lot.Remaining, amount)
+		_, err := tx.Exec(`
+			UPDATE credit_lots
+			SET remaining = remaining - $1
+			WHERE id = $2
+		`, take, lot.ID)
+		if err != nil {
+			log.Printf("consume credit lot failed: %v", err)
+			return err
+		}
+		amount -= take
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetDrifts() ([]Drift, error) {
+	rows, err := repo.DB.Query(`
+		SELECT id, credit_type, stored, ledger FROM (
+			SELECT
+				u.id,
+				'individual' AS credit_type,
+				u.individual_credits AS stored,
+				COALESCE(SUM(e.amount) FILTER (WHERE e.credit_type = 'individual'), 0) AS ledger
+			FROM users u
+			LEFT JOIN credit_ledger_entries e ON e.user_id = u.id
+			GROUP BY u.id
+			UNION ALL
+			SELECT
+				u.id,
+				'subscription' AS credit_type,
+				u.subscription_credits AS stored,
+				COALESCE(SUM(e.amount) FILTER (WHERE e.credit_type = 'subscription'), 0) AS ledger
+			FROM users u
+			LEFT JOIN credit_ledger_entries e ON e.user_id = u.id
+			GROUP BY u.id
+		) balances
+		WHERE stored <> ledger
+		ORDER BY id, credit_type
+	`)
+	if err != nil {
+		log.Printf("GetDrifts query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drifts []Drift
+	for rows.Next() {
+		var drift Drift
+		if err := rows.Scan(&drift.UserID, &drift.CreditType, &drift.Stored, &drift.Ledger); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift)
+	}
+
+	return drifts, rows.Err()
+}
+
+func (repo *Repository) GetUnbalancedTransactions() ([]int, error) {
+	rows, err := repo.DB.Query(`
+		SELECT transaction_id
+		FROM credit_ledger_entries
+		GROUP BY transaction_id
+		HAVING SUM(amount) <> 0
+		ORDER BY transaction_id
+	`)
+	if err != nil {
+		log.Printf("GetUnbalancedTransactions query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (repo *Repository) RepairDrift(drift Drift) error {
+	column, err := balanceColumn(drift.CreditType)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Exec(fmt.Sprintf(`
+		UPDATE users
+		SET %s = $1, updated_at = $2
+		WHERE id = $3 AND %s = $4
+	`, column, column), drift.Ledger, time.Now().UTC(), drift.UserID, drift.Stored)
+	if err != nil {
+		log.Printf("RepairDrift failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) ListExpiredLots(now time.Time, limit int) ([]Lot, error) {
+	rows, err := repo.DB.Query(`
+		SELECT l.id, l.user_id, u.email, u.locale, l.credit_type, l.amount, l.remaining, l.expires_at, l.warned_at, l.created_at
+		FROM credit_lots l
+		JOIN users u ON u.id = l.user_id
+		WHERE l.remaining > 0 AND l.expires_at <= $1
+		ORDER BY l.expires_at, l.id
+		LIMIT $2
+	`, now, limit)
+	if err != nil {
+		log.Printf("ListExpiredLots query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLots(rows)
+}
+
+// ExpireLot forfeits whatever is left in an expired lot and returns how many
+// credits were written off.
+func (repo *Repository) ExpireLot(lotID int) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lot Lot
+	err = tx.QueryRow(`
+		SELECT id, user_id, credit_type, remaining
+		FROM credit_lots
+		WHERE id = $1 AND remaining > 0 AND expires_at <= $2
+		FOR UPDATE
+	`, lotID, time.Now().UTC()).Scan(&lot.ID, &lot.UserID, &lot.CreditType, &lot.Remaining)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Printf("select expired credit lot failed: %v", err)
+		return 0, err
+	}
+
+	applied, err := Post(tx, Posting{
+		UserID:       lot.UserID,
+		Amount:       -lot.Remaining,
+		CreditType:   lot.CreditType,
+		Reason:       "Credits expired",
+		Counterparty: AccountExpirations,
+		LotID:        lot.ID,
+		Clamp:        true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE credit_lots SET remaining = 0 WHERE id = $1", lot.ID)
+	if err != nil {
+		log.Printf("close expired credit lot failed: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return 0, err
+	}
+
+	return -applied, nil
+}
+
+func (repo *Repository) ListExpiringLots(now, before time.Time) ([]Lot, error) {
+	rows, err := repo.DB.Query(`
+		SELECT l.id, l.user_id, u.email, u.locale, l.credit_type, l.amount, l.remaining, l.expires_at, l.warned_at, l.created_at
+		FROM credit_lots l
+		JOIN users u ON u.id = l.user_id
+		WHERE l.remaining > 0 AND l.warned_at IS NULL
+		  AND l.expires_at > $1 AND l.expires_at <= $2
+		  AND u.account_status = 'active'
+		ORDER BY l.user_id, l.expires_at
+	`, now, before)
+	if err != nil {
+		log.Printf("ListExpiringLots query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLots(rows)
+}
+
+func (repo *Repository) MarkLotsWarned(warning ExpiryWarning) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE credit_lots
+		SET warned_at = $1
+		WHERE id = ANY($2)
+	`, time.Now().UTC(), pq.Array(warning.LotIDs))
+	if err != nil {
+		log.Printf("MarkLotsWarned failed: %v", err)
+		return err
+	}
+
+	_, err = outbox.Enqueue(tx, &outbox.Email{
+		Key:       fmt.Sprintf("credit_expiry_warning:%d:%d", warning.UserID, warning.LotIDs[0]),
+		UserID:    warning.UserID,
+		Recipient: warning.Email,
+		Locale:    warning.Locale,
+		Template:  "credit_expiry_warning",
+		Data:      map[string]any{"Credits": warning.Credits, "ExpiresAt": warning.ExpiresAt},
+	})
+	if err != nil {
+		log.Printf("outbox.Enqueue failed: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanLots(rows *sql.Rows) ([]Lot, error) {
+	var lots []Lot
+	for rows.Next() {
+		var lot Lot
+		err := rows.Scan(
+			&lot.ID,
+			&lot.UserID,
+			&lot.Email,
+			&lot.Locale,
+			&lot.CreditType,
+			&lot.Amount,
+			&lot.Remaining,
+			&lot.ExpiresAt,
+			&lot.WarnedAt,
+			&lot.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	return lots, rows.Err()
+}
@@ -0,0 +1,110 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Drifts                 []Drift
+	UnbalancedTransactions []int
+	Repaired               []Drift
+	FailRepo               bool
+	FailRepair             bool
+	Lots                   []Lot
+	Expired                []int
+	FailExpireLot          bool
+	Warnings               []ExpiryWarning
+	FailWarn               bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{}
+}
+
+func (m *MockRepo) GetDrifts() ([]Drift, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	return m.Drifts, nil
+}
+
+func (m *MockRepo) GetUnbalancedTransactions() ([]int, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	return m.UnbalancedTransactions, nil
+}
+
+func (m *MockRepo) RepairDrift(drift Drift) error {
+	if m.FailRepair {
+		return errors.New("mocked RepairDrift failure")
+	}
+
+	m.Repaired = append(m.Repaired, drift)
+	return nil
+}
+
+func (m *MockRepo) ListExpiredLots(now time.Time, limit int) ([]Lot, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	var lots []Lot
+	for _, lot := range m.Lots {
+		if lot.Remaining > 0 && lot.ExpiresAt != nil && !lot.ExpiresAt.After(now) && len(lots) < limit {
+			lots = append(lots, lot)
+		}
+	}
+	return lots, nil
+}
+
+func (m *MockRepo) ExpireLot(lotID int) (int, error) {
+	if m.FailExpireLot {
+		return 0, errors.New("mocked ExpireLot failure")
+	}
+
+	for i := range m.Lots {
+		if m.Lots[i].ID == lotID {
+			expired := m.Lots[i].Remaining
+			m.Lots[i].Remaining = 0
+			m.Expired = append(m.Expired, lotID)
+			return expired, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MockRepo) ListExpiringLots(now, before time.Time) ([]Lot, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	var lots []Lot
+	for _, lot := range m.Lots {
+		if lot.Remaining > 0 && lot.WarnedAt == nil && lot.ExpiresAt != nil &&
+			lot.ExpiresAt.After(now) && !lot.ExpiresAt.After(before) {
+			lots = append(lots, lot)
+		}
+	}
+	return lots, nil
+}
+
+func (m *MockRepo) MarkLotsWarned(warning ExpiryWarning) error {
+	if m.FailWarn {
+		return errors.New("mocked DB failure")
+	}
+
+	now := time.Now().UTC()
+	for _, id := range warning.LotIDs {
+		for i := range m.Lots {
+			if m.Lots[i].ID == id {
+				m.Lots[i].WarnedAt = &now
+			}
+		}
+	}
+	m.Warnings = append(m.Warnings, warning)
+	return nil
+}
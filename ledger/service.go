@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Reconcile compares cached user balances with the ledger. When repair is
+// set, drifted balances are overwritten with the ledger value, which is the
+// source of truth.
+func Reconcile(repo LedgerRepo, repair bool) (*Report, error) {
+	drifts, err := repo.GetDrifts()
+	if err != nil {
+		log.Printf("repo.GetDrifts failed: %v", err)
+		return nil, err
+	}
+
+	unbalanced, err := repo.GetUnbalancedTransactions()
+	if err != nil {
+		log.Printf("repo.GetUnbalancedTransactions failed: %v", err)
+		return nil, err
+	}
+
+	report := &Report{
+		Drifts:                 drifts,
+		UnbalancedTransactions: unbalanced,
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, drift := range drifts {
+		if err := repo.RepairDrift(drift); err != nil {
+			log.Printf("repo.RepairDrift failed for user %d: %v", drift.UserID, err)
+			return report, err
+		}
+		report.Repaired++
+	}
+
+	return report, nil
+}
+
+// ExpiryJob forfeits lapsed credit lots through the ledger and queues an
+// email for users whose credits are about to lapse.
+type ExpiryJob struct {
+	Repo       LedgerRepo
+	Interval   time.Duration
+	WarnBefore time.Duration
+	BatchSize  int
+}
+
+func NewExpiryJob(repo LedgerRepo) *ExpiryJob {
+	return &ExpiryJob{
+		Repo:       repo,
+		Interval:   time.Hour,
+		WarnBefore: 7 * 24 * time.Hour,
+		BatchSize:  100,
+	}
+}
+
+func (j *ExpiryJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, _, err := j.Run(time.Now().UTC()); err != nil {
+			log.Printf("ExpiryJob.Run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run expires every lot that lapsed by now, then queues one warning per user
+// for lots lapsing within WarnBefore. It returns the number of credits
+// expired and warnings queued. A warning that cannot be queued is retried on
+// the next run.
+func (j *ExpiryJob) Run(now time.Time) (int, int, error) {
+	expired := 0
+	for {
+		lots, err := j.Repo.ListExpiredLots(now, j.BatchSize)
+		if err != nil {
+			log.Printf("repo.ListExpiredLots failed: %v", err)
+			return expired, 0, err
+		}
+
+		for _, lot := range lots {
+			credits, err := j.Repo.ExpireLot(lot.ID)
+			if err != nil {
+				log.Printf("repo.ExpireLot failed for lot %d: %v", lot.ID, err)
+				return expired, 0, err
+			}
+			expired += credits
+		}
+
+		if len(lots) < j.BatchSize {
+			break
+		}
+	}
+
+	lots, err := j.Repo.ListExpiringLots(now, now.Add(j.WarnBefore))
+	if err != nil {
+		log.Printf("repo.ListExpiringLots failed: %v", err)
+		return expired, 0, err
+	}
+
+	warned := 0
+	for _, warning := range groupExpiryWarnings(lots) {
+		if err := j.Repo.MarkLotsWarned(warning); err != nil {
+			log.Printf("repo.MarkLotsWarned failed for user %d: %v", warning.UserID, err)
+			continue
+		}
+		warned++
+	}
+
+	return expired, warned, nil
+}
+
+// groupExpiryWarnings folds lots into one warning per user, reporting the
+// total credits at risk and the earliest expiry among them.
+func groupExpiryWarnings(lots []Lot) []ExpiryWarning {
+	var warnings []ExpiryWarning
+	index := map[int]int{}
+	for _, lot := range lots {
+		if lot.ExpiresAt == nil {
+			continue
+		}
+
+		i, ok := index[lot.UserID]
+		if !ok {
+			index[lot.UserID] = len(warnings)
+			warnings = append(warnings, ExpiryWarning{
+				UserID:    lot.UserID,
+				Email:     lot.Email,
+				Locale:    lot.Locale,
+				ExpiresAt: *lot.ExpiresAt,
+			})
+			i = len(warnings) - 1
+		}
+
+		warning := &warnings[i]
+		warning.Credits += lot.Remaining
+		warning.LotIDs = append(warning.LotIDs, lot.ID)
+		if lot.ExpiresAt.Before(warning.ExpiresAt) {
+			warning.ExpiresAt = *lot.ExpiresAt
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,205 @@
+package ledger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReconcile(t *testing.T) {
+	drifts := []Drift{
+		{UserID: 1, CreditType: "individual", Stored: 3, Ledger: 1},
+		{UserID: 2, CreditType: "subscription", Stored: 0, Ledger: 10},
+	}
+
+	tests := []struct {
+		name             string
+		drifts           []Drift
+		unbalanced       []int
+		repair           bool
+		failRepo         bool
+		failRepair       bool
+		expectedReport   *Report
+		expectedRepaired []Drift
+		expectError      bool
+	}{
+		{
+			name:   "Reconcile_ReportOnly",
+			drifts: drifts,
+			expectedReport: &Report{
+				Drifts: drifts,
+			},
+		},
+		{
+			name:       "Reconcile_Repair",
+			drifts:     drifts,
+			unbalanced: []int{7},
+			repair:     true,
+			expectedReport: &Report{
+				Drifts:                 drifts,
+				UnbalancedTransactions: []int{7},
+				Repaired:               2,
+			},
+			expectedRepaired: drifts,
+		},
+		{
+			name:           "Reconcile_NoDrift",
+			repair:         true,
+			expectedReport: &Report{},
+		},
+		{
+			name:        "Reconcile_RepoError",
+			failRepo:    true,
+			expectError: true,
+		},
+		{
+			name:        "Reconcile_RepairError",
+			drifts:      drifts,
+			repair:      true,
+			failRepair:  true,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Drifts = tc.drifts
+			repo.UnbalancedTransactions = tc.unbalanced
+			repo.FailRepo = tc.failRepo
+			repo.FailRepair = tc.failRepair
+
+			report, err := Reconcile(repo, tc.repair)
+
+			if tc.expectError && err == nil {
+				t.Fatalf("expected error but got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			if !tc.expectError {
+				if diff := cmp.Diff(tc.expectedReport, report); diff != "" {
+					t.Errorf("Report mismatch (-want +got):\n%s", diff)
+				}
+				if diff := cmp.Diff(tc.expectedRepaired, repo.Repaired); diff != "" {
+					t.Errorf("Repaired mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestUserAccount(t *testing.T) {
+	if got := UserAccount(42, "subscription"); got != "user:42:subscription" {
+		t.Errorf("expected user:42:subscription but got %s", got)
+	}
+}
+
+func TestExpiryJob(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name             string
+		lots             []Lot
+		failWarn         bool
+		expectedExpired  int
+		expectedWarnings []ExpiryWarning
+		expectedWarned   []int
+	}{
+		{
+			name: "ExpiryJob_ExpiresLapsedLots",
+			lots: []Lot{
+				{ID: 1, UserID: 1, Email: "a@example.com", Remaining: 3, ExpiresAt: at(-time.Hour)},
+				{ID: 2, UserID: 2, Email: "b@example.com", Remaining: 2, ExpiresAt: at(-48 * time.Hour)},
+				{ID: 3, UserID: 2, Email: "b@example.com", Remaining: 0, ExpiresAt: at(-48 * time.Hour)},
+				{ID: 4, UserID: 3, Email: "c@example.com", Remaining: 5},
+			},
+			expectedExpired: 5,
+		},
+		{
+			name: "ExpiryWarnings_GroupedPerUser",
+			lots: []Lot{
+				{ID: 1, UserID: 1, Email: "a@example.com", Locale: "es", Remaining: 3, ExpiresAt: at(72 * time.Hour)},
+				{ID: 2, UserID: 1, Email: "a@example.com", Locale: "es", Remaining: 1, ExpiresAt: at(24 * time.Hour)},
+				{ID: 3, UserID: 2, Email: "b@example.com", Remaining: 2, ExpiresAt: at(30 * 24 * time.Hour)},
+			},
+			expectedWarnings: []ExpiryWarning{
+				{UserID: 1, Email: "a@example.com", Locale: "es", Credits: 4, ExpiresAt: *at(24 * time.Hour), LotIDs: []int{1, 2}},
+			},
+			expectedWarned: []int{1, 2},
+		},
+		{
+			name: "ExpiryWarnings_AlreadyWarned",
+			lots: []Lot{
+				{ID: 1, UserID: 1, Email: "a@example.com", Remaining: 3, ExpiresAt: at(24 * time.Hour), WarnedAt: at(-24 * time.Hour)},
+			},
+			expectedWarned: []int{1},
+		},
+		{
+			name: "ExpiryWarnings_FailureRetriedLater",
+			lots: []Lot{
+				{ID: 1, UserID: 1, Email: "a@example.com", Remaining: 3, ExpiresAt: at(24 * time.Hour)},
+			},
+			failWarn: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Lots = tc.lots
+			repo.FailWarn = tc.failWarn
+
+			job := NewExpiryJob(repo)
+			expired, warned, err := job.Run(now)
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if expired != tc.expectedExpired {
+				t.Errorf("expected %d credits expired, got %d", tc.expectedExpired, expired)
+			}
+			if warned != len(tc.expectedWarnings) {
+				t.Errorf("expected %d warnings sent, got %d", len(tc.expectedWarnings), warned)
+			}
+			if diff := cmp.Diff(tc.expectedWarnings, repo.Warnings); diff != "" {
+				t.Errorf("warnings mismatch (-want +got):\n%s", diff)
+			}
+
+			var warnedLots []int
+			for _, lot := range repo.Lots {
+				if lot.WarnedAt != nil {
+					warnedLots = append(warnedLots, lot.ID)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedWarned, warnedLots); diff != "" {
+				t.Errorf("warned lots mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
@@ -0,0 +1,180 @@
+package mailer
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const captureLimit = 200
+
+// CapturedMessage is a message the Capture transport kept instead of
+// delivering.
+type CapturedMessage struct {
+	ID      int
+	SentAt  time.Time
+	Message Message
+}
+
+// Capture keeps outgoing mail for local development instead of delivering
+// it. The most recent messages are held in memory for the dev mail page,
+// and when Dir is set each one is also written there as an .eml file.
+type Capture struct {
+	Dir string
+	Now func() time.Time
+
+	mu       sync.Mutex
+	nextID   int
+	messages []CapturedMessage
+}
+
+func NewCapture(dir string) *Capture {
+	return &Capture{
+		Dir: dir,
+		Now: time.Now,
+	}
+}
+
+func (c *Capture) Name() string {
+	return "capture"
+}
+
+func (c *Capture) Send(msg *Message) error {
+	now := c.Now().UTC()
+
+	c.mu.Lock()
+	c.nextID++
+	captured := CapturedMessage{ID: c.nextID, SentAt: now, Message: *msg}
+	c.messages = append(c.messages, captured)
+	if len(c.messages) > captureLimit {
+		c.messages = c.messages[len(c.messages)-captureLimit:]
+	}
+	c.mu.Unlock()
+
+	if c.Dir == "" {
+		return nil
+	}
+
+	data, err := buildMIME(msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", now.Format("20060102T150405"), captured.ID)
+	return os.WriteFile(filepath.Join(c.Dir, name), data, 0o644)
+}
+
+// Messages returns the captured messages, newest first.
+func (c *Capture) Messages() []CapturedMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	messages := make([]CapturedMessage, len(c.messages))
+	for i, msg := range c.messages {
+		messages[len(messages)-1-i] = msg
+	}
+	return messages
+}
+
+// Get returns the captured message with the given ID.
+func (c *Capture) Get(id int) (CapturedMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, msg := range c.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return CapturedMessage{}, false
+}
+
+// Reset drops every captured message.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
+
+var captureListTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Captured mail</title></head>
+<body style="font-family: sans-serif;">
+<h1>Captured mail</h1>
+<form method="post" action="{{.Base}}"><button type="submit">Clear</button></form>
+{{if not .Messages}}<p>No mail has been sent yet.</p>{{else}}
+<table cellpadding="6">
+<tr><th align="left">Sent</th><th align="left">To</th><th align="left">Subject</th></tr>
+{{range .Messages}}<tr>
+<td>{{.SentAt.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.Message.To}}</td>
+<td><a href="{{$.Base}}{{.ID}}">{{.Message.Subject}}</a></td>
+</tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+var captureMessageTemplate = template.Must(template.New("message").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Message.Subject}}</title></head>
+<body style="font-family: sans-serif;">
+<p><a href="{{.Base}}">&larr; All mail</a></p>
+<p>
+<strong>From:</strong> {{.Message.From}}<br>
+<strong>To:</strong> {{.Message.To}}<br>
+<strong>Subject:</strong> {{.Message.Subject}}<br>
+<strong>Sent:</strong> {{.SentAt.Format "2006-01-02 15:04:05"}} UTC
+</p>
+<iframe sandbox="allow-popups" srcdoc="{{.Message.HTML}}" style="width: 100%; height: 80vh; border: 1px solid #ccc;"></iframe>
+{{if .Message.Text}}<h2>Plain text</h2>
+<pre style="white-space: pre-wrap;">{{.Message.Text}}</pre>{{end}}
+</body>
+</html>
+`))
+
+// Handler serves a page for browsing captured mail under base, which must
+// end in a slash. A POST to base clears the captured messages.
+func (c *Capture) Handler(base string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		rest := strings.TrimPrefix(r.URL.Path, base)
+		if rest == "" {
+			switch r.Method {
+			case http.MethodGet:
+				_ = captureListTemplate.Execute(w, map[string]any{"Base": base, "Messages": c.Messages()})
+			case http.MethodPost:
+				c.Reset()
+				http.Redirect(w, r, base, http.StatusSeeOther)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		id, err := strconv.Atoi(rest)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		msg, ok := c.Get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = captureMessageTemplate.Execute(w, struct {
+			CapturedMessage
+			Base string
+		}{msg, base})
+	})
+}
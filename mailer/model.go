@@ -1,39 +1,70 @@
 package mailer
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 )
 
+const defaultFrom = "Interviewer Support <support@mail.interviewer.dev>"
+
+// Mailer composes the application's emails and hands them to a Transport.
 type Mailer struct {
-	APIKey  string
-	BaseURL string
-	Logger  *slog.Logger
+	Transport Transport
+	From      string
+	Logger    *slog.Logger
 }
 
-const signature = `
-		<br><br>
-		<p>
-			<strong>Michael Boegner</strong><br>
-			Founder • <a href="https://interviewer.dev" style="color: #007bff; text-decoration: none;">Interviewer</a><br>
-			<a href="mailto:support@mail.interviewer.dev" style="color: #000;">support@mail.interviewer.dev</a><br>
-		</p>
-		<p style="color: gray; font-size: 12px; margin-top: 4px;">
-			Everything gets easier with practice!
-		</p>
-	`
-
-func NewMailer(logger *slog.Logger) *Mailer {
-	return &Mailer{
-		APIKey:  os.Getenv("RESEND_API_KEY"),
-		BaseURL: "https://api.resend.com",
-		Logger:  logger,
+// Message is a composed email, independent of how it is delivered.
+// IdempotencyKey, when set, identifies the email across retries so
+// transports can avoid delivering it twice. ListUnsubscribe, when set, is a
+// one-click unsubscribe URL (RFC 8058) sent in the List-Unsubscribe headers.
+type Message struct {
+	From            string
+	To              string
+	Subject         string
+	HTML            string
+	Text            string
+	IdempotencyKey  string
+	ListUnsubscribe string
+}
+
+// Transport delivers a composed message.
+type Transport interface {
+	Name() string
+	Send(msg *Message) error
+}
+
+// NewMailer picks the transport named by MAIL_TRANSPORT: resend (default),
+// smtp or capture.
+func NewMailer(logger *slog.Logger) (*Mailer, error) {
+	var transport Transport
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "", "resend":
+		transport = NewResend()
+	case "smtp":
+		transport = NewSMTP()
+	case "capture":
+		transport = NewCapture(os.Getenv("MAIL_CAPTURE_DIR"))
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT: %s", os.Getenv("MAIL_TRANSPORT"))
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = defaultFrom
 	}
+
+	return &Mailer{
+		Transport: transport,
+		From:      from,
+		Logger:    logger,
+	}, nil
 }
 
+// MailerClient renders a template and delivers it. locale picks the
+// translation; unsupported locales get DefaultLocale. key identifies the
+// email so a retried send is not delivered twice.
 type MailerClient interface {
-	SendPasswordReset(email, resetURL string) error
-	SendVerificationEmail(email, verifyURL string) error
-	SendWelcome(email string) error
-	SendDeletionConfirmation(email string) error
+	SendTemplate(email, locale, template string, data map[string]any, key string) error
 }
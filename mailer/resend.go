@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Resend delivers mail through the Resend HTTP API.
+type Resend struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewResend() *Resend {
+	return &Resend{
+		APIKey:  os.Getenv("RESEND_API_KEY"),
+		BaseURL: "https://api.resend.com",
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *Resend) Name() string {
+	return "resend"
+}
+
+func (r *Resend) Send(msg *Message) error {
+	payload := map[string]any{
+		"from":    msg.From,
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"html":    msg.HTML,
+		"text":    msg.Text,
+	}
+	if msg.ListUnsubscribe != "" {
+		payload["headers"] = map[string]string{
+			"List-Unsubscribe":      "<" + msg.ListUnsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.BaseURL+"/emails", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	if msg.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", msg.IdempotencyKey)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("resend error: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMailer(transport Transport) *Mailer {
+	return &Mailer{
+		Transport: transport,
+		From:      defaultFrom,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestMailerComposes(t *testing.T) {
+	tests := []struct {
+		name            string
+		send            func(m *Mailer) error
+		expectedSubject string
+		expectedBody    string
+		expectedText    string
+	}{
+		{
+			name: "Composes_PasswordReset",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "en", "password_reset", map[string]any{"ResetURL": "https://app.test/reset?token=abc"}, "")
+			},
+			expectedSubject: "Reset your password",
+			expectedBody:    "https://app.test/reset?token=abc",
+			expectedText:    "open this link to reset your password:\nhttps://app.test/reset?token=abc",
+		},
+		{
+			name: "Composes_PasswordResetInSpanish",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "es-MX", "password_reset", map[string]any{"ResetURL": "https://app.test/reset?token=abc"}, "")
+			},
+			expectedSubject: "Restablece tu contraseña",
+			expectedBody:    "https://app.test/reset?token=abc",
+			expectedText:    "Fundador, Interviewer",
+		},
+		{
+			name:            "Composes_UnsupportedLocaleFallsBack",
+			send:            func(m *Mailer) error { return m.SendTemplate("a@test.com", "fr", "welcome", nil, "") },
+			expectedSubject: "Welcome to Interviewer!",
+			expectedBody:    `<html lang="en">`,
+			expectedText:    "https://interviewer.dev/dashboard",
+		},
+		{
+			name: "Composes_CreditExpiryWarning",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "en", "credit_expiry_warning", map[string]any{"Credits": 1, "ExpiresAt": time.Date(2025, 7, 4, 0, 0, 0, 0, time.UTC)}, "")
+			},
+			expectedSubject: "Your Interviewer credits are about to expire",
+			expectedBody:    "1 interview credit</strong> on your account will expire on July 4, 2025",
+			expectedText:    "1 interview credit on your account will expire on July 4, 2025",
+		},
+		{
+			name: "Composes_CreditExpiryWarningInSpanish",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "es", "credit_expiry_warning", map[string]any{"Credits": 3, "ExpiresAt": time.Date(2025, 7, 4, 0, 0, 0, 0, time.UTC)}, "")
+			},
+			expectedSubject: "Tus créditos de Interviewer están por vencer",
+			expectedBody:    "3 créditos de entrevista</strong> de tu cuenta vencen el 4 de julio de 2025",
+			expectedText:    "3 créditos de entrevista de tu cuenta vencen el 4 de julio de 2025",
+		},
+		{
+			name: "Composes_CreditExpiryWarningFromJSON",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "en", "credit_expiry_warning", map[string]any{"Credits": float64(1), "ExpiresAt": "2025-07-04T00:00:00Z"}, "")
+			},
+			expectedSubject: "Your Interviewer credits are about to expire",
+			expectedBody:    "1 interview credit</strong> on your account will expire on July 4, 2025",
+			expectedText:    "1 interview credit on your account will expire on July 4, 2025",
+		},
+		{
+			name: "Composes_EmailChangeNoticeEscapesAddress",
+			send: func(m *Mailer) error {
+				return m.SendTemplate("a@test.com", "en", "email_change_notice", map[string]any{"NewEmail": "<b>x</b>@test.com", "CancelURL": "https://app.test/cancel"}, "")
+			},
+			expectedSubject: "Your Interviewer email address is being changed",
+			expectedBody:    "&lt;b&gt;x&lt;/b&gt;@test.com",
+			expectedText:    "<b>x</b>@test.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			capture := NewCapture("")
+			if err := tc.send(newTestMailer(capture)); err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+
+			messages := capture.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("expected 1 captured message, got %d", len(messages))
+			}
+			msg := messages[0].Message
+			if msg.From != defaultFrom || msg.To != "a@test.com" || msg.Subject != tc.expectedSubject {
+				t.Errorf("unexpected envelope %+v", msg)
+			}
+			if !strings.Contains(msg.HTML, tc.expectedBody) {
+				t.Errorf("expected body to contain %q, got:\n%s", tc.expectedBody, msg.HTML)
+			}
+			if !strings.Contains(msg.Text, tc.expectedText) {
+				t.Errorf("expected text to contain %q, got:\n%s", tc.expectedText, msg.Text)
+			}
+		})
+	}
+}
+
+func TestResendSend(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		expectedError bool
+	}{
+		{
+			name:   "ResendSend_Success",
+			status: http.StatusOK,
+		},
+		{
+			name:          "ResendSend_APIError",
+			status:        http.StatusUnprocessableEntity,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var payload map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" || r.URL.Path != "/emails" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+				if auth := r.Header.Get("Authorization"); auth != "Bearer re_test" {
+					t.Errorf("unexpected Authorization header %q", auth)
+				}
+				if key := r.Header.Get("Idempotency-Key"); key != "welcome:1" {
+					t.Errorf("unexpected Idempotency-Key header %q", key)
+				}
+				_ = json.NewDecoder(r.Body).Decode(&payload)
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			resend := &Resend{APIKey: "re_test", BaseURL: server.URL, Client: server.Client()}
+			err := resend.Send(&Message{From: defaultFrom, To: "a@test.com", Subject: "Hi", HTML: "<p>Hi</p>", Text: "Hi", IdempotencyKey: "welcome:1"})
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedError, err)
+			}
+			if payload["to"] != "a@test.com" || payload["subject"] != "Hi" || payload["html"] != "<p>Hi</p>" {
+				t.Errorf("unexpected payload %v", payload)
+			}
+		})
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	tests := []struct {
+		name            string
+		username        string
+		msg             Message
+		expectedError   error
+		expectedAuth    bool
+		expectedSubject string
+		expectedHeaders []string
+	}{
+		{
+			name:            "SMTPSend_WithAuth",
+			username:        "mailer",
+			msg:             Message{From: defaultFrom, To: "Alice <a@test.com>", Subject: "Grüße", HTML: "<p>Hi</p>", Text: "Hi"},
+			expectedAuth:    true,
+			expectedSubject: "=?utf-8?q?Gr=C3=BC=C3=9Fe?=",
+		},
+		{
+			name:            "SMTPSend_WithoutAuth",
+			msg:             Message{From: defaultFrom, To: "a@test.com", Subject: "Hi", HTML: "<p>Hi</p>", Text: "Hi"},
+			expectedSubject: "Hi",
+		},
+		{
+			name:            "SMTPSend_ListUnsubscribe",
+			msg:             Message{From: defaultFrom, To: "a@test.com", Subject: "Hi", HTML: "<p>Hi</p>", Text: "Hi", ListUnsubscribe: "https://api.test/unsubscribe?token=abc"},
+			expectedSubject: "Hi",
+			expectedHeaders: []string{
+				"List-Unsubscribe: <https://api.test/unsubscribe?token=abc>\r\n",
+				"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n",
+			},
+		},
+		{
+			name:          "SMTPSend_RejectsHeaderInjection",
+			msg:           Message{From: defaultFrom, To: "a@test.com", Subject: "Hi\r\nBcc: b@test.com", HTML: "<p>Hi</p>", Text: "Hi"},
+			expectedError: ErrInvalidHeader,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				gotAddr string
+				gotAuth smtp.Auth
+				gotFrom string
+				gotTo   []string
+				gotData string
+			)
+			sender := &SMTP{
+				Host:     "smtp.test",
+				Port:     "587",
+				Username: tc.username,
+				Password: "secret",
+				Now:      func() time.Time { return time.Date(2025, 7, 4, 12, 0, 0, 0, time.UTC) },
+				SendMail: func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+					gotAddr, gotAuth, gotFrom, gotTo, gotData = addr, auth, from, to, string(msg)
+					return nil
+				},
+			}
+
+			err := sender.Send(&tc.msg)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if gotData != "" {
+					t.Errorf("message was sent despite error")
+				}
+				return
+			}
+
+			if gotAddr != "smtp.test:587" || gotFrom != "support@mail.interviewer.dev" || len(gotTo) != 1 || gotTo[0] != "a@test.com" {
+				t.Errorf("unexpected envelope %s %s %v", gotAddr, gotFrom, gotTo)
+			}
+			if (gotAuth != nil) != tc.expectedAuth {
+				t.Errorf("expected auth: %v, got %v", tc.expectedAuth, gotAuth)
+			}
+			for _, header := range append([]string{
+				"Subject: " + tc.expectedSubject + "\r\n",
+				"Date: Fri, 04 Jul 2025 12:00:00 +0000\r\n",
+				"Content-Type: multipart/alternative; boundary=",
+				"Content-Type: text/plain; charset=UTF-8\r\n",
+				"Content-Type: text/html; charset=UTF-8\r\n",
+				"Message-ID: <",
+			}, tc.expectedHeaders...) {
+				if !strings.Contains(gotData, header) {
+					t.Errorf("expected message to contain %q, got:\n%s", header, gotData)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	first := messageID(defaultFrom, "welcome:1")
+	if first != messageID(defaultFrom, "welcome:1") {
+		t.Errorf("expected a stable Message-ID for the same key")
+	}
+	if first == messageID(defaultFrom, "welcome:2") {
+		t.Errorf("expected different keys to give different Message-IDs")
+	}
+	if !strings.HasSuffix(first, "@mail.interviewer.dev>") {
+		t.Errorf("unexpected Message-ID %q", first)
+	}
+	if messageID(defaultFrom, "") == messageID(defaultFrom, "") {
+		t.Errorf("expected random Message-IDs without a key")
+	}
+}
+
+func TestCaptureHandler(t *testing.T) {
+	dir := t.TempDir()
+	capture := NewCapture(dir)
+	capture.Now = func() time.Time { return time.Date(2025, 7, 4, 12, 0, 0, 0, time.UTC) }
+	mailer := newTestMailer(capture)
+	if err := mailer.SendTemplate("a@test.com", "en", "verification", map[string]any{"VerifyURL": "https://app.test/verify?token=abc"}, ""); err != nil {
+		t.Fatalf("SendTemplate failed: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %v (%v)", files, err)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "Subject: Verify your Interviewer account") {
+		t.Errorf("unexpected .eml contents:\n%s", data)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "CaptureHandler_List",
+			method:         http.MethodGet,
+			path:           "/dev/mail/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `<a href="/dev/mail/1">Verify your Interviewer account</a>`,
+		},
+		{
+			name:           "CaptureHandler_Message",
+			method:         http.MethodGet,
+			path:           "/dev/mail/1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "verify?token=abc",
+		},
+		{
+			name:           "CaptureHandler_UnknownMessage",
+			method:         http.MethodGet,
+			path:           "/dev/mail/9",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "CaptureHandler_Clear",
+			method:         http.MethodPost,
+			path:           "/dev/mail/",
+			expectedStatus: http.StatusSeeOther,
+		},
+	}
+
+	handler := capture.Handler("/dev/mail/")
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain %q, got:\n%s", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+
+	if len(capture.Messages()) != 0 {
+		t.Errorf("expected captured mail to be cleared")
+	}
+}
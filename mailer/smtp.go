@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// SMTP delivers mail to any SMTP server. net/smtp upgrades the connection
+// with STARTTLS when the server offers it, and only sends credentials over
+// TLS or to localhost.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	SendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+	Now      func() time.Time
+}
+
+func NewSMTP() *SMTP {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return &SMTP{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		SendMail: smtp.SendMail,
+		Now:      time.Now,
+	}
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Send(msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid to address: %w", err)
+	}
+
+	data, err := buildMIME(msg, s.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	return s.SendMail(net.JoinHostPort(s.Host, s.Port), auth, from.Address, []string{to.Address}, data)
+}
+
+// buildMIME renders the message as an RFC 5322 email. A message with a
+// plain-text body becomes multipart/alternative with the text part first;
+// parts are quoted-printable.
+func buildMIME(msg *Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{msg.From, msg.To, msg.Subject, msg.ListUnsubscribe} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", msg.From},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(msg.From, msg.IdempotencyKey)},
+		{"MIME-Version", "1.0"},
+	}
+	if msg.ListUnsubscribe != "" {
+		headers = append(headers,
+			[2]string{"List-Unsubscribe", "<" + msg.ListUnsubscribe + ">"},
+			[2]string{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+		)
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+
+	if msg.Text == "" {
+		buf.WriteString("Content-Type: text/html; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.HTML); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID derives the ID from the idempotency key when there is one, so a
+// retried send carries the same Message-ID and receivers can drop the copy.
+func messageID(from, key string) string {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domain = address.Address[at+1:]
+		}
+	}
+
+	id := make([]byte, 16)
+	if key != "" {
+		sum := sha256.Sum256([]byte(key))
+		copy(id, sum[:])
+	} else {
+		_, _ = rand.Read(id)
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+}
@@ -0,0 +1,313 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultLocale is used for recipients whose locale has no translations.
+const DefaultLocale = "en"
+
+const siteURL = "https://interviewer.dev"
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Email templates live in templates/<locale>/<name>.html and .txt. The .txt
+// file defines the subject and the plain-text body, the .html file the HTML
+// body. Both are wrapped by the shared layout files, and each locale's
+// common files supply the signature.
+//
+//go:embed templates
+var templateFS embed.FS
+
+// templateNames lists every email the mailer sends. Each locale must
+// translate all of them.
+var templateNames = []string{
+	"account_locked",
+	"credit_expiry_warning",
+	"credits_low",
+	"deletion_confirmation",
+	"email_change_confirmation",
+	"email_change_notice",
+	"interview_report_ready",
+	"password_reset",
+	"payment_failed",
+	"practice_reminder",
+	"reactivation_link",
+	"subscription_renewed",
+	"verification",
+	"welcome",
+}
+
+type localizedTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var templates = mustLoadTemplates(templateFS)
+
+func mustLoadTemplates(fsys fs.FS) map[string]map[string]localizedTemplate {
+	loaded, err := loadTemplates(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return loaded
+}
+
+func loadTemplates(fsys fs.FS) (map[string]map[string]localizedTemplate, error) {
+	entries, err := fs.ReadDir(fsys, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := map[string]map[string]localizedTemplate{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+		funcs := templateFuncs(locale)
+
+		loaded[locale] = map[string]localizedTemplate{}
+		for _, name := range templateNames {
+			html, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs)).ParseFS(fsys,
+				"templates/layout.html",
+				"templates/"+locale+"/common.html",
+				"templates/"+locale+"/"+name+".html",
+			)
+			if err != nil {
+				return nil, fmt.Errorf("email template %s/%s.html: %w", locale, name, err)
+			}
+			text, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).ParseFS(fsys,
+				"templates/layout.txt",
+				"templates/"+locale+"/common.txt",
+				"templates/"+locale+"/"+name+".txt",
+			)
+			if err != nil {
+				return nil, fmt.Errorf("email template %s/%s.txt: %w", locale, name, err)
+			}
+			loaded[locale][name] = localizedTemplate{html: html, text: text}
+		}
+	}
+
+	if _, ok := loaded[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("email templates missing default locale %q", DefaultLocale)
+	}
+	return loaded, nil
+}
+
+// render builds the message for the named template in the recipient's
+// locale, falling back to DefaultLocale.
+func render(name, locale string, data map[string]any) (*Message, error) {
+	locale, ok := NormalizeLocale(locale)
+	if !ok {
+		locale = DefaultLocale
+	}
+	tmpl, ok := templates[locale][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	values := map[string]any{
+		"Locale":  locale,
+		"SiteURL": siteURL,
+	}
+	for key, value := range data {
+		values[key] = value
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", values); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "layout", values); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", values); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// Locales returns the locales that have translations.
+func Locales() []string {
+	locales := make([]string, 0, len(templates))
+	for locale := range templates {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Templates returns the names of every email template.
+func Templates() []string {
+	return append([]string(nil), templateNames...)
+}
+
+// NormalizeLocale reduces a language tag such as "es-MX" to the locale that
+// translates it and reports whether there is one.
+func NormalizeLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	_, ok := templates[locale]
+	return locale, ok
+}
+
+// MatchLocale picks the preferred supported locale from an Accept-Language
+// header, or DefaultLocale when none match.
+func MatchLocale(acceptLanguage string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+
+	candidates := []candidate{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if locale, ok := NormalizeLocale(tag); ok && q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLocale
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// categoryLabels names each notification category in the unsubscribe
+// footer of notification emails.
+var categoryLabels = map[string]map[string]string{
+	"en": {
+		"interview_reports":  "interview report emails",
+		"credits":            "credit balance emails",
+		"billing":            "billing emails",
+		"practice_reminders": "weekly practice reminders",
+	},
+	"es": {
+		"interview_reports":  "los avisos de informes de entrevista",
+		"credits":            "los avisos de saldo de créditos",
+		"billing":            "los avisos de facturación",
+		"practice_reminders": "los recordatorios semanales de práctica",
+	},
+}
+
+var spanishMonths = []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+
+func templateFuncs(locale string) map[string]any {
+	date := func(t time.Time) string {
+		t = t.UTC()
+		switch locale {
+		case "es":
+			return fmt.Sprintf("%d de %s de %d", t.Day(), spanishMonths[t.Month()-1], t.Year())
+		default:
+			return t.Format("January 2, 2006")
+		}
+	}
+
+	return map[string]any{
+		"date": date,
+		"datetime": func(t time.Time) string {
+			return date(t) + ", " + t.UTC().Format("15:04") + " UTC"
+		},
+		"category": func(category string) string {
+			if label, ok := categoryLabels[locale][category]; ok {
+				return label
+			}
+			return category
+		},
+		"button": func(url, label string, danger bool) map[string]any {
+			return map[string]any{"URL": url, "Label": label, "Danger": danger}
+		},
+	}
+}
+
+// previewData is sample data for every template, so copy can be reviewed
+// without sending mail.
+var previewData = map[string]map[string]any{
+	"account_locked":            {"LockedUntil": time.Date(2025, 7, 4, 15, 30, 0, 0, time.UTC)},
+	"credit_expiry_warning":     {"Credits": 3, "ExpiresAt": time.Date(2025, 7, 11, 0, 0, 0, 0, time.UTC)},
+	"credits_low":               withUnsubscribe("credits", map[string]any{"Credits": 1}),
+	"deletion_confirmation":     {"RestoreBy": time.Date(2025, 8, 3, 0, 0, 0, 0, time.UTC)},
+	"email_change_confirmation": {"ConfirmURL": siteURL + "/confirm-email-change?token=preview"},
+	"email_change_notice":       {"NewEmail": "new.address@example.com", "CancelURL": siteURL + "/cancel-email-change?token=preview"},
+	"interview_report_ready":    withUnsubscribe("interview_reports", map[string]any{"InterviewID": 42}),
+	"password_reset":            {"ResetURL": siteURL + "/reset-password?token=preview"},
+	"payment_failed":            withUnsubscribe("billing", map[string]any{}),
+	"practice_reminder":         withUnsubscribe("practice_reminders", map[string]any{}),
+	"reactivation_link":         {"ReactivateURL": siteURL + "/reactivate-account?token=preview"},
+	"subscription_renewed":      withUnsubscribe("billing", map[string]any{"RenewsAt": time.Date(2025, 8, 4, 0, 0, 0, 0, time.UTC)}),
+	"verification":              {"VerifyURL": siteURL + "/verify-email?token=preview"},
+	"welcome":                   {},
+}
+
+// withUnsubscribe adds the fields every notification email carries.
+func withUnsubscribe(category string, data map[string]any) map[string]any {
+	data["Category"] = category
+	data["UnsubscribeURL"] = siteURL + "/unsubscribe?token=preview"
+	data["OneClickUnsubscribeURL"] = siteURL + "/api/notifications/unsubscribe?token=preview"
+	return data
+}
+
+// templateData restores the types the template expects to data that has been
+// through JSON, such as an outbox row: timestamps come back as strings and
+// counts as float64. previewData supplies the expected type of each field.
+func templateData(name string, data map[string]any) map[string]any {
+	restored := make(map[string]any, len(data))
+	for key, value := range data {
+		restored[key] = value
+		switch previewData[name][key].(type) {
+		case time.Time:
+			if s, ok := value.(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					restored[key] = t
+				}
+			}
+		case int:
+			if f, ok := value.(float64); ok {
+				restored[key] = int(f)
+			}
+		}
+	}
+	return restored
+}
+
+// Preview renders the named template with sample data.
+func Preview(name, locale string) (*Message, error) {
+	data, ok := previewData[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	msg, err := render(name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.To = "preview@example.com"
+	return msg, nil
+}
@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPreviewRendersEveryTemplate(t *testing.T) {
+	if len(Locales()) < 2 {
+		t.Fatalf("expected translations besides %q, got %v", DefaultLocale, Locales())
+	}
+
+	for _, locale := range Locales() {
+		for _, name := range Templates() {
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				msg, err := Preview(name, locale)
+				if err != nil {
+					t.Fatalf("Preview failed: %v", err)
+				}
+				if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+					t.Errorf("unexpected subject %q", msg.Subject)
+				}
+				for format, body := range map[string]string{"html": msg.HTML, "text": msg.Text} {
+					if strings.Contains(body, "<no value>") || strings.Contains(body, "ZgotmplZ") {
+						t.Errorf("%s body has a missing or unsafe value:\n%s", format, body)
+					}
+				}
+				if !strings.Contains(msg.HTML, `<html lang="`+locale+`">`) {
+					t.Errorf("expected html in %s, got:\n%s", locale, msg.HTML)
+				}
+			})
+		}
+	}
+
+	if _, err := Preview("missing", DefaultLocale); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("expected ErrUnknownTemplate, got %v", err)
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expected       string
+	}{
+		{
+			name:           "MatchLocale_Empty",
+			acceptLanguage: "",
+			expected:       DefaultLocale,
+		},
+		{
+			name:           "MatchLocale_RegionalTag",
+			acceptLanguage: "es-MX,es;q=0.9",
+			expected:       "es",
+		},
+		{
+			name:           "MatchLocale_HonorsQuality",
+			acceptLanguage: "en;q=0.5, es;q=0.8",
+			expected:       "es",
+		},
+		{
+			name:           "MatchLocale_SkipsUnsupported",
+			acceptLanguage: "fr-FR, de;q=0.9, en;q=0.1",
+			expected:       "en",
+		},
+		{
+			name:           "MatchLocale_NoneSupported",
+			acceptLanguage: "fr-FR, de;q=0.9",
+			expected:       DefaultLocale,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := MatchLocale(tc.acceptLanguage); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
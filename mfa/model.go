@@ -0,0 +1,69 @@
+package mfa
+
+import (
+	"errors"
+	"time"
+)
+
+// TOTP is a user's authenticator app enrollment. It only guards logins once
+// EnabledAt is set, which happens after the user proves the app works.
+type TOTP struct {
+	UserID          int
+	SecretEncrypted string
+	EnabledAt       *time.Time
+	LastUsedStep    int64
+	FailedAttempts  int
+	LockedUntil     *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type Enrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type Status struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+const (
+	Issuer = "Interviewer"
+
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts codes from one period either side of now to allow for
+	// clock drift on the user's device.
+	totpSkew = 1
+
+	RecoveryCodeCount = 10
+
+	// MaxFailedAttempts wrong codes lock second-factor verification for
+	// LockoutDuration.
+	MaxFailedAttempts = 5
+	LockoutDuration   = 15 * time.Minute
+
+	ChallengeLifetime = 5 * time.Minute
+)
+
+type MFARepo interface {
+	GetTOTP(userID int) (*TOTP, error)
+	SavePendingTOTP(totp *TOTP) error
+	EnableTOTP(userID int, recoveryCodeHashes []string, at time.Time) error
+	RecordTOTPUse(userID int, step int64, at time.Time) (bool, error)
+	UpdateFailures(userID, failedAttempts int, lockedUntil *time.Time) error
+	UseRecoveryCode(userID int, codeHash string, at time.Time) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	DeleteTOTP(userID int) error
+}
+
+var (
+	ErrNotEnrolled        = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrInvalidCode        = errors.New("invalid verification code")
+	ErrLocked             = errors.New("too many failed attempts")
+	ErrInvalidChallenge   = errors.New("invalid or expired challenge")
+	ErrNoEncryptionKey    = errors.New("MFA_ENCRYPTION_KEY or JWT_SECRET must be set")
+	ErrReauthenticateFail = errors.New("re-authentication failed")
+)
@@ -0,0 +1,196 @@
+package mfa
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) GetTOTP(userID int) (*TOTP, error) {
+	var totp TOTP
+	err := r.DB.QueryRow(`
+		SELECT user_id, secret_encrypted, enabled_at, last_used_step, failed_attempts, locked_until, created_at, updated_at
+		FROM mfa_totp
+		WHERE user_id = $1
+	`, userID).Scan(
+		&totp.UserID,
+		&totp.SecretEncrypted,
+		&totp.EnabledAt,
+		&totp.LastUsedStep,
+		&totp.FailedAttempts,
+		&totp.LockedUntil,
+		&totp.CreatedAt,
+		&totp.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotEnrolled
+	} else if err != nil {
+		log.Printf("GetTOTP failed: %v", err)
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// SavePendingTOTP stores a new, not yet enabled, secret. It never replaces
+// an enabled enrollment.
+func (r *Repository) SavePendingTOTP(totp *TOTP) error {
+	result, err := r.DB.Exec(`
+		INSERT INTO mfa_totp (user_id, secret_encrypted, created_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret_encrypted = EXCLUDED.secret_encrypted,
+		    last_used_step = 0,
+		    failed_attempts = 0,
+		    locked_until = NULL,
+		    updated_at = EXCLUDED.updated_at
+		WHERE mfa_totp.enabled_at IS NULL
+	`, totp.UserID, totp.SecretEncrypted, totp.CreatedAt, totp.UpdatedAt)
+	if err != nil {
+		log.Printf("SavePendingTOTP failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableTOTP turns the enrollment on and replaces the user's recovery codes.
+func (r *Repository) EnableTOTP(userID int, recoveryCodeHashes []string, at time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE mfa_totp SET enabled_at = $1, updated_at = $1 WHERE user_id = $2`, at, userID)
+	if err != nil {
+		log.Printf("enable totp failed: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Printf("delete recovery codes failed: %v", err)
+		return err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, $3)
+		`, userID, codeHash, at)
+		if err != nil {
+			log.Printf("insert recovery code failed: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RecordTOTPUse stores the time step a code was accepted for. It reports
+// false if that step (or a later one) was already used, which stops a code
+// from being replayed by a concurrent request.
+func (r *Repository) RecordTOTPUse(userID int, step int64, at time.Time) (bool, error) {
+	result, err := r.DB.Exec(`
+		UPDATE mfa_totp
+		SET last_used_step = $1, updated_at = $2
+		WHERE user_id = $3 AND last_used_step < $1
+	`, step, at, userID)
+	if err != nil {
+		log.Printf("RecordTOTPUse failed: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (r *Repository) UpdateFailures(userID, failedAttempts int, lockedUntil *time.Time) error {
+	_, err := r.DB.Exec(`
+		UPDATE mfa_totp
+		SET failed_attempts = $1, locked_until = $2
+		WHERE user_id = $3
+	`, failedAttempts, lockedUntil, userID)
+	if err != nil {
+		log.Printf("UpdateFailures failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) UseRecoveryCode(userID int, codeHash string, at time.Time) (bool, error) {
+	result, err := r.DB.Exec(`
+		UPDATE mfa_recovery_codes
+		SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+	`, at, userID, codeHash)
+	if err != nil {
+		log.Printf("UseRecoveryCode failed: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (r *Repository) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&count)
+	if err != nil {
+		log.Printf("CountRecoveryCodes failed: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Repository) DeleteTOTP(userID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		log.Printf("delete recovery codes failed: %v", err)
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_totp WHERE user_id = $1`, userID); err != nil {
+		log.Printf("delete totp failed: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,122 @@
+package mfa
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	TOTPs         map[int]TOTP
+	RecoveryCodes map[int]map[string]bool
+	FailRepo      bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		TOTPs:         map[int]TOTP{},
+		RecoveryCodes: map[int]map[string]bool{},
+	}
+}
+
+func (m *MockRepo) GetTOTP(userID int) (*TOTP, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	totp, ok := m.TOTPs[userID]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	return &totp, nil
+}
+
+func (m *MockRepo) SavePendingTOTP(totp *TOTP) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	if existing, ok := m.TOTPs[totp.UserID]; ok && existing.EnabledAt != nil {
+		return ErrAlreadyEnabled
+	}
+	m.TOTPs[totp.UserID] = *totp
+	return nil
+}
+
+func (m *MockRepo) EnableTOTP(userID int, recoveryCodeHashes []string, at time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	totp := m.TOTPs[userID]
+	totp.EnabledAt = &at
+	m.TOTPs[userID] = totp
+
+	m.RecoveryCodes[userID] = map[string]bool{}
+	for _, codeHash := range recoveryCodeHashes {
+		m.RecoveryCodes[userID][codeHash] = false
+	}
+	return nil
+}
+
+func (m *MockRepo) RecordTOTPUse(userID int, step int64, at time.Time) (bool, error) {
+	if m.FailRepo {
+		return false, errors.New("mocked DB failure")
+	}
+
+	totp := m.TOTPs[userID]
+	if totp.LastUsedStep >= step {
+		return false, nil
+	}
+	totp.LastUsedStep = step
+	m.TOTPs[userID] = totp
+	return true, nil
+}
+
+func (m *MockRepo) UpdateFailures(userID, failedAttempts int, lockedUntil *time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	totp := m.TOTPs[userID]
+	totp.FailedAttempts = failedAttempts
+	totp.LockedUntil = lockedUntil
+	m.TOTPs[userID] = totp
+	return nil
+}
+
+func (m *MockRepo) UseRecoveryCode(userID int, codeHash string, at time.Time) (bool, error) {
+	if m.FailRepo {
+		return false, errors.New("mocked DB failure")
+	}
+
+	used, ok := m.RecoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.RecoveryCodes[userID][codeHash] = true
+	return true, nil
+}
+
+func (m *MockRepo) CountRecoveryCodes(userID int) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+
+	count := 0
+	for _, used := range m.RecoveryCodes[userID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockRepo) DeleteTOTP(userID int) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	delete(m.TOTPs, userID)
+	delete(m.RecoveryCodes, userID)
+	return nil
+}
@@ -0,0 +1,254 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/token"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+// Enroll generates a new TOTP secret for the user. It replaces any earlier
+// enrollment that was never confirmed, and stays inactive until
+// ConfirmEnrollment succeeds.
+func Enroll(repo MFARepo, userID int, accountName string) (*Enrollment, error) {
+	existing, err := repo.GetTOTP(userID)
+	if err == nil && existing.EnabledAt != nil {
+		return nil, ErrAlreadyEnabled
+	} else if err != nil && !errors.Is(err, ErrNotEnrolled) {
+		return nil, err
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	sealed, err := sealSecret(secret)
+	if err != nil {
+		log.Printf("sealSecret failed: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err = repo.SavePendingTOTP(&TOTP{
+		UserID:          userID,
+		SecretEncrypted: sealed,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+	if err != nil {
+		log.Printf("repo.SavePendingTOTP failed: %v", err)
+		return nil, err
+	}
+
+	encoded := secretEncoding.EncodeToString(secret)
+	return &Enrollment{
+		Secret:          encoded,
+		ProvisioningURI: provisioningURI(encoded, accountName),
+	}, nil
+}
+
+// ConfirmEnrollment enables TOTP once the user enters a code from their app,
+// and returns the recovery codes. They are only ever shown here.
+func ConfirmEnrollment(repo MFARepo, userID int, code string) ([]string, error) {
+	totp, err := repo.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.EnabledAt != nil {
+		return nil, ErrAlreadyEnabled
+	}
+
+	if err := verify(repo, totp, code, false); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := repo.EnableTOTP(userID, hashes, time.Now().UTC()); err != nil {
+		log.Printf("repo.EnableTOTP failed: %v", err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Enabled reports whether logins for the user need a second factor.
+func Enabled(repo MFARepo, userID int) (bool, error) {
+	totp, err := repo.GetTOTP(userID)
+	if errors.Is(err, ErrNotEnrolled) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return totp.EnabledAt != nil, nil
+}
+
+func GetStatus(repo MFARepo, userID int) (*Status, error) {
+	enabled, err := Enabled(repo, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{TOTPEnabled: enabled}
+	if enabled {
+		status.RecoveryCodesRemaining, err = repo.CountRecoveryCodes(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
+
+// CreateChallenge returns the short-lived token a client exchanges, together
+// with a code, for a session after the password step succeeds.
+func CreateChallenge(userID int) (string, error) {
+	now := time.Now().UTC()
+	return token.Sign(token.PurposeMFAChallenge, jwt.RegisteredClaims{
+		Issuer:    "interviewer",
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeLifetime)),
+	})
+}
+
+// CompleteChallenge checks the challenge token and the second factor and
+// returns the user to log in. Once the challenge itself is valid, the user
+// is returned alongside any code error so the failure can be attributed.
+func CompleteChallenge(repo MFARepo, challenge, code string) (int, error) {
+	parsed, err := token.Parse(token.PurposeMFAChallenge, challenge, &jwt.RegisteredClaims{})
+	if err != nil || !parsed.Valid {
+		return 0, ErrInvalidChallenge
+	}
+	claims, ok := parsed.Claims.(*jwt.RegisteredClaims)
+	if !ok {
+		return 0, ErrInvalidChallenge
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, ErrInvalidChallenge
+	}
+
+	if err := Verify(repo, userID, code); err != nil {
+		return userID, err
+	}
+
+	return userID, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code for a user with TOTP
+// enabled.
+func Verify(repo MFARepo, userID int, code string) error {
+	totp, err := repo.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if totp.EnabledAt == nil {
+		return ErrNotEnrolled
+	}
+
+	return verify(repo, totp, code, true)
+}
+
+// Disable turns TOTP off after the user re-authenticates with their password
+// (if the account has one) and a current code.
+func Disable(repo MFARepo, userRepo user.UserRepo, userID int, password, code string) error {
+	hasPassword, err := user.HasPassword(userRepo, userID)
+	if err != nil {
+		return err
+	}
+	if hasPassword {
+		if err := user.CheckPassword(userRepo, userID, password); err != nil {
+			return ErrReauthenticateFail
+		}
+	}
+
+	if err := Verify(repo, userID, code); err != nil {
+		return err
+	}
+
+	return repo.DeleteTOTP(userID)
+}
+
+// AdminDisable turns TOTP off for a user who lost their device, after the
+// admin re-enters their own password.
+func AdminDisable(repo MFARepo, userRepo user.UserRepo, adminID int, adminPassword string, userID int) error {
+	if err := user.CheckPassword(userRepo, adminID, adminPassword); err != nil {
+		return ErrReauthenticateFail
+	}
+
+	if _, err := repo.GetTOTP(userID); err != nil {
+		return err
+	}
+
+	log.Printf("admin %d disabled two-factor authentication for user %d", adminID, userID)
+	return repo.DeleteTOTP(userID)
+}
+
+// verify accepts a TOTP code, or a recovery code when allowRecovery is set,
+// counting failures towards a temporary lockout.
+func verify(repo MFARepo, totp *TOTP, code string, allowRecovery bool) error {
+	now := time.Now().UTC()
+	if totp.LockedUntil != nil && now.Before(*totp.LockedUntil) {
+		return ErrLocked
+	}
+
+	code = strings.TrimSpace(code)
+	ok := false
+	if isTOTPCode(code) {
+		secret, err := openSecret(totp.SecretEncrypted)
+		if err != nil {
+			log.Printf("openSecret failed for user %d: %v", totp.UserID, err)
+			return err
+		}
+		if step, matched := matchTOTP(secret, code, now, totp.LastUsedStep); matched {
+			ok, err = repo.RecordTOTPUse(totp.UserID, step, now)
+			if err != nil {
+				return err
+			}
+		}
+	} else if allowRecovery && code != "" {
+		var err error
+		ok, err = repo.UseRecoveryCode(totp.UserID, hashRecoveryCode(code), now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ok {
+		if totp.FailedAttempts > 0 {
+			return repo.UpdateFailures(totp.UserID, 0, nil)
+		}
+		return nil
+	}
+
+	attempts := totp.FailedAttempts + 1
+	var lockedUntil *time.Time
+	if attempts >= MaxFailedAttempts {
+		until := now.Add(LockoutDuration)
+		lockedUntil = &until
+		attempts = 0
+		log.Printf("two-factor verification locked for user %d", totp.UserID)
+	}
+	if err := repo.UpdateFailures(totp.UserID, attempts, lockedUntil); err != nil {
+		return err
+	}
+
+	return ErrInvalidCode
+}
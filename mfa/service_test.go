@@ -0,0 +1,197 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits.
+	secret := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range tests {
+		if got := totpCode(secret, timeStep(time.Unix(unix, 0))); got != expected {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, expected)
+		}
+	}
+}
+
+// enroll runs the enrollment flow and returns the raw secret and recovery
+// codes.
+func enroll(t *testing.T, repo *MockRepo, userID int) ([]byte, []string) {
+	enrollment, err := Enroll(repo, userID, "test@test.com")
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	uri, err := url.Parse(enrollment.ProvisioningURI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret {
+		t.Fatalf("unexpected provisioning URI %q", enrollment.ProvisioningURI)
+	}
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("DecodeString failed: %v", err)
+	}
+
+	// Use the previous step so tests can still present the current one.
+	codes, err := ConfirmEnrollment(repo, userID, totpCode(secret, timeStep(time.Now())-1))
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment failed: %v", err)
+	}
+	return secret, codes
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        func(secret []byte, recovery []string) string
+		replay      bool
+		lockedUntil *time.Time
+		expectedErr error
+	}{
+		{
+			name: "Verify_TOTPCode",
+			code: func(secret []byte, _ []string) string { return totpCode(secret, timeStep(time.Now())) },
+		},
+		{
+			name:        "Verify_TOTPCodeReplayed",
+			code:        func(secret []byte, _ []string) string { return totpCode(secret, timeStep(time.Now())) },
+			replay:      true,
+			expectedErr: ErrInvalidCode,
+		},
+		{
+			name: "Verify_RecoveryCode",
+			code: func(_ []byte, recovery []string) string { return strings.ToUpper(recovery[0]) },
+		},
+		{
+			name:        "Verify_RecoveryCodeReused",
+			code:        func(_ []byte, recovery []string) string { return recovery[0] },
+			replay:      true,
+			expectedErr: ErrInvalidCode,
+		},
+		{
+			name:        "Verify_WrongCode",
+			code:        func(_ []byte, _ []string) string { return "000000" },
+			expectedErr: ErrInvalidCode,
+		},
+		{
+			name:        "Verify_Locked",
+			code:        func(secret []byte, _ []string) string { return totpCode(secret, timeStep(time.Now())) },
+			lockedUntil: func() *time.Time { t := time.Now().Add(time.Minute); return &t }(),
+			expectedErr: ErrLocked,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+			t.Setenv("MFA_ENCRYPTION_KEY", "testkey")
+
+			repo := NewMockRepo()
+			secret, recovery := enroll(t, repo, 1)
+			if len(recovery) != RecoveryCodeCount {
+				t.Fatalf("expected %d recovery codes, got %d", RecoveryCodeCount, len(recovery))
+			}
+			if tc.lockedUntil != nil {
+				repo.UpdateFailures(1, 0, tc.lockedUntil)
+			}
+
+			code := tc.code(secret, recovery)
+			if tc.replay {
+				if err := Verify(repo, 1, code); err != nil {
+					t.Fatalf("first Verify failed: %v", err)
+				}
+			}
+
+			err := Verify(repo, 1, code)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+	t.Setenv("MFA_ENCRYPTION_KEY", "testkey")
+
+	repo := NewMockRepo()
+	secret, _ := enroll(t, repo, 1)
+
+	for i := 0; i < MaxFailedAttempts; i++ {
+		if err := Verify(repo, 1, "000000"); err != ErrInvalidCode {
+			t.Fatalf("attempt %d: expected ErrInvalidCode, got %v", i+1, err)
+		}
+	}
+	if err := Verify(repo, 1, totpCode(secret, timeStep(time.Now()))); err != ErrLocked {
+		t.Fatalf("expected a valid code to be refused while locked, got %v", err)
+	}
+}
+
+func TestChallengeAndDisable(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+	t.Setenv("MFA_ENCRYPTION_KEY", "testkey")
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	repo := NewMockRepo()
+	userRepo := user.NewMockRepo()
+	secret, recovery := enroll(t, repo, 1)
+
+	if _, err := Enroll(repo, 1, "test@test.com"); err != ErrAlreadyEnabled {
+		t.Fatalf("expected ErrAlreadyEnabled when enrolling twice, got %v", err)
+	}
+
+	challenge, err := CreateChallenge(1)
+	if err != nil {
+		t.Fatalf("CreateChallenge failed: %v", err)
+	}
+	if _, err := CompleteChallenge(repo, "not-a-token", recovery[0]); err != ErrInvalidChallenge {
+		t.Fatalf("expected ErrInvalidChallenge, got %v", err)
+	}
+	userID, err := CompleteChallenge(repo, challenge, recovery[0])
+	if err != nil || userID != 1 {
+		t.Fatalf("expected challenge to complete for user 1, got %d, err=%v", userID, err)
+	}
+
+	status, err := GetStatus(repo, 1)
+	if err != nil || !status.TOTPEnabled || status.RecoveryCodesRemaining != RecoveryCodeCount-1 {
+		t.Fatalf("unexpected status %+v, err=%v", status, err)
+	}
+
+	code := totpCode(secret, timeStep(time.Now()))
+	if err := Disable(repo, userRepo, 1, "wrong", code); err != ErrReauthenticateFail {
+		t.Fatalf("expected ErrReauthenticateFail for a wrong password, got %v", err)
+	}
+	if err := Disable(repo, userRepo, 1, "test", code); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+	if enabled, _ := Enabled(repo, 1); enabled {
+		t.Fatalf("expected TOTP to be disabled")
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
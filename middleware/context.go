@@ -3,15 +3,15 @@ package middleware
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/apikey"
 	"github.com/michaelboegner/interviewer/conversation"
+	"github.com/michaelboegner/interviewer/token"
 	"github.com/michaelboegner/interviewer/user"
 )
 
@@ -26,6 +26,7 @@ type AcceptedVals struct {
 	Message        string                     `json:"message,omitempty"`
 	Conversation   *conversation.Conversation `json:"conversation,omitempty"`
 	JD             string                     `json:"job_description,omitempty"`
+	DeviceLabel    string                     `json:"device_label,omitempty"`
 }
 
 type returnVals struct {
@@ -38,8 +39,72 @@ const (
 	ContextKeyParams      ContextKey = "params"
 	ContextKeyTokenKey    ContextKey = "tokenKey"
 	ContextKeyTokenParams ContextKey = "tokenParams"
+	ContextKeyAPIKey      ContextKey = "apiKey"
+	ContextKeyActorID     ContextKey = "actorID"
 )
 
+// apiKeyRoutes lists the only routes an API key can call and the scope each
+// needs. Anything else, including account and key management, needs a
+// login.
+var apiKeyRoutes = []struct {
+	method string
+	path   string
+	prefix bool
+	scope  string
+}{
+	{http.MethodPost, "/api/interviews", false, apikey.ScopeInterviewsWrite},
+	{http.MethodGet, "/api/interviews/", true, apikey.ScopeInterviewsRead},
+	{http.MethodPatch, "/api/interviews/", true, apikey.ScopeInterviewsWrite},
+	{http.MethodPost, "/api/conversations/create/", true, apikey.ScopeInterviewsWrite},
+	{http.MethodPost, "/api/conversations/append/", true, apikey.ScopeInterviewsWrite},
+	{http.MethodGet, "/api/conversations/", true, apikey.ScopeInterviewsRead},
+	{http.MethodGet, "/api/payment/history", false, apikey.ScopeBillingRead},
+	{http.MethodGet, "/api/payment/receipts/", true, apikey.ScopeBillingRead},
+}
+
+// adminRoutes lists every admin endpoint and the permission it needs. A *
+// matches one path segment, such as a user ID. Admin requests that match no
+// entry are refused.
+var adminRoutes = []struct {
+	method     string
+	pattern    string
+	permission user.Permission
+}{
+	{http.MethodGet, "/api/admin/audit", user.PermAuditRead},
+	{http.MethodGet, "/api/admin/users", user.PermUsersRead},
+	{http.MethodGet, "/api/admin/users/*", user.PermUsersRead},
+	{http.MethodGet, "/api/admin/users/*/interviews", user.PermUsersRead},
+	{http.MethodGet, "/api/admin/users/*/transactions", user.PermUsersRead},
+	{http.MethodPost, "/api/admin/users/*/credits", user.PermCreditsAdjust},
+	{http.MethodPost, "/api/admin/users/*/impersonate", user.PermUsersImpersonate},
+	{http.MethodPost, "/api/admin/users/*/deactivate", user.PermUsersManage},
+	{http.MethodPost, "/api/admin/users/*/reactivate", user.PermUsersManage},
+	{http.MethodPut, "/api/admin/users/*/role", user.PermUsersManage},
+	{http.MethodDelete, "/api/admin/mfa/*", user.PermMFAReset},
+	{http.MethodGet, "/api/admin/promotions", user.PermBillingManage},
+	{http.MethodPost, "/api/admin/promotions", user.PermBillingManage},
+	{http.MethodPut, "/api/admin/promotions/*", user.PermBillingManage},
+	{http.MethodGet, "/api/admin/plans", user.PermBillingManage},
+	{http.MethodPost, "/api/admin/plans", user.PermBillingManage},
+	{http.MethodPut, "/api/admin/plans/*", user.PermBillingManage},
+	{http.MethodGet, "/api/admin/webhooks", user.PermBillingManage},
+	{http.MethodGet, "/api/admin/webhooks/*", user.PermBillingManage},
+	{http.MethodPost, "/api/admin/webhooks/*/replay", user.PermBillingManage},
+	{http.MethodGet, "/api/admin/emails", user.PermEmailsPreview},
+	{http.MethodGet, "/api/admin/emails/*", user.PermEmailsPreview},
+	{http.MethodGet, "/api/admin/outbox", user.PermEmailsManage},
+	{http.MethodGet, "/api/admin/outbox/*", user.PermEmailsManage},
+	{http.MethodPost, "/api/admin/outbox/*/retry", user.PermEmailsManage},
+}
+
+// apiKeys verifies API keys presented to GetContext. Until UseAPIKeys is
+// called, API keys are refused.
+var apiKeys apikey.APIKeyRepo
+
+func UseAPIKeys(repo apikey.APIKeyRepo) {
+	apiKeys = repo
+}
+
 func EnableCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if os.Getenv("ENV") == "production" {
@@ -64,7 +129,6 @@ func GetContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
 			userID   int
-			err      error
 			tokenKey string
 		)
 		tokenParts := strings.Split(r.Header.Get("Authorization"), " ")
@@ -81,16 +145,41 @@ func GetContext(next http.Handler) http.Handler {
 			return
 		}
 
-		if isAccessToken(tokenKey) {
-			userID, err = VerifyToken(tokenKey)
+		ctx := r.Context()
+		if apikey.IsAPIKey(tokenKey) {
+			key, status, msg := authenticateAPIKey(r, tokenKey)
+			if key == nil {
+				respondWithError(w, status, msg)
+				return
+			}
+			userID = key.UserID
+			ctx = context.WithValue(ctx, ContextKeyAPIKey, key)
+		} else if isAccessToken(tokenKey) {
+			claims, err := token.ParseAccessToken(tokenKey)
+			if err != nil {
+				log.Printf("ParseAccessToken failed: %v", err)
+				respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			userID, err = strconv.Atoi(claims.UserID)
 			if err != nil {
-				log.Printf("VerifyToken failed: %v", err)
+				log.Printf("invalid subject in access token: %v", err)
 				respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 				return
 			}
+
+			// Staff impersonating a customer can look but not change
+			// anything on their behalf.
+			if actorID := claims.ActorID(); actorID != 0 {
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					log.Printf("Blocked %s %s by user %d impersonating user %d", r.Method, r.URL.Path, actorID, userID)
+					respondWithError(w, http.StatusForbidden, "Impersonated sessions are read-only")
+					return
+				}
+				ctx = context.WithValue(ctx, ContextKeyActorID, actorID)
+			}
 		}
 
-		ctx := r.Context()
 		ctx = context.WithValue(ctx, ContextKeyTokenKey, tokenKey)
 		ctx = context.WithValue(ctx, ContextKeyTokenParams, userID)
 
@@ -98,6 +187,34 @@ func GetContext(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey checks the key and that it may call this route. On
+// failure it returns the status and message to respond with.
+func authenticateAPIKey(r *http.Request, presented string) (*apikey.APIKey, int, string) {
+	scope := ""
+	for _, route := range apiKeyRoutes {
+		matches := r.URL.Path == route.path || (route.prefix && strings.HasPrefix(r.URL.Path, route.path))
+		if r.Method == route.method && matches {
+			scope = route.scope
+			break
+		}
+	}
+	if scope == "" || apiKeys == nil {
+		return nil, http.StatusForbidden, "API keys cannot access this endpoint"
+	}
+
+	key, err := apikey.Authenticate(apiKeys, presented)
+	if err != nil {
+		log.Printf("apikey.Authenticate failed: %v", err)
+		return nil, http.StatusUnauthorized, "Unauthorized"
+	}
+	if !key.HasScope(scope) {
+		log.Printf("API key %d lacks scope %s for %s %s", key.ID, scope, r.Method, r.URL.Path)
+		return nil, http.StatusForbidden, "API key is missing the " + scope + " scope"
+	}
+
+	return key, 0, ""
+}
+
 func ValidateUserActive(userRepo *user.Repository) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -107,9 +224,9 @@ func ValidateUserActive(userRepo *user.Repository) func(http.Handler) http.Handl
 				return
 			}
 
-			user, err := userRepo.GetUser(userID)
-			if err != nil || user.AccountStatus == "deleted" {
-				log.Printf("Blocked access for deleted user ID %d", userID)
+			account, err := userRepo.GetUser(userID)
+			if err != nil || account.AccountStatus != user.AccountActive {
+				log.Printf("Blocked access for inactive user ID %d", userID)
 				respondWithError(w, http.StatusUnauthorized, "Account deactivated")
 				return
 			}
@@ -119,33 +236,89 @@ func ValidateUserActive(userRepo *user.Repository) func(http.Handler) http.Handl
 	}
 }
 
-func isAccessToken(tokenString string) bool {
-	return strings.Count(tokenString, ".") == 2
+// Authorize lets a request through only when the caller's role grants the
+// permission adminRoutes lists for it. Users whose email is in the
+// comma-separated ADMIN_EMAILS environment variable are always admins, so a
+// fresh deployment has someone who can assign roles.
+func Authorize(userRepo *user.Repository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(ContextKeyTokenParams).(int)
+			if !ok {
+				respondWithError(w, http.StatusUnauthorized, "Invalid context")
+				return
+			}
+			if _, impersonated := r.Context().Value(ContextKeyActorID).(int); impersonated {
+				log.Printf("Blocked admin access from impersonated session for user ID %d", userID)
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+
+			permission, ok := adminPermission(r)
+			if !ok {
+				log.Printf("No admin permission defined for %s %s", r.Method, r.URL.Path)
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+
+			account, err := userRepo.GetUser(userID)
+			if err != nil {
+				log.Printf("Blocked admin access for user ID %d: %v", userID, err)
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			if isAdminEmail(account.Email) {
+				account.Role = user.RoleAdmin
+			}
+			if !account.Can(permission) {
+				log.Printf("Blocked admin access for user ID %d: role %q lacks %s", userID, account.Role, permission)
+				respondWithError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func VerifyToken(tokenString string) (int, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Printf("JWT secret is not set")
-		err := errors.New("jwt secret is not set")
-		return 0, err
+func adminPermission(r *http.Request) (user.Permission, bool) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for _, route := range adminRoutes {
+		if r.Method == route.method && matchPattern(strings.Split(strings.Trim(route.pattern, "/"), "/"), path) {
+			return route.permission, true
+		}
 	}
+	return "", false
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
-	if err != nil {
-		return 0, err
+func matchPattern(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
 	}
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		userID, err := strconv.Atoi(claims.Subject)
-		if err != nil {
-			return 0, err
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
 		}
-		return userID, nil
-	} else {
-		return 0, errors.New("Invalid token")
 	}
+	return true
+}
+
+func isAdminEmail(email string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		admin = strings.TrimSpace(admin)
+		if admin != "" && strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAccessToken(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 2
+}
+
+func VerifyToken(tokenString string) (int, error) {
+	return token.ExtractUserIDFromToken(tokenString)
 }
 
 func respondWithError(w http.ResponseWriter, code int, msg string) {
@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Categories group the lifecycle emails a user can turn on or off.
+// Transactional emails (verification, password resets, security notices)
+// are not in a category and are always sent.
+const (
+	CategoryInterviewReports  = "interview_reports"
+	CategoryCredits           = "credits"
+	CategoryBilling           = "billing"
+	CategoryPracticeReminders = "practice_reminders"
+)
+
+// Categories lists every category in the order they are shown to users.
+var Categories = []string{
+	CategoryInterviewReports,
+	CategoryCredits,
+	CategoryBilling,
+	CategoryPracticeReminders,
+}
+
+// defaultEnabled is the setting for users who have not chosen. Practice
+// reminders are opt-in; the rest are about the user's own account and are
+// on until turned off.
+var defaultEnabled = map[string]bool{
+	CategoryInterviewReports:  true,
+	CategoryCredits:           true,
+	CategoryBilling:           true,
+	CategoryPracticeReminders: false,
+}
+
+// LowCreditsThreshold is the balance at or below which starting an interview
+// sends the credits low email.
+const LowCreditsThreshold = 1
+
+// Preferences maps every category to whether it is turned on.
+type Preferences map[string]bool
+
+// Recipient is a user due a practice reminder.
+type Recipient struct {
+	UserID int
+	Email  string
+	Locale string
+}
+
+type UnsubscribeClaims struct {
+	Category string `json:"category"`
+	jwt.RegisteredClaims
+}
+
+type NotificationRepo interface {
+	// GetPreferences returns only the categories the user has chosen.
+	GetPreferences(userID int) (map[string]bool, error)
+	SetPreferences(userID int, preferences map[string]bool) error
+	// ListReminderRecipients returns active users who opted in to practice
+	// reminders, have not started an interview since inactiveSince and have
+	// no reminder queued for week.
+	ListReminderRecipients(inactiveSince time.Time, week string, limit int) ([]Recipient, error)
+}
+
+var (
+	ErrUnknownCategory    = errors.New("unknown notification category")
+	ErrInvalidUnsubscribe = errors.New("unsubscribe link is invalid")
+)
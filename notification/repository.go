@@ -0,0 +1,105 @@
+package notification
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) GetPreferences(userID int) (map[string]bool, error) {
+	rows, err := r.DB.Query(`
+		SELECT category, enabled
+		FROM notification_preferences
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		log.Printf("GetPreferences failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	preferences := map[string]bool{}
+	for rows.Next() {
+		var (
+			category string
+			enabled  bool
+		)
+		if err := rows.Scan(&category, &enabled); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		preferences[category] = enabled
+	}
+
+	return preferences, rows.Err()
+}
+
+func (r *Repository) SetPreferences(userID int, preferences map[string]bool) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for category, enabled := range preferences {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, category, enabled, updated_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, category) DO UPDATE
+			SET enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at
+		`, userID, category, enabled, now)
+		if err != nil {
+			log.Printf("SetPreferences failed: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) ListReminderRecipients(inactiveSince time.Time, week string, limit int) ([]Recipient, error) {
+	rows, err := r.DB.Query(`
+		SELECT u.id, u.email, u.locale
+		FROM users u
+		JOIN notification_preferences p
+		  ON p.user_id = u.id AND p.category = 'practice_reminders' AND p.enabled
+		WHERE u.account_status = 'active'
+		  AND NOT EXISTS (
+			SELECT 1 FROM interviews i WHERE i.user_id = u.id AND i.created_at > $1
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM email_outbox o WHERE o.idempotency_key = 'practice_reminder:' || u.id || ':' || $2
+		  )
+		ORDER BY u.id
+		LIMIT $3
+	`, inactiveSince, week, limit)
+	if err != nil {
+		log.Printf("ListReminderRecipients failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []Recipient{}
+	for rows.Next() {
+		var recipient Recipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Email, &recipient.Locale); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, rows.Err()
+}
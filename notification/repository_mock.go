@@ -0,0 +1,57 @@
+package notification
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Preferences map[int]map[string]bool
+	// Reminders holds the recipients ListReminderRecipients returns; the
+	// mock leaves filtering to the caller's setup.
+	Reminders []Recipient
+	FailRepo  bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		Preferences: map[int]map[string]bool{},
+	}
+}
+
+func (m *MockRepo) GetPreferences(userID int) (map[string]bool, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	preferences := map[string]bool{}
+	for category, enabled := range m.Preferences[userID] {
+		preferences[category] = enabled
+	}
+	return preferences, nil
+}
+
+func (m *MockRepo) SetPreferences(userID int, preferences map[string]bool) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	if m.Preferences[userID] == nil {
+		m.Preferences[userID] = map[string]bool{}
+	}
+	for category, enabled := range preferences {
+		m.Preferences[userID][category] = enabled
+	}
+	return nil
+}
+
+func (m *MockRepo) ListReminderRecipients(inactiveSince time.Time, week string, limit int) ([]Recipient, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	if len(m.Reminders) > limit {
+		return m.Reminders[:limit], nil
+	}
+	return m.Reminders, nil
+}
@@ -0,0 +1,268 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/token"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+// GetPreferences returns the user's setting for every category, falling back
+// to the defaults for categories they have not chosen.
+func GetPreferences(repo NotificationRepo, userID int) (Preferences, error) {
+	chosen, err := repo.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := Preferences{}
+	for _, category := range Categories {
+		enabled, ok := chosen[category]
+		if !ok {
+			enabled = defaultEnabled[category]
+		}
+		preferences[category] = enabled
+	}
+
+	return preferences, nil
+}
+
+// UpdatePreferences stores the categories given and returns the full set.
+// Categories left out keep their current setting.
+func UpdatePreferences(repo NotificationRepo, userID int, changes map[string]bool) (Preferences, error) {
+	for category := range changes {
+		if _, ok := defaultEnabled[category]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCategory, category)
+		}
+	}
+
+	if len(changes) > 0 {
+		if err := repo.SetPreferences(userID, changes); err != nil {
+			return nil, err
+		}
+	}
+
+	return GetPreferences(repo, userID)
+}
+
+func Enabled(repo NotificationRepo, userID int, category string) (bool, error) {
+	preferences, err := GetPreferences(repo, userID)
+	if err != nil {
+		return false, err
+	}
+	return preferences[category], nil
+}
+
+// UnsubscribeToken signs a link that turns one category off for one user.
+// It does not expire: an old email should still unsubscribe.
+func UnsubscribeToken(userID int, category string) (string, error) {
+	tokenString, err := token.Sign(token.PurposeUnsubscribe, &UnsubscribeClaims{
+		Category: category,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   "interviewer",
+			Subject:  strconv.Itoa(userID),
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+		},
+	})
+	if err != nil {
+		log.Printf("token.Sign failed: %v", err)
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// Unsubscribe turns off the category named by an unsubscribe link and
+// returns it.
+func Unsubscribe(repo NotificationRepo, tokenString string) (string, error) {
+	claims := &UnsubscribeClaims{}
+	parsed, err := token.Parse(token.PurposeUnsubscribe, tokenString, claims)
+	if err != nil || !parsed.Valid {
+		return "", ErrInvalidUnsubscribe
+	}
+	if _, ok := defaultEnabled[claims.Category]; !ok {
+		return "", ErrInvalidUnsubscribe
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID == 0 {
+		return "", ErrInvalidUnsubscribe
+	}
+
+	if err := repo.SetPreferences(userID, map[string]bool{claims.Category: false}); err != nil {
+		return "", err
+	}
+
+	return claims.Category, nil
+}
+
+// Notify queues a lifecycle email if the user has its category turned on,
+// adding the unsubscribe links the template and List-Unsubscribe header use.
+// It reports whether the email was queued.
+func Notify(repo NotificationRepo, outboxRepo outbox.OutboxRepo, account *user.User, category, template, key string, data map[string]any) (bool, error) {
+	enabled, err := Enabled(repo, account.ID, category)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	unsubscribeToken, err := UnsubscribeToken(account.ID, category)
+	if err != nil {
+		return false, err
+	}
+
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["Category"] = category
+	data["UnsubscribeURL"] = os.Getenv("FRONTEND_URL") + "unsubscribe?token=" + unsubscribeToken
+	data["OneClickUnsubscribeURL"] = apiURL() + "api/notifications/unsubscribe?token=" + unsubscribeToken
+
+	return outboxRepo.Enqueue(&outbox.Email{
+		Key:       key,
+		UserID:    account.ID,
+		Recipient: account.Email,
+		Locale:    account.Locale,
+		Template:  template,
+		Data:      data,
+	})
+}
+
+// InterviewReportReady tells the user their finished interview's report is
+// ready. The key makes repeated calls for the same interview harmless.
+func InterviewReportReady(repo NotificationRepo, outboxRepo outbox.OutboxRepo, account *user.User, interviewID int) error {
+	_, err := Notify(repo, outboxRepo, account, CategoryInterviewReports, "interview_report_ready",
+		fmt.Sprintf("interview_report:%d", interviewID),
+		map[string]any{"InterviewID": interviewID})
+	return err
+}
+
+// CreditsLow warns a user whose balance has dropped to LowCreditsThreshold or
+// below, at most once a week.
+func CreditsLow(repo NotificationRepo, outboxRepo outbox.OutboxRepo, account *user.User, now time.Time) error {
+	credits := account.IndividualCredits + account.SubscriptionCredits
+	if credits > LowCreditsThreshold {
+		return nil
+	}
+
+	_, err := Notify(repo, outboxRepo, account, CategoryCredits, "credits_low",
+		fmt.Sprintf("credits_low:%d:%s", account.ID, isoWeek(now)),
+		map[string]any{"Credits": credits})
+	return err
+}
+
+// HandleBillingEvent sends the renewal and failed payment emails for
+// subscription payment webhooks.
+func HandleBillingEvent(repo NotificationRepo, outboxRepo outbox.OutboxRepo, userRepo user.UserRepo, event *billing.Event) error {
+	var (
+		template string
+		data     = map[string]any{}
+	)
+	switch {
+	case event.Type == billing.EventPaymentSucceeded && event.BillingReason != billing.BillingReasonInitial:
+		template = "subscription_renewed"
+		if !event.EndsAt.IsZero() {
+			data["RenewsAt"] = event.EndsAt
+		}
+	case event.Type == billing.EventPaymentFailed:
+		template = "payment_failed"
+	default:
+		return nil
+	}
+
+	account, err := userRepo.GetUserByEmail(event.UserEmail)
+	if err != nil {
+		log.Printf("userRepo.GetUserByEmail failed: %v", err)
+		return err
+	}
+
+	_, err = Notify(repo, outboxRepo, account, CategoryBilling, template, template+":"+event.ID, data)
+	return err
+}
+
+// ReminderJob queues a weekly practice reminder for opted-in users who have
+// not started an interview in the past week.
+type ReminderJob struct {
+	Repo       NotificationRepo
+	OutboxRepo outbox.OutboxRepo
+	Interval   time.Duration
+	BatchSize  int
+}
+
+func NewReminderJob(repo NotificationRepo, outboxRepo outbox.OutboxRepo) *ReminderJob {
+	return &ReminderJob{
+		Repo:       repo,
+		OutboxRepo: outboxRepo,
+		Interval:   time.Hour,
+		BatchSize:  100,
+	}
+}
+
+func (j *ReminderJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Run(time.Now().UTC()); err != nil {
+			log.Printf("ReminderJob.Run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run queues reminders in batches until no one is due and returns how many
+// were queued. Recipients are skipped once their reminder for the week is in
+// the outbox, so a failed enqueue is retried on the next run.
+func (j *ReminderJob) Run(now time.Time) (int, error) {
+	week := isoWeek(now)
+	queued := 0
+
+	for {
+		recipients, err := j.Repo.ListReminderRecipients(now.Add(-7*24*time.Hour), week, j.BatchSize)
+		if err != nil {
+			return queued, err
+		}
+
+		batchQueued := 0
+		for _, recipient := range recipients {
+			account := &user.User{ID: recipient.UserID, Email: recipient.Email, Locale: recipient.Locale}
+			created, err := Notify(j.Repo, j.OutboxRepo, account, CategoryPracticeReminders, "practice_reminder",
+				fmt.Sprintf("practice_reminder:%d:%s", recipient.UserID, week), nil)
+			if err != nil {
+				log.Printf("Notify failed for user %d: %v", recipient.UserID, err)
+				continue
+			}
+			if created {
+				batchQueued++
+			}
+		}
+		queued += batchQueued
+
+		if len(recipients) < j.BatchSize || batchQueued == 0 {
+			return queued, nil
+		}
+	}
+}
+
+func apiURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return url
+	}
+	return os.Getenv("FRONTEND_URL")
+}
+
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
@@ -0,0 +1,300 @@
+package notification
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestUpdatePreferences(t *testing.T) {
+	tests := []struct {
+		name          string
+		changes       map[string]bool
+		failRepo      bool
+		expectError   error
+		expectEnabled map[string]bool
+	}{
+		{
+			name:    "Defaults",
+			changes: map[string]bool{},
+			expectEnabled: map[string]bool{
+				CategoryInterviewReports:  true,
+				CategoryCredits:           true,
+				CategoryBilling:           true,
+				CategoryPracticeReminders: false,
+			},
+		},
+		{
+			name:    "OptInAndOut",
+			changes: map[string]bool{CategoryPracticeReminders: true, CategoryCredits: false},
+			expectEnabled: map[string]bool{
+				CategoryInterviewReports:  true,
+				CategoryCredits:           false,
+				CategoryBilling:           true,
+				CategoryPracticeReminders: true,
+			},
+		},
+		{
+			name:        "UnknownCategory",
+			changes:     map[string]bool{"newsletter": true},
+			expectError: ErrUnknownCategory,
+		},
+		{
+			name:        "RepoFailure",
+			changes:     map[string]bool{CategoryBilling: false},
+			failRepo:    true,
+			expectError: errors.New("mocked DB failure"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.FailRepo = tc.failRepo
+
+			preferences, err := UpdatePreferences(repo, 1, tc.changes)
+			if tc.expectError != nil {
+				if err == nil || (!errors.Is(err, tc.expectError) && err.Error() != tc.expectError.Error()) {
+					t.Fatalf("expected error %v, got %v", tc.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdatePreferences failed: %v", err)
+			}
+
+			for category, enabled := range tc.expectEnabled {
+				if preferences[category] != enabled {
+					t.Fatalf("expected %s=%v, got %v", category, enabled, preferences[category])
+				}
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	repo := NewMockRepo()
+	tokenString, err := UnsubscribeToken(7, CategoryBilling)
+	if err != nil {
+		t.Fatalf("UnsubscribeToken failed: %v", err)
+	}
+
+	category, err := Unsubscribe(repo, tokenString)
+	if err != nil || category != CategoryBilling {
+		t.Fatalf("expected billing to be unsubscribed, got %q, err=%v", category, err)
+	}
+	if enabled, _ := Enabled(repo, 7, CategoryBilling); enabled {
+		t.Fatal("expected billing emails to be turned off")
+	}
+	if enabled, _ := Enabled(repo, 7, CategoryCredits); !enabled {
+		t.Fatal("expected other categories to be untouched")
+	}
+
+	for _, bad := range []string{"", "not-a-token", tokenString + "x"} {
+		if _, err := Unsubscribe(repo, bad); !errors.Is(err, ErrInvalidUnsubscribe) {
+			t.Fatalf("expected %q to be rejected, got %v", bad, err)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+	t.Setenv("FRONTEND_URL", "https://app.test/")
+	t.Setenv("API_URL", "https://api.test/")
+
+	tests := []struct {
+		name        string
+		category    string
+		preferences map[string]bool
+		expectQueue bool
+	}{
+		{
+			name:        "DefaultOn",
+			category:    CategoryInterviewReports,
+			expectQueue: true,
+		},
+		{
+			name:        "TurnedOff",
+			category:    CategoryInterviewReports,
+			preferences: map[string]bool{CategoryInterviewReports: false},
+		},
+		{
+			name:     "OptInNotChosen",
+			category: CategoryPracticeReminders,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			if tc.preferences != nil {
+				repo.Preferences[1] = tc.preferences
+			}
+			outboxRepo := outbox.NewMockRepo()
+			account := &user.User{ID: 1, Email: "a@test.com", Locale: "es"}
+
+			queued, err := Notify(repo, outboxRepo, account, tc.category, "interview_report_ready", "interview_report:9", nil)
+			if err != nil {
+				t.Fatalf("Notify failed: %v", err)
+			}
+			if queued != tc.expectQueue || len(outboxRepo.Emails) != map[bool]int{true: 1}[tc.expectQueue] {
+				t.Fatalf("expected queued=%v, got %v with %d emails", tc.expectQueue, queued, len(outboxRepo.Emails))
+			}
+			if !tc.expectQueue {
+				return
+			}
+
+			email := outboxRepo.Emails[1]
+			if email.Recipient != "a@test.com" || email.Locale != "es" || email.Key != "interview_report:9" {
+				t.Fatalf("unexpected email %+v", email)
+			}
+			if !strings.HasPrefix(email.Data["UnsubscribeURL"].(string), "https://app.test/unsubscribe?token=") ||
+				!strings.HasPrefix(email.Data["OneClickUnsubscribeURL"].(string), "https://api.test/api/notifications/unsubscribe?token=") {
+				t.Fatalf("unexpected unsubscribe links %v", email.Data)
+			}
+		})
+	}
+}
+
+func TestCreditsLow(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	repo := NewMockRepo()
+	outboxRepo := outbox.NewMockRepo()
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	plenty := &user.User{ID: 1, Email: "a@test.com", IndividualCredits: 2}
+	if err := CreditsLow(repo, outboxRepo, plenty, now); err != nil || len(outboxRepo.Emails) != 0 {
+		t.Fatalf("expected no email above the threshold, got %d, err=%v", len(outboxRepo.Emails), err)
+	}
+
+	low := &user.User{ID: 1, Email: "a@test.com", SubscriptionCredits: 1}
+	for i := 0; i < 2; i++ {
+		if err := CreditsLow(repo, outboxRepo, low, now.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("CreditsLow failed: %v", err)
+		}
+	}
+	if len(outboxRepo.Emails) != 1 {
+		t.Fatalf("expected one warning per week, got %d", len(outboxRepo.Emails))
+	}
+	if email := outboxRepo.Emails[1]; email.Key != "credits_low:1:2025-W27" || email.Data["Credits"] != 1 {
+		t.Fatalf("unexpected email %+v", email)
+	}
+}
+
+func TestHandleBillingEvent(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	renewsAt := time.Date(2025, 8, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		event          *billing.Event
+		expectTemplate string
+	}{
+		{
+			name:           "Renewal",
+			event:          &billing.Event{ID: "evt_1", Type: billing.EventPaymentSucceeded, BillingReason: "renewal", EndsAt: renewsAt},
+			expectTemplate: "subscription_renewed",
+		},
+		{
+			name:  "InitialPayment",
+			event: &billing.Event{ID: "evt_2", Type: billing.EventPaymentSucceeded, BillingReason: billing.BillingReasonInitial},
+		},
+		{
+			name:           "PaymentFailed",
+			event:          &billing.Event{ID: "evt_3", Type: billing.EventPaymentFailed},
+			expectTemplate: "payment_failed",
+		},
+		{
+			name:  "OtherEvent",
+			event: &billing.Event{ID: "evt_4", Type: billing.EventOrderCreated},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{ID: 1, Email: "a@test.com"}
+			tc.event.UserEmail = "a@test.com"
+			outboxRepo := outbox.NewMockRepo()
+
+			if err := HandleBillingEvent(NewMockRepo(), outboxRepo, userRepo, tc.event); err != nil {
+				t.Fatalf("HandleBillingEvent failed: %v", err)
+			}
+
+			if tc.expectTemplate == "" {
+				if len(outboxRepo.Emails) != 0 {
+					t.Fatalf("expected no email, got %d", len(outboxRepo.Emails))
+				}
+				return
+			}
+
+			email := outboxRepo.Emails[1]
+			if email == nil || email.Template != tc.expectTemplate || email.Key != tc.expectTemplate+":"+tc.event.ID {
+				t.Fatalf("unexpected email %+v", email)
+			}
+			if tc.expectTemplate == "subscription_renewed" && email.Data["RenewsAt"] != renewsAt {
+				t.Fatalf("expected renewal date, got %v", email.Data["RenewsAt"])
+			}
+		})
+	}
+}
+
+func TestReminderJob(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	repo := NewMockRepo()
+	repo.Preferences[1] = map[string]bool{CategoryPracticeReminders: true}
+	repo.Preferences[2] = map[string]bool{CategoryPracticeReminders: true}
+	repo.Reminders = []Recipient{
+		{UserID: 1, Email: "a@test.com", Locale: "en"},
+		{UserID: 2, Email: "b@test.com", Locale: "es"},
+	}
+	outboxRepo := outbox.NewMockRepo()
+	job := NewReminderJob(repo, outboxRepo)
+	job.BatchSize = 1
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	queued, err := job.Run(now)
+	if err != nil || queued != 1 {
+		t.Fatalf("expected 1 reminder in the first batch, got %d, err=%v", queued, err)
+	}
+	if outboxRepo.Emails[1].Key != "practice_reminder:1:2025-W27" {
+		t.Fatalf("unexpected key %s", outboxRepo.Emails[1].Key)
+	}
+
+	if queued, _ := job.Run(now); queued != 0 {
+		t.Fatalf("expected the week's reminder not to be queued twice, got %d", queued)
+	}
+
+	repo.FailRepo = true
+	if _, err := job.Run(now); err == nil {
+		t.Fatal("expected repo failure to be returned")
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	if t.Failed() {
+		t.Logf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusSent       = "sent"
+	StatusFailed     = "failed"
+	StatusDead       = "dead"
+)
+
+// MaxAttempts is how many times the dispatcher tries an email before moving
+// it to the dead-letter state. With the 30 second base delay the last retry
+// happens a little over an hour after the first attempt.
+const MaxAttempts = 8
+
+// Email is a message queued for delivery. Key is unique, so enqueueing the
+// same email twice stores it once. Data is the template data; it can carry
+// single-use links, so it is never returned by the API and is cleared once
+// the email is sent.
+type Email struct {
+	ID            int            `json:"id"`
+	Key           string         `json:"key"`
+	UserID        int            `json:"user_id,omitempty"`
+	Recipient     string         `json:"recipient"`
+	Locale        string         `json:"locale"`
+	Template      string         `json:"template"`
+	Data          map[string]any `json:"-"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	LastError     string         `json:"last_error,omitempty"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	SentAt        *time.Time     `json:"sent_at,omitempty"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so emails can be queued
+// inside the transaction that makes the change they announce.
+type Querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type OutboxRepo interface {
+	Enqueue(email *Email) (bool, error)
+	ClaimEmails(limit int) ([]Email, error)
+	CompleteEmail(id int) error
+	FailEmail(id int, status, lastError string, nextAttemptAt time.Time) error
+	GetEmail(id int) (*Email, error)
+	ListEmails(status string, limit, offset int) ([]Email, error)
+	RetryEmail(id int) error
+	PruneSent(before time.Time) (int, error)
+}
+
+var ErrEmailNotFound = errors.New("outbox email not found")
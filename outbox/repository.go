@@ -0,0 +1,245 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+// Enqueue stores the email as pending using q, which may be the caller's
+// transaction. It reports false when an email with the same key is already
+// queued or sent.
+func Enqueue(q Querier, email *Email) (bool, error) {
+	data := email.Data
+	if data == nil {
+		data = map[string]any{}
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	err = q.QueryRow(`
+		INSERT INTO email_outbox (idempotency_key, user_id, recipient, locale, template, data, status,
+		                          attempts, next_attempt_at, created_at, updated_at)
+		VALUES ($1, NULLIF($2, 0), $3, COALESCE(NULLIF($4, ''), 'en'), $5, $6, 'pending', 0, $7, $7, $7)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id
+	`,
+		email.Key,
+		email.UserID,
+		email.Recipient,
+		email.Locale,
+		email.Template,
+		dataJSON,
+		now,
+	).Scan(&email.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("Enqueue failed: %v", err)
+		return false, err
+	}
+
+	email.Status = StatusPending
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	email.UpdatedAt = now
+
+	return true, nil
+}
+
+func (r *Repository) Enqueue(email *Email) (bool, error) {
+	return Enqueue(r.DB, email)
+}
+
+// ClaimEmails moves up to limit due emails to processing and returns them
+// with their attempt count already incremented. Rows left in processing by a
+// crashed worker are reclaimed after five minutes.
+func (r *Repository) ClaimEmails(limit int) ([]Email, error) {
+	now := time.Now().UTC()
+	rows, err := r.DB.Query(`
+		UPDATE email_outbox
+		SET status = 'processing', attempts = attempts + 1, updated_at = $1
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE (status IN ('pending', 'failed') AND next_attempt_at <= $1)
+			   OR (status = 'processing' AND updated_at <= $2)
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+emailColumns,
+		now, now.Add(-5*time.Minute), limit)
+	if err != nil {
+		log.Printf("ClaimEmails failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEmails(rows)
+}
+
+// CompleteEmail marks the email sent and drops its data, which may hold
+// single-use links.
+func (r *Repository) CompleteEmail(id int) error {
+	now := time.Now().UTC()
+	_, err := r.DB.Exec(`
+		UPDATE email_outbox
+		SET status = 'sent', data = '{}', last_error = NULL, sent_at = $1, updated_at = $1
+		WHERE id = $2
+	`, now, id)
+	if err != nil {
+		log.Printf("CompleteEmail failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) FailEmail(id int, status, lastError string, nextAttemptAt time.Time) error {
+	_, err := r.DB.Exec(`
+		UPDATE email_outbox
+		SET status = $1, last_error = $2, next_attempt_at = $3, updated_at = $4
+		WHERE id = $5
+	`, status, lastError, nextAttemptAt, time.Now().UTC(), id)
+	if err != nil {
+		log.Printf("FailEmail failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetEmail(id int) (*Email, error) {
+	rows, err := r.DB.Query(`SELECT `+emailColumns+` FROM email_outbox WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("GetEmail failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails, err := scanEmails(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, ErrEmailNotFound
+	}
+
+	return &emails[0], nil
+}
+
+func (r *Repository) ListEmails(status string, limit, offset int) ([]Email, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+emailColumns+`
+		FROM email_outbox
+		WHERE $1 = '' OR status = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		log.Printf("ListEmails failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEmails(rows)
+}
+
+// RetryEmail queues a failed or dead email for an immediate attempt with a
+// fresh attempt budget. Sent emails are never resent.
+func (r *Repository) RetryEmail(id int) error {
+	now := time.Now().UTC()
+	result, err := r.DB.Exec(`
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = $1, updated_at = $1
+		WHERE id = $2 AND status IN ('failed', 'dead')
+	`, now, id)
+	if err != nil {
+		log.Printf("RetryEmail failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEmailNotFound
+	}
+
+	return nil
+}
+
+// PruneSent deletes emails sent before the cutoff and returns how many were
+// removed.
+func (r *Repository) PruneSent(before time.Time) (int, error) {
+	result, err := r.DB.Exec(`
+		DELETE FROM email_outbox
+		WHERE status = 'sent' AND sent_at < $1
+	`, before)
+	if err != nil {
+		log.Printf("PruneSent failed: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rowsAffected), nil
+}
+
+const emailColumns = `id, idempotency_key, COALESCE(user_id, 0), recipient, locale, template, data, status, attempts,
+	COALESCE(last_error, ''), next_attempt_at, created_at, sent_at, updated_at`
+
+func scanEmails(rows *sql.Rows) ([]Email, error) {
+	emails := []Email{}
+	for rows.Next() {
+		var (
+			email    Email
+			dataJSON []byte
+		)
+		err := rows.Scan(
+			&email.ID,
+			&email.Key,
+			&email.UserID,
+			&email.Recipient,
+			&email.Locale,
+			&email.Template,
+			&dataJSON,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.NextAttemptAt,
+			&email.CreatedAt,
+			&email.SentAt,
+			&email.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(dataJSON, &email.Data); err != nil {
+			log.Printf("json.Unmarshal email data failed: %v", err)
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, rows.Err()
+}
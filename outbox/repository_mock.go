@@ -0,0 +1,185 @@
+package outbox
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+type MockRepo struct {
+	Emails   map[int]*Email
+	FailRepo bool
+	nextID   int
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		Emails: map[int]*Email{},
+	}
+}
+
+func (m *MockRepo) Enqueue(email *Email) (bool, error) {
+	if m.FailRepo {
+		return false, errors.New("mocked DB failure")
+	}
+
+	for _, existing := range m.Emails {
+		if existing.Key == email.Key {
+			return false, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	m.nextID++
+	email.ID = m.nextID
+	if email.Locale == "" {
+		email.Locale = "en"
+	}
+	email.Status = StatusPending
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	email.UpdatedAt = now
+
+	stored := *email
+	m.Emails[stored.ID] = &stored
+	return true, nil
+}
+
+func (m *MockRepo) ClaimEmails(limit int) ([]Email, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	now := time.Now().UTC()
+	claimed := []Email{}
+	for _, id := range m.sortedIDs() {
+		if len(claimed) == limit {
+			break
+		}
+		email := m.Emails[id]
+		due := (email.Status == StatusPending || email.Status == StatusFailed) && !email.NextAttemptAt.After(now)
+		stale := email.Status == StatusProcessing && !email.UpdatedAt.After(now.Add(-5*time.Minute))
+		if !due && !stale {
+			continue
+		}
+		email.Status = StatusProcessing
+		email.Attempts++
+		email.UpdatedAt = now
+		claimed = append(claimed, *email)
+	}
+
+	return claimed, nil
+}
+
+func (m *MockRepo) CompleteEmail(id int) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	email, ok := m.Emails[id]
+	if !ok {
+		return ErrEmailNotFound
+	}
+	now := time.Now().UTC()
+	email.Status = StatusSent
+	email.Data = map[string]any{}
+	email.LastError = ""
+	email.SentAt = &now
+	email.UpdatedAt = now
+	return nil
+}
+
+func (m *MockRepo) FailEmail(id int, status, lastError string, nextAttemptAt time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	email, ok := m.Emails[id]
+	if !ok {
+		return ErrEmailNotFound
+	}
+	email.Status = status
+	email.LastError = lastError
+	email.NextAttemptAt = nextAttemptAt
+	email.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+func (m *MockRepo) GetEmail(id int) (*Email, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	email, ok := m.Emails[id]
+	if !ok {
+		return nil, ErrEmailNotFound
+	}
+	copied := *email
+	return &copied, nil
+}
+
+func (m *MockRepo) ListEmails(status string, limit, offset int) ([]Email, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	ids := m.sortedIDs()
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+	emails := []Email{}
+	for _, id := range ids {
+		if status != "" && m.Emails[id].Status != status {
+			continue
+		}
+		emails = append(emails, *m.Emails[id])
+	}
+	if offset >= len(emails) {
+		return []Email{}, nil
+	}
+	emails = emails[offset:]
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	return emails, nil
+}
+
+func (m *MockRepo) RetryEmail(id int) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	email, ok := m.Emails[id]
+	if !ok || (email.Status != StatusFailed && email.Status != StatusDead) {
+		return ErrEmailNotFound
+	}
+	now := time.Now().UTC()
+	email.Status = StatusPending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.UpdatedAt = now
+	return nil
+}
+
+func (m *MockRepo) PruneSent(before time.Time) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+
+	pruned := 0
+	for id, email := range m.Emails {
+		if email.Status == StatusSent && email.SentAt != nil && email.SentAt.Before(before) {
+			delete(m.Emails, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func (m *MockRepo) sortedIDs() []int {
+	ids := make([]int, 0, len(m.Emails))
+	for id := range m.Emails {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/michaelboegner/interviewer/mailer"
+)
+
+const retryBaseDelay = 30 * time.Second
+
+// Dispatcher sends queued emails in the background, retrying failures with
+// exponential backoff until MaxAttempts is reached. Sent emails older than
+// Retention are pruned once an hour.
+type Dispatcher struct {
+	Repo      OutboxRepo
+	Mailer    mailer.MailerClient
+	Logger    *slog.Logger
+	Interval  time.Duration
+	BatchSize int
+	Retention time.Duration
+
+	lastPruned time.Time
+}
+
+func NewDispatcher(repo OutboxRepo, mailer mailer.MailerClient, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Repo:      repo,
+		Mailer:    mailer,
+		Logger:    logger,
+		Interval:  5 * time.Second,
+		BatchSize: 20,
+		Retention: 30 * 24 * time.Hour,
+	}
+}
+
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.ProcessBatch(); err != nil {
+			d.Logger.Error("Dispatcher.ProcessBatch failed", "error", err)
+		}
+		d.prune(time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch claims due emails and sends them, returning how many were
+// claimed.
+func (d *Dispatcher) ProcessBatch() (int, error) {
+	emails, err := d.Repo.ClaimEmails(d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range emails {
+		d.Process(&emails[i])
+	}
+
+	return len(emails), nil
+}
+
+// Process sends a claimed email and records the outcome. Emails that can
+// never be rendered or addressed go straight to the dead-letter state.
+func (d *Dispatcher) Process(email *Email) {
+	logger := d.Logger.With("outboxEmailID", email.ID, "template", email.Template, "attempt", email.Attempts)
+
+	err := d.Mailer.SendTemplate(email.Recipient, email.Locale, email.Template, email.Data, email.Key)
+	if err == nil {
+		if err := d.Repo.CompleteEmail(email.ID); err != nil {
+			logger.Error("repo.CompleteEmail failed", "error", err)
+		}
+		return
+	}
+
+	status := StatusFailed
+	nextAttemptAt := time.Now().UTC().Add(retryDelay(email.Attempts))
+	if email.Attempts >= MaxAttempts || errors.Is(err, mailer.ErrUnknownTemplate) || errors.Is(err, mailer.ErrInvalidHeader) {
+		status = StatusDead
+		logger.Error("outbox email moved to dead-letter", "error", err)
+	} else {
+		logger.Warn("outbox email failed, will retry", "error", err, "nextAttemptAt", nextAttemptAt)
+	}
+
+	if err := d.Repo.FailEmail(email.ID, status, err.Error(), nextAttemptAt); err != nil {
+		logger.Error("repo.FailEmail failed", "error", err)
+	}
+}
+
+func (d *Dispatcher) prune(now time.Time) {
+	if now.Sub(d.lastPruned) < time.Hour {
+		return
+	}
+	d.lastPruned = now
+
+	if _, err := d.Repo.PruneSent(now.Add(-d.Retention)); err != nil {
+		d.Logger.Error("repo.PruneSent failed", "error", err)
+	}
+}
+
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return retryBaseDelay << (attempts - 1)
+}
@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/mailer"
+)
+
+type sentEmail struct {
+	Email    string
+	Locale   string
+	Template string
+	Data     map[string]any
+	Key      string
+}
+
+type fakeMailer struct {
+	Sent []sentEmail
+	Err  error
+}
+
+func (f *fakeMailer) SendTemplate(email, locale, template string, data map[string]any, key string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Sent = append(f.Sent, sentEmail{email, locale, template, data, key})
+	return nil
+}
+
+func newTestDispatcher(repo OutboxRepo, m mailer.MailerClient) *Dispatcher {
+	return NewDispatcher(repo, m, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestDispatcher(t *testing.T) {
+	tests := []struct {
+		name           string
+		template       string
+		attempts       int
+		sendErr        error
+		expectStatus   string
+		expectAttempts int
+		expectSent     int
+	}{
+		{
+			name:           "Process_Success",
+			template:       "welcome",
+			expectStatus:   StatusSent,
+			expectAttempts: 1,
+			expectSent:     1,
+		},
+		{
+			name:           "Process_FailureRetries",
+			template:       "welcome",
+			sendErr:        errors.New("mocked transport failure"),
+			expectStatus:   StatusFailed,
+			expectAttempts: 1,
+		},
+		{
+			name:           "Process_FinalAttemptDeadLetters",
+			template:       "welcome",
+			attempts:       MaxAttempts - 1,
+			sendErr:        errors.New("mocked transport failure"),
+			expectStatus:   StatusDead,
+			expectAttempts: MaxAttempts,
+		},
+		{
+			name:           "Process_UnknownTemplateDeadLetters",
+			template:       "mystery",
+			sendErr:        fmt.Errorf("%w: mystery", mailer.ErrUnknownTemplate),
+			expectStatus:   StatusDead,
+			expectAttempts: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			email := &Email{
+				Key:       "welcome:1",
+				UserID:    1,
+				Recipient: "a@test.com",
+				Template:  tc.template,
+				Data:      map[string]any{"URL": "https://app.test/secret"},
+			}
+			if _, err := repo.Enqueue(email); err != nil {
+				t.Fatalf("Enqueue failed: %v", err)
+			}
+			repo.Emails[email.ID].Attempts = tc.attempts
+
+			m := &fakeMailer{Err: tc.sendErr}
+			claimed, err := newTestDispatcher(repo, m).ProcessBatch()
+			if err != nil || claimed != 1 {
+				t.Fatalf("expected 1 claimed email, got %d, err=%v", claimed, err)
+			}
+
+			stored := repo.Emails[email.ID]
+			if stored.Status != tc.expectStatus {
+				t.Fatalf("expected status %s, got %s", tc.expectStatus, stored.Status)
+			}
+			if stored.Attempts != tc.expectAttempts {
+				t.Fatalf("expected %d attempts, got %d", tc.expectAttempts, stored.Attempts)
+			}
+			if len(m.Sent) != tc.expectSent {
+				t.Fatalf("expected %d sent, got %d", tc.expectSent, len(m.Sent))
+			}
+			if tc.expectSent > 0 && (m.Sent[0].Key != "welcome:1" || m.Sent[0].Locale != "en") {
+				t.Fatalf("unexpected send %+v", m.Sent[0])
+			}
+			if tc.expectStatus == StatusSent && len(stored.Data) != 0 {
+				t.Fatalf("expected data to be cleared after sending, got %v", stored.Data)
+			}
+			if tc.expectStatus == StatusFailed && !stored.NextAttemptAt.After(time.Now()) {
+				t.Fatal("expected retry to be scheduled in the future")
+			}
+			if tc.expectStatus != StatusSent && stored.LastError == "" {
+				t.Fatal("expected last error to be recorded")
+			}
+		})
+	}
+}
+
+func TestEnqueueIsIdempotent(t *testing.T) {
+	repo := NewMockRepo()
+	m := &fakeMailer{}
+	dispatcher := newTestDispatcher(repo, m)
+
+	for i := 0; i < 2; i++ {
+		created, err := repo.Enqueue(&Email{Key: "welcome:1", Recipient: "a@test.com", Template: "welcome"})
+		if err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		if created != (i == 0) {
+			t.Fatalf("enqueue %d: expected created=%v, got %v", i, i == 0, created)
+		}
+		if _, err := dispatcher.ProcessBatch(); err != nil {
+			t.Fatalf("ProcessBatch failed: %v", err)
+		}
+	}
+
+	if len(m.Sent) != 1 {
+		t.Fatalf("expected the email to be sent once, got %d", len(m.Sent))
+	}
+}
+
+func TestRetryEmail(t *testing.T) {
+	repo := NewMockRepo()
+	email := &Email{Key: "welcome:1", Recipient: "a@test.com", Template: "welcome"}
+	if _, err := repo.Enqueue(email); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	if err := repo.RetryEmail(email.ID); !errors.Is(err, ErrEmailNotFound) {
+		t.Fatalf("expected pending email to be refused, got %v", err)
+	}
+
+	repo.Emails[email.ID].Status = StatusDead
+	repo.Emails[email.ID].Attempts = MaxAttempts
+	if err := repo.RetryEmail(email.ID); err != nil {
+		t.Fatalf("RetryEmail failed: %v", err)
+	}
+
+	m := &fakeMailer{}
+	if _, err := newTestDispatcher(repo, m).ProcessBatch(); err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+	if repo.Emails[email.ID].Status != StatusSent || repo.Emails[email.ID].Attempts != 1 {
+		t.Fatalf("expected retried email to be sent on a fresh attempt, got %+v", repo.Emails[email.ID])
+	}
+
+	if err := repo.RetryEmail(email.ID); !errors.Is(err, ErrEmailNotFound) {
+		t.Fatalf("expected sent email to be refused, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if retryDelay(1) != retryBaseDelay || retryDelay(3) != 4*retryBaseDelay {
+		t.Fatalf("unexpected backoff %s, %s", retryDelay(1), retryDelay(3))
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	if t.Failed() {
+		t.Logf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
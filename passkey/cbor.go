@@ -0,0 +1,167 @@
+package passkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// This is the subset of CBOR (RFC 8949) that authenticators use for
+// attestation objects and COSE keys: definite-length integers, byte and text
+// strings, arrays, maps and simple values. Integers decode to int64, maps to
+// map[any]any keyed by int64 or string.
+
+var errMalformedCBOR = errors.New("malformed CBOR")
+
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first item in data and returns it along with the
+// number of bytes it used, so callers can find where it ends.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := &cborDecoder{data: data}
+	value, err := d.item(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, errMalformedCBOR
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errMalformedCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errMalformedCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		raw, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(raw), nil
+		}
+		return append([]byte(nil), raw...), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errMalformedCBOR
+		}
+		items := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			value, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errMalformedCBOR
+		}
+		items := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errMalformedCBOR
+			}
+			if _, ok := items[key]; ok {
+				return nil, errMalformedCBOR
+			}
+			value, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items[key] = value
+		}
+		return items, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn; return the tagged item.
+		return d.item(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, errMalformedCBOR
+	}
+}
+
+// head reads an item's initial byte and argument. Indefinite lengths are
+// rejected, as CTAP2 requires canonical encoding.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errMalformedCBOR
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, errMalformedCBOR
+	}
+
+	raw, err := d.bytes(uint64(size))
+	if err != nil {
+		return 0, 0, err
+	}
+	buf := make([]byte, 8)
+	copy(buf[8-size:], raw)
+	arg := binary.BigEndian.Uint64(buf)
+
+	// Floats share major type 7 with simple values and never appear in
+	// WebAuthn structures.
+	if major == 7 && size > 1 {
+		return 0, 0, errMalformedCBOR
+	}
+	return major, arg, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errMalformedCBOR
+	}
+	raw := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return raw, nil
+}
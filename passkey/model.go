@@ -0,0 +1,168 @@
+package passkey
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Credential is a passkey registered to a user. PublicKey is the COSE_Key
+// the authenticator returned at registration.
+type Credential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"-"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"`
+	Algorithm    int64      `json:"-"`
+	SignCount    uint32     `json:"-"`
+	Transports   []string   `json:"transports"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// Challenge is the server side of one ceremony. It can be consumed once.
+// UserID is the user registering a passkey, or 0 for a login.
+type Challenge struct {
+	Challenge string
+	UserID    int
+	Ceremony  string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+const (
+	CeremonyRegistration   = "registration"
+	CeremonyAuthentication = "authentication"
+
+	ChallengeLifetime = 5 * time.Minute
+)
+
+// URLEncoded is binary data carried as unpadded base64url in JSON, the
+// encoding WebAuthn's JSON serialization uses.
+type URLEncoded []byte
+
+func (u URLEncoded) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(u))
+}
+
+func (u *URLEncoded) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return err
+	}
+	*u = decoded
+	return nil
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          URLEncoded `json:"id"`
+	Name        string     `json:"name"`
+	DisplayName string     `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string     `json:"type"`
+	ID         URLEncoded `json:"id"`
+	Transports []string   `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is PublicKeyCredentialCreationOptions for
+// navigator.credentials.create().
+type CreationOptions struct {
+	Challenge              URLEncoded             `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is PublicKeyCredentialRequestOptions for
+// navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        URLEncoded             `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned
+// by navigator.credentials.create().
+type RegistrationResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AttestationObject URLEncoded `json:"attestationObject"`
+		Transports        []string   `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AuthenticatorData URLEncoded `json:"authenticatorData"`
+		Signature         URLEncoded `json:"signature"`
+		UserHandle        URLEncoded `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Assertion is the outcome of a successful passkey login.
+type Assertion struct {
+	UserID       int
+	CredentialID int
+	// UserVerified is set when the authenticator checked a PIN or biometric,
+	// which makes the passkey a second factor on its own.
+	UserVerified bool
+}
+
+type PasskeyRepo interface {
+	CreateChallenge(challenge *Challenge) error
+	ConsumeChallenge(challenge string) (*Challenge, error)
+	CreateCredential(credential *Credential) error
+	GetCredential(credentialID []byte) (*Credential, error)
+	ListCredentials(userID int) ([]Credential, error)
+	UpdateSignCount(id int, signCount uint32, at time.Time) error
+	DeleteCredential(userID, id int) error
+}
+
+var (
+	ErrNotConfigured        = errors.New("WEBAUTHN_RP_ID and WEBAUTHN_ORIGINS or FRONTEND_URL must be set")
+	ErrChallengeInvalid     = errors.New("invalid or expired challenge")
+	ErrCredentialNotFound   = errors.New("passkey not found")
+	ErrDuplicateCredential  = errors.New("passkey is already registered")
+	ErrVerificationFailed   = errors.New("passkey verification failed")
+	ErrUnsupportedAlgorithm = errors.New("unsupported passkey algorithm")
+	ErrCloneDetected        = errors.New("passkey signature counter went backwards")
+)
@@ -0,0 +1,199 @@
+package passkey
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) CreateChallenge(challenge *Challenge) error {
+	// Abandoned ceremonies are cleared as new ones start.
+	_, err := r.DB.Exec(`DELETE FROM passkey_challenges WHERE expires_at < $1`, challenge.CreatedAt)
+	if err != nil {
+		log.Printf("delete expired passkey challenges failed: %v", err)
+		return err
+	}
+
+	_, err = r.DB.Exec(`
+		INSERT INTO passkey_challenges (challenge, user_id, ceremony, expires_at, created_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+	`, challenge.Challenge, challenge.UserID, challenge.Ceremony, challenge.ExpiresAt, challenge.CreatedAt)
+	if err != nil {
+		log.Printf("CreateChallenge failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeChallenge deletes and returns the challenge, so each one can
+// complete at most one ceremony.
+func (r *Repository) ConsumeChallenge(challenge string) (*Challenge, error) {
+	var (
+		stored Challenge
+		userID sql.NullInt64
+	)
+	err := r.DB.QueryRow(`
+		DELETE FROM passkey_challenges
+		WHERE challenge = $1
+		RETURNING challenge, user_id, ceremony, expires_at, created_at
+	`, challenge).Scan(
+		&stored.Challenge,
+		&userID,
+		&stored.Ceremony,
+		&stored.ExpiresAt,
+		&stored.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrChallengeInvalid
+	} else if err != nil {
+		log.Printf("ConsumeChallenge failed: %v", err)
+		return nil, err
+	}
+
+	stored.UserID = int(userID.Int64)
+	return &stored, nil
+}
+
+func (r *Repository) CreateCredential(credential *Credential) error {
+	err := r.DB.QueryRow(`
+		INSERT INTO passkey_credentials (user_id, credential_id, public_key, algorithm, sign_count, transports, name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`,
+		credential.UserID,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.Algorithm,
+		int64(credential.SignCount),
+		pq.Array(credential.Transports),
+		credential.Name,
+		credential.CreatedAt,
+	).Scan(&credential.ID)
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return ErrDuplicateCredential
+		}
+		log.Printf("CreateCredential failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetCredential(credentialID []byte) (*Credential, error) {
+	credential, err := scanCredential(r.DB.QueryRow(`
+		SELECT id, user_id, credential_id, public_key, algorithm, sign_count, transports, name, created_at, last_used_at
+		FROM passkey_credentials
+		WHERE credential_id = $1
+	`, credentialID))
+	if err == sql.ErrNoRows {
+		return nil, ErrCredentialNotFound
+	} else if err != nil {
+		log.Printf("GetCredential failed: %v", err)
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+func (r *Repository) ListCredentials(userID int) ([]Credential, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, user_id, credential_id, public_key, algorithm, sign_count, transports, name, created_at, last_used_at
+		FROM passkey_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		log.Printf("ListCredentials failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []Credential{}
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (r *Repository) UpdateSignCount(id int, signCount uint32, at time.Time) error {
+	_, err := r.DB.Exec(`
+		UPDATE passkey_credentials
+		SET sign_count = $1, last_used_at = $2
+		WHERE id = $3
+	`, int64(signCount), at, id)
+	if err != nil {
+		log.Printf("UpdateSignCount failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteCredential(userID, id int) error {
+	result, err := r.DB.Exec(`DELETE FROM passkey_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		log.Printf("DeleteCredential failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCredential(row rowScanner) (*Credential, error) {
+	var (
+		credential Credential
+		signCount  int64
+		transports pq.StringArray
+	)
+	err := row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.Algorithm,
+		&signCount,
+		&transports,
+		&credential.Name,
+		&credential.CreatedAt,
+		&credential.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.SignCount = uint32(signCount)
+	credential.Transports = transports
+	return &credential, nil
+}
@@ -0,0 +1,116 @@
+package passkey
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Challenges  map[string]Challenge
+	Credentials []Credential
+	FailRepo    bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		Challenges: map[string]Challenge{},
+	}
+}
+
+func (m *MockRepo) CreateChallenge(challenge *Challenge) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	m.Challenges[challenge.Challenge] = *challenge
+	return nil
+}
+
+func (m *MockRepo) ConsumeChallenge(challenge string) (*Challenge, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	stored, ok := m.Challenges[challenge]
+	if !ok {
+		return nil, ErrChallengeInvalid
+	}
+	delete(m.Challenges, challenge)
+	return &stored, nil
+}
+
+func (m *MockRepo) CreateCredential(credential *Credential) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for _, existing := range m.Credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return ErrDuplicateCredential
+		}
+	}
+	credential.ID = 1
+	for _, existing := range m.Credentials {
+		if existing.ID >= credential.ID {
+			credential.ID = existing.ID + 1
+		}
+	}
+	m.Credentials = append(m.Credentials, *credential)
+	return nil
+}
+
+func (m *MockRepo) GetCredential(credentialID []byte) (*Credential, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	for _, credential := range m.Credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return &credential, nil
+		}
+	}
+	return nil, ErrCredentialNotFound
+}
+
+func (m *MockRepo) ListCredentials(userID int) ([]Credential, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	credentials := []Credential{}
+	for _, credential := range m.Credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (m *MockRepo) UpdateSignCount(id int, signCount uint32, at time.Time) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i := range m.Credentials {
+		if m.Credentials[i].ID == id {
+			m.Credentials[i].SignCount = signCount
+			m.Credentials[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *MockRepo) DeleteCredential(userID, id int) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i, credential := range m.Credentials {
+		if credential.ID == id && credential.UserID == userID {
+			m.Credentials = append(m.Credentials[:i], m.Credentials[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCredentialNotFound
+}
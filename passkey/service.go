@@ -0,0 +1,250 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+const maxNameLength = 64
+
+// BeginRegistration starts adding a passkey to the signed-in user and
+// returns the options for navigator.credentials.create().
+func BeginRegistration(repo PasskeyRepo, rp *RelyingParty, account *user.User) (*CreationOptions, error) {
+	existing, err := repo.ListCredentials(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := newChallenge(repo, CeremonyRegistration, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User: UserEntity{
+			ID:          userHandle(account.ID),
+			Name:        account.Email,
+			DisplayName: account.Username,
+		},
+		Timeout:            ChallengeLifetime.Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+	for _, alg := range supportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return options, nil
+}
+
+// FinishRegistration verifies the authenticator's response to
+// BeginRegistration and stores the new passkey.
+func FinishRegistration(repo PasskeyRepo, rp *RelyingParty, userID int, name string, response *RegistrationResponse) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, ErrVerificationFailed
+	}
+
+	clientData, err := rp.parseClientData(response.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if err := consumeChallenge(repo, clientData.Challenge, CeremonyRegistration, userID); err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := parseAttestationObject(response.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if !rp.checkRPID(authData) || !authData.has(flagUserPresent) || !authData.has(flagAttested) {
+		return nil, ErrVerificationFailed
+	}
+	if !bytes.Equal(authData.CredentialID, response.RawID) {
+		return nil, ErrVerificationFailed
+	}
+
+	_, alg, err := parsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len([]rune(name)) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+
+	credential := &Credential{
+		UserID:       userID,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    alg,
+		SignCount:    authData.SignCount,
+		Transports:   response.Response.Transports,
+		Name:         name,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := repo.CreateCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// BeginLogin starts a passkey login and returns the options for
+// navigator.credentials.get(). No credentials are listed, so the browser
+// offers any discoverable passkey for the site.
+func BeginLogin(repo PasskeyRepo, rp *RelyingParty) (*RequestOptions, error) {
+	challenge, err := newChallenge(repo, CeremonyAuthentication, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          ChallengeLifetime.Milliseconds(),
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "preferred",
+	}, nil
+}
+
+// FinishLogin verifies an assertion against the stored passkey and returns
+// the user it belongs to.
+func FinishLogin(repo PasskeyRepo, rp *RelyingParty, response *AssertionResponse) (*Assertion, error) {
+	if response.Type != "public-key" {
+		return nil, ErrVerificationFailed
+	}
+
+	clientData, err := rp.parseClientData(response.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	if err := consumeChallenge(repo, clientData.Challenge, CeremonyAuthentication, 0); err != nil {
+		return nil, err
+	}
+
+	credential, err := repo.GetCredential(response.RawID)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Response.UserHandle) > 0 && !bytes.Equal(response.Response.UserHandle, userHandle(credential.UserID)) {
+		return nil, ErrVerificationFailed
+	}
+
+	authData, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if !rp.checkRPID(authData) || !authData.has(flagUserPresent) {
+		return nil, ErrVerificationFailed
+	}
+
+	err = verifySignature(credential.PublicKey, response.Response.AuthenticatorData, response.Response.ClientDataJSON, response.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticators that keep a counter must increase it on every use. A
+	// counter that does not move forward suggests the key was copied.
+	if (authData.SignCount != 0 || credential.SignCount != 0) && authData.SignCount <= credential.SignCount {
+		log.Printf("passkey %d for user %d presented sign count %d, stored %d", credential.ID, credential.UserID, authData.SignCount, credential.SignCount)
+		return nil, ErrCloneDetected
+	}
+
+	if err := repo.UpdateSignCount(credential.ID, authData.SignCount, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		UserID:       credential.UserID,
+		CredentialID: credential.ID,
+		UserVerified: authData.has(flagUserVerified),
+	}, nil
+}
+
+func ListCredentials(repo PasskeyRepo, userID int) ([]Credential, error) {
+	credentials, err := repo.ListCredentials(userID)
+	if err != nil {
+		log.Printf("repo.ListCredentials failed: %v", err)
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func DeleteCredential(repo PasskeyRepo, userID, id int) error {
+	return repo.DeleteCredential(userID, id)
+}
+
+func newChallenge(repo PasskeyRepo, ceremony string, userID int) (URLEncoded, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	err := repo.CreateChallenge(&Challenge{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		ExpiresAt: now.Add(ChallengeLifetime),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Printf("repo.CreateChallenge failed: %v", err)
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge uses up the challenge the client signed and checks that
+// it was issued for this ceremony and user.
+func consumeChallenge(repo PasskeyRepo, challenge, ceremony string, userID int) error {
+	stored, err := repo.ConsumeChallenge(challenge)
+	if err != nil {
+		return err
+	}
+	if stored.Ceremony != ceremony || stored.UserID != userID || time.Now().UTC().After(stored.ExpiresAt) {
+		return ErrChallengeInvalid
+	}
+
+	return nil
+}
+
+// userHandle is the WebAuthn user ID stored with a discoverable passkey.
+func userHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func descriptors(credentials []Credential) []CredentialDescriptor {
+	list := []CredentialDescriptor{}
+	for _, credential := range credentials {
+		list = append(list, CredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: credential.Transports,
+		})
+	}
+	return list
+}
@@ -0,0 +1,378 @@
+package passkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+var testRP = &RelyingParty{
+	ID:      "interviewer.dev",
+	Name:    "Interviewer",
+	Origins: []string{"https://interviewer.dev"},
+}
+
+// softAuthenticator is a software passkey that produces the same
+// attestation objects and assertions a platform authenticator would.
+type softAuthenticator struct {
+	alg          int64
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	userHandle   []byte
+	rpID         string
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	a := &softAuthenticator{alg: alg, rpID: testRP.ID, origin: testRP.Origins[0], credentialID: make([]byte, 16)}
+	rand.Read(a.credentialID)
+
+	var err error
+	switch alg {
+	case algES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	if a.alg == algEdDSA {
+		return cborEncode(map[any]any{
+			int64(1): int64(1), int64(3): algEdDSA, int64(-1): int64(6),
+			int64(-2): []byte(a.edKey.Public().(ed25519.PublicKey)),
+		})
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return cborEncode(map[any]any{
+		int64(1): int64(2), int64(3): algES256, int64(-1): int64(1),
+		int64(-2): x, int64(-3): y,
+	})
+}
+
+func (a *softAuthenticator) clientData(ceremonyType string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremonyType,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) register(options *CreationOptions) *RegistrationResponse {
+	a.userHandle = options.User.ID
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+
+	response := &RegistrationResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), RawID: a.credentialID, Type: "public-key"}
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge)
+	response.Response.AttestationObject = cborEncode(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	response.Response.Transports = []string{"internal"}
+	return response
+}
+
+func (a *softAuthenticator) login(options *RequestOptions) *AssertionResponse {
+	a.signCount++
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData("webauthn.get", options.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	var signature []byte
+	if a.alg == algEdDSA {
+		signature = ed25519.Sign(a.edKey, signed)
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, _ = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	}
+
+	response := &AssertionResponse{ID: base64.RawURLEncoding.EncodeToString(a.credentialID), RawID: a.credentialID, Type: "public-key"}
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = a.userHandle
+	return response
+}
+
+// cborEncode writes the values the software authenticator needs.
+func cborEncode(value any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+	}
+
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		out := head(5, uint64(len(v)))
+		for key, item := range v {
+			out = append(out, cborEncode(key)...)
+			out = append(out, cborEncode(item)...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("cborEncode: unsupported %T", value))
+}
+
+func TestFinishRegistration(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(a *softAuthenticator, repo *MockRepo)
+		userID      int
+		expectedErr error
+	}{
+		{
+			name:   "FinishRegistration_Success",
+			userID: 1,
+		},
+		{
+			name:        "FinishRegistration_WrongOrigin",
+			modify:      func(a *softAuthenticator, _ *MockRepo) { a.origin = "https://evil.example.com" },
+			userID:      1,
+			expectedErr: ErrVerificationFailed,
+		},
+		{
+			name:        "FinishRegistration_WrongRPID",
+			modify:      func(a *softAuthenticator, _ *MockRepo) { a.rpID = "evil.example.com" },
+			userID:      1,
+			expectedErr: ErrVerificationFailed,
+		},
+		{
+			name:        "FinishRegistration_OtherUsersChallenge",
+			userID:      2,
+			expectedErr: ErrChallengeInvalid,
+		},
+		{
+			name: "FinishRegistration_ChallengeExpired",
+			modify: func(_ *softAuthenticator, repo *MockRepo) {
+				for key, challenge := range repo.Challenges {
+					challenge.ExpiresAt = time.Now().Add(-time.Minute)
+					repo.Challenges[key] = challenge
+				}
+			},
+			userID:      1,
+			expectedErr: ErrChallengeInvalid,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			authenticator := newSoftAuthenticator(t, algES256)
+
+			options, err := BeginRegistration(repo, testRP, &user.User{ID: 1, Email: "test@test.com", Username: "test"})
+			if err != nil {
+				t.Fatalf("BeginRegistration failed: %v", err)
+			}
+			if tc.modify != nil {
+				tc.modify(authenticator, repo)
+			}
+
+			credential, err := FinishRegistration(repo, testRP, tc.userID, " Laptop ", authenticator.register(options))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil {
+				if credential.Name != "Laptop" || credential.Algorithm != algES256 || credential.UserID != 1 {
+					t.Errorf("unexpected credential %+v", credential)
+				}
+				if _, err := FinishRegistration(repo, testRP, 1, "", authenticator.register(options)); err != ErrChallengeInvalid {
+					t.Errorf("expected a used challenge to be rejected, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFinishLogin(t *testing.T) {
+	tests := []struct {
+		name        string
+		alg         int64
+		modify      func(response *AssertionResponse, repo *MockRepo)
+		expectedErr error
+	}{
+		{
+			name: "FinishLogin_ES256",
+			alg:  algES256,
+		},
+		{
+			name: "FinishLogin_EdDSA",
+			alg:  algEdDSA,
+		},
+		{
+			name: "FinishLogin_TamperedSignature",
+			alg:  algES256,
+			modify: func(response *AssertionResponse, _ *MockRepo) {
+				response.Response.AuthenticatorData[len(response.Response.AuthenticatorData)-1]++
+			},
+			expectedErr: ErrVerificationFailed,
+		},
+		{
+			name: "FinishLogin_CounterWentBackwards",
+			alg:  algES256,
+			modify: func(_ *AssertionResponse, repo *MockRepo) {
+				repo.Credentials[0].SignCount = 50
+			},
+			expectedErr: ErrCloneDetected,
+		},
+		{
+			name: "FinishLogin_WrongUserHandle",
+			alg:  algES256,
+			modify: func(response *AssertionResponse, _ *MockRepo) {
+				response.Response.UserHandle = userHandle(2)
+			},
+			expectedErr: ErrVerificationFailed,
+		},
+		{
+			name: "FinishLogin_UnknownCredential",
+			alg:  algES256,
+			modify: func(_ *AssertionResponse, repo *MockRepo) {
+				repo.Credentials = nil
+			},
+			expectedErr: ErrCredentialNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			authenticator := newSoftAuthenticator(t, tc.alg)
+			creation, err := BeginRegistration(repo, testRP, &user.User{ID: 1, Email: "test@test.com", Username: "test"})
+			if err != nil {
+				t.Fatalf("BeginRegistration failed: %v", err)
+			}
+			if _, err := FinishRegistration(repo, testRP, 1, "", authenticator.register(creation)); err != nil {
+				t.Fatalf("FinishRegistration failed: %v", err)
+			}
+
+			options, err := BeginLogin(repo, testRP)
+			if err != nil {
+				t.Fatalf("BeginLogin failed: %v", err)
+			}
+			response := authenticator.login(options)
+			if tc.modify != nil {
+				tc.modify(response, repo)
+			}
+
+			assertion, err := FinishLogin(repo, testRP, response)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+
+			if tc.expectedErr == nil {
+				if assertion.UserID != 1 || !assertion.UserVerified {
+					t.Errorf("unexpected assertion %+v", assertion)
+				}
+				if repo.Credentials[0].SignCount != 1 || repo.Credentials[0].LastUsedAt == nil {
+					t.Errorf("expected sign count and last use to be recorded, got %+v", repo.Credentials[0])
+				}
+				if _, err := FinishLogin(repo, testRP, response); err != ErrChallengeInvalid {
+					t.Errorf("expected a replayed assertion to be rejected, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	inputs := map[string][]byte{
+		"truncated":         {0x42, 0x01},
+		"indefinite length": {0x5f, 0x41, 0x01, 0xff},
+		"float":             {0xfa, 0x00, 0x00, 0x00, 0x00},
+		"duplicate key":     {0xa2, 0x01, 0x01, 0x01, 0x02},
+		"huge array":        {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	for name, input := range inputs {
+		if _, _, err := decodeCBOR(input); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadRelyingParty(t *testing.T) {
+	t.Setenv("WEBAUTHN_RP_ID", "")
+	t.Setenv("WEBAUTHN_ORIGINS", "")
+	t.Setenv("FRONTEND_URL", "https://app.interviewer.dev/")
+
+	rp, err := LoadRelyingParty()
+	if err != nil {
+		t.Fatalf("LoadRelyingParty failed: %v", err)
+	}
+	if rp.ID != "app.interviewer.dev" || len(rp.Origins) != 1 || rp.Origins[0] != "https://app.interviewer.dev" {
+		t.Fatalf("unexpected relying party %+v", rp)
+	}
+
+	t.Setenv("FRONTEND_URL", "")
+	if _, err := LoadRelyingParty(); err != ErrNotConfigured {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
@@ -0,0 +1,266 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RelyingParty is the site passkeys are bound to. ID is the registrable
+// domain (no scheme or port) and Origins are the exact origins the browser
+// may report.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// LoadRelyingParty reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and
+// WEBAUTHN_ORIGINS (comma-separated), defaulting the ID and origin to
+// FRONTEND_URL.
+func LoadRelyingParty() (*RelyingParty, error) {
+	rp := &RelyingParty{
+		ID:   os.Getenv("WEBAUTHN_RP_ID"),
+		Name: os.Getenv("WEBAUTHN_RP_NAME"),
+	}
+	if rp.Name == "" {
+		rp.Name = "Interviewer"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+
+	if frontend, err := url.Parse(os.Getenv("FRONTEND_URL")); err == nil && frontend.Host != "" {
+		if rp.ID == "" {
+			rp.ID = frontend.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{frontend.Scheme + "://" + frontend.Host}
+		}
+	}
+
+	if rp.ID == "" || len(rp.Origins) == 0 {
+		return nil, ErrNotConfigured
+	}
+	return rp, nil
+}
+
+// COSE algorithm identifiers we accept, in order of preference.
+const (
+	algES256 int64 = -7
+	algEdDSA int64 = -8
+	algRS256 int64 = -257
+)
+
+var supportedAlgorithms = []int64{algES256, algEdDSA, algRS256}
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// parseClientData decodes clientDataJSON and checks everything except the
+// challenge, which the caller looks up.
+func (rp *RelyingParty) parseClientData(raw []byte, ceremonyType string) (*clientData, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrVerificationFailed
+	}
+	if data.Type != ceremonyType || data.CrossOrigin || !slices.Contains(rp.Origins, data.Origin) {
+		return nil, ErrVerificationFailed
+	}
+	if data.Challenge == "" {
+		return nil, ErrChallengeInvalid
+	}
+	return &data, nil
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (a *authenticatorData) has(flag byte) bool {
+	return a.Flags&flag != 0
+}
+
+// parseAuthenticatorData decodes the binary authenticator data, including
+// the attested credential when the AT flag is set.
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrVerificationFailed
+	}
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+
+	if data.has(flagAttested) {
+		// aaguid(16) || credentialIdLength(2) || credentialId || COSE key
+		if len(rest) < 18 {
+			return nil, ErrVerificationFailed
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, ErrVerificationFailed
+		}
+		data.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrVerificationFailed
+		}
+		data.PublicKey = rest[:used]
+		rest = rest[used:]
+	}
+
+	if data.has(flagExtensions) {
+		_, used, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrVerificationFailed
+		}
+		rest = rest[used:]
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrVerificationFailed
+	}
+	return data, nil
+}
+
+// checkRPID compares the authenticator's RP ID hash with ours.
+func (rp *RelyingParty) checkRPID(data *authenticatorData) bool {
+	sum := sha256.Sum256([]byte(rp.ID))
+	return subtle.ConstantTimeCompare(sum[:], data.RPIDHash) == 1
+}
+
+// parseAttestationObject returns the authenticator data from an attestation
+// object. We request "none" attestation and do not evaluate attestation
+// statements, so the format is not checked.
+func parseAttestationObject(raw []byte) ([]byte, error) {
+	decoded, used, err := decodeCBOR(raw)
+	if err != nil || used != len(raw) {
+		return nil, ErrVerificationFailed
+	}
+	object, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, ErrVerificationFailed
+	}
+	if _, ok := object["fmt"].(string); !ok {
+		return nil, ErrVerificationFailed
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, ErrVerificationFailed
+	}
+	return authData, nil
+}
+
+// parsePublicKey decodes a COSE_Key into a Go public key and its algorithm.
+func parsePublicKey(raw []byte) (crypto.PublicKey, int64, error) {
+	decoded, used, err := decodeCBOR(raw)
+	if err != nil || used != len(raw) {
+		return nil, 0, ErrVerificationFailed
+	}
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, 0, ErrVerificationFailed
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == algES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrVerificationFailed
+		}
+		point := append(append([]byte{0x04}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, ErrVerificationFailed
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, alg, nil
+	case kty == 1 && alg == algEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrVerificationFailed
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == algRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrVerificationFailed
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, alg, nil
+	}
+
+	return nil, 0, ErrUnsupportedAlgorithm
+}
+
+// verifySignature checks an assertion signature, which covers the
+// authenticator data followed by the SHA-256 of clientDataJSON.
+func verifySignature(publicKey []byte, authData, clientDataJSON, signature []byte) error {
+	key, _, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := bytes.Join([][]byte{authData, clientDataHash[:]}, nil)
+	digest := sha256.Sum256(signed)
+
+	valid := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrVerificationFailed
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package privacy
+
+import (
+	"errors"
+	"time"
+
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/conversation"
+	"github.com/michaelboegner/interviewer/interview"
+)
+
+// Export is everything a user can download about themselves. Each field
+// becomes one JSON file in the archive.
+type Export struct {
+	GeneratedAt   time.Time
+	Profile       Profile
+	Interviews    []interview.Interview
+	Conversations []Conversation
+	Reports       []Report
+	CreditHistory []billing.HistoryItem
+}
+
+type Profile struct {
+	ID                    int        `json:"id"`
+	Username              string     `json:"username"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	AccountStatus         string     `json:"account_status"`
+	SubscriptionTier      string     `json:"subscription_tier"`
+	SubscriptionStatus    string     `json:"subscription_status"`
+	SubscriptionStartDate *time.Time `json:"subscription_start_date"`
+	SubscriptionEndDate   *time.Time `json:"subscription_end_date"`
+	IndividualCredits     int        `json:"individual_credits"`
+	SubscriptionCredits   int        `json:"subscription_credits"`
+	CreatedAt             time.Time  `json:"created_at"`
+}
+
+// Conversation is the full transcript of one interview: every question
+// asked, with the user's answers and the interviewer's feedback.
+type Conversation struct {
+	ID          int                      `json:"id"`
+	InterviewID int                      `json:"interview_id"`
+	CreatedAt   time.Time                `json:"created_at"`
+	Questions   []*conversation.Question `json:"questions"`
+}
+
+// Report is the result of one finished interview.
+type Report struct {
+	InterviewID       int       `json:"interview_id"`
+	Difficulty        string    `json:"difficulty"`
+	Language          string    `json:"language"`
+	Score             int       `json:"score"`
+	QuestionsAnswered int       `json:"questions_answered"`
+	NumberQuestions   int       `json:"number_questions"`
+	StartedAt         time.Time `json:"started_at"`
+	FinishedAt        time.Time `json:"finished_at"`
+}
+
+// PrivacyRepo reads a user's data for export and purges deleted accounts.
+type PrivacyRepo interface {
+	ListInterviews(userID int) ([]interview.Interview, error)
+	ListConversations(userID int) ([]Conversation, error)
+	// ListPurgeable returns accounts deleted before the cutoff whose data
+	// has not been purged yet, oldest first.
+	ListPurgeable(deletedBefore time.Time, limit int) ([]int, error)
+	// PurgeUser removes the account's interviews, transcripts and sign-in
+	// methods and anonymizes the user row, in one transaction. It returns
+	// ErrNotPurgeable if the account is no longer deleted before the cutoff.
+	PurgeUser(userID int, deletedBefore, purgedAt time.Time) error
+}
+
+var ErrNotPurgeable = errors.New("account is not due for purging")
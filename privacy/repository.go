@@ -0,0 +1,293 @@
+package privacy
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/conversation"
+	"github.com/michaelboegner/interviewer/interview"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) ListInterviews(userID int) ([]interview.Interview, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, COALESCE(conversation_id, 0), user_id, length, number_questions, number_questions_answered,
+		       score_numerator, COALESCE(score, 0), difficulty, status, language, COALESCE(prompt, ''),
+		       COALESCE(jd_summary, ''), COALESCE(first_question, ''), COALESCE(subtopic, ''), created_at, updated_at
+		FROM interviews
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		log.Printf("ListInterviews failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	interviews := []interview.Interview{}
+	for rows.Next() {
+		var i interview.Interview
+		err := rows.Scan(
+			&i.Id,
+			&i.ConversationID,
+			&i.UserId,
+			&i.Length,
+			&i.NumberQuestions,
+			&i.NumberQuestionsAnswered,
+			&i.ScoreNumerator,
+			&i.Score,
+			&i.Difficulty,
+			&i.Status,
+			&i.Language,
+			&i.Prompt,
+			&i.JDSummary,
+			&i.FirstQuestion,
+			&i.Subtopic,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		interviews = append(interviews, i)
+	}
+
+	return interviews, rows.Err()
+}
+
+// ListConversations loads every transcript for the user's interviews with
+// three queries, rather than one per question.
+func (r *Repository) ListConversations(userID int) ([]Conversation, error) {
+	rows, err := r.DB.Query(`
+		SELECT c.id, c.interview_id, c.created_at
+		FROM conversations c
+		JOIN interviews i ON i.id = c.interview_id
+		WHERE i.user_id = $1
+		ORDER BY c.created_at, c.id
+	`, userID)
+	if err != nil {
+		log.Printf("ListConversations failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	index := map[int]int{}
+	for rows.Next() {
+		c := Conversation{Questions: []*conversation.Question{}}
+		if err := rows.Scan(&c.ID, &c.InterviewID, &c.CreatedAt); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		index[c.ID] = len(conversations)
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	questionRows, err := r.DB.Query(`
+		SELECT q.conversation_id, q.topic_id, q.question_number, q.prompt, q.created_at
+		FROM questions q
+		JOIN conversations c ON c.id = q.conversation_id
+		JOIN interviews i ON i.id = c.interview_id
+		WHERE i.user_id = $1
+		ORDER BY q.conversation_id, q.topic_id, q.question_number
+	`, userID)
+	if err != nil {
+		log.Printf("ListConversations questions failed: %v", err)
+		return nil, err
+	}
+	defer questionRows.Close()
+
+	questions := map[string]*conversation.Question{}
+	for questionRows.Next() {
+		q := &conversation.Question{Messages: []conversation.Message{}}
+		if err := questionRows.Scan(&q.ConversationID, &q.TopicID, &q.QuestionNumber, &q.Prompt, &q.CreatedAt); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		i, ok := index[q.ConversationID]
+		if !ok {
+			continue
+		}
+		conversations[i].Questions = append(conversations[i].Questions, q)
+		questions[questionKey(q.ConversationID, q.TopicID, q.QuestionNumber)] = q
+	}
+	if err := questionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	messageRows, err := r.DB.Query(`
+		SELECT m.conversation_id, m.topic_id, m.question_number, m.author, m.content, m.created_at
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN interviews i ON i.id = c.interview_id
+		WHERE i.user_id = $1
+		ORDER BY m.created_at, m.id
+	`, userID)
+	if err != nil {
+		log.Printf("ListConversations messages failed: %v", err)
+		return nil, err
+	}
+	defer messageRows.Close()
+
+	for messageRows.Next() {
+		var m conversation.Message
+		if err := messageRows.Scan(&m.ConversationID, &m.TopicID, &m.QuestionNumber, &m.Author, &m.Content, &m.CreatedAt); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		if q, ok := questions[questionKey(m.ConversationID, m.TopicID, m.QuestionNumber)]; ok {
+			q.Messages = append(q.Messages, m)
+		}
+	}
+
+	return conversations, messageRows.Err()
+}
+
+func (r *Repository) ListPurgeable(deletedBefore time.Time, limit int) ([]int, error) {
+	rows, err := r.DB.Query(`
+		SELECT id
+		FROM users
+		WHERE account_status = 'deleted' AND purged_at IS NULL AND deleted_at <= $1
+		ORDER BY deleted_at, id
+		LIMIT $2
+	`, deletedBefore, limit)
+	if err != nil {
+		log.Printf("ListPurgeable failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// purgeStatements remove the user's content and sign-in methods, children
+// before parents. Payments, credit transactions, the credit ledger and the
+// audit log are kept as financial and security records; they refer to the
+// user only by ID. Audit events keep their IP address and user agent, which
+// security reviews of the account's history depend on.
+var purgeStatements = []string{
+	`DELETE FROM messages WHERE conversation_id IN (
+		SELECT c.id FROM conversations c JOIN interviews i ON i.id = c.interview_id WHERE i.user_id = $1)`,
+	`DELETE FROM questions WHERE conversation_id IN (
+		SELECT c.id FROM conversations c JOIN interviews i ON i.id = c.interview_id WHERE i.user_id = $1)`,
+	`DELETE FROM conversations WHERE interview_id IN (SELECT id FROM interviews WHERE user_id = $1)`,
+	`UPDATE credit_reservations SET interview_id = NULL WHERE user_id = $1`,
+	`DELETE FROM interviews WHERE user_id = $1`,
+	`DELETE FROM refresh_tokens WHERE user_id = $1`,
+	`DELETE FROM oauth_states WHERE user_id = $1`,
+	`DELETE FROM identities WHERE user_id = $1`,
+	`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+	`DELETE FROM mfa_totp WHERE user_id = $1`,
+	`DELETE FROM passkey_challenges WHERE user_id = $1`,
+	`DELETE FROM passkey_credentials WHERE user_id = $1`,
+	`DELETE FROM api_keys WHERE user_id = $1`,
+	`DELETE FROM email_change_requests WHERE user_id = $1`,
+	`DELETE FROM email_outbox WHERE user_id = $1`,
+	`DELETE FROM notification_preferences WHERE user_id = $1`,
+}
+
+func (r *Repository) PurgeUser(userID int, deletedBefore, purgedAt time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the row first means a reactivation racing the job either
+	// lands before the purge and is seen here, or waits for it to finish.
+	var email, subscriptionID string
+	err = tx.QueryRow(`
+		SELECT email, pre_deletion_subscription_id
+		FROM users
+		WHERE id = $1 AND account_status = 'deleted' AND purged_at IS NULL AND deleted_at <= $2
+		FOR UPDATE
+	`, userID, deletedBefore).Scan(&email, &subscriptionID)
+	if err == sql.ErrNoRows {
+		return ErrNotPurgeable
+	} else if err != nil {
+		log.Printf("PurgeUser lookup failed: %v", err)
+		return err
+	}
+
+	for _, statement := range purgeStatements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			log.Printf("PurgeUser failed: %v", err)
+			return err
+		}
+	}
+
+	// Stored webhooks carry the customer's email, name and address and have
+	// no user ID, so they are matched on the address the account was deleted
+	// with and its subscription. Only the envelope needed for deduplication
+	// stays.
+	email = strings.TrimPrefix(email, fmt.Sprintf("deleted_%d_", userID))
+	_, err = tx.Exec(`
+		UPDATE webhook_events
+		SET payload = '', event = '{}', updated_at = $3
+		WHERE payload <> ''
+			AND (
+				LOWER(event->>'user_email') = LOWER($1)
+				OR STRPOS(LOWER(payload), LOWER($1)) > 0
+				OR ($2 NOT IN ('', '0') AND event->>'subscription_id' = $2)
+			)
+	`, email, subscriptionID, purgedAt)
+	if err != nil {
+		log.Printf("PurgeUser redact webhooks failed: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET
+			email = CONCAT('purged_', id),
+			username = CONCAT('deleted_user_', id),
+			password = '',
+			subscription_id = '',
+			pre_deletion_subscription_id = '',
+			purged_at = $1,
+			updated_at = $1
+		WHERE id = $2
+	`, purgedAt, userID)
+	if err != nil {
+		log.Printf("PurgeUser anonymize failed: %v", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func questionKey(conversationID, topicID, questionNumber int) string {
+	return fmt.Sprintf("%d:%d:%d", conversationID, topicID, questionNumber)
+}
@@ -0,0 +1,92 @@
+package privacy
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/michaelboegner/interviewer/interview"
+)
+
+type MockRepo struct {
+	Interviews    []interview.Interview
+	Conversations map[int][]Conversation
+	// DeletedAt holds when each deleted account was deleted, and Purged
+	// when it was purged.
+	DeletedAt map[int]time.Time
+	Purged    map[int]time.Time
+	FailRepo  bool
+	FailPurge bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		Conversations: map[int][]Conversation{},
+		DeletedAt:     map[int]time.Time{},
+		Purged:        map[int]time.Time{},
+	}
+}
+
+func (m *MockRepo) ListInterviews(userID int) ([]interview.Interview, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	interviews := []interview.Interview{}
+	for _, i := range m.Interviews {
+		if i.UserId == userID {
+			interviews = append(interviews, i)
+		}
+	}
+	return interviews, nil
+}
+
+func (m *MockRepo) ListConversations(userID int) ([]Conversation, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	conversations := []Conversation{}
+	conversations = append(conversations, m.Conversations[userID]...)
+	return conversations, nil
+}
+
+func (m *MockRepo) ListPurgeable(deletedBefore time.Time, limit int) ([]int, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	userIDs := []int{}
+	for userID, deletedAt := range m.DeletedAt {
+		if _, purged := m.Purged[userID]; !purged && !deletedAt.After(deletedBefore) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Ints(userIDs)
+	if len(userIDs) > limit {
+		userIDs = userIDs[:limit]
+	}
+	return userIDs, nil
+}
+
+func (m *MockRepo) PurgeUser(userID int, deletedBefore, purgedAt time.Time) error {
+	if m.FailPurge {
+		return errors.New("mocked DB failure")
+	}
+
+	deletedAt, ok := m.DeletedAt[userID]
+	if _, purged := m.Purged[userID]; !ok || purged || deletedAt.After(deletedBefore) {
+		return ErrNotPurgeable
+	}
+
+	remaining := []interview.Interview{}
+	for _, i := range m.Interviews {
+		if i.UserId != userID {
+			remaining = append(remaining, i)
+		}
+	}
+	m.Interviews = remaining
+	delete(m.Conversations, userID)
+	m.Purged[userID] = purgedAt
+	return nil
+}
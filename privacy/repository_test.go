@@ -0,0 +1,74 @@
+package privacy_test
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/internal/testutil"
+	"github.com/michaelboegner/interviewer/privacy"
+)
+
+func TestRepositoryPurgeUserRedactsWebhooks(t *testing.T) {
+	db := testutil.OpenTestDB(t)
+	userID := testutil.InsertTestUser(t, db)
+
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer func() {
+		if t.Failed() {
+			t.Logf("---- logs ----\n%s\n", buf.String())
+		}
+	}()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	email := fmt.Sprintf("purge-%d@test.com", now.UnixNano())
+	_, err := db.Exec(`
+		UPDATE users
+		SET account_status = 'deleted', email = CONCAT('deleted_', id, '_', $1::text),
+			pre_deletion_subscription_id = $2, deleted_at = $3
+		WHERE id = $4
+	`, email, "sub_"+email, now.Add(-time.Hour), userID)
+	if err != nil {
+		t.Fatalf("mark user deleted failed: %v", err)
+	}
+
+	events := map[string]string{
+		"by_email":        fmt.Sprintf(`{"user_email":%q}`, email),
+		"by_subscription": fmt.Sprintf(`{"subscription_id":%q}`, "sub_"+email),
+		"other":           `{"user_email":"someone-else@test.com"}`,
+	}
+	for name, event := range events {
+		eventID := name + "_" + email
+		_, err := db.Exec(`
+			INSERT INTO webhook_events (provider, event_id, event_type, payload, event, next_attempt_at, received_at, updated_at)
+			VALUES ('lemonsqueezy', $1, 'order_created', $2, $2, $3, $3, $3)
+		`, eventID, event, now)
+		if err != nil {
+			t.Fatalf("insert webhook event failed: %v", err)
+		}
+		t.Cleanup(func() { db.Exec("DELETE FROM webhook_events WHERE event_id = $1", eventID) })
+	}
+
+	repo := privacy.NewRepository(db)
+	if err := repo.PurgeUser(userID, now, now); err != nil {
+		t.Fatalf("PurgeUser failed: %v", err)
+	}
+	if err := repo.PurgeUser(userID, now, now); !errors.Is(err, privacy.ErrNotPurgeable) {
+		t.Fatalf("expected a purged user not to be purgeable again, got %v", err)
+	}
+
+	for name := range events {
+		var payload string
+		err := db.QueryRow(`SELECT payload FROM webhook_events WHERE event_id = $1`, name+"_"+email).Scan(&payload)
+		if err != nil {
+			t.Fatalf("load webhook event failed: %v", err)
+		}
+		if redacted := payload == ""; redacted != (name != "other") {
+			t.Errorf("event %s: expected redacted=%v, got payload %q", name, name != "other", payload)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+const historyPageSize = 100
+
+// BuildExport gathers everything stored about the user for download.
+func BuildExport(repo PrivacyRepo, userRepo user.UserRepo, billingRepo billing.BillingRepo, userID int) (*Export, error) {
+	account, err := userRepo.GetUser(userID)
+	if err != nil {
+		log.Printf("repo.GetUser failed: %v", err)
+		return nil, err
+	}
+
+	interviews, err := repo.ListInterviews(userID)
+	if err != nil {
+		log.Printf("repo.ListInterviews failed: %v", err)
+		return nil, err
+	}
+
+	conversations, err := repo.ListConversations(userID)
+	if err != nil {
+		log.Printf("repo.ListConversations failed: %v", err)
+		return nil, err
+	}
+
+	history := []billing.HistoryItem{}
+	for offset := 0; ; offset += historyPageSize {
+		page, err := billingRepo.ListBillingHistory(userID, historyPageSize, offset)
+		if err != nil {
+			log.Printf("billingRepo.ListBillingHistory failed: %v", err)
+			return nil, err
+		}
+		history = append(history, page...)
+		if len(page) < historyPageSize {
+			break
+		}
+	}
+
+	return &Export{
+		GeneratedAt: time.Now().UTC(),
+		Profile: Profile{
+			ID:                    account.ID,
+			Username:              account.Username,
+			Email:                 account.Email,
+			Role:                  account.Role,
+			AccountStatus:         account.AccountStatus,
+			SubscriptionTier:      account.SubscriptionTier,
+			SubscriptionStatus:    account.SubscriptionStatus,
+			SubscriptionStartDate: account.SubscriptionStartDate,
+			SubscriptionEndDate:   account.SubscriptionEndDate,
+			IndividualCredits:     account.IndividualCredits,
+			SubscriptionCredits:   account.SubscriptionCredits,
+			CreatedAt:             account.CreatedAt,
+		},
+		Interviews:    interviews,
+		Conversations: conversations,
+		Reports:       buildReports(interviews),
+		CreditHistory: history,
+	}, nil
+}
+
+func buildReports(interviews []interview.Interview) []Report {
+	reports := []Report{}
+	for _, i := range interviews {
+		if i.Status != "finished" {
+			continue
+		}
+		reports = append(reports, Report{
+			InterviewID:       i.Id,
+			Difficulty:        i.Difficulty,
+			Language:          i.Language,
+			Score:             i.Score,
+			QuestionsAnswered: i.NumberQuestionsAnswered,
+			NumberQuestions:   i.NumberQuestions,
+			StartedAt:         i.CreatedAt,
+			FinishedAt:        i.UpdatedAt,
+		})
+	}
+	return reports
+}
+
+// WriteArchive writes the export as a zip with one JSON file per section.
+func WriteArchive(w io.Writer, export *Export) error {
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"interviews.json", export.Interviews},
+		{"conversations.json", export.Conversations},
+		{"reports.json", export.Reports},
+		{"credit_history.json", export.CreditHistory},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// RetentionJob purges accounts once they have been deleted for longer than
+// GracePeriod. Until then the account can still be restored.
+type RetentionJob struct {
+	Repo        PrivacyRepo
+	AuditRepo   audit.AuditRepo
+	Interval    time.Duration
+	GracePeriod time.Duration
+	BatchSize   int
+}
+
+func NewRetentionJob(repo PrivacyRepo, auditRepo audit.AuditRepo) *RetentionJob {
+	return &RetentionJob{
+		Repo:        repo,
+		AuditRepo:   auditRepo,
+		Interval:    time.Hour,
+		GracePeriod: user.DeletionGracePeriod(),
+		BatchSize:   100,
+	}
+}
+
+func (j *RetentionJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Run(time.Now().UTC()); err != nil {
+			log.Printf("RetentionJob.Run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run purges every account deleted more than GracePeriod before now and
+// returns how many were purged.
+func (j *RetentionJob) Run(now time.Time) (int, error) {
+	cutoff := now.Add(-j.GracePeriod)
+	purged := 0
+	for {
+		userIDs, err := j.Repo.ListPurgeable(cutoff, j.BatchSize)
+		if err != nil {
+			log.Printf("repo.ListPurgeable failed: %v", err)
+			return purged, err
+		}
+
+		for _, userID := range userIDs {
+			err := j.Repo.PurgeUser(userID, cutoff, now)
+			if errors.Is(err, ErrNotPurgeable) {
+				continue
+			} else if err != nil {
+				log.Printf("repo.PurgeUser failed for user %d: %v", userID, err)
+				return purged, err
+			}
+
+			_ = audit.Record(j.AuditRepo, audit.Event{
+				Action:       audit.ActionAccountPurged,
+				TargetUserID: userID,
+				Metadata:     map[string]any{"grace_period": j.GracePeriod.String()},
+			})
+			purged++
+		}
+
+		if len(userIDs) < j.BatchSize {
+			break
+		}
+	}
+
+	return purged, nil
+}
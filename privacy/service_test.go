@@ -0,0 +1,255 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/conversation"
+	"github.com/michaelboegner/interviewer/interview"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestBuildExport(t *testing.T) {
+	created := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                  string
+		interviews            []interview.Interview
+		conversations         []Conversation
+		transactions          int
+		failRepo              bool
+		failBilling           bool
+		expectedError         bool
+		expectedReports       []int
+		expectedCreditHistory int
+	}{
+		{
+			name: "BuildExport_Success",
+			interviews: []interview.Interview{
+				{Id: 1, UserId: 1, Status: "finished", Score: 80, NumberQuestions: 2, NumberQuestionsAnswered: 2, CreatedAt: created},
+				{Id: 2, UserId: 1, Status: "active", CreatedAt: created},
+				{Id: 3, UserId: 2, Status: "finished", Score: 50, CreatedAt: created},
+			},
+			conversations: []Conversation{
+				{ID: 1, InterviewID: 1, Questions: []*conversation.Question{
+					{ConversationID: 1, TopicID: 1, QuestionNumber: 1, Prompt: "Tell me about yourself", Messages: []conversation.Message{
+						{ConversationID: 1, TopicID: 1, QuestionNumber: 1, Author: conversation.User, Content: "I build APIs"},
+					}},
+				}},
+			},
+			transactions:          3,
+			expectedReports:       []int{1},
+			expectedCreditHistory: 3,
+		},
+		{
+			name:                  "BuildExport_PagesCreditHistory",
+			transactions:          historyPageSize + 5,
+			expectedReports:       []int{},
+			expectedCreditHistory: historyPageSize + 5,
+		},
+		{
+			name:          "BuildExport_RepoFailure",
+			failRepo:      true,
+			expectedError: true,
+		},
+		{
+			name:          "BuildExport_BillingFailure",
+			failBilling:   true,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Interviews = tc.interviews
+			repo.Conversations[1] = tc.conversations
+			repo.FailRepo = tc.failRepo
+
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{ID: 1, Username: "alice", Email: "alice@example.com", AccountStatus: user.AccountActive}
+
+			billingRepo := billing.NewMockRepo()
+			billingRepo.FailInvoices = tc.failBilling
+			for i := 0; i < tc.transactions; i++ {
+				billingRepo.Transactions = append(billingRepo.Transactions, billing.CreditTransaction{UserID: 1, Amount: 1, CreditType: "individual", Reason: "Purchase"})
+			}
+			billingRepo.Transactions = append(billingRepo.Transactions, billing.CreditTransaction{UserID: 2, Amount: 1, CreditType: "individual", Reason: "Purchase"})
+
+			export, err := BuildExport(repo, userRepo, billingRepo, 1)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedError, err)
+			}
+			if tc.expectedError {
+				return
+			}
+
+			if export.Profile.Email != "alice@example.com" {
+				t.Errorf("expected profile for alice@example.com, got %q", export.Profile.Email)
+			}
+			for _, i := range export.Interviews {
+				if i.UserId != 1 {
+					t.Errorf("export includes interview %d of user %d", i.Id, i.UserId)
+				}
+			}
+
+			reports := []int{}
+			for _, report := range export.Reports {
+				reports = append(reports, report.InterviewID)
+			}
+			if diff := cmp.Diff(tc.expectedReports, reports); diff != "" {
+				t.Errorf("reports mismatch (-want +got):\n%s", diff)
+			}
+			if len(export.CreditHistory) != tc.expectedCreditHistory {
+				t.Errorf("expected %d credit history items, got %d", tc.expectedCreditHistory, len(export.CreditHistory))
+			}
+
+			var archive bytes.Buffer
+			if err := WriteArchive(&archive, export); err != nil {
+				t.Fatalf("WriteArchive failed: %v", err)
+			}
+			reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+			if err != nil {
+				t.Fatalf("zip.NewReader failed: %v", err)
+			}
+
+			names := []string{}
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			expectedNames := []string{"profile.json", "interviews.json", "conversations.json", "reports.json", "credit_history.json"}
+			if diff := cmp.Diff(expectedNames, names); diff != "" {
+				t.Errorf("archive files mismatch (-want +got):\n%s", diff)
+			}
+
+			var conversations []Conversation
+			readArchiveFile(t, reader, "conversations.json", &conversations)
+			if len(conversations) != len(tc.conversations) {
+				t.Errorf("expected %d conversations in archive, got %d", len(tc.conversations), len(conversations))
+			}
+		})
+	}
+}
+
+func TestRetentionJob(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		deletedAt      map[int]time.Duration
+		failPurge      bool
+		expectedError  bool
+		expectedPurged []int
+	}{
+		{
+			name: "RetentionJob_PurgesAfterGracePeriod",
+			deletedAt: map[int]time.Duration{
+				1: -31 * 24 * time.Hour,
+				2: -29 * 24 * time.Hour,
+				3: -30 * 24 * time.Hour,
+			},
+			expectedPurged: []int{1, 3},
+		},
+		{
+			name: "RetentionJob_SpansBatches",
+			deletedAt: map[int]time.Duration{
+				1: -40 * 24 * time.Hour,
+				2: -40 * 24 * time.Hour,
+				3: -40 * 24 * time.Hour,
+			},
+			expectedPurged: []int{1, 2, 3},
+		},
+		{
+			name: "RetentionJob_PurgeFailure",
+			deletedAt: map[int]time.Duration{
+				1: -40 * 24 * time.Hour,
+			},
+			failPurge:      true,
+			expectedError:  true,
+			expectedPurged: []int{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.FailPurge = tc.failPurge
+			for userID, age := range tc.deletedAt {
+				repo.DeletedAt[userID] = now.Add(age)
+				repo.Interviews = append(repo.Interviews, interview.Interview{Id: userID, UserId: userID})
+			}
+			auditRepo := audit.NewMockRepo()
+
+			job := NewRetentionJob(repo, auditRepo)
+			job.GracePeriod = 30 * 24 * time.Hour
+			job.BatchSize = 2
+
+			purged, err := job.Run(now)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error: %v, got: %v", tc.expectedError, err)
+			}
+			if purged != len(tc.expectedPurged) {
+				t.Errorf("expected %d accounts purged, got %d", len(tc.expectedPurged), purged)
+			}
+
+			audited := []int{}
+			for _, event := range auditRepo.Events {
+				if event.Action == audit.ActionAccountPurged {
+					audited = append(audited, event.TargetUserID)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedPurged, audited); diff != "" {
+				t.Errorf("purged accounts mismatch (-want +got):\n%s", diff)
+			}
+
+			for _, i := range repo.Interviews {
+				if _, purged := repo.Purged[i.UserId]; purged {
+					t.Errorf("interview %d of purged user %d was kept", i.Id, i.UserId)
+				}
+			}
+		})
+	}
+}
+
+func readArchiveFile(t *testing.T, reader *zip.Reader, name string, v any) {
+	t.Helper()
+	file, err := reader.Open(name)
+	if err != nil {
+		t.Fatalf("open %s failed: %v", name, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("read %s failed: %v", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s failed: %v", name, err)
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
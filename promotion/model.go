@@ -0,0 +1,58 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+)
+
+// Promotion is a redeemable code that grants credits. A MaxRedemptions of 0
+// means unlimited; an empty AllowedPlans lets users on any tier redeem it.
+type Promotion struct {
+	ID             int        `json:"id"`
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	Credits        int        `json:"credits"`
+	CreditType     string     `json:"credit_type"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	PerUserLimit   int        `json:"per_user_limit"`
+	AllowedPlans   []string   `json:"allowed_plans"`
+	Active         bool       `json:"active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type Redemption struct {
+	ID          int       `json:"id"`
+	PromotionID int       `json:"promotion_id"`
+	UserID      int       `json:"user_id"`
+	Credits     int       `json:"credits"`
+	CreditType  string    `json:"credit_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Stats struct {
+	Promotion
+	Redemptions    int `json:"redemptions"`
+	UniqueUsers    int `json:"unique_users"`
+	CreditsGranted int `json:"credits_granted"`
+}
+
+type PromotionRepo interface {
+	CreatePromotion(promotion *Promotion) (int, error)
+	GetPromotion(id int) (*Promotion, error)
+	GetPromotionByCode(code string) (*Promotion, error)
+	UpdatePromotion(promotion *Promotion) error
+	ListPromotionStats() ([]Stats, error)
+	Redeem(promotion *Promotion, userID int) (*Redemption, error)
+}
+
+var (
+	ErrPromotionNotFound     = errors.New("promotion not found")
+	ErrPromotionExpired      = errors.New("promotion expired")
+	ErrPlanNotEligible       = errors.New("promotion not available on this plan")
+	ErrMaxRedemptionsReached = errors.New("promotion fully redeemed")
+	ErrUserLimitReached      = errors.New("promotion already redeemed")
+	ErrInvalidPromotion      = errors.New("invalid promotion")
+	ErrDuplicateCode         = errors.New("duplicate promotion code")
+)
@@ -0,0 +1,241 @@
+package promotion
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/michaelboegner/interviewer/ledger"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) CreatePromotion(promotion *Promotion) (int, error) {
+	now := time.Now().UTC()
+	var id int
+	err := r.DB.QueryRow(`
+		INSERT INTO promotions (code, description, credits, credit_type, expires_at, max_redemptions,
+		                        per_user_limit, allowed_plans, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		RETURNING id
+	`,
+		promotion.Code,
+		promotion.Description,
+		promotion.Credits,
+		promotion.CreditType,
+		promotion.ExpiresAt,
+		promotion.MaxRedemptions,
+		promotion.PerUserLimit,
+		pq.Array(promotion.AllowedPlans),
+		promotion.Active,
+		now,
+	).Scan(&id)
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return 0, fmt.Errorf("%w: %s", ErrDuplicateCode, promotion.Code)
+		}
+		log.Printf("CreatePromotion failed: %v", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetPromotion(id int) (*Promotion, error) {
+	return r.getPromotion("id = $1", id)
+}
+
+func (r *Repository) GetPromotionByCode(code string) (*Promotion, error) {
+	return r.getPromotion("code = $1", code)
+}
+
+func (r *Repository) getPromotion(where string, arg any) (*Promotion, error) {
+	var promotion Promotion
+	err := r.DB.QueryRow(`
+		SELECT id, code, description, credits, credit_type, expires_at, max_redemptions,
+		       per_user_limit, allowed_plans, active, created_at, updated_at
+		FROM promotions
+		WHERE `+where, arg).Scan(
+		&promotion.ID,
+		&promotion.Code,
+		&promotion.Description,
+		&promotion.Credits,
+		&promotion.CreditType,
+		&promotion.ExpiresAt,
+		&promotion.MaxRedemptions,
+		&promotion.PerUserLimit,
+		pq.Array(&promotion.AllowedPlans),
+		&promotion.Active,
+		&promotion.CreatedAt,
+		&promotion.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrPromotionNotFound
+	} else if err != nil {
+		log.Printf("getPromotion failed: %v", err)
+		return nil, err
+	}
+
+	return &promotion, nil
+}
+
+func (r *Repository) UpdatePromotion(promotion *Promotion) error {
+	now := time.Now().UTC()
+	result, err := r.DB.Exec(`
+		UPDATE promotions
+		SET description = $1, credits = $2, credit_type = $3, expires_at = $4, max_redemptions = $5,
+		    per_user_limit = $6, allowed_plans = $7, active = $8, updated_at = $9
+		WHERE id = $10
+	`,
+		promotion.Description,
+		promotion.Credits,
+		promotion.CreditType,
+		promotion.ExpiresAt,
+		promotion.MaxRedemptions,
+		promotion.PerUserLimit,
+		pq.Array(promotion.AllowedPlans),
+		promotion.Active,
+		now,
+		promotion.ID,
+	)
+	if err != nil {
+		log.Printf("UpdatePromotion failed: %v", err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("RowsAffected failed: %v", err)
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrPromotionNotFound
+	}
+	promotion.UpdatedAt = now
+
+	return nil
+}
+
+func (r *Repository) ListPromotionStats() ([]Stats, error) {
+	rows, err := r.DB.Query(`
+		SELECT p.id, p.code, p.description, p.credits, p.credit_type, p.expires_at, p.max_redemptions,
+		       p.per_user_limit, p.allowed_plans, p.active, p.created_at, p.updated_at,
+		       COUNT(pr.id), COUNT(DISTINCT pr.user_id), COALESCE(SUM(pr.credits), 0)
+		FROM promotions p
+		LEFT JOIN promotion_redemptions pr ON pr.promotion_id = p.id
+		GROUP BY p.id
+		ORDER BY p.created_at DESC
+	`)
+	if err != nil {
+		log.Printf("ListPromotionStats failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []Stats{}
+	for rows.Next() {
+		var s Stats
+		err := rows.Scan(
+			&s.ID,
+			&s.Code,
+			&s.Description,
+			&s.Credits,
+			&s.CreditType,
+			&s.ExpiresAt,
+			&s.MaxRedemptions,
+			&s.PerUserLimit,
+			pq.Array(&s.AllowedPlans),
+			&s.Active,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Redemptions,
+			&s.UniqueUsers,
+			&s.CreditsGranted,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
+// Redeem checks redemption limits under a row lock on the promotion and
+// grants the credits in the same transaction as the redemption record.
+func (r *Repository) Redeem(promotion *Promotion, userID int) (*Redemption, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var total, perUser int
+	err = tx.QueryRow(`SELECT id FROM promotions WHERE id = $1 FOR UPDATE`, promotion.ID).Scan(new(int))
+	if err != nil {
+		log.Printf("lock promotion failed: %v", err)
+		return nil, err
+	}
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2)
+		FROM promotion_redemptions
+		WHERE promotion_id = $1
+	`, promotion.ID, userID).Scan(&total, &perUser)
+	if err != nil {
+		log.Printf("count promotion redemptions failed: %v", err)
+		return nil, err
+	}
+	if promotion.MaxRedemptions > 0 && total >= promotion.MaxRedemptions {
+		return nil, ErrMaxRedemptionsReached
+	}
+	if perUser >= promotion.PerUserLimit {
+		return nil, ErrUserLimitReached
+	}
+
+	_, err = ledger.Post(tx, ledger.Posting{
+		UserID:       userID,
+		Amount:       promotion.Credits,
+		CreditType:   promotion.CreditType,
+		Reason:       fmt.Sprintf("Promotion %s redeemed", promotion.Code),
+		Counterparty: ledger.AccountPromotions,
+	})
+	if err != nil {
+		log.Printf("ledger.Post failed: %v", err)
+		return nil, err
+	}
+
+	redemption := &Redemption{
+		PromotionID: promotion.ID,
+		UserID:      userID,
+		Credits:     promotion.Credits,
+		CreditType:  promotion.CreditType,
+		CreatedAt:   time.Now().UTC(),
+	}
+	err = tx.QueryRow(`
+		INSERT INTO promotion_redemptions (promotion_id, user_id, credits, credit_type, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, redemption.PromotionID, userID, redemption.Credits, redemption.CreditType, redemption.CreatedAt).Scan(&redemption.ID)
+	if err != nil {
+		log.Printf("insert promotion redemption failed: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return nil, err
+	}
+
+	return redemption, nil
+}
@@ -0,0 +1,123 @@
+package promotion
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Promotions  []Promotion
+	Redemptions []Redemption
+	FailRepo    bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{}
+}
+
+func (m *MockRepo) CreatePromotion(promotion *Promotion) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+	for _, existing := range m.Promotions {
+		if existing.Code == promotion.Code {
+			return 0, ErrDuplicateCode
+		}
+	}
+
+	promotion.ID = len(m.Promotions) + 1
+	m.Promotions = append(m.Promotions, *promotion)
+	return promotion.ID, nil
+}
+
+func (m *MockRepo) GetPromotion(id int) (*Promotion, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+	for _, promotion := range m.Promotions {
+		if promotion.ID == id {
+			return &promotion, nil
+		}
+	}
+	return nil, ErrPromotionNotFound
+}
+
+func (m *MockRepo) UpdatePromotion(promotion *Promotion) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+	for i, existing := range m.Promotions {
+		if existing.ID == promotion.ID {
+			m.Promotions[i] = *promotion
+			return nil
+		}
+	}
+	return ErrPromotionNotFound
+}
+
+func (m *MockRepo) GetPromotionByCode(code string) (*Promotion, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+	for _, promotion := range m.Promotions {
+		if promotion.Code == code {
+			return &promotion, nil
+		}
+	}
+	return nil, ErrPromotionNotFound
+}
+
+func (m *MockRepo) ListPromotionStats() ([]Stats, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	stats := []Stats{}
+	for _, promotion := range m.Promotions {
+		s := Stats{Promotion: promotion}
+		users := map[int]bool{}
+		for _, redemption := range m.Redemptions {
+			if redemption.PromotionID == promotion.ID {
+				s.Redemptions++
+				s.CreditsGranted += redemption.Credits
+				users[redemption.UserID] = true
+			}
+		}
+		s.UniqueUsers = len(users)
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+func (m *MockRepo) Redeem(promotion *Promotion, userID int) (*Redemption, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	var total, perUser int
+	for _, redemption := range m.Redemptions {
+		if redemption.PromotionID == promotion.ID {
+			total++
+			if redemption.UserID == userID {
+				perUser++
+			}
+		}
+	}
+	if promotion.MaxRedemptions > 0 && total >= promotion.MaxRedemptions {
+		return nil, ErrMaxRedemptionsReached
+	}
+	if perUser >= promotion.PerUserLimit {
+		return nil, ErrUserLimitReached
+	}
+
+	redemption := Redemption{
+		ID:          len(m.Redemptions) + 1,
+		PromotionID: promotion.ID,
+		UserID:      userID,
+		Credits:     promotion.Credits,
+		CreditType:  promotion.CreditType,
+		CreatedAt:   time.Now().UTC(),
+	}
+	m.Redemptions = append(m.Redemptions, redemption)
+	return &redemption, nil
+}
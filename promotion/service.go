@@ -0,0 +1,136 @@
+package promotion
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func CreatePromotion(repo PromotionRepo, promotion *Promotion) (*Promotion, error) {
+	promotion.Code = NormalizeCode(promotion.Code)
+	if promotion.PerUserLimit == 0 {
+		promotion.PerUserLimit = 1
+	}
+	if err := validatePromotion(promotion); err != nil {
+		return nil, err
+	}
+	if promotion.ExpiresAt != nil && promotion.ExpiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at is in the past", ErrInvalidPromotion)
+	}
+
+	id, err := repo.CreatePromotion(promotion)
+	if err != nil {
+		log.Printf("repo.CreatePromotion failed: %v", err)
+		return nil, err
+	}
+	promotion.ID = id
+
+	return promotion, nil
+}
+
+// UpdatePromotion applies an admin edit. The code is immutable because it
+// has already been handed out; deactivating a promotion stops further
+// redemptions without touching those already made.
+func UpdatePromotion(repo PromotionRepo, id int, update *Promotion) (*Promotion, error) {
+	promotion, err := repo.GetPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+
+	promotion.Description = update.Description
+	promotion.Credits = update.Credits
+	promotion.CreditType = update.CreditType
+	promotion.ExpiresAt = update.ExpiresAt
+	promotion.MaxRedemptions = update.MaxRedemptions
+	promotion.PerUserLimit = update.PerUserLimit
+	promotion.AllowedPlans = update.AllowedPlans
+	promotion.Active = update.Active
+	if promotion.PerUserLimit == 0 {
+		promotion.PerUserLimit = 1
+	}
+	if err := validatePromotion(promotion); err != nil {
+		return nil, err
+	}
+
+	if err := repo.UpdatePromotion(promotion); err != nil {
+		log.Printf("repo.UpdatePromotion failed: %v", err)
+		return nil, err
+	}
+
+	return promotion, nil
+}
+
+func validatePromotion(promotion *Promotion) error {
+	for i, plan := range promotion.AllowedPlans {
+		promotion.AllowedPlans[i] = strings.ToLower(strings.TrimSpace(plan))
+	}
+	if promotion.AllowedPlans == nil {
+		promotion.AllowedPlans = []string{}
+	}
+
+	switch {
+	case promotion.Code == "":
+		return fmt.Errorf("%w: code is required", ErrInvalidPromotion)
+	case promotion.Credits <= 0:
+		return fmt.Errorf("%w: credits must be positive", ErrInvalidPromotion)
+	case promotion.CreditType != "individual" && promotion.CreditType != "subscription":
+		return fmt.Errorf("%w: credit_type must be individual or subscription", ErrInvalidPromotion)
+	case promotion.MaxRedemptions < 0 || promotion.PerUserLimit < 0:
+		return fmt.Errorf("%w: limits cannot be negative", ErrInvalidPromotion)
+	}
+	return nil
+}
+
+// RedeemPromotion validates a code against the user's plan and the
+// promotion's expiry before the repository enforces redemption limits.
+func RedeemPromotion(repo PromotionRepo, userRepo user.UserRepo, userID int, code string) (*Redemption, error) {
+	u, err := userRepo.GetUser(userID)
+	if err != nil {
+		log.Printf("userRepo.GetUser failed: %v", err)
+		return nil, err
+	}
+
+	promotion, err := repo.GetPromotionByCode(NormalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if !promotion.Active {
+		return nil, ErrPromotionNotFound
+	}
+	if promotion.ExpiresAt != nil && time.Now().After(*promotion.ExpiresAt) {
+		return nil, ErrPromotionExpired
+	}
+	if !planAllowed(promotion.AllowedPlans, u.SubscriptionTier) {
+		return nil, ErrPlanNotEligible
+	}
+
+	redemption, err := repo.Redeem(promotion, u.ID)
+	if err != nil {
+		log.Printf("repo.Redeem failed for promotion %s: %v", promotion.Code, err)
+		return nil, err
+	}
+
+	return redemption, nil
+}
+
+func planAllowed(allowedPlans []string, tier string) bool {
+	if len(allowedPlans) == 0 {
+		return true
+	}
+	if tier == "" {
+		tier = "free"
+	}
+	for _, plan := range allowedPlans {
+		if plan == tier {
+			return true
+		}
+	}
+	return false
+}
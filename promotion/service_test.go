@@ -0,0 +1,236 @@
+package promotion
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestCreatePromotion(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name         string
+		promotion    Promotion
+		expectedCode string
+		expectedErr  error
+	}{
+		{
+			name:         "CreatePromotion_Success",
+			promotion:    Promotion{Code: " bootcamp25 ", Credits: 3, CreditType: "individual", Active: true},
+			expectedCode: "BOOTCAMP25",
+		},
+		{
+			name:        "CreatePromotion_ZeroCredits",
+			promotion:   Promotion{Code: "FREE", Credits: 0, CreditType: "individual"},
+			expectedErr: ErrInvalidPromotion,
+		},
+		{
+			name:        "CreatePromotion_BadCreditType",
+			promotion:   Promotion{Code: "FREE", Credits: 1, CreditType: "bonus"},
+			expectedErr: ErrInvalidPromotion,
+		},
+		{
+			name:        "CreatePromotion_AlreadyExpired",
+			promotion:   Promotion{Code: "OLD", Credits: 1, CreditType: "individual", ExpiresAt: &past},
+			expectedErr: ErrInvalidPromotion,
+		},
+		{
+			name:        "CreatePromotion_DuplicateCode",
+			promotion:   Promotion{Code: "existing", Credits: 1, CreditType: "individual"},
+			expectedErr: ErrDuplicateCode,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Promotions = []Promotion{{ID: 1, Code: "EXISTING", Credits: 1, CreditType: "individual"}}
+
+			created, err := CreatePromotion(repo, &tc.promotion)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if created.Code != tc.expectedCode || created.PerUserLimit != 1 {
+				t.Fatalf("expected normalized code %s with per-user limit 1, got %+v", tc.expectedCode, created)
+			}
+		})
+	}
+}
+
+func TestUpdatePromotion(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		id          int
+		update      Promotion
+		expectedErr error
+	}{
+		{
+			name:   "UpdatePromotion_Deactivate",
+			id:     1,
+			update: Promotion{Code: "RENAMED", Credits: 2, CreditType: "individual", AllowedPlans: []string{" Pro "}},
+		},
+		{
+			name:   "UpdatePromotion_EndByExpiry",
+			id:     1,
+			update: Promotion{Credits: 1, CreditType: "individual", ExpiresAt: &past, Active: true},
+		},
+		{
+			name:        "UpdatePromotion_ZeroCredits",
+			id:          1,
+			update:      Promotion{Credits: 0, CreditType: "individual", Active: true},
+			expectedErr: ErrInvalidPromotion,
+		},
+		{
+			name:        "UpdatePromotion_NotFound",
+			id:          99,
+			update:      Promotion{Credits: 1, CreditType: "individual", Active: true},
+			expectedErr: ErrPromotionNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Promotions = []Promotion{{ID: 1, Code: "EXISTING", Credits: 1, CreditType: "individual", PerUserLimit: 1, Active: true}}
+
+			updated, err := UpdatePromotion(repo, tc.id, &tc.update)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+				}
+				if repo.Promotions[0].Credits != 1 || !repo.Promotions[0].Active {
+					t.Fatalf("expected the promotion to be unchanged, got %+v", repo.Promotions[0])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated.Code != "EXISTING" || updated.Active != tc.update.Active || updated.PerUserLimit != 1 {
+				t.Fatalf("expected the code to be kept and the edit applied, got %+v", updated)
+			}
+			if stored := repo.Promotions[0]; stored.Credits != tc.update.Credits || stored.Active != tc.update.Active {
+				t.Fatalf("expected the edit to be stored, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestRedeemPromotion(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		promotion   Promotion
+		tier        string
+		previous    []Redemption
+		code        string
+		expectedErr error
+	}{
+		{
+			name:      "Redeem_Success",
+			promotion: Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, ExpiresAt: &future, Active: true},
+			code:      "welcome",
+		},
+		{
+			name:        "Redeem_UnknownCode",
+			promotion:   Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, Active: true},
+			code:        "NOPE",
+			expectedErr: ErrPromotionNotFound,
+		},
+		{
+			name:        "Redeem_Inactive",
+			promotion:   Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, Active: false},
+			code:        "WELCOME",
+			expectedErr: ErrPromotionNotFound,
+		},
+		{
+			name:        "Redeem_Expired",
+			promotion:   Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, ExpiresAt: &past, Active: true},
+			code:        "WELCOME",
+			expectedErr: ErrPromotionExpired,
+		},
+		{
+			name:        "Redeem_PlanNotEligible",
+			promotion:   Promotion{ID: 1, Code: "PROONLY", Credits: 5, CreditType: "subscription", PerUserLimit: 1, AllowedPlans: []string{"pro"}, Active: true},
+			tier:        "premium",
+			code:        "PROONLY",
+			expectedErr: ErrPlanNotEligible,
+		},
+		{
+			name:      "Redeem_FreeTierAllowed",
+			promotion: Promotion{ID: 1, Code: "FREEONLY", Credits: 1, CreditType: "individual", PerUserLimit: 1, AllowedPlans: []string{"free"}, Active: true},
+			code:      "FREEONLY",
+		},
+		{
+			name:        "Redeem_UserLimitReached",
+			promotion:   Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, Active: true},
+			previous:    []Redemption{{ID: 1, PromotionID: 1, UserID: 1, Credits: 2}},
+			code:        "WELCOME",
+			expectedErr: ErrUserLimitReached,
+		},
+		{
+			name:        "Redeem_MaxRedemptionsReached",
+			promotion:   Promotion{ID: 1, Code: "WELCOME", Credits: 2, CreditType: "individual", PerUserLimit: 1, MaxRedemptions: 1, Active: true},
+			previous:    []Redemption{{ID: 1, PromotionID: 1, UserID: 2, Credits: 2}},
+			code:        "WELCOME",
+			expectedErr: ErrMaxRedemptionsReached,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Promotions = []Promotion{tc.promotion}
+			repo.Redemptions = tc.previous
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{ID: 1, Email: "test@example.com", SubscriptionTier: tc.tier}
+
+			redemption, err := RedeemPromotion(repo, userRepo, 1, tc.code)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if redemption.Credits != tc.promotion.Credits || redemption.CreditType != tc.promotion.CreditType {
+				t.Fatalf("unexpected redemption: %+v", redemption)
+			}
+		})
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	if t.Failed() {
+		t.Logf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
@@ -0,0 +1,78 @@
+package referral
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRewarded = "rewarded"
+	StatusRejected = "rejected"
+)
+
+const (
+	QualifiedByInterview = "first_interview"
+	QualifiedByPurchase  = "first_purchase"
+)
+
+const (
+	RejectedSelfReferral    = "self_referral"
+	RejectedReturningUser   = "returning_account"
+	RejectedDomainLimit     = "domain_limit"
+	RejectedReferrerLimit   = "referrer_limit"
+	RejectedReferrerRemoved = "referrer_inactive"
+)
+
+const (
+	ReferrerBonus = 1
+	ReferredBonus = 1
+
+	// MaxReferralsPerDomain caps how many referrals one user can collect from
+	// a single private email domain, which is the cheapest way to farm
+	// accounts. Public mailbox providers are exempt.
+	MaxReferralsPerDomain = 3
+
+	// MaxPublicReferralsPerWindow caps the referrals one user can collect from
+	// public mailbox providers, where free addresses are just as easy to
+	// farm, within PublicReferralWindow.
+	MaxPublicReferralsPerWindow = 10
+	PublicReferralWindow        = 30 * 24 * time.Hour
+)
+
+type Referral struct {
+	ID              int
+	ReferrerID      int
+	ReferredID      int
+	Code            string
+	ReferredDomain  string
+	Status          string
+	RejectionReason string
+	QualifiedBy     string
+	CreatedAt       time.Time
+	RewardedAt      *time.Time
+}
+
+type Summary struct {
+	Code          string `json:"code"`
+	URL           string `json:"url"`
+	Pending       int    `json:"pending"`
+	Rewarded      int    `json:"rewarded"`
+	CreditsEarned int    `json:"credits_earned"`
+}
+
+type ReferralRepo interface {
+	GetCode(userID int) (string, error)
+	CreateCode(userID int, code string) error
+	GetUserIDByCode(code string) (int, error)
+	CreateReferral(referral *Referral) (int, error)
+	CountReferralsByDomain(referrerID int, domain string) (int, error)
+	CountReferralsSince(referrerID int, domains []string, since time.Time) (int, error)
+	Reward(referredID int, qualifiedBy string) (bool, error)
+	GetSummary(referrerID int) (*Summary, error)
+}
+
+var (
+	ErrCodeNotFound  = errors.New("referral code not found")
+	ErrDuplicateCode = errors.New("duplicate referral code")
+)
@@ -0,0 +1,208 @@
+package referral
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/michaelboegner/interviewer/ledger"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+func (r *Repository) GetCode(userID int) (string, error) {
+	var code string
+	err := r.DB.QueryRow(`SELECT code FROM referral_codes WHERE user_id = $1`, userID).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", ErrCodeNotFound
+	} else if err != nil {
+		log.Printf("GetCode failed: %v", err)
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (r *Repository) CreateCode(userID int, code string) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO referral_codes (user_id, code, created_at)
+		VALUES ($1, $2, $3)
+	`, userID, code, time.Now().UTC())
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return ErrDuplicateCode
+		}
+		log.Printf("CreateCode failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetUserIDByCode(code string) (int, error) {
+	var userID int
+	err := r.DB.QueryRow(`SELECT user_id FROM referral_codes WHERE code = $1`, code).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrCodeNotFound
+	} else if err != nil {
+		log.Printf("GetUserIDByCode failed: %v", err)
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (r *Repository) CreateReferral(referral *Referral) (int, error) {
+	var id int
+	err := r.DB.QueryRow(`
+		INSERT INTO referrals (referrer_id, referred_id, code, referred_domain, status, rejection_reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (referred_id) DO NOTHING
+		RETURNING id
+	`,
+		referral.ReferrerID,
+		referral.ReferredID,
+		referral.Code,
+		referral.ReferredDomain,
+		referral.Status,
+		referral.RejectionReason,
+		referral.CreatedAt,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Printf("CreateReferral failed: %v", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *Repository) CountReferralsByDomain(referrerID int, domain string) (int, error) {
+	var count int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM referrals
+		WHERE referrer_id = $1 AND referred_domain = $2 AND status != 'rejected'
+	`, referrerID, domain).Scan(&count)
+	if err != nil {
+		log.Printf("CountReferralsByDomain failed: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountReferralsSince counts the referrer's referrals into any of domains
+// made at or after since, leaving out rejected ones.
+func (r *Repository) CountReferralsSince(referrerID int, domains []string, since time.Time) (int, error) {
+	var count int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM referrals
+		WHERE referrer_id = $1 AND referred_domain = ANY($2) AND created_at >= $3 AND status != 'rejected'
+	`, referrerID, pq.Array(domains), since).Scan(&count)
+	if err != nil {
+		log.Printf("CountReferralsSince failed: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Reward pays both sides of the referred user's pending referral. It reports
+// false when there is nothing pending, so callers can invoke it on every
+// qualifying event.
+func (r *Repository) Reward(referredID int, qualifiedBy string) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		log.Printf("r.DB.Begin failed: %v", err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var (
+		referralID     int
+		referrerID     int
+		referrerStatus string
+	)
+	err = tx.QueryRow(`
+		SELECT r.id, r.referrer_id, u.account_status
+		FROM referrals r
+		JOIN users u ON u.id = r.referrer_id
+		WHERE r.referred_id = $1 AND r.status = 'pending'
+		FOR UPDATE OF r
+	`, referredID).Scan(&referralID, &referrerID, &referrerStatus)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("select pending referral failed: %v", err)
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	if referrerStatus != "active" {
+		_, err = tx.Exec(`
+			UPDATE referrals SET status = 'rejected', rejection_reason = $1 WHERE id = $2
+		`, RejectedReferrerRemoved, referralID)
+		if err != nil {
+			log.Printf("reject referral failed: %v", err)
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	postings := []ledger.Posting{
+		{UserID: referrerID, Amount: ReferrerBonus, CreditType: "individual", Reason: "Referral bonus", Counterparty: ledger.AccountReferrals},
+		{UserID: referredID, Amount: ReferredBonus, CreditType: "individual", Reason: "Referred signup bonus", Counterparty: ledger.AccountReferrals},
+	}
+	for _, posting := range postings {
+		if _, err := ledger.Post(tx, posting); err != nil {
+			log.Printf("ledger.Post failed: %v", err)
+			return false, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE referrals
+		SET status = 'rewarded', qualified_by = $1, referrer_bonus = $2, referred_bonus = $3, rewarded_at = $4
+		WHERE id = $5
+	`, qualifiedBy, ReferrerBonus, ReferredBonus, now, referralID)
+	if err != nil {
+		log.Printf("update referral failed: %v", err)
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *Repository) GetSummary(referrerID int) (*Summary, error) {
+	summary := &Summary{}
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE status = 'pending'),
+		       COUNT(*) FILTER (WHERE status = 'rewarded'),
+		       COALESCE(SUM(referrer_bonus), 0)
+		FROM referrals
+		WHERE referrer_id = $1
+	`, referrerID).Scan(&summary.Pending, &summary.Rewarded, &summary.CreditsEarned)
+	if err != nil {
+		log.Printf("GetSummary failed: %v", err)
+		return nil, err
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,131 @@
+package referral
+
+import (
+	"errors"
+	"time"
+)
+
+type MockRepo struct {
+	Codes     map[int]string
+	Referrals []Referral
+	FailRepo  bool
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{
+		Codes: map[int]string{},
+	}
+}
+
+func (m *MockRepo) GetCode(userID int) (string, error) {
+	if m.FailRepo {
+		return "", errors.New("mocked DB failure")
+	}
+	code, ok := m.Codes[userID]
+	if !ok {
+		return "", ErrCodeNotFound
+	}
+	return code, nil
+}
+
+func (m *MockRepo) CreateCode(userID int, code string) error {
+	if m.FailRepo {
+		return errors.New("mocked DB failure")
+	}
+	for _, existing := range m.Codes {
+		if existing == code {
+			return ErrDuplicateCode
+		}
+	}
+	m.Codes[userID] = code
+	return nil
+}
+
+func (m *MockRepo) GetUserIDByCode(code string) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+	for userID, existing := range m.Codes {
+		if existing == code {
+			return userID, nil
+		}
+	}
+	return 0, ErrCodeNotFound
+}
+
+func (m *MockRepo) CreateReferral(referral *Referral) (int, error) {
+	if m.FailRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+	for _, existing := range m.Referrals {
+		if existing.ReferredID == referral.ReferredID {
+			return 0, nil
+		}
+	}
+	referral.ID = len(m.Referrals) + 1
+	m.Referrals = append(m.Referrals, *referral)
+	return referral.ID, nil
+}
+
+func (m *MockRepo) CountReferralsByDomain(referrerID int, domain string) (int, error) {
+	count := 0
+	for _, referral := range m.Referrals {
+		if referral.ReferrerID == referrerID && referral.ReferredDomain == domain && referral.Status != StatusRejected {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockRepo) CountReferralsSince(referrerID int, domains []string, since time.Time) (int, error) {
+	count := 0
+	for _, referral := range m.Referrals {
+		if referral.ReferrerID != referrerID || referral.Status == StatusRejected || referral.CreatedAt.Before(since) {
+			continue
+		}
+		for _, domain := range domains {
+			if referral.ReferredDomain == domain {
+				count++
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+func (m *MockRepo) Reward(referredID int, qualifiedBy string) (bool, error) {
+	if m.FailRepo {
+		return false, errors.New("mocked DB failure")
+	}
+	for i := range m.Referrals {
+		referral := &m.Referrals[i]
+		if referral.ReferredID == referredID && referral.Status == StatusPending {
+			now := time.Now().UTC()
+			referral.Status = StatusRewarded
+			referral.QualifiedBy = qualifiedBy
+			referral.RewardedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockRepo) GetSummary(referrerID int) (*Summary, error) {
+	if m.FailRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+	summary := &Summary{}
+	for _, referral := range m.Referrals {
+		if referral.ReferrerID != referrerID {
+			continue
+		}
+		switch referral.Status {
+		case StatusPending:
+			summary.Pending++
+		case StatusRewarded:
+			summary.Rewarded++
+			summary.CreditsEarned += ReferrerBonus
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,225 @@
+package referral
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+// codeAlphabet leaves out characters that are easy to confuse when a code is
+// read aloud or typed from a screenshot.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var publicEmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"yahoo.com":      true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"proton.me":      true,
+	"protonmail.com": true,
+	"aol.com":        true,
+}
+
+func publicDomainList() []string {
+	domains := make([]string, 0, len(publicEmailDomains))
+	for domain := range publicEmailDomains {
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func generateCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// EnsureCode returns the user's referral code, creating one on first use.
+func EnsureCode(repo ReferralRepo, userID int) (string, error) {
+	code, err := repo.GetCode(userID)
+	if err == nil {
+		return code, nil
+	}
+	if !errors.Is(err, ErrCodeNotFound) {
+		return "", err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err = generateCode()
+		if err != nil {
+			return "", err
+		}
+		err = repo.CreateCode(userID, code)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, ErrDuplicateCode) {
+			log.Printf("repo.CreateCode failed: %v", err)
+			return "", err
+		}
+	}
+
+	return "", err
+}
+
+// Attribute records that newUser signed up with code. Referrals that trip a
+// fraud guard are still stored, as rejected, so they show up in reporting.
+func Attribute(repo ReferralRepo, userRepo user.UserRepo, code string, newUser *user.User) error {
+	code = NormalizeCode(code)
+	if code == "" {
+		return nil
+	}
+
+	referrerID, err := repo.GetUserIDByCode(code)
+	if err != nil {
+		log.Printf("repo.GetUserIDByCode failed for code %s: %v", code, err)
+		return err
+	}
+	referrer, err := userRepo.GetUser(referrerID)
+	if err != nil {
+		log.Printf("userRepo.GetUser failed: %v", err)
+		return err
+	}
+
+	domain := emailDomain(newUser.Email)
+	referral := &Referral{
+		ReferrerID:     referrerID,
+		ReferredID:     newUser.ID,
+		Code:           code,
+		ReferredDomain: domain,
+		Status:         StatusPending,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	switch {
+	case referrerID == newUser.ID || normalizeMailbox(referrer.Email) == normalizeMailbox(newUser.Email):
+		referral.Status = StatusRejected
+		referral.RejectionReason = RejectedSelfReferral
+	case newUser.IndividualCredits == 0:
+		// CreateUser withholds the signup credit from emails that belonged to a
+		// deleted account; the same accounts should not earn referral rewards.
+		referral.Status = StatusRejected
+		referral.RejectionReason = RejectedReturningUser
+	case !publicEmailDomains[domain]:
+		count, err := repo.CountReferralsByDomain(referrerID, domain)
+		if err != nil {
+			return err
+		}
+		if count >= MaxReferralsPerDomain {
+			referral.Status = StatusRejected
+			referral.RejectionReason = RejectedDomainLimit
+		}
+	default:
+		count, err := repo.CountReferralsSince(referrerID, publicDomainList(), referral.CreatedAt.Add(-PublicReferralWindow))
+		if err != nil {
+			return err
+		}
+		if count >= MaxPublicReferralsPerWindow {
+			referral.Status = StatusRejected
+			referral.RejectionReason = RejectedReferrerLimit
+		}
+	}
+
+	if _, err := repo.CreateReferral(referral); err != nil {
+		log.Printf("repo.CreateReferral failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Qualify rewards the user's pending referral, if any, after a qualifying
+// event. Only the first qualifying event pays out.
+func Qualify(repo ReferralRepo, referredID int, qualifiedBy string) error {
+	rewarded, err := repo.Reward(referredID, qualifiedBy)
+	if err != nil {
+		log.Printf("repo.Reward failed for user %d: %v", referredID, err)
+		return err
+	}
+	if rewarded {
+		log.Printf("referral rewarded for user %d via %s", referredID, qualifiedBy)
+	}
+
+	return nil
+}
+
+// HandleBillingEvent qualifies referrals on a user's first purchase. It is
+// meant to run inside webhook processing, so a failure is retried with the
+// event; rewarding is a no-op once the referral has paid out.
+func HandleBillingEvent(repo ReferralRepo, userRepo user.UserRepo, event *billing.Event) error {
+	if event.Type != billing.EventOrderCreated && event.Type != billing.EventSubscriptionCreated {
+		return nil
+	}
+
+	u, err := userRepo.GetUserByEmail(event.UserEmail)
+	if err != nil {
+		log.Printf("userRepo.GetUserByEmail failed: %v", err)
+		return err
+	}
+
+	return Qualify(repo, u.ID, QualifiedByPurchase)
+}
+
+func GetSummary(repo ReferralRepo, userID int) (*Summary, error) {
+	code, err := EnsureCode(repo, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := repo.GetSummary(userID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Code = code
+	summary.URL = os.Getenv("FRONTEND_URL") + "signup?ref=" + code
+
+	return summary, nil
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// normalizeMailbox folds plus-addressing, and dots for Gmail, so aliases of
+// the same inbox compare equal.
+func normalizeMailbox(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
@@ -0,0 +1,249 @@
+package referral
+
+import (
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/billing"
+	"github.com/michaelboegner/interviewer/user"
+)
+
+func TestEnsureCode(t *testing.T) {
+	repo := NewMockRepo()
+
+	code, err := EnsureCode(repo, 1)
+	if err != nil {
+		t.Fatalf("EnsureCode failed: %v", err)
+	}
+	if len(code) != 8 || strings.ContainsAny(code, "01IO") {
+		t.Fatalf("unexpected referral code %q", code)
+	}
+
+	again, err := EnsureCode(repo, 1)
+	if err != nil || again != code {
+		t.Fatalf("expected existing code %q to be reused, got %q, err=%v", code, again, err)
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	tests := []struct {
+		name           string
+		referrerEmail  string
+		referredEmail  string
+		referredID     int
+		signupCredits  int
+		existing       []Referral
+		expectedStatus string
+		expectedReason string
+	}{
+		{
+			name:           "Attribute_Pending",
+			referrerEmail:  "alice@example.com",
+			referredEmail:  "bob@gmail.com",
+			referredID:     2,
+			signupCredits:  1,
+			expectedStatus: StatusPending,
+		},
+		{
+			name:           "Attribute_SelfReferralByID",
+			referrerEmail:  "alice@example.com",
+			referredEmail:  "alice@example.com",
+			referredID:     1,
+			signupCredits:  1,
+			expectedStatus: StatusRejected,
+			expectedReason: RejectedSelfReferral,
+		},
+		{
+			name:           "Attribute_SelfReferralGmailAlias",
+			referrerEmail:  "alice.smith@gmail.com",
+			referredEmail:  "AliceSmith+promo@googlemail.com",
+			referredID:     2,
+			signupCredits:  1,
+			expectedStatus: StatusRejected,
+			expectedReason: RejectedSelfReferral,
+		},
+		{
+			name:           "Attribute_ReturningAccount",
+			referrerEmail:  "alice@example.com",
+			referredEmail:  "bob@gmail.com",
+			referredID:     2,
+			signupCredits:  0,
+			expectedStatus: StatusRejected,
+			expectedReason: RejectedReturningUser,
+		},
+		{
+			name:          "Attribute_PrivateDomainLimit",
+			referrerEmail: "alice@example.com",
+			referredEmail: "d@farm.io",
+			referredID:    5,
+			signupCredits: 1,
+			existing: []Referral{
+				{ReferrerID: 1, ReferredID: 2, ReferredDomain: "farm.io", Status: StatusRewarded},
+				{ReferrerID: 1, ReferredID: 3, ReferredDomain: "farm.io", Status: StatusPending},
+				{ReferrerID: 1, ReferredID: 4, ReferredDomain: "farm.io", Status: StatusPending},
+			},
+			expectedStatus: StatusRejected,
+			expectedReason: RejectedDomainLimit,
+		},
+		{
+			name:          "Attribute_PublicDomainNotLimited",
+			referrerEmail: "alice@example.com",
+			referredEmail: "d@gmail.com",
+			referredID:    5,
+			signupCredits: 1,
+			existing: []Referral{
+				{ReferrerID: 1, ReferredID: 2, ReferredDomain: "gmail.com", Status: StatusRewarded},
+				{ReferrerID: 1, ReferredID: 3, ReferredDomain: "gmail.com", Status: StatusPending},
+				{ReferrerID: 1, ReferredID: 4, ReferredDomain: "gmail.com", Status: StatusPending},
+			},
+			expectedStatus: StatusPending,
+		},
+		{
+			name:           "Attribute_PublicDomainReferrerLimit",
+			referrerEmail:  "alice@example.com",
+			referredEmail:  "z@outlook.com",
+			referredID:     99,
+			signupCredits:  1,
+			existing:       recentPublicReferrals(MaxPublicReferralsPerWindow),
+			expectedStatus: StatusRejected,
+			expectedReason: RejectedReferrerLimit,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Codes[1] = "ALICE234"
+			repo.Referrals = tc.existing
+			userRepo := user.NewMockRepo()
+			userRepo.Users[1] = user.User{ID: 1, Email: tc.referrerEmail, AccountStatus: "active"}
+
+			newUser := &user.User{ID: tc.referredID, Email: tc.referredEmail, IndividualCredits: tc.signupCredits}
+			if err := Attribute(repo, userRepo, " alice234 ", newUser); err != nil {
+				t.Fatalf("Attribute failed: %v", err)
+			}
+
+			got := repo.Referrals[len(repo.Referrals)-1]
+			if got.ReferredID != tc.referredID || got.Status != tc.expectedStatus || got.RejectionReason != tc.expectedReason {
+				t.Fatalf("expected %s/%q, got %+v", tc.expectedStatus, tc.expectedReason, got)
+			}
+		})
+	}
+}
+
+func TestAttributeUnknownCode(t *testing.T) {
+	repo := NewMockRepo()
+	err := Attribute(repo, user.NewMockRepo(), "NOPE", &user.User{ID: 2, Email: "bob@gmail.com", IndividualCredits: 1})
+	if err != ErrCodeNotFound {
+		t.Fatalf("expected ErrCodeNotFound, got %v", err)
+	}
+	if len(repo.Referrals) != 0 {
+		t.Fatal("expected no referral to be recorded")
+	}
+}
+
+func TestHandleBillingEvent(t *testing.T) {
+	tests := []struct {
+		name             string
+		eventType        string
+		expectedRewarded bool
+	}{
+		{
+			name:             "HandleBillingEvent_FirstOrder",
+			eventType:        billing.EventOrderCreated,
+			expectedRewarded: true,
+		},
+		{
+			name:             "HandleBillingEvent_FirstSubscription",
+			eventType:        billing.EventSubscriptionCreated,
+			expectedRewarded: true,
+		},
+		{
+			name:             "HandleBillingEvent_RenewalIgnored",
+			eventType:        billing.EventPaymentSucceeded,
+			expectedRewarded: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Referrals = []Referral{{ID: 1, ReferrerID: 1, ReferredID: 2, Status: StatusPending}}
+			userRepo := user.NewMockRepo()
+			userRepo.Users[2] = user.User{ID: 2, Email: "bob@gmail.com"}
+
+			err := HandleBillingEvent(repo, userRepo, &billing.Event{Type: tc.eventType, UserEmail: "bob@gmail.com"})
+			if err != nil {
+				t.Fatalf("HandleBillingEvent failed: %v", err)
+			}
+
+			rewarded := repo.Referrals[0].Status == StatusRewarded
+			if rewarded != tc.expectedRewarded {
+				t.Fatalf("expected rewarded=%v, got %v", tc.expectedRewarded, rewarded)
+			}
+			if rewarded && repo.Referrals[0].QualifiedBy != QualifiedByPurchase {
+				t.Fatalf("expected qualified_by %s, got %s", QualifiedByPurchase, repo.Referrals[0].QualifiedBy)
+			}
+		})
+	}
+}
+
+func TestGetSummary(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "https://interviewer.dev/")
+
+	repo := NewMockRepo()
+	repo.Codes[1] = "ALICE234"
+	repo.Referrals = []Referral{
+		{ReferrerID: 1, ReferredID: 2, Status: StatusRewarded},
+		{ReferrerID: 1, ReferredID: 3, Status: StatusPending},
+		{ReferrerID: 1, ReferredID: 4, Status: StatusRejected},
+	}
+
+	summary, err := GetSummary(repo, 1)
+	if err != nil {
+		t.Fatalf("GetSummary failed: %v", err)
+	}
+	expected := Summary{
+		Code:          "ALICE234",
+		URL:           "https://interviewer.dev/signup?ref=ALICE234",
+		Pending:       1,
+		Rewarded:      1,
+		CreditsEarned: ReferrerBonus,
+	}
+	if *summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *summary)
+	}
+}
+
+// recentPublicReferrals returns n referrals by user 1 made today, spread
+// across public mailbox providers.
+func recentPublicReferrals(n int) []Referral {
+	domains := []string{"gmail.com", "yahoo.com", "icloud.com"}
+	referrals := []Referral{}
+	for i := 0; i < n; i++ {
+		referrals = append(referrals, Referral{
+			ReferrerID:     1,
+			ReferredID:     i + 2,
+			ReferredDomain: domains[i%len(domains)],
+			Status:         StatusPending,
+			CreatedAt:      time.Now().UTC(),
+		})
+	}
+	return referrals
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	if t.Failed() {
+		t.Logf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
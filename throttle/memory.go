@@ -0,0 +1,82 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryPruneSize is how many keys MemoryStore holds before it drops
+// expired ones.
+const memoryPruneSize = 10000
+
+// MemoryStore keeps counters in process memory. Counters are lost on
+// restart and are not shared between instances.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: map[string]Attempts{},
+	}
+}
+
+func (m *MemoryStore) GetAttempts(key string) (*Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return &Attempts{Key: key}, nil
+	}
+	return &attempts, nil
+}
+
+func (m *MemoryStore) AddFailure(key string, at time.Time, window time.Duration) (*Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.attempts) >= memoryPruneSize {
+		m.prune(at, window)
+	}
+
+	attempts, ok := m.attempts[key]
+	if !ok || at.Sub(attempts.LastFailureAt) > window {
+		attempts = Attempts{Key: key, LockedUntil: attempts.LockedUntil}
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	m.attempts[key] = attempts
+
+	return &attempts, nil
+}
+
+func (m *MemoryStore) Lock(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := m.attempts[key]
+	attempts.Key = key
+	attempts.Failures = 0
+	attempts.LockedUntil = &until
+	m.attempts[key] = attempts
+	return nil
+}
+
+func (m *MemoryStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryStore) prune(now time.Time, window time.Duration) {
+	for key, attempts := range m.attempts {
+		locked := attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil)
+		if !locked && now.Sub(attempts.LastFailureAt) > window {
+			delete(m.attempts, key)
+		}
+	}
+}
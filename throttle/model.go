@@ -0,0 +1,86 @@
+package throttle
+
+import (
+	"errors"
+	"time"
+)
+
+// Attempts counts recent failures for one key, such as an email address or
+// a client IP within a policy.
+type Attempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Policy sets the limits for one protected action. Up to FreeFailures
+// failures are allowed back to back. After that, each failure doubles the
+// wait before the next attempt, starting at BaseDelay and capped at MaxDelay.
+// MaxAccountFailures or MaxIPFailures within Window locks the key for
+// Lockout. A zero maximum turns that limit off.
+type Policy struct {
+	Name               string
+	FreeFailures       int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	MaxAccountFailures int
+	MaxIPFailures      int
+	Window             time.Duration
+	Lockout            time.Duration
+}
+
+var (
+	// Login guards password logins. Only failed logins count.
+	Login = Policy{
+		Name:               "login",
+		FreeFailures:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Minute,
+		MaxAccountFailures: 10,
+		MaxIPFailures:      50,
+		Window:             time.Hour,
+		Lockout:            15 * time.Minute,
+	}
+
+	// PasswordReset and Verification guard the endpoints that send email.
+	// Every request counts, so the limits are per hour.
+	PasswordReset = Policy{
+		Name:               "password_reset",
+		FreeFailures:       2,
+		BaseDelay:          30 * time.Second,
+		MaxDelay:           10 * time.Minute,
+		MaxAccountFailures: 5,
+		MaxIPFailures:      20,
+		Window:             time.Hour,
+		Lockout:            time.Hour,
+	}
+	Verification = Policy{
+		Name:               "verification",
+		FreeFailures:       2,
+		BaseDelay:          30 * time.Second,
+		MaxDelay:           10 * time.Minute,
+		MaxAccountFailures: 5,
+		MaxIPFailures:      20,
+		Window:             time.Hour,
+		Lockout:            time.Hour,
+	}
+)
+
+// Store keeps attempt counters. MemoryStore suits a single instance;
+// Repository shares counters between instances through Postgres.
+type Store interface {
+	GetAttempts(key string) (*Attempts, error)
+	// AddFailure counts a failure at the given time, restarting the count
+	// when the previous failure is older than window.
+	AddFailure(key string, at time.Time, window time.Duration) (*Attempts, error)
+	// Lock blocks the key until the given time and clears its failures,
+	// so the count starts again once the lockout ends.
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+var (
+	ErrThrottled = errors.New("too many attempts; slow down")
+	ErrLocked    = errors.New("too many failed attempts; temporarily locked")
+)
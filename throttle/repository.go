@@ -0,0 +1,110 @@
+package throttle
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"time"
+)
+
+type Repository struct {
+	DB *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		DB: db,
+	}
+}
+
+// NewStore returns the store named by THROTTLE_STORE: "postgres" to share
+// counters between instances, or the default in-memory store.
+func NewStore(db *sql.DB) Store {
+	if os.Getenv("THROTTLE_STORE") == "postgres" {
+		return NewRepository(db)
+	}
+	return NewMemoryStore()
+}
+
+func (r *Repository) GetAttempts(key string) (*Attempts, error) {
+	attempts := Attempts{Key: key}
+	err := r.DB.QueryRow(`
+		SELECT failures, last_failure_at, locked_until
+		FROM auth_attempts
+		WHERE key = $1
+	`, key).Scan(
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+	if err == sql.ErrNoRows {
+		return &attempts, nil
+	} else if err != nil {
+		log.Printf("GetAttempts failed: %v", err)
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+// AddFailure increments the counter in one statement so concurrent
+// failures on different instances are all counted.
+func (r *Repository) AddFailure(key string, at time.Time, window time.Duration) (*Attempts, error) {
+	// Counters nobody has touched for a day are cleared as new ones are
+	// written.
+	_, err := r.DB.Exec(`
+		DELETE FROM auth_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)
+	`, at.Add(-24*time.Hour), at)
+	if err != nil {
+		log.Printf("delete stale auth attempts failed: %v", err)
+		return nil, err
+	}
+
+	attempts := Attempts{Key: key}
+	err = r.DB.QueryRow(`
+		INSERT INTO auth_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+		        WHEN auth_attempts.last_failure_at < $3 THEN 1
+		        ELSE auth_attempts.failures + 1
+		    END,
+		    last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures, last_failure_at, locked_until
+	`, key, at, at.Add(-window)).Scan(
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&attempts.LockedUntil,
+	)
+	if err != nil {
+		log.Printf("AddFailure failed: %v", err)
+		return nil, err
+	}
+
+	return &attempts, nil
+}
+
+func (r *Repository) Lock(key string, until time.Time) error {
+	_, err := r.DB.Exec(`
+		UPDATE auth_attempts
+		SET failures = 0, locked_until = $1
+		WHERE key = $2
+	`, until, key)
+	if err != nil {
+		log.Printf("Lock failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) Reset(key string) error {
+	_, err := r.DB.Exec(`DELETE FROM auth_attempts WHERE key = $1`, key)
+	if err != nil {
+		log.Printf("Reset failed: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package throttle
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// Check reports whether an attempt by account from ip may go ahead. If not,
+// it returns how long the client should wait along with ErrThrottled or
+// ErrLocked. Progressive delays apply per account only, so people sharing
+// an IP address don't slow each other down.
+func Check(store Store, policy Policy, account, ip string) (time.Duration, error) {
+	now := timeNow().UTC()
+
+	var wait time.Duration
+	for _, key := range keys(policy, account, ip) {
+		attempts, err := store.GetAttempts(key)
+		if err != nil {
+			log.Printf("store.GetAttempts failed: %v", err)
+			return 0, err
+		}
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			return attempts.LockedUntil.Sub(now), ErrLocked
+		}
+		if key == accountKey(policy, account) {
+			wait = policy.delay(attempts, now)
+		}
+	}
+
+	if wait > 0 {
+		return wait, ErrThrottled
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt and locks any key that reached its limit.
+// It reports whether this failure locked the account, so the owner can be
+// told.
+func Fail(store Store, policy Policy, account, ip string) (bool, error) {
+	now := timeNow().UTC()
+
+	accountLocked := false
+	for _, key := range keys(policy, account, ip) {
+		attempts, err := store.AddFailure(key, now, policy.Window)
+		if err != nil {
+			log.Printf("store.AddFailure failed: %v", err)
+			return false, err
+		}
+
+		limit := policy.MaxIPFailures
+		if key == accountKey(policy, account) {
+			limit = policy.MaxAccountFailures
+		}
+		if limit == 0 || attempts.Failures < limit {
+			continue
+		}
+
+		if err := store.Lock(key, now.Add(policy.Lockout)); err != nil {
+			log.Printf("store.Lock failed: %v", err)
+			return false, err
+		}
+		log.Printf("%s locked for %s after %d failed attempts", key, policy.Lockout, attempts.Failures)
+		if key == accountKey(policy, account) {
+			accountLocked = true
+		}
+	}
+
+	return accountLocked, nil
+}
+
+// Allow checks an attempt and counts it whether or not it succeeds. It is
+// for actions such as sending email, where every request costs something.
+func Allow(store Store, policy Policy, account, ip string) (time.Duration, error) {
+	wait, err := Check(store, policy, account, ip)
+	if err != nil {
+		return wait, err
+	}
+
+	if _, err := Fail(store, policy, account, ip); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Succeed clears the account's failures after a successful attempt. The IP
+// count is kept, so one valid login can't reset a spray across accounts.
+func Succeed(store Store, policy Policy, account string) error {
+	if account = normalize(account); account == "" {
+		return nil
+	}
+
+	if err := store.Reset(accountKey(policy, account)); err != nil {
+		log.Printf("store.Reset failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// delay is how long the account still has to wait after its last failure.
+func (p Policy) delay(attempts *Attempts, now time.Time) time.Duration {
+	over := attempts.Failures - p.FreeFailures
+	if over <= 0 || now.Sub(attempts.LastFailureAt) > p.Window {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	if over <= 30 && p.BaseDelay<<(over-1) < p.MaxDelay {
+		delay = p.BaseDelay << (over - 1)
+	}
+
+	remaining := attempts.LastFailureAt.Add(delay).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func keys(policy Policy, account, ip string) []string {
+	var keys []string
+	if account = normalize(account); account != "" {
+		keys = append(keys, accountKey(policy, account))
+	}
+	if ip = strings.TrimSpace(ip); ip != "" {
+		keys = append(keys, policy.Name+":ip:"+ip)
+	}
+	return keys
+}
+
+func accountKey(policy Policy, account string) string {
+	return policy.Name + ":account:" + normalize(account)
+}
+
+func normalize(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
@@ -0,0 +1,194 @@
+package throttle
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock pins timeNow for the duration of a test.
+func clock(t *testing.T) *time.Time {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+	return &now
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		account      string
+		ip           string
+		elapsed      time.Duration
+		expectedErr  error
+		expectedWait time.Duration
+	}{
+		{
+			name:     "Check_FreeFailures",
+			failures: Login.FreeFailures,
+			account:  "test@test.com",
+		},
+		{
+			name:         "Check_FirstDelay",
+			failures:     Login.FreeFailures + 1,
+			account:      "test@test.com",
+			expectedErr:  ErrThrottled,
+			expectedWait: Login.BaseDelay,
+		},
+		{
+			name:         "Check_DelayDoubles",
+			failures:     Login.FreeFailures + 3,
+			account:      "TEST@test.com ",
+			expectedErr:  ErrThrottled,
+			expectedWait: 4 * Login.BaseDelay,
+		},
+		{
+			name:         "Check_LastDelayBeforeLockout",
+			failures:     Login.MaxAccountFailures - 1,
+			account:      "test@test.com",
+			expectedErr:  ErrThrottled,
+			expectedWait: 32 * Login.BaseDelay,
+		},
+		{
+			name:     "Check_DelayElapsed",
+			failures: Login.FreeFailures + 1,
+			account:  "test@test.com",
+			elapsed:  Login.BaseDelay,
+		},
+		{
+			name:         "Check_AccountLocked",
+			failures:     Login.MaxAccountFailures,
+			account:      "test@test.com",
+			expectedErr:  ErrLocked,
+			expectedWait: Login.Lockout,
+		},
+		{
+			name:         "Check_IPLockedForOtherAccounts",
+			failures:     Login.MaxIPFailures,
+			ip:           "203.0.113.7",
+			account:      "other@test.com",
+			expectedErr:  ErrLocked,
+			expectedWait: Login.Lockout,
+		},
+		{
+			name:     "Check_IPFailuresDoNotDelay",
+			failures: Login.FreeFailures + 2,
+			ip:       "203.0.113.7",
+			account:  "other@test.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			now := clock(t)
+			store := NewMemoryStore()
+			for i := 0; i < tc.failures; i++ {
+				// IP cases fail against many accounts from one address.
+				account := "test@test.com"
+				if tc.ip != "" {
+					account = fmt.Sprintf("user%d@test.com", i)
+				}
+				if _, err := Fail(store, Login, account, tc.ip); err != nil {
+					t.Fatalf("Fail failed: %v", err)
+				}
+			}
+			*now = now.Add(tc.elapsed)
+
+			wait, err := Check(store, Login, tc.account, tc.ip)
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
+			if wait != tc.expectedWait {
+				t.Fatalf("expected wait %s but got %s", tc.expectedWait, wait)
+			}
+		})
+	}
+}
+
+func TestFailLocksAccountOnce(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	now := clock(t)
+	store := NewMemoryStore()
+
+	for i := 1; i <= Login.MaxAccountFailures; i++ {
+		locked, err := Fail(store, Login, "test@test.com", "198.51.100.1")
+		if err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if locked != (i == Login.MaxAccountFailures) {
+			t.Fatalf("failure %d: expected locked=%v", i, i == Login.MaxAccountFailures)
+		}
+	}
+
+	*now = now.Add(Login.Lockout)
+	if _, err := Check(store, Login, "test@test.com", "198.51.100.1"); err != nil {
+		t.Fatalf("expected the lockout to expire, got %v", err)
+	}
+}
+
+func TestSucceedResetsAccountOnly(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	clock(t)
+	store := NewMemoryStore()
+	for i := 0; i < Login.FreeFailures+1; i++ {
+		Fail(store, Login, "test@test.com", "198.51.100.1")
+	}
+
+	if err := Succeed(store, Login, "Test@Test.com"); err != nil {
+		t.Fatalf("Succeed failed: %v", err)
+	}
+	if _, err := Check(store, Login, "test@test.com", "198.51.100.1"); err != nil {
+		t.Fatalf("expected account delay to be cleared, got %v", err)
+	}
+	ipAttempts, _ := store.GetAttempts("login:ip:198.51.100.1")
+	if ipAttempts.Failures != Login.FreeFailures+1 {
+		t.Fatalf("expected IP failures to be kept, got %d", ipAttempts.Failures)
+	}
+}
+
+func TestAllowCountsEveryRequest(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	now := clock(t)
+	store := NewMemoryStore()
+
+	for i := 0; i < PasswordReset.FreeFailures; i++ {
+		if _, err := Allow(store, PasswordReset, "test@test.com", "198.51.100.1"); err != nil {
+			t.Fatalf("request %d: expected to be allowed, got %v", i+1, err)
+		}
+	}
+	if _, err := Allow(store, PasswordReset, "test@test.com", "198.51.100.1"); err != nil {
+		t.Fatalf("expected the first request over the free limit to be allowed, got %v", err)
+	}
+	if wait, err := Allow(store, PasswordReset, "test@test.com", "198.51.100.1"); err != ErrThrottled || wait != PasswordReset.BaseDelay {
+		t.Fatalf("expected ErrThrottled for %s, got %v for %s", PasswordReset.BaseDelay, err, wait)
+	}
+
+	*now = now.Add(PasswordReset.Window + time.Second)
+	if _, err := Allow(store, PasswordReset, "test@test.com", "198.51.100.1"); err != nil {
+		t.Fatalf("expected the count to restart after the window, got %v", err)
+	}
+}
+
+func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
+	log.SetOutput(os.Stderr)
+	if t.Failed() {
+		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
+	}
+}
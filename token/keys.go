@@ -0,0 +1,448 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Purpose separates the keys used for each kind of token, so that a token
+// minted for one flow can never be replayed in another.
+type Purpose string
+
+const (
+	PurposeAccess            Purpose = "access"
+	PurposeEmailVerification Purpose = "email_verification"
+	PurposePasswordReset     Purpose = "password_reset"
+	PurposeMFAChallenge      Purpose = "mfa_challenge"
+	PurposeEmailChange       Purpose = "email_change"
+	PurposeReactivation      Purpose = "account_reactivation"
+	PurposeUnsubscribe       Purpose = "unsubscribe"
+)
+
+var purposes = []Purpose{PurposeAccess, PurposeEmailVerification, PurposePasswordReset, PurposeMFAChallenge, PurposeEmailChange, PurposeReactivation, PurposeUnsubscribe}
+
+// legacyPurposes are the only flows that issued tokens without a kid, all
+// signed with the raw JWT_SECRET. Newer purposes never accept such tokens.
+var legacyPurposes = []Purpose{PurposeAccess, PurposeEmailVerification, PurposePasswordReset}
+
+// legacyMaxLifetime is the longest lifetime of a token without a kid (the
+// 10-hour access token), which bounds how long after the cutoff one can still
+// be presented.
+const legacyMaxLifetime = 10 * time.Hour
+
+// startedAt stands in for the deploy time when JWT_LEGACY_UNTIL is unset.
+var startedAt = time.Now().UTC()
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgHS256 = "HS256"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key configured")
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrLegacyToken  = errors.New("legacy token is no longer accepted")
+)
+
+// KeyConfig is one entry of JWT_SIGNING_KEYS. Asymmetric keys are given as
+// PEM (PKCS#8 or PKCS#1 private keys, PKIX public keys); a key with only a
+// public key can verify but not sign. Exactly one key per purpose may be
+// active, and that key signs new tokens.
+type KeyConfig struct {
+	ID         string  `json:"kid"`
+	Purpose    Purpose `json:"purpose"`
+	Algorithm  string  `json:"alg"`
+	PrivateKey string  `json:"private_key,omitempty"`
+	PublicKey  string  `json:"public_key,omitempty"`
+	Secret     string  `json:"secret,omitempty"`
+	Active     bool    `json:"active,omitempty"`
+}
+
+type signingKey struct {
+	id        string
+	purpose   Purpose
+	algorithm string
+	signKey   any
+	verifyKey any
+}
+
+func (k *signingKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.algorithm)
+}
+
+// KeyManager holds the signing keys for every token purpose, keyed by kid.
+type KeyManager struct {
+	keys        map[string]*signingKey
+	active      map[Purpose]*signingKey
+	legacy      []byte
+	legacyUntil time.Time
+}
+
+// NewKeyManager builds a key manager from explicit key configs. A non-empty
+// legacySecret provides derived HS256 keys for purposes with no configured
+// key, and keeps tokens minted before key IDs were introduced verifiable if
+// they were issued no later than legacyUntil.
+func NewKeyManager(configs []KeyConfig, legacySecret string, legacyUntil time.Time) (*KeyManager, error) {
+	km := &KeyManager{
+		keys:        map[string]*signingKey{},
+		active:      map[Purpose]*signingKey{},
+		legacyUntil: legacyUntil,
+	}
+
+	for _, config := range configs {
+		key, err := parseKeyConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", config.ID, err)
+		}
+		if _, exists := km.keys[key.id]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", key.id)
+		}
+		km.keys[key.id] = key
+
+		if config.Active {
+			if key.signKey == nil {
+				return nil, fmt.Errorf("signing key %q is active but has no private key", key.id)
+			}
+			if _, exists := km.active[key.purpose]; exists {
+				return nil, fmt.Errorf("more than one active signing key for purpose %q", key.purpose)
+			}
+			km.active[key.purpose] = key
+		}
+	}
+
+	if legacySecret != "" {
+		km.legacy = []byte(legacySecret)
+		for _, purpose := range purposes {
+			secret := derivePurposeSecret(legacySecret, purpose)
+			key := &signingKey{
+				id:        "hs-" + string(purpose),
+				purpose:   purpose,
+				algorithm: AlgHS256,
+				signKey:   secret,
+				verifyKey: secret,
+			}
+			if _, exists := km.keys[key.id]; !exists {
+				km.keys[key.id] = key
+			}
+			if _, exists := km.active[purpose]; !exists {
+				km.active[purpose] = key
+			}
+		}
+	}
+
+	return km, nil
+}
+
+// Sign signs claims with the active key for purpose and sets its kid header.
+func (km *KeyManager) Sign(purpose Purpose, claims jwt.Claims) (string, error) {
+	key, ok := km.active[purpose]
+	if !ok {
+		return "", fmt.Errorf("%w for purpose %q", ErrNoSigningKey, purpose)
+	}
+
+	jwtoken := jwt.NewWithClaims(key.method(), claims)
+	jwtoken.Header["kid"] = key.id
+	return jwtoken.SignedString(key.signKey)
+}
+
+// Parse verifies a token minted for purpose and decodes it into claims. The
+// kid header selects the key, which must belong to the same purpose and
+// algorithm. Tokens without a kid are only accepted against the legacy
+// secret, for the purposes that issued them, and until the legacy cutoff.
+func (km *KeyManager) Parse(purpose Purpose, tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			if km.legacy == nil || !isLegacyPurpose(purpose) {
+				return nil, ErrUnknownKey
+			}
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+			}
+			if err := km.checkLegacyCutoff(t.Claims); err != nil {
+				return nil, err
+			}
+			return km.legacy, nil
+		}
+
+		key, ok := km.keys[kid]
+		if !ok || key.purpose != purpose {
+			return nil, ErrUnknownKey
+		}
+		if t.Method.Alg() != key.algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key.verifyKey, nil
+	})
+}
+
+// checkLegacyCutoff rejects tokens without a kid issued after legacyUntil.
+// Email verification tokens carried no iat, so their expiry is held to the
+// cutoff plus the longest legacy lifetime instead.
+func (km *KeyManager) checkLegacyCutoff(claims jwt.Claims) error {
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil {
+		return err
+	}
+	if issuedAt != nil && issuedAt.After(km.legacyUntil) {
+		return ErrLegacyToken
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil {
+		return err
+	}
+	if expiresAt == nil || expiresAt.After(km.legacyUntil.Add(legacyMaxLifetime)) {
+		return ErrLegacyToken
+	}
+	return nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public half of every asymmetric key, including retired
+// verify-only keys, so other services can verify tokens we issued. HMAC keys
+// are never published.
+func (km *KeyManager) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range km.keys {
+		switch pub := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				Use:       "sig",
+				KeyID:     key.id,
+				Algorithm: key.algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				Use:       "sig",
+				KeyID:     key.id,
+				Algorithm: key.algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+func parseKeyConfig(config KeyConfig) (*signingKey, error) {
+	if config.ID == "" {
+		return nil, errors.New("kid is required")
+	}
+	if !validPurpose(config.Purpose) {
+		return nil, fmt.Errorf("unknown purpose %q", config.Purpose)
+	}
+
+	key := &signingKey{id: config.ID, purpose: config.Purpose, algorithm: config.Algorithm}
+
+	switch config.Algorithm {
+	case AlgHS256:
+		if config.Secret == "" {
+			return nil, errors.New("HS256 keys need a secret")
+		}
+		key.signKey = []byte(config.Secret)
+		key.verifyKey = key.signKey
+		return key, nil
+	case AlgEdDSA, AlgRS256:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+	}
+
+	if config.PrivateKey != "" {
+		private, err := parsePrivateKey(config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey = private
+		key.verifyKey = private.Public()
+	} else if config.PublicKey != "" {
+		public, err := parsePublicKey(config.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.verifyKey = public
+	} else {
+		return nil, errors.New("private_key or public_key is required")
+	}
+
+	switch key.verifyKey.(type) {
+	case ed25519.PublicKey:
+		if config.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("Ed25519 key cannot be used with %s", config.Algorithm)
+		}
+	case *rsa.PublicKey:
+		if config.Algorithm != AlgRS256 {
+			return nil, fmt.Errorf("RSA key cannot be used with %s", config.Algorithm)
+		}
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	return key, nil
+}
+
+func parsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("public_key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public_key: %w", err)
+	}
+	return key, nil
+}
+
+func isLegacyPurpose(purpose Purpose) bool {
+	for _, p := range legacyPurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+func validPurpose(purpose Purpose) bool {
+	for _, p := range purposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// derivePurposeSecret gives each purpose its own HMAC key when only
+// JWT_SECRET is configured.
+func derivePurposeSecret(secret string, purpose Purpose) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("interviewer/jwt/" + purpose))
+	return mac.Sum(nil)
+}
+
+var keys struct {
+	sync.Mutex
+	source  string
+	manager *KeyManager
+}
+
+// Keys returns the key manager configured from the environment:
+// JWT_SIGNING_KEYS (a JSON array of KeyConfig) or JWT_SIGNING_KEYS_FILE,
+// plus JWT_SECRET and JWT_LEGACY_UNTIL (RFC 3339, defaulting to when the
+// process started). It is rebuilt whenever that configuration changes.
+func Keys() (*KeyManager, error) {
+	configJSON := os.Getenv("JWT_SIGNING_KEYS")
+	if path := os.Getenv("JWT_SIGNING_KEYS_FILE"); configJSON == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT_SIGNING_KEYS_FILE: %w", err)
+		}
+		configJSON = string(data)
+	}
+	secret := os.Getenv("JWT_SECRET")
+	legacyUntil := startedAt
+	if value := os.Getenv("JWT_LEGACY_UNTIL"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT_LEGACY_UNTIL: %w", err)
+		}
+		legacyUntil = parsed
+	}
+	source := configJSON + "\x00" + secret + "\x00" + legacyUntil.String()
+
+	keys.Lock()
+	defer keys.Unlock()
+	if keys.manager != nil && keys.source == source {
+		return keys.manager, nil
+	}
+
+	var configs []KeyConfig
+	if configJSON != "" {
+		if err := json.Unmarshal([]byte(configJSON), &configs); err != nil {
+			return nil, fmt.Errorf("parse JWT_SIGNING_KEYS: %w", err)
+		}
+	}
+
+	manager, err := NewKeyManager(configs, secret, legacyUntil)
+	if err != nil {
+		return nil, err
+	}
+	keys.source = source
+	keys.manager = manager
+	return manager, nil
+}
+
+// Sign signs claims with the environment's active key for purpose.
+func Sign(purpose Purpose, claims jwt.Claims) (string, error) {
+	km, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	return km.Sign(purpose, claims)
+}
+
+// Parse verifies a token for purpose against the environment's keys.
+func Parse(purpose Purpose, tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	km, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	return km.Parse(purpose, tokenString, claims)
+}
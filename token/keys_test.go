@@ -0,0 +1,284 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyManager(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed: %v", err)
+	}
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %v", err)
+	}
+
+	configs := []KeyConfig{
+		{ID: "access-2", Purpose: PurposeAccess, Algorithm: AlgEdDSA, PrivateKey: privatePEM(t, edKey), Active: true},
+		{ID: "access-1", Purpose: PurposeAccess, Algorithm: AlgEdDSA, PublicKey: publicPEM(t, oldKey.Public())},
+		{ID: "reset-1", Purpose: PurposePasswordReset, Algorithm: AlgRS256, PrivateKey: privatePEM(t, rsaKey), Active: true},
+	}
+
+	cutoff := time.Now().UTC()
+	km, err := NewKeyManager(configs, "legacysecret", cutoff)
+	if err != nil {
+		t.Fatalf("NewKeyManager failed: %v", err)
+	}
+	oldManager, err := NewKeyManager([]KeyConfig{
+		{ID: "access-1", Purpose: PurposeAccess, Algorithm: AlgEdDSA, PrivateKey: privatePEM(t, oldKey), Active: true},
+	}, "", time.Time{})
+	if err != nil {
+		t.Fatalf("NewKeyManager failed: %v", err)
+	}
+
+	sign := func(km *KeyManager, purpose Purpose) string {
+		tokenString, err := km.Sign(purpose, jwt.RegisteredClaims{
+			Subject:   "42",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		})
+		if err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+		return tokenString
+	}
+	signLegacy := func(claims jwt.Claims) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacysecret"))
+		if err != nil {
+			t.Fatalf("SignedString failed: %v", err)
+		}
+		return tokenString
+	}
+	legacy := signLegacy(jwt.RegisteredClaims{
+		Subject:   "42",
+		IssuedAt:  jwt.NewNumericDate(cutoff.Add(-time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(cutoff.Add(time.Hour)),
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		purpose     Purpose
+		expectedAlg string
+		expectedKid string
+		expectError bool
+	}{
+		{
+			name:        "KeyManager_EdDSAAccessToken",
+			token:       sign(km, PurposeAccess),
+			purpose:     PurposeAccess,
+			expectedAlg: AlgEdDSA,
+			expectedKid: "access-2",
+		},
+		{
+			name:        "KeyManager_RS256ResetToken",
+			token:       sign(km, PurposePasswordReset),
+			purpose:     PurposePasswordReset,
+			expectedAlg: AlgRS256,
+			expectedKid: "reset-1",
+		},
+		{
+			name:        "KeyManager_DerivedKeyForUnconfiguredPurpose",
+			token:       sign(km, PurposeEmailVerification),
+			purpose:     PurposeEmailVerification,
+			expectedAlg: AlgHS256,
+			expectedKid: "hs-email_verification",
+		},
+		{
+			name:        "KeyManager_RetiredKeyStillVerifies",
+			token:       sign(oldManager, PurposeAccess),
+			purpose:     PurposeAccess,
+			expectedAlg: AlgEdDSA,
+			expectedKid: "access-1",
+		},
+		{
+			name:        "KeyManager_LegacyTokenWithoutKid",
+			token:       legacy,
+			purpose:     PurposeAccess,
+			expectedAlg: AlgHS256,
+		},
+		{
+			name:        "KeyManager_LegacyResetToken",
+			token:       legacy,
+			purpose:     PurposePasswordReset,
+			expectedAlg: AlgHS256,
+		},
+		{
+			name:        "KeyManager_LegacyVerificationTokenWithoutIssuedAt",
+			token:       signLegacy(jwt.MapClaims{"purpose": "verify_email", "exp": cutoff.Add(15 * time.Minute).Unix()}),
+			purpose:     PurposeEmailVerification,
+			expectedAlg: AlgHS256,
+		},
+		{
+			name:        "KeyManager_LegacyTokenRejectedForEmailChange",
+			token:       legacy,
+			purpose:     PurposeEmailChange,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_LegacyTokenRejectedForReactivation",
+			token:       legacy,
+			purpose:     PurposeReactivation,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_LegacyTokenRejectedForUnsubscribe",
+			token:       legacy,
+			purpose:     PurposeUnsubscribe,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_LegacyTokenRejectedForMFAChallenge",
+			token:       legacy,
+			purpose:     PurposeMFAChallenge,
+			expectError: true,
+		},
+		{
+			name: "KeyManager_LegacyTokenIssuedAfterCutoff",
+			token: signLegacy(jwt.RegisteredClaims{
+				Subject:   "42",
+				IssuedAt:  jwt.NewNumericDate(cutoff.Add(time.Minute)),
+				ExpiresAt: jwt.NewNumericDate(cutoff.Add(time.Hour)),
+			}),
+			purpose:     PurposeAccess,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_LegacyTokenWithoutIssuedAtExpiringPastCutoff",
+			token:       signLegacy(jwt.MapClaims{"purpose": "verify_email", "exp": cutoff.Add(24 * time.Hour).Unix()}),
+			purpose:     PurposeEmailVerification,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_LegacyTokenWithoutExpiry",
+			token:       signLegacy(jwt.RegisteredClaims{Subject: "42", IssuedAt: jwt.NewNumericDate(cutoff.Add(-time.Hour))}),
+			purpose:     PurposeAccess,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_WrongPurpose",
+			token:       sign(km, PurposeAccess),
+			purpose:     PurposePasswordReset,
+			expectError: true,
+		},
+		{
+			name:        "KeyManager_DerivedKeyIsNotTheLegacySecret",
+			token:       sign(km, PurposeEmailVerification),
+			purpose:     PurposeAccess,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			parsed, err := km.Parse(tc.purpose, tc.token, &jwt.RegisteredClaims{})
+
+			if tc.expectError && err == nil {
+				t.Fatalf("expected error but got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			if !tc.expectError {
+				kid, _ := parsed.Header["kid"].(string)
+				if parsed.Method.Alg() != tc.expectedAlg || kid != tc.expectedKid {
+					t.Errorf("expected %s token with kid %q, got %s with kid %q", tc.expectedAlg, tc.expectedKid, parsed.Method.Alg(), kid)
+				}
+			}
+		})
+	}
+
+	jwks := km.JWKS()
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("expected the three asymmetric keys to be published, got %+v", jwks.Keys)
+	}
+	for i, expected := range []struct{ kid, kty string }{{"access-1", "OKP"}, {"access-2", "OKP"}, {"reset-1", "RSA"}} {
+		if jwks.Keys[i].KeyID != expected.kid || jwks.Keys[i].KeyType != expected.kty {
+			t.Errorf("expected JWK %d to be %s %s, got %+v", i, expected.kty, expected.kid, jwks.Keys[i])
+		}
+	}
+	if jwks.Keys[2].E != "AQAB" {
+		t.Errorf("expected RSA exponent AQAB, got %q", jwks.Keys[2].E)
+	}
+}
+
+func TestNewKeyManagerInvalidConfig(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		configs []KeyConfig
+	}{
+		{
+			name:    "InvalidConfig_TwoActiveKeys",
+			configs: []KeyConfig{{ID: "a", Purpose: PurposeAccess, Algorithm: AlgEdDSA, PrivateKey: privatePEM(t, edKey), Active: true}, {ID: "b", Purpose: PurposeAccess, Algorithm: AlgHS256, Secret: "s", Active: true}},
+		},
+		{
+			name:    "InvalidConfig_ActivePublicOnly",
+			configs: []KeyConfig{{ID: "a", Purpose: PurposeAccess, Algorithm: AlgEdDSA, PublicKey: publicPEM(t, edKey.Public()), Active: true}},
+		},
+		{
+			name:    "InvalidConfig_AlgorithmMismatch",
+			configs: []KeyConfig{{ID: "a", Purpose: PurposeAccess, Algorithm: AlgRS256, PrivateKey: privatePEM(t, edKey)}},
+		},
+		{
+			name:    "InvalidConfig_UnknownPurpose",
+			configs: []KeyConfig{{ID: "a", Purpose: "billing", Algorithm: AlgHS256, Secret: "s"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewKeyManager(tc.configs, "", time.Time{}); err == nil {
+				t.Fatalf("expected error but got nil")
+			}
+		})
+	}
+
+	km, err := NewKeyManager(nil, "", time.Time{})
+	if err != nil {
+		t.Fatalf("NewKeyManager failed: %v", err)
+	}
+	if _, err := km.Sign(PurposeAccess, jwt.RegisteredClaims{}); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("expected ErrNoSigningKey without any keys, got %v", err)
+	}
+}
+
+func privatePEM(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func publicPEM(t *testing.T, key any) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
@@ -1,30 +1,82 @@
 package token
 
 import (
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// RefreshToken is one issued refresh token. Tokens are stored only as a
+// SHA-256 hash and are single use: refreshing rotates to a new token in the
+// same family. A family is one login session on one device, and its ID is
+// the session ID exposed to clients.
 type RefreshToken struct {
-	UserID       int
-	RefreshToken string
-	ExpiresAt    time.Time
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID              int        `json:"-"`
+	FamilyID        int        `json:"id"`
+	UserID          int        `json:"-"`
+	RefreshToken    string     `json:"-"`
+	TokenHash       string     `json:"-"`
+	DeviceLabel     string     `json:"device_label"`
+	IPAddress       string     `json:"ip_address"`
+	UserAgent       string     `json:"user_agent"`
+	LastUsedAt      time.Time  `json:"last_used_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	FamilyExpiresAt time.Time  `json:"-"`
+	RotatedAt       *time.Time `json:"-"`
+	RevokedAt       *time.Time `json:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"-"`
+}
+
+// SessionInfo describes the client a session was created or last used from.
+type SessionInfo struct {
+	DeviceLabel string
+	IPAddress   string
+	UserAgent   string
 }
 
 type CustomClaims struct {
 	UserID string `json:"sub"`
+	Actor  *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Actor names the staff member using an impersonation token, following the
+// act claim of RFC 8693.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ActorID returns the impersonating staff member's user ID, or 0 for an
+// ordinary access token.
+func (c *CustomClaims) ActorID() int {
+	if c.Actor == nil {
+		return 0
+	}
+	id, err := strconv.Atoi(c.Actor.Subject)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
 func (c *CustomClaims) GetAudience() (jwt.ClaimStrings, error) {
 	return c.Audience, nil
 }
 
 type TokenRepo interface {
 	AddRefreshToken(token *RefreshToken) error
-	GetStoredRefreshToken(userID int) (string, error)
-	DeleteRefreshToken(userID int) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(current, next *RefreshToken) error
+	RevokeFamily(familyID int) error
+	ListSessions(userID int) ([]RefreshToken, error)
+	RevokeSession(userID, familyID int) error
+	RevokeAllSessions(userID int) error
 }
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrTokenReused     = errors.New("refresh token reused")
+)
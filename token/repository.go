@@ -3,6 +3,7 @@ package token
 import (
 	"database/sql"
 	"log"
+	"time"
 )
 
 type Repository struct {
@@ -15,52 +16,221 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
-// TODO: These are extremely simplistic in protocol (not tracking revoked, multi-sessions, etc. . . )
-// Will advance this after unit tests, CI/CD, and AWS deploy.
+// AddRefreshToken stores the first token of a new family; the family takes
+// the token's ID.
 func (repo *Repository) AddRefreshToken(token *RefreshToken) error {
-	_, err := repo.DB.Exec(
-		"INSERT INTO refresh_tokens (user_id, refresh_token, expires_at, created_at, updated_at) "+
-			"VALUES ($1, $2, $3, $4, $5)",
-		token.UserID,
-		token.RefreshToken,
-		token.ExpiresAt,
-		token.CreatedAt,
-		token.UpdatedAt,
-	)
+	tx, err := repo.DB.Begin()
 	if err != nil {
-		log.Printf("Error: %v\n", err)
+		log.Printf("repo.DB.Begin failed: %v", err)
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	token.FamilyID = 0
+	if err := insertRefreshToken(tx, token); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (repo *Repository) GetStoredRefreshToken(userID int) (string, error) {
-	var storedToken string
+// GetRefreshTokenByHash returns the token with the given hash whether or not
+// it is still usable, so callers can tell a replayed token from an unknown one.
+func (repo *Repository) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
 	err := repo.DB.QueryRow(`
-		SELECT refresh_token FROM refresh_tokens 
-		WHERE user_id = $1 
-		ORDER BY created_at DESC 
-		LIMIT 1
-		`, userID).Scan(&storedToken)
+		SELECT id, family_id, user_id, token_hash, device_label, ip_address, user_agent,
+		       last_used_at, expires_at, family_expires_at, rotated_at, revoked_at, created_at, updated_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`, tokenHash).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.DeviceLabel,
+		&token.IPAddress,
+		&token.UserAgent,
+		&token.LastUsedAt,
+		&token.ExpiresAt,
+		&token.FamilyExpiresAt,
+		&token.RotatedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+		&token.UpdatedAt,
+	)
 	if err == sql.ErrNoRows {
-		log.Printf("User ID invalid: %v", err)
-		return "", err
+		return nil, ErrSessionNotFound
 	} else if err != nil {
-		log.Printf("Error querying database: %v\n", err)
-		return "", err
+		log.Printf("GetRefreshTokenByHash failed: %v", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks current as used and stores next in its family.
+// Only one rotation of a token can win; a concurrent second attempt gets
+// ErrTokenReused.
+func (repo *Repository) RotateRefreshToken(current, next *RefreshToken) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET rotated_at = $1, updated_at = $1
+		WHERE id = $2 AND rotated_at IS NULL AND revoked_at IS NULL
+	`, next.LastUsedAt, current.ID)
+	if err != nil {
+		log.Printf("mark refresh token rotated failed: %v", err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTokenReused
+	}
+
+	if err := insertRefreshToken(tx, next); err != nil {
+		return err
 	}
-	return storedToken, nil
+
+	return tx.Commit()
 }
 
-func (repo *Repository) DeleteRefreshToken(userID int) error {
+func (repo *Repository) RevokeFamily(familyID int) error {
+	now := time.Now().UTC()
 	_, err := repo.DB.Exec(`
-		DELETE FROM refresh_tokens
-		WHERE user_id = $1
-	`, userID)
+		UPDATE refresh_tokens
+		SET revoked_at = $1, updated_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+	`, now, familyID)
+	if err != nil {
+		log.Printf("RevokeFamily failed: %v", err)
+		return err
+	}
 
+	return nil
+}
+
+// ListSessions returns the live token of each of the user's families.
+func (repo *Repository) ListSessions(userID int) ([]RefreshToken, error) {
+	rows, err := repo.DB.Query(`
+		SELECT id, family_id, user_id, device_label, ip_address, user_agent,
+		       last_used_at, expires_at, family_expires_at, created_at, updated_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`, userID, time.Now().UTC())
+	if err != nil {
+		log.Printf("ListSessions failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []RefreshToken{}
+	for rows.Next() {
+		var session RefreshToken
+		err := rows.Scan(
+			&session.ID,
+			&session.FamilyID,
+			&session.UserID,
+			&session.DeviceLabel,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.FamilyExpiresAt,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (repo *Repository) RevokeSession(userID, familyID int) error {
+	now := time.Now().UTC()
+	result, err := repo.DB.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = $1, updated_at = $1
+		WHERE family_id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, now, familyID, userID)
+	if err != nil {
+		log.Printf("RevokeSession failed: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (repo *Repository) RevokeAllSessions(userID int) error {
+	now := time.Now().UTC()
+	_, err := repo.DB.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = $1, updated_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`, now, userID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", userID, err)
+		return err
+	}
+
+	return nil
+}
+
+// insertRefreshToken stores token in token.FamilyID, or in a new family when
+// it is zero. The ID is taken from the sequence first so a new family's
+// family_id, which is NOT NULL, can be set in the same insert.
+func insertRefreshToken(tx *sql.Tx, token *RefreshToken) error {
+	err := tx.QueryRow(`SELECT nextval(pg_get_serial_sequence('refresh_tokens', 'id'))`).Scan(&token.ID)
+	if err != nil {
+		log.Printf("refresh token id failed: %v", err)
+		return err
+	}
+	if token.FamilyID == 0 {
+		token.FamilyID = token.ID
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, device_label, ip_address, user_agent,
+		                            last_used_at, expires_at, family_expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`,
+		token.ID,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.DeviceLabel,
+		token.IPAddress,
+		token.UserAgent,
+		token.LastUsedAt,
+		token.ExpiresAt,
+		token.FamilyExpiresAt,
+		token.CreatedAt,
+		token.UpdatedAt,
+	)
 	if err != nil {
-		log.Printf("Failed to delete refresh tokens for user %d: %v", userID, err)
+		log.Printf("insert refresh token failed: %v", err)
 		return err
 	}
 
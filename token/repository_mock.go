@@ -1,9 +1,13 @@
 package token
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type MockRepo struct {
 	failRepo bool
+	Tokens   []RefreshToken
 }
 
 func NewMockRepo() *MockRepo {
@@ -15,25 +19,106 @@ func (m *MockRepo) AddRefreshToken(token *RefreshToken) error {
 		return errors.New("mocked DB failure")
 	}
 
+	token.ID = len(m.Tokens) + 1
+	token.FamilyID = token.ID
+	stored := *token
+	stored.RefreshToken = ""
+	m.Tokens = append(m.Tokens, stored)
 	return nil
 }
 
-func (m *MockRepo) GetStoredRefreshToken(userID int) (string, error) {
+func (m *MockRepo) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
 	if m.failRepo {
-		return "", errors.New("mocked DB failure")
+		return nil, errors.New("mocked DB failure")
 	}
 
-	if userID != 1 {
-		return "", errors.New("userID does not exist")
+	for _, token := range m.Tokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (m *MockRepo) RotateRefreshToken(current, next *RefreshToken) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	for i := range m.Tokens {
+		if m.Tokens[i].ID == current.ID {
+			if m.Tokens[i].RotatedAt != nil || m.Tokens[i].RevokedAt != nil {
+				return ErrTokenReused
+			}
+			rotatedAt := next.LastUsedAt
+			m.Tokens[i].RotatedAt = &rotatedAt
+			next.ID = len(m.Tokens) + 1
+			stored := *next
+			stored.RefreshToken = ""
+			m.Tokens = append(m.Tokens, stored)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+func (m *MockRepo) RevokeFamily(familyID int) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	now := time.Now().UTC()
+	for i := range m.Tokens {
+		if m.Tokens[i].FamilyID == familyID && m.Tokens[i].RevokedAt == nil {
+			m.Tokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockRepo) ListSessions(userID int) ([]RefreshToken, error) {
+	if m.failRepo {
+		return nil, errors.New("mocked DB failure")
 	}
 
-	return "abc123", nil
+	sessions := []RefreshToken{}
+	for _, token := range m.Tokens {
+		if token.UserID == userID && token.RotatedAt == nil && token.RevokedAt == nil && token.ExpiresAt.After(time.Now()) {
+			sessions = append(sessions, token)
+		}
+	}
+	return sessions, nil
 }
 
-func (m *MockRepo) DeleteRefreshToken(userID int) error {
+func (m *MockRepo) RevokeSession(userID, familyID int) error {
 	if m.failRepo {
 		return errors.New("mocked DB failure")
 	}
 
+	now := time.Now().UTC()
+	revoked := false
+	for i := range m.Tokens {
+		if m.Tokens[i].FamilyID == familyID && m.Tokens[i].UserID == userID && m.Tokens[i].RevokedAt == nil {
+			m.Tokens[i].RevokedAt = &now
+			revoked = true
+		}
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (m *MockRepo) RevokeAllSessions(userID int) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	now := time.Now().UTC()
+	for i := range m.Tokens {
+		if m.Tokens[i].UserID == userID && m.Tokens[i].RevokedAt == nil {
+			m.Tokens[i].RevokedAt = &now
+		}
+	}
 	return nil
 }
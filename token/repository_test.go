@@ -0,0 +1,70 @@
+package token_test
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michaelboegner/interviewer/internal/testutil"
+	"github.com/michaelboegner/interviewer/token"
+)
+
+func TestRepositoryRefreshTokenFamilies(t *testing.T) {
+	db := testutil.OpenTestDB(t)
+	userID := testutil.InsertTestUser(t, db, "DELETE FROM refresh_tokens WHERE user_id = $1")
+
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer func() {
+		if t.Failed() {
+			t.Logf("---- logs ----\n%s\n", buf.String())
+		}
+	}()
+
+	repo := token.NewRepository(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	newToken := func(hash string) *token.RefreshToken {
+		return &token.RefreshToken{
+			UserID:          userID,
+			TokenHash:       hash + now.Format(time.RFC3339Nano),
+			LastUsedAt:      now,
+			ExpiresAt:       now.Add(time.Hour),
+			FamilyExpiresAt: now.Add(24 * time.Hour),
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+	}
+
+	first := newToken("first")
+	if err := repo.AddRefreshToken(first); err != nil {
+		t.Fatalf("AddRefreshToken failed: %v", err)
+	}
+	if first.ID == 0 || first.FamilyID != first.ID {
+		t.Fatalf("expected a new family named after the token, got id=%d family=%d", first.ID, first.FamilyID)
+	}
+
+	stored, err := repo.GetRefreshTokenByHash(first.TokenHash)
+	if err != nil || stored.FamilyID != first.ID {
+		t.Fatalf("expected stored family %d, got %+v, err=%v", first.ID, stored, err)
+	}
+
+	second := newToken("second")
+	second.FamilyID = first.FamilyID
+	if err := repo.RotateRefreshToken(stored, second); err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if second.ID == first.ID || second.FamilyID != first.ID {
+		t.Fatalf("expected rotation to stay in family %d, got id=%d family=%d", first.ID, second.ID, second.FamilyID)
+	}
+
+	if err := repo.RotateRefreshToken(stored, newToken("third")); !errors.Is(err, token.ErrTokenReused) {
+		t.Fatalf("expected reusing a rotated token to fail, got %v", err)
+	}
+
+	sessions, err := repo.ListSessions(userID)
+	if err != nil || len(sessions) != 1 || sessions[0].FamilyID != first.ID {
+		t.Fatalf("expected one session in family %d, got %+v, err=%v", first.ID, sessions, err)
+	}
+}
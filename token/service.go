@@ -2,119 +2,322 @@ package token
 
 import (
 	"crypto/rand"
-	"crypto/subtle"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/audit"
 )
 
 func CreateJWT(subject string, expires int) (string, error) {
-	var (
-		key     []byte
-		jwtoken *jwt.Token
-	)
-
-	jwtSecret := os.Getenv("JWT_SECRET")
 	now := time.Now().UTC()
 	if expires == 0 {
 		expires = 36000
 	}
 	expiresAt := now.Add(time.Duration(expires) * time.Second)
-	key = []byte(jwtSecret)
 	claims := jwt.RegisteredClaims{
 		Issuer:    "interviewer",
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		Subject:   subject,
 	}
-	jwtoken = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := jwtoken.SignedString(key)
+
+	tokenString, err := Sign(PurposeAccess, claims)
 	if err != nil {
-		log.Fatalf("Bad SignedString: %s", err)
+		log.Printf("Sign failed: %v", err)
 		return "", err
 	}
 
 	return tokenString, nil
 }
 
-func CreateRefreshToken(repo TokenRepo, userID int) (string, error) {
+// CreateImpersonationJWT issues an access token for subject that also names
+// the staff member acting as them. No refresh token goes with it, so the
+// session ends when it expires.
+func CreateImpersonationJWT(subject string, actorID int, ttl time.Duration) (string, error) {
 	now := time.Now().UTC()
+	claims := CustomClaims{
+		UserID: subject,
+		Actor:  &Actor{Subject: strconv.Itoa(actorID)},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "interviewer",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
 
-	refreshLength := 32
-	refreshBytes := make([]byte, refreshLength)
-	_, err := rand.Read([]byte(refreshBytes))
+	tokenString, err := Sign(PurposeAccess, &claims)
 	if err != nil {
+		log.Printf("Sign failed: %v", err)
 		return "", err
 	}
-	token := hex.EncodeToString(refreshBytes)
-	expiry := now.Add(time.Duration(168*60) * time.Hour)
+
+	return tokenString, nil
+}
+
+const (
+	defaultRefreshTokenTTL = 14 * 24 * time.Hour
+	defaultSessionTTL      = 90 * 24 * time.Hour
+)
+
+// RefreshTokenTTL is how long a refresh token stays valid without being
+// used, from REFRESH_TOKEN_TTL (a Go duration such as "336h").
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// SessionTTL caps the lifetime of a token family from its first login,
+// however often it is refreshed, from REFRESH_SESSION_TTL.
+func SessionTTL() time.Duration {
+	return durationFromEnv("REFRESH_SESSION_TTL", defaultSessionTTL)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// CreateRefreshToken starts a new session for the user on the described
+// device. Existing sessions are left untouched.
+func CreateRefreshToken(repo TokenRepo, userID int, info SessionInfo) (*RefreshToken, error) {
+	now := time.Now().UTC()
+
+	label := info.DeviceLabel
+	if label == "" {
+		label = DeviceLabel(info.UserAgent)
+	}
 
 	refreshToken := &RefreshToken{
-		UserID:       userID,
-		RefreshToken: token,
-		ExpiresAt:    expiry,
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		UserID:          userID,
+		DeviceLabel:     label,
+		IPAddress:       info.IPAddress,
+		UserAgent:       info.UserAgent,
+		LastUsedAt:      now,
+		FamilyExpiresAt: now.Add(SessionTTL()),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := issueRefreshToken(refreshToken, now); err != nil {
+		return nil, err
 	}
 
-	err = repo.AddRefreshToken(refreshToken)
+	err := repo.AddRefreshToken(refreshToken)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return refreshToken, nil
+}
+
+// RefreshSession exchanges a refresh token for the next one in its family.
+// Each token can be used once; presenting a token that was already rotated
+// means it was copied, so the whole family is revoked and ErrTokenReused is
+// returned. Both outcomes are written to the audit log.
+func RefreshSession(repo TokenRepo, auditRepo audit.AuditRepo, userID int, providedToken string, info SessionInfo) (*RefreshToken, error) {
+	current, err := repo.GetRefreshTokenByHash(HashRefreshToken(providedToken))
+	if err != nil {
+		return nil, err
+	}
+	if current.UserID != userID || current.RevokedAt != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	origin := audit.Origin{IPAddress: info.IPAddress, UserAgent: info.UserAgent}
+	if current.RotatedAt != nil {
+		log.Printf("refresh token reuse detected for user %d, revoking session %d", current.UserID, current.FamilyID)
+		audit.RecordUserAction(auditRepo, origin, audit.ActionTokenReused, current.UserID, map[string]any{"session_id": current.FamilyID})
+		if err := repo.RevokeFamily(current.FamilyID); err != nil {
+			log.Printf("repo.RevokeFamily failed: %v", err)
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	now := time.Now().UTC()
+	if !current.ExpiresAt.After(now) || !current.FamilyExpiresAt.After(now) {
+		return nil, ErrSessionNotFound
+	}
+
+	next := &RefreshToken{
+		FamilyID:        current.FamilyID,
+		UserID:          current.UserID,
+		DeviceLabel:     current.DeviceLabel,
+		IPAddress:       info.IPAddress,
+		UserAgent:       info.UserAgent,
+		LastUsedAt:      now,
+		FamilyExpiresAt: current.FamilyExpiresAt,
+		CreatedAt:       current.CreatedAt,
+		UpdatedAt:       now,
+	}
+	if err := issueRefreshToken(next, now); err != nil {
+		return nil, err
+	}
+
+	err = repo.RotateRefreshToken(current, next)
+	if errors.Is(err, ErrTokenReused) {
+		// Another request rotated this token first.
+		audit.RecordUserAction(auditRepo, origin, audit.ActionTokenReused, current.UserID, map[string]any{"session_id": current.FamilyID})
+		if err := repo.RevokeFamily(current.FamilyID); err != nil {
+			log.Printf("repo.RevokeFamily failed: %v", err)
+		}
+		return nil, ErrTokenReused
+	} else if err != nil {
+		log.Printf("repo.RotateRefreshToken failed: %v", err)
+		return nil, err
 	}
 
-	return refreshToken.RefreshToken, nil
+	audit.RecordUserAction(auditRepo, origin, audit.ActionTokenRefreshed, current.UserID, map[string]any{"session_id": current.FamilyID})
+	return next, nil
 }
 
-func DeleteRefreshToken(repo TokenRepo, userID int) error {
-	err := repo.DeleteRefreshToken(userID)
+// GetSession returns the live session a refresh token belongs to.
+func GetSession(repo TokenRepo, userID int, providedToken string) (*RefreshToken, error) {
+	session, err := repo.GetRefreshTokenByHash(HashRefreshToken(providedToken))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if session.UserID != userID || session.RotatedAt != nil || session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+		return nil, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+// HashRefreshToken returns the hex SHA-256 digest under which a refresh
+// token is stored.
+func HashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken generates a token value for t, expiring after the idle
+// lifetime but never later than its family.
+func issueRefreshToken(t *RefreshToken, now time.Time) error {
+	value, err := newRefreshTokenValue()
 	if err != nil {
-		log.Printf("repo.DeleteRefreshToken failed: %v", err)
 		return err
 	}
 
+	t.RefreshToken = value
+	t.TokenHash = HashRefreshToken(value)
+	t.ExpiresAt = now.Add(RefreshTokenTTL())
+	if t.ExpiresAt.After(t.FamilyExpiresAt) {
+		t.ExpiresAt = t.FamilyExpiresAt
+	}
 	return nil
 }
 
-func GetStoredRefreshToken(repo TokenRepo, userID int) (string, error) {
-	storedToken, err := repo.GetStoredRefreshToken(userID)
+func ListSessions(repo TokenRepo, userID int) ([]RefreshToken, error) {
+	sessions, err := repo.ListSessions(userID)
 	if err != nil {
-		return "", err
+		log.Printf("repo.ListSessions failed: %v", err)
+		return nil, err
 	}
-	return storedToken, nil
+
+	return sessions, nil
 }
 
-func VerifyRefreshToken(storedToken, providedToken string) bool {
-	return subtle.ConstantTimeCompare([]byte(storedToken), []byte(providedToken)) == 1
+// RevokeSession revokes every token in the session's family.
+func RevokeSession(repo TokenRepo, userID, sessionID int) error {
+	return repo.RevokeSession(userID, sessionID)
 }
 
-func ExtractUserIDFromToken(tokenString string) (int, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
+// RevokeAllSessions logs the user out everywhere.
+func RevokeAllSessions(repo TokenRepo, userID int) error {
+	err := repo.RevokeAllSessions(userID)
+	if err != nil {
+		log.Printf("repo.RevokeAllSessions failed: %v", err)
+		return err
+	}
+
+	return nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(tokenString *jwt.Token) (interface{}, error) {
-		if _, ok := tokenString.Method.(*jwt.SigningMethodHMAC); !ok {
-			return 0, fmt.Errorf("unauthorized")
+// DeviceLabel derives a readable label such as "Chrome on macOS" from a
+// user agent string.
+func DeviceLabel(userAgent string) string {
+	browser := "Unknown browser"
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
 		}
-		return []byte(jwtSecret), nil
-	})
+	}
+
+	platform := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			platform = candidate.name
+			break
+		}
+	}
+
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+func newRefreshTokenValue() (string, error) {
+	refreshBytes := make([]byte, 32)
+	if _, err := rand.Read(refreshBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(refreshBytes), nil
+}
+
+func ExtractUserIDFromToken(tokenString string) (int, error) {
+	claims, err := ParseAccessToken(tokenString)
 	if err != nil {
-		log.Printf("ParseWithClaims failed: %v", err)
 		return 0, err
 	}
 
-	claims, ok := token.Claims.(*CustomClaims)
-	if ok && token.Valid {
-		userID, err := strconv.Atoi(claims.UserID)
-		if err != nil {
-			return 0, err
-		}
+	return strconv.Atoi(claims.UserID)
+}
 
-		return userID, nil
+// ParseAccessToken verifies an access token and returns its claims.
+func ParseAccessToken(tokenString string) (*CustomClaims, error) {
+	token, err := Parse(PurposeAccess, tokenString, &CustomClaims{})
+	if err != nil {
+		log.Printf("Parse failed: %v", err)
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("unauthorized")
 	}
 
-	return 0, fmt.Errorf("unauthorized")
+	return claims, nil
 }
@@ -7,21 +7,33 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/michaelboegner/interviewer/audit"
 )
 
 func TestCreateRefreshToken(t *testing.T) {
 	tests := []struct {
-		name        string
-		userID      int
-		failRepo    bool
-		expectError bool
+		name          string
+		userID        int
+		info          SessionInfo
+		failRepo      bool
+		expectedLabel string
+		expectError   bool
 	}{
 		{
-			name:        "CreateRefreshToken_Success",
-			userID:      1,
-			expectError: false,
+			name:          "CreateRefreshToken_Success",
+			userID:        1,
+			info:          SessionInfo{IPAddress: "203.0.113.7", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Version/17.0 Mobile/15E148 Safari/604.1"},
+			expectedLabel: "Safari on iOS",
+			expectError:   false,
+		},
+		{
+			name:          "CreateRefreshToken_ExplicitLabel",
+			userID:        1,
+			info:          SessionInfo{DeviceLabel: "Work laptop", UserAgent: "curl/8.0"},
+			expectedLabel: "Work laptop",
 		},
 		{
 			name:        "CreateRefreshToken_RepoError",
@@ -42,7 +54,7 @@ func TestCreateRefreshToken(t *testing.T) {
 				repo.failRepo = true
 			}
 
-			token, err := CreateRefreshToken(repo, tc.userID)
+			session, err := CreateRefreshToken(repo, tc.userID, tc.info)
 
 			if tc.expectError && err == nil {
 				t.Fatalf("expected error but got nil")
@@ -51,37 +63,101 @@ func TestCreateRefreshToken(t *testing.T) {
 				t.Fatalf("did not expect error but got: %v", err)
 			}
 
-			if !tc.expectError && token == "" {
-				t.Errorf("expected non-empty token but got empty string")
+			if !tc.expectError {
+				if session.RefreshToken == "" || session.ID == 0 {
+					t.Errorf("expected a stored session with a token, got %+v", session)
+				}
+				if session.DeviceLabel != tc.expectedLabel {
+					t.Errorf("expected device label %q but got %q", tc.expectedLabel, session.DeviceLabel)
+				}
 			}
 		})
 	}
 }
 
-func TestGetStoredRefreshToken(t *testing.T) {
+func TestMultipleSessions(t *testing.T) {
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	repo := NewMockRepo()
+	auditRepo := audit.NewMockRepo()
+	laptop, err := CreateRefreshToken(repo, 1, SessionInfo{DeviceLabel: "Laptop"})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+	phone, err := CreateRefreshToken(repo, 1, SessionInfo{DeviceLabel: "Phone"})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+
+	refreshed, err := RefreshSession(repo, auditRepo, 1, laptop.RefreshToken, SessionInfo{IPAddress: "198.51.100.4"})
+	if err != nil {
+		t.Fatalf("RefreshSession failed: %v", err)
+	}
+	if refreshed.FamilyID != laptop.FamilyID || refreshed.RefreshToken == laptop.RefreshToken || refreshed.IPAddress != "198.51.100.4" || refreshed.DeviceLabel != "Laptop" {
+		t.Fatalf("expected laptop session to be refreshed within its family, got %+v", refreshed)
+	}
+	if _, err := RefreshSession(repo, auditRepo, 2, phone.RefreshToken, SessionInfo{}); err != ErrSessionNotFound {
+		t.Fatalf("expected another user's token to be rejected, got %v", err)
+	}
+
+	if err := RevokeSession(repo, 2, phone.FamilyID); err != ErrSessionNotFound {
+		t.Fatalf("expected revoking another user's session to fail, got %v", err)
+	}
+	if err := RevokeSession(repo, 1, phone.FamilyID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, err := RefreshSession(repo, auditRepo, 1, phone.RefreshToken, SessionInfo{}); err != ErrSessionNotFound {
+		t.Fatalf("expected revoked session to be rejected, got %v", err)
+	}
+
+	sessions, err := ListSessions(repo, 1)
+	if err != nil || len(sessions) != 1 || sessions[0].FamilyID != laptop.FamilyID {
+		t.Fatalf("expected only the laptop session to remain, got %+v, err=%v", sessions, err)
+	}
+
+	if err := RevokeAllSessions(repo, 1); err != nil {
+		t.Fatalf("RevokeAllSessions failed: %v", err)
+	}
+	sessions, _ = ListSessions(repo, 1)
+	if len(sessions) != 0 {
+		t.Fatalf("expected no sessions after logging out everywhere, got %d", len(sessions))
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
 	tests := []struct {
-		name        string
-		userID      int
-		storedToken string
-		failRepo    bool
-		expectError bool
+		name           string
+		tokenTTL       string
+		sessionTTL     string
+		reuse          bool
+		age            time.Duration
+		expectedErr    error
+		expectedAction string
 	}{
 		{
-			name:        "GetStoredRefreshToken_Success",
-			userID:      1,
-			storedToken: "abc123",
-			expectError: false,
+			name:           "Rotation_Success",
+			expectedAction: audit.ActionTokenRefreshed,
 		},
 		{
-			name:        "GetStoredRefreshToken_RepoError",
-			userID:      1,
-			failRepo:    true,
-			expectError: true,
+			name:           "Rotation_ReuseRevokesFamily",
+			reuse:          true,
+			expectedErr:    ErrTokenReused,
+			expectedAction: audit.ActionTokenReused,
 		},
 		{
-			name:        "GetStoredRefreshToken_NotFound",
-			userID:      99,
-			expectError: true,
+			name:        "Rotation_IdleTokenExpired",
+			tokenTTL:    "1h",
+			age:         2 * time.Hour,
+			expectedErr: ErrSessionNotFound,
+		},
+		{
+			name:        "Rotation_SessionLifetimeExceeded",
+			tokenTTL:    "24h",
+			sessionTTL:  "2h",
+			age:         3 * time.Hour,
+			expectedErr: ErrSessionNotFound,
 		},
 	}
 
@@ -91,65 +167,77 @@ func TestGetStoredRefreshToken(t *testing.T) {
 			log.SetOutput(&buf)
 			defer showLogsIfFail(t, tc.name, buf)
 
+			t.Setenv("REFRESH_TOKEN_TTL", tc.tokenTTL)
+			t.Setenv("REFRESH_SESSION_TTL", tc.sessionTTL)
+
 			repo := NewMockRepo()
-			if tc.failRepo {
-				repo.failRepo = true
+			auditRepo := audit.NewMockRepo()
+			first, err := CreateRefreshToken(repo, 1, SessionInfo{DeviceLabel: "Laptop"})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken failed: %v", err)
+			}
+			if stored := repo.Tokens[0]; stored.RefreshToken != "" || stored.TokenHash != HashRefreshToken(first.RefreshToken) {
+				t.Fatalf("expected only the token hash to be stored, got %+v", stored)
+			}
+			if tc.sessionTTL != "" && first.ExpiresAt.After(first.FamilyExpiresAt) {
+				t.Fatalf("expected token expiry to be capped by the session lifetime")
 			}
 
-			token, err := GetStoredRefreshToken(repo, tc.userID)
-
-			if tc.expectError && err == nil {
-				t.Fatalf("expected error but got nil")
+			presented := first.RefreshToken
+			if tc.reuse {
+				if _, err := RefreshSession(repo, auditRepo, 1, presented, SessionInfo{}); err != nil {
+					t.Fatalf("first RefreshSession failed: %v", err)
+				}
 			}
-			if !tc.expectError && err != nil {
-				t.Fatalf("did not expect error but got: %v", err)
+			if tc.age > 0 {
+				for i := range repo.Tokens {
+					repo.Tokens[i].ExpiresAt = repo.Tokens[i].ExpiresAt.Add(-tc.age)
+					repo.Tokens[i].FamilyExpiresAt = repo.Tokens[i].FamilyExpiresAt.Add(-tc.age)
+				}
 			}
 
-			if !tc.expectError {
-				expected := tc.storedToken
-				got := token
+			next, err := RefreshSession(repo, auditRepo, 1, presented, SessionInfo{})
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v but got %v", tc.expectedErr, err)
+			}
 
-				if diff := cmp.Diff(expected, got); diff != "" {
-					t.Errorf("Token mismatch (-want +got):\n%s", diff)
+			if tc.expectedErr == nil {
+				if next.FamilyID != first.FamilyID || next.ID == first.ID {
+					t.Errorf("expected a new token in the same family, got %+v", next)
+				}
+				if _, err := GetSession(repo, 1, next.RefreshToken); err != nil {
+					t.Errorf("expected the new token to be live, got %v", err)
+				}
+			}
+			if tc.expectedAction != "" {
+				last := auditRepo.Events[len(auditRepo.Events)-1]
+				if last.Action != tc.expectedAction || last.TargetUserID != 1 {
+					t.Errorf("expected audit event %s for user 1, got %+v", tc.expectedAction, last)
+				}
+			}
+			if tc.reuse {
+				for _, stored := range repo.Tokens {
+					if stored.RevokedAt == nil {
+						t.Errorf("expected every token in the family to be revoked, token %d is live", stored.ID)
+					}
 				}
 			}
 		})
 	}
 }
 
-func TestVerifyRefreshToken(t *testing.T) {
-	tests := []struct {
-		name        string
-		storedToken string
-		inputToken  string
-		expected    bool
-	}{
-		{
-			name:        "VerifyRefreshToken_Match",
-			storedToken: "abc123",
-			inputToken:  "abc123",
-			expected:    true,
-		},
-		{
-			name:        "VerifyRefreshToken_NoMatch",
-			storedToken: "abc123",
-			inputToken:  "xyz789",
-			expected:    false,
-		},
+func TestDeviceLabel(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36": "Chrome on macOS",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36 Edg/126.0":                 "Edge on Windows",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0":                                            "Firefox on Linux",
+		"": "Unknown browser",
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			var buf strings.Builder
-			log.SetOutput(&buf)
-			defer showLogsIfFail(t, tc.name, buf)
-
-			result := VerifyRefreshToken(tc.storedToken, tc.inputToken)
-
-			if result != tc.expected {
-				t.Errorf("expected %v but got %v", tc.expected, result)
-			}
-		})
+	for userAgent, expected := range tests {
+		if got := DeviceLabel(userAgent); got != expected {
+			t.Errorf("DeviceLabel(%q) = %q, want %q", userAgent, got, expected)
+		}
 	}
 }
 
@@ -212,6 +300,65 @@ func TestExtractUserIDFromToken(t *testing.T) {
 	}
 }
 
+func TestCreateImpersonationJWT(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+
+	tests := []struct {
+		name            string
+		userID          int
+		actorID         int
+		ttl             time.Duration
+		expectError     bool
+		expectedActorID int
+	}{
+		{
+			name:            "CreateImpersonationJWT_CarriesActor",
+			userID:          42,
+			actorID:         7,
+			ttl:             time.Minute,
+			expectedActorID: 7,
+		},
+		{
+			name:        "CreateImpersonationJWT_Expired",
+			userID:      42,
+			actorID:     7,
+			ttl:         -time.Minute,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			token, err := CreateImpersonationJWT(strconv.Itoa(tc.userID), tc.actorID, tc.ttl)
+			if err != nil {
+				t.Fatalf("failed to create JWT: %v", err)
+			}
+
+			claims, err := ParseAccessToken(token)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			if diff := cmp.Diff(strconv.Itoa(tc.userID), claims.UserID); diff != "" {
+				t.Errorf("UserID mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expectedActorID, claims.ActorID()); diff != "" {
+				t.Errorf("ActorID mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
 	log.SetOutput(os.Stderr)
 	if t.Failed() {
@@ -0,0 +1,222 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/outbox"
+	"github.com/michaelboegner/interviewer/token"
+)
+
+// ReactivationLinkLifetime is how long the link to restore a deleted
+// account works.
+const ReactivationLinkLifetime = time.Hour
+
+// RequestEmailChange starts moving the user to a new address. It returns
+// the pending change with a confirm token for the new address and a cancel
+// token for the old one; notices builds the emails carrying them, which are
+// queued with the change. Accounts with a password must re-enter it.
+func RequestEmailChange(repo UserRepo, auditRepo audit.AuditRepo, userID int, newEmail, password string, origin audit.Origin, notices func(change *EmailChange, confirmToken, cancelToken string) []*outbox.Email) (*EmailChange, string, string, error) {
+	account, err := repo.GetUser(userID)
+	if err != nil {
+		log.Printf("repo.GetUser failed: %v", err)
+		return nil, "", "", err
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	if address, err := mail.ParseAddress(newEmail); err != nil || address.Address != newEmail {
+		return nil, "", "", ErrInvalidEmail
+	}
+	if strings.EqualFold(newEmail, account.Email) {
+		return nil, "", "", ErrSameEmail
+	}
+
+	hasPassword, err := HasPassword(repo, userID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if hasPassword {
+		if err := CheckPassword(repo, userID, password); err != nil {
+			return nil, "", "", ErrReauthenticateFail
+		}
+	}
+
+	if _, err := repo.GetUserByEmail(newEmail); err == nil {
+		return nil, "", "", ErrDuplicateEmail
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", "", err
+	}
+
+	now := time.Now().UTC()
+	change := &EmailChange{
+		UserID:    userID,
+		OldEmail:  account.Email,
+		NewEmail:  newEmail,
+		ExpiresAt: now.Add(EmailChangeLifetime),
+		CreatedAt: now,
+	}
+	var confirmToken, cancelToken string
+	err = repo.CreateEmailChange(change, func(change *EmailChange) ([]*outbox.Email, error) {
+		var err error
+		if confirmToken, err = signEmailChangeToken(change, purposeConfirmEmailChange); err != nil {
+			return nil, err
+		}
+		if cancelToken, err = signEmailChangeToken(change, purposeCancelEmailChange); err != nil {
+			return nil, err
+		}
+
+		emails := notices(change, confirmToken, cancelToken)
+		for _, email := range emails {
+			email.UserID = change.UserID
+			email.Locale = account.Locale
+		}
+		return emails, nil
+	})
+	if err != nil {
+		log.Printf("repo.CreateEmailChange failed: %v", err)
+		return nil, "", "", err
+	}
+
+	audit.RecordUserAction(auditRepo, origin, audit.ActionEmailChangeRequested, userID, map[string]any{"email_change_id": change.ID})
+	return change, confirmToken, cancelToken, nil
+}
+
+// ConfirmEmailChange applies the change named by a confirm link.
+func ConfirmEmailChange(repo UserRepo, auditRepo audit.AuditRepo, tokenString string, origin audit.Origin) (*EmailChange, error) {
+	changeID, err := parseEmailChangeToken(tokenString, purposeConfirmEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := repo.CompleteEmailChange(changeID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	audit.RecordUserAction(auditRepo, origin, audit.ActionEmailChanged, change.UserID, map[string]any{"email_change_id": change.ID})
+	return change, nil
+}
+
+// CancelEmailChange withdraws a pending change from the link sent to the
+// old address. A change that was already confirmed cannot be cancelled.
+func CancelEmailChange(repo UserRepo, auditRepo audit.AuditRepo, tokenString string, origin audit.Origin) (*EmailChange, error) {
+	changeID, err := parseEmailChangeToken(tokenString, purposeCancelEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := repo.CancelEmailChange(changeID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	audit.RecordUserAction(auditRepo, origin, audit.ActionEmailChangeCancelled, change.UserID, map[string]any{"email_change_id": change.ID})
+	return change, nil
+}
+
+func signEmailChangeToken(change *EmailChange, purpose string) (string, error) {
+	tokenString, err := token.Sign(token.PurposeEmailChange, &EmailChangeClaims{
+		ChangeID: change.ID,
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "interviewer",
+			Subject:   strconv.Itoa(change.UserID),
+			IssuedAt:  jwt.NewNumericDate(change.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(change.ExpiresAt),
+		},
+	})
+	if err != nil {
+		log.Printf("token.Sign failed: %v", err)
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+func parseEmailChangeToken(tokenString, purpose string) (int, error) {
+	claims := &EmailChangeClaims{}
+	parsed, err := token.Parse(token.PurposeEmailChange, tokenString, claims)
+	if err != nil || !parsed.Valid || claims.Purpose != purpose || claims.ChangeID == 0 {
+		return 0, ErrEmailChangeInvalid
+	}
+
+	return claims.ChangeID, nil
+}
+
+// RequestReactivation returns the deleted account and a token for the link
+// that restores it, as long as it is still within DeletionGracePeriod.
+func RequestReactivation(repo UserRepo, email string) (*User, string, error) {
+	userID, err := repo.GetDeletedUserID(strings.TrimSpace(email))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, "", ErrNotRestorable
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	account, err := repo.GetUser(userID)
+	if err != nil {
+		log.Printf("repo.GetUser failed: %v", err)
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	if account.DeletedAt == nil || !account.DeletedAt.After(now.Add(-DeletionGracePeriod())) {
+		return nil, "", ErrNotRestorable
+	}
+
+	tokenString, err := token.Sign(token.PurposeReactivation, jwt.RegisteredClaims{
+		Issuer:    "interviewer",
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ReactivationLinkLifetime)),
+	})
+	if err != nil {
+		log.Printf("token.Sign failed: %v", err)
+		return nil, "", err
+	}
+
+	return account, tokenString, nil
+}
+
+// ReactivateAccount restores a deleted account from a reactivation link:
+// its original email comes back along with the subscription it had when
+// it was deleted. The address must not have been taken in the meantime.
+func ReactivateAccount(repo UserRepo, auditRepo audit.AuditRepo, tokenString string, origin audit.Origin) (*User, error) {
+	parsed, err := token.Parse(token.PurposeReactivation, tokenString, &jwt.RegisteredClaims{})
+	if err != nil || !parsed.Valid {
+		return nil, ErrNotRestorable
+	}
+	subject, err := parsed.Claims.GetSubject()
+	if err != nil {
+		return nil, ErrNotRestorable
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return nil, ErrNotRestorable
+	}
+
+	account, err := repo.GetUser(userID)
+	if err != nil {
+		log.Printf("repo.GetUser failed: %v", err)
+		return nil, err
+	}
+	if account.AccountStatus != AccountDeleted {
+		return nil, ErrNotRestorable
+	}
+
+	now := time.Now().UTC()
+	email := strings.TrimPrefix(account.Email, fmt.Sprintf("deleted_%d_", userID))
+	if err := repo.RestoreDeletedUser(userID, email, now.Add(-DeletionGracePeriod()), now); err != nil {
+		return nil, err
+	}
+
+	audit.RecordUserAction(auditRepo, origin, audit.ActionAccountReactivated, userID, nil)
+	return repo.GetUser(userID)
+}
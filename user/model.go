@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/outbox"
 )
 
 type Users struct {
@@ -24,8 +25,61 @@ type User struct {
 	IndividualCredits     int
 	SubscriptionCredits   int
 	AccountStatus         string
+	Role                  string
+	Locale                string
+	DeletedAt             *time.Time
 	CreatedAt             time.Time
 	UpdatedAt             time.Time
+
+	// ReferredByCode is the referral code carried in the verification token
+	// at signup. It is not stored on users.
+	ReferredByCode string
+}
+
+const (
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+	AccountDeleted   = "deleted"
+)
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Permission names one thing staff can do through the admin API.
+type Permission string
+
+const (
+	PermUsersRead        Permission = "users:read"
+	PermUsersImpersonate Permission = "users:impersonate"
+	PermUsersManage      Permission = "users:manage"
+	PermCreditsAdjust    Permission = "credits:adjust"
+	PermBillingManage    Permission = "billing:manage"
+	PermMFAReset         Permission = "mfa:reset"
+	PermAuditRead        Permission = "audit:read"
+	PermEmailsPreview    Permission = "emails:preview"
+	PermEmailsManage     Permission = "emails:manage"
+)
+
+// rolePermissions grants each role its permissions. Support staff can look
+// up accounts, sign in as a customer, issue goodwill credits and review
+// email copy; only admins change accounts, roles and billing configuration.
+var rolePermissions = map[string][]Permission{
+	RoleUser:    {},
+	RoleSupport: {PermUsersRead, PermUsersImpersonate, PermCreditsAdjust, PermEmailsPreview},
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersImpersonate,
+		PermUsersManage,
+		PermCreditsAdjust,
+		PermBillingManage,
+		PermMFAReset,
+		PermAuditRead,
+		PermEmailsPreview,
+		PermEmailsManage,
+	},
 }
 
 type EmailClaims struct {
@@ -33,9 +87,41 @@ type EmailClaims struct {
 	Username     string `json:"username"`
 	PasswordHash string `json:"password_hash"`
 	Purpose      string `json:"purpose"`
+	ReferralCode string `json:"referral_code,omitempty"`
+	Locale       string `json:"locale,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// EmailChange is a pending move to a new address. It is applied only when
+// the link sent to the new address is followed, and the old address gets a
+// link to cancel it.
+type EmailChange struct {
+	ID          int
+	UserID      int
+	OldEmail    string
+	NewEmail    string
+	ExpiresAt   time.Time
+	ConfirmedAt *time.Time
+	CancelledAt *time.Time
+	CreatedAt   time.Time
+}
+
+// EmailChangeLifetime is how long the links for an email change work.
+const EmailChangeLifetime = 24 * time.Hour
+
+// EmailChangeClaims are carried by both email change links. Purpose tells
+// the confirm link from the cancel link, which are signed with the same key.
+type EmailChangeClaims struct {
+	ChangeID int    `json:"change_id"`
+	Purpose  string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+const (
+	purposeConfirmEmailChange = "confirm_email_change"
+	purposeCancelEmailChange  = "cancel_email_change"
+)
+
 type UserRepo interface {
 	CreateUser(user *User) (int, error)
 	MarkUserDeleted(userID int) error
@@ -44,14 +130,39 @@ type UserRepo interface {
 	GetUserByEmail(email string) (*User, error)
 	GetUserByCustomerID(customerID string) (*User, error)
 	UpdatePasswordByEmail(email string, password []byte) error
-	AddCredits(userID, credits int, creditType string) error
 	UpdateSubscriptionData(userID int, status, tier, subscriptionID string, startsAt, endsAt time.Time) error
 	UpdateSubscriptionStatusData(userID int, status string) error
 	HasActiveOrCancelledSubscription(email string) (bool, error)
+	SearchUsers(query string, limit, offset int) ([]User, error)
+	UpdateRole(userID int, role string) error
+	UpdateAccountStatus(userID int, status string) error
+	UpdateLocale(userID int, locale string) error
+	// CreateEmailChange stores the change and queues the emails notices
+	// builds for it in the same transaction, so a change is never pending
+	// without its links having been sent.
+	CreateEmailChange(change *EmailChange, notices func(*EmailChange) ([]*outbox.Email, error)) error
+	// CompleteEmailChange moves the user to the new address if the change
+	// is still pending and the user still has the old one.
+	CompleteEmailChange(changeID int, at time.Time) (*EmailChange, error)
+	CancelEmailChange(changeID int, at time.Time) (*EmailChange, error)
+	// GetDeletedUserID finds a deleted, not yet purged account by the
+	// address it had before deletion.
+	GetDeletedUserID(email string) (int, error)
+	// RestoreDeletedUser reactivates an account deleted after deletedAfter,
+	// putting back its email and its subscription as it was at deletion.
+	RestoreDeletedUser(userID int, email string, deletedAfter, at time.Time) error
 }
 
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 	ErrDuplicateUser  = errors.New("duplicate user")
 	ErrAccountDeleted = errors.New("account is no longer active")
+	ErrUserNotFound   = errors.New("user not found")
+	ErrInvalidRole    = errors.New("invalid role")
+
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrSameEmail          = errors.New("new email matches the current one")
+	ErrEmailChangeInvalid = errors.New("email change link is invalid or has expired")
+	ErrNotRestorable      = errors.New("account cannot be restored")
+	ErrReauthenticateFail = errors.New("re-authentication failed")
 )
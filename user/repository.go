@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/michaelboegner/interviewer/ledger"
+	"github.com/michaelboegner/interviewer/outbox"
 )
 
 type Repository struct {
@@ -39,18 +43,25 @@ func (repo *Repository) CreateUser(user *User) (int, error) {
 		user.IndividualCredits = 0
 	}
 
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v\n", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var id int
 	insertQuery := `
-		INSERT INTO users (username, password, email, individual_credits, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (username, password, email, individual_credits, locale, created_at, updated_at) 
+		VALUES ($1, $2, $3, 0, COALESCE(NULLIF($4, ''), 'en'), $5, $6)
 		RETURNING id
 	`
 
-	err = repo.DB.QueryRow(insertQuery,
+	err = tx.QueryRow(insertQuery,
 		user.Username,
 		user.Password,
 		user.Email,
-		user.IndividualCredits,
+		user.Locale,
 		now,
 		now,
 	).Scan(&id)
@@ -70,28 +81,102 @@ func (repo *Repository) CreateUser(user *User) (int, error) {
 		return 0, err
 	}
 
+	if user.IndividualCredits > 0 {
+		_, err = ledger.Post(tx, ledger.Posting{
+			UserID:       id,
+			Amount:       user.IndividualCredits,
+			CreditType:   "individual",
+			Reason:       "Signup free interview",
+			Counterparty: ledger.AccountSignupBonus,
+		})
+		if err != nil {
+			log.Printf("ledger.Post failed: %v\n", err)
+			return 0, err
+		}
+	}
+
+	_, err = outbox.Enqueue(tx, &outbox.Email{
+		Key:       "welcome:" + strconv.Itoa(id),
+		UserID:    id,
+		Recipient: user.Email,
+		Locale:    user.Locale,
+		Template:  "welcome",
+	})
+	if err != nil {
+		log.Printf("outbox.Enqueue failed: %v\n", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v\n", err)
+		return 0, err
+	}
+
 	return id, nil
 }
 
+// MarkUserDeleted soft-deletes the user and queues the deletion
+// confirmation in the same transaction.
 func (repo *Repository) MarkUserDeleted(userID int) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var email, locale string
+	err = tx.QueryRow(`
+		SELECT email, locale FROM users
+		WHERE id = $1 AND account_status <> 'deleted'
+		FOR UPDATE
+	`, userID).Scan(&email, &locale)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		log.Printf("MarkUserDeleted lookup failed: %v\n", err)
+		return err
+	}
+
+	now := time.Now().UTC()
 	query := `
 		UPDATE users
 		SET 
 			account_status = 'deleted',
 			email = CONCAT('deleted_', id, '_', email),
-			username = CONCAT('deleted_user_', id),
-			password = '',
+			pre_deletion_subscription_id = subscription_id,
+			pre_deletion_subscription_status = subscription_status,
 			subscription_id = '',
+			subscription_status = CASE WHEN subscription_status = 'active' THEN 'cancelled' ELSE subscription_status END,
+			deleted_at = $1,
 			updated_at = $1
 		WHERE id = $2
 	`
 
-	_, err := repo.DB.Exec(query, time.Now().UTC(), userID)
+	_, err = tx.Exec(query, now, userID)
 	if err != nil {
 		log.Printf("MarkUserDeleted failed: %v\n", err)
 		return err
 	}
 
+	_, err = outbox.Enqueue(tx, &outbox.Email{
+		Key:       fmt.Sprintf("deletion_confirmation:%d:%d", userID, now.Unix()),
+		UserID:    userID,
+		Recipient: email,
+		Locale:    locale,
+		Template:  "deletion_confirmation",
+		Data:      map[string]any{"RestoreBy": now.Add(DeletionGracePeriod())},
+	})
+	if err != nil {
+		log.Printf("outbox.Enqueue failed: %v\n", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v\n", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -126,7 +211,11 @@ func (repo *Repository) GetUser(userID int) (*User, error) {
 								subscription_status, 
 								subscription_tier, 
 								subscription_id,
-								account_status
+								account_status,
+								role,
+								locale,
+								deleted_at,
+								created_at
 							FROM users 
 							WHERE id= $1`, userID).Scan(
 		&user.ID,
@@ -140,6 +229,10 @@ func (repo *Repository) GetUser(userID int) (*User, error) {
 		&user.SubscriptionTier,
 		&user.SubscriptionID,
 		&user.AccountStatus,
+		&user.Role,
+		&user.Locale,
+		&user.DeletedAt,
+		&user.CreatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -160,7 +253,8 @@ func (repo *Repository) GetUserByEmail(email string) (*User, error) {
 								username, 
 								email, 
 								subscription_tier, 
-								subscription_credits
+								subscription_credits,
+								locale
 							FROM users 
 							WHERE email= $1`, email).Scan(
 		&user.ID,
@@ -168,6 +262,7 @@ func (repo *Repository) GetUserByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.SubscriptionTier,
 		&user.SubscriptionCredits,
+		&user.Locale,
 	)
 
 	if err == sql.ErrNoRows {
@@ -225,49 +320,6 @@ func (repo *Repository) UpdatePasswordByEmail(email string, password []byte) err
 	return nil
 }
 
-func (repo *Repository) AddCredits(userID, credits int, creditType string) error {
-	var column string
-	switch creditType {
-	case "individual":
-		column = "individual_credits"
-	case "subscription":
-		column = "subscription_credits"
-	default:
-		return fmt.Errorf("invalid credit type: %s", creditType)
-	}
-
-	if credits < 0 {
-		var current int
-		err := repo.DB.QueryRow(fmt.Sprintf("SELECT %s FROM users WHERE id = $1", column), userID).Scan(&current)
-		if err != nil {
-			log.Printf("repo.DB.QueryRow failed: %v", err)
-			return fmt.Errorf("fetch current credit balance failed: %w", err)
-		}
-
-		if current < -credits {
-			credits = -current
-		}
-	}
-
-	query := fmt.Sprintf(`
-		UPDATE users
-		SET %s = %s + $1, updated_at = $2
-		WHERE id = $3 AND %s + $1 >= 0
-	`, column, column, column)
-
-	_, err := repo.DB.Exec(query,
-		credits,
-		time.Now().UTC(),
-		userID,
-	)
-	if err != nil {
-		log.Printf("AddCredits failed: %v", err)
-		return err
-	}
-
-	return nil
-}
-
 func (repo *Repository) UpdateSubscriptionData(userID int, status, tier, subscriptionID string, startsAt, endsAt time.Time) error {
 	query := `
 		UPDATE users
@@ -334,3 +386,295 @@ func (repo *Repository) HasActiveOrCancelledSubscription(email string) (bool, er
 
 	return exists, err
 }
+
+// SearchUsers matches query against email and username, or against the ID
+// when query is a number. An empty query lists the newest accounts.
+func (repo *Repository) SearchUsers(query string, limit, offset int) ([]User, error) {
+	id, err := strconv.Atoi(query)
+	if err != nil {
+		id = 0
+	}
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	rows, err := repo.DB.Query(`
+		SELECT
+			id,
+			username,
+			email,
+			individual_credits,
+			subscription_credits,
+			subscription_status,
+			subscription_tier,
+			account_status,
+			role,
+			created_at
+		FROM users
+		WHERE $1 = '' OR id = $2 OR email ILIKE $3 OR username ILIKE $3
+		ORDER BY id DESC
+		LIMIT $4 OFFSET $5
+	`, query, id, pattern, limit, offset)
+	if err != nil {
+		log.Printf("SearchUsers failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.IndividualCredits,
+			&user.SubscriptionCredits,
+			&user.SubscriptionStatus,
+			&user.SubscriptionTier,
+			&user.AccountStatus,
+			&user.Role,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("rows.Scan failed: %v", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (repo *Repository) UpdateRole(userID int, role string) error {
+	result, err := repo.DB.Exec(`
+		UPDATE users
+		SET role = $1, updated_at = $2
+		WHERE id = $3
+	`, role, time.Now().UTC(), userID)
+	if err != nil {
+		log.Printf("UpdateRole failed: %v", err)
+		return err
+	}
+
+	return requireRow(result)
+}
+
+func (repo *Repository) UpdateLocale(userID int, locale string) error {
+	result, err := repo.DB.Exec(`
+		UPDATE users
+		SET locale = $1, updated_at = $2
+		WHERE id = $3
+	`, locale, time.Now().UTC(), userID)
+	if err != nil {
+		log.Printf("UpdateLocale failed: %v", err)
+		return err
+	}
+
+	return requireRow(result)
+}
+
+// UpdateAccountStatus never touches deleted accounts, which cannot be
+// restored.
+func (repo *Repository) UpdateAccountStatus(userID int, status string) error {
+	result, err := repo.DB.Exec(`
+		UPDATE users
+		SET account_status = $1, updated_at = $2
+		WHERE id = $3 AND account_status <> 'deleted'
+	`, status, time.Now().UTC(), userID)
+	if err != nil {
+		log.Printf("UpdateAccountStatus failed: %v", err)
+		return err
+	}
+
+	return requireRow(result)
+}
+
+// CreateEmailChange stores a new pending change and cancels any older one
+// for the same user, so only the latest links work.
+func (repo *Repository) CreateEmailChange(change *EmailChange, notices func(*EmailChange) ([]*outbox.Email, error)) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE email_change_requests
+		SET cancelled_at = $1
+		WHERE user_id = $2 AND confirmed_at IS NULL AND cancelled_at IS NULL
+	`, change.CreatedAt, change.UserID)
+	if err != nil {
+		log.Printf("CreateEmailChange cancel pending failed: %v", err)
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO email_change_requests (user_id, old_email, new_email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, change.UserID, change.OldEmail, change.NewEmail, change.ExpiresAt, change.CreatedAt).Scan(&change.ID)
+	if err != nil {
+		log.Printf("CreateEmailChange failed: %v", err)
+		return err
+	}
+
+	emails, err := notices(change)
+	if err != nil {
+		return err
+	}
+	for _, email := range emails {
+		if _, err := outbox.Enqueue(tx, email); err != nil {
+			log.Printf("outbox.Enqueue failed: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (repo *Repository) CompleteEmailChange(changeID int, at time.Time) (*EmailChange, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		log.Printf("repo.DB.Begin failed: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	change, err := scanEmailChange(tx.QueryRow(`
+		UPDATE email_change_requests
+		SET confirmed_at = $1
+		WHERE id = $2 AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > $1
+		RETURNING id, user_id, old_email, new_email, expires_at, confirmed_at, cancelled_at, created_at
+	`, at, changeID))
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailChangeInvalid
+	} else if err != nil {
+		log.Printf("CompleteEmailChange failed: %v", err)
+		return nil, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users
+		SET email = $1, updated_at = $2
+		WHERE id = $3 AND email = $4 AND account_status = 'active'
+	`, change.NewEmail, at, change.UserID, change.OldEmail)
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return nil, ErrDuplicateEmail
+		}
+		log.Printf("CompleteEmailChange update user failed: %v", err)
+		return nil, err
+	}
+	if err := requireRow(result); err != nil {
+		return nil, ErrEmailChangeInvalid
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("tx.Commit failed: %v", err)
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func (repo *Repository) CancelEmailChange(changeID int, at time.Time) (*EmailChange, error) {
+	change, err := scanEmailChange(repo.DB.QueryRow(`
+		UPDATE email_change_requests
+		SET cancelled_at = $1
+		WHERE id = $2 AND confirmed_at IS NULL AND cancelled_at IS NULL
+		RETURNING id, user_id, old_email, new_email, expires_at, confirmed_at, cancelled_at, created_at
+	`, at, changeID))
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailChangeInvalid
+	} else if err != nil {
+		log.Printf("CancelEmailChange failed: %v", err)
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func (repo *Repository) GetDeletedUserID(email string) (int, error) {
+	var id int
+	err := repo.DB.QueryRow(`
+		SELECT id
+		FROM users
+		WHERE account_status = 'deleted' AND purged_at IS NULL AND email = CONCAT('deleted_', id, '_', $1::text)
+	`, email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		log.Printf("GetDeletedUserID failed: %v", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// RestoreDeletedUser puts the subscription back as it was at deletion. A
+// subscription whose paid period ended in the meantime comes back expired.
+func (repo *Repository) RestoreDeletedUser(userID int, email string, deletedAfter, at time.Time) error {
+	result, err := repo.DB.Exec(`
+		UPDATE users
+		SET
+			account_status = 'active',
+			email = $1,
+			subscription_id = pre_deletion_subscription_id,
+			subscription_status = CASE
+				WHEN pre_deletion_subscription_status IN ('active', 'cancelled') AND subscription_end_date < $2 THEN 'expired'
+				ELSE COALESCE(NULLIF(pre_deletion_subscription_status, ''), subscription_status)
+			END,
+			pre_deletion_subscription_id = '',
+			pre_deletion_subscription_status = '',
+			deleted_at = NULL,
+			updated_at = $2
+		WHERE id = $3 AND account_status = 'deleted' AND purged_at IS NULL AND deleted_at > $4
+	`, email, at, userID, deletedAfter)
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code == "23505" {
+			return ErrDuplicateEmail
+		}
+		log.Printf("RestoreDeletedUser failed: %v", err)
+		return err
+	}
+
+	if err := requireRow(result); err != nil {
+		return ErrNotRestorable
+	}
+
+	return nil
+}
+
+func scanEmailChange(row interface{ Scan(dest ...any) error }) (*EmailChange, error) {
+	var change EmailChange
+	err := row.Scan(
+		&change.ID,
+		&change.UserID,
+		&change.OldEmail,
+		&change.NewEmail,
+		&change.ExpiresAt,
+		&change.ConfirmedAt,
+		&change.CancelledAt,
+		&change.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+func requireRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("RowsAffected failed: %v", err)
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
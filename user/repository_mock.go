@@ -1,18 +1,28 @@
 package user
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/michaelboegner/interviewer/outbox"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type MockRepo struct {
 	Users              map[int]User
+	EmailChanges       map[int]EmailChange
+	Emails             []*outbox.Email
 	failRepo           bool
 	FailGetUserByEmail bool
-	FailAddCredits     bool
+	// OnlyListedUsers makes GetUserByEmail fail for addresses not in Users
+	// instead of returning the default test user.
+	OnlyListedUsers bool
 }
 
 var (
@@ -27,7 +37,8 @@ func NewMockRepo() *MockRepo {
 	}
 
 	return &MockRepo{
-		Users: map[int]User{},
+		Users:        map[int]User{},
+		EmailChanges: map[int]EmailChange{},
 	}
 }
 
@@ -44,6 +55,13 @@ func (m *MockRepo) MarkUserDeleted(userID int) error {
 		return errors.New("mocked DB failure")
 	}
 
+	if u, ok := m.Users[userID]; ok && u.AccountStatus != AccountDeleted {
+		now := time.Now().UTC()
+		u.AccountStatus = AccountDeleted
+		u.Email = fmt.Sprintf("deleted_%d_%s", u.ID, u.Email)
+		u.DeletedAt = &now
+		m.Users[userID] = u
+	}
 	return nil
 }
 
@@ -52,6 +70,10 @@ func (m *MockRepo) GetUser(userID int) (*User, error) {
 		return nil, errors.New("mocked DB failure")
 	}
 
+	if u, ok := m.Users[userID]; ok {
+		return &u, nil
+	}
+
 	mockUser := &User{
 		ID:            1,
 		Username:      "test",
@@ -79,6 +101,15 @@ func (m *MockRepo) GetUserByEmail(email string) (*User, error) {
 		return nil, errors.New("mocked DB failure")
 	}
 
+	for _, u := range m.Users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	if m.OnlyListedUsers {
+		return nil, sql.ErrNoRows
+	}
+
 	mockUser := &User{
 		ID:       1,
 		Username: "test",
@@ -112,10 +143,15 @@ func (m *MockRepo) UpdatePasswordByEmail(email string, password []byte) error {
 	return nil
 }
 
-func (m *MockRepo) AddCredits(userID, credits int, creditType string) error {
-	if m.FailAddCredits {
-		return errors.New("mocked AddCredits failure")
+func (m *MockRepo) UpdateSubscriptionData(userID int, status, tier, subscriptionID string, startsAt, endsAt time.Time) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
 	}
+
+	return nil
+}
+
+func (m *MockRepo) UpdateSubscriptionStatusData(userID int, status string) error {
 	if m.failRepo {
 		return errors.New("mocked DB failure")
 	}
@@ -123,26 +159,175 @@ func (m *MockRepo) AddCredits(userID, credits int, creditType string) error {
 	return nil
 }
 
-func (m *MockRepo) UpdateSubscriptionData(userID int, status, tier, subscriptionID string, startsAt, endsAt time.Time) error {
+func (m *MockRepo) HasActiveOrCancelledSubscription(email string) (bool, error) {
+	if m.failRepo {
+		return false, errors.New("mocked DB failure")
+	}
+
+	return true, nil
+}
+
+func (m *MockRepo) SearchUsers(query string, limit, offset int) ([]User, error) {
+	if m.failRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	users := []User{}
+	for _, u := range m.Users {
+		if query == "" || strconv.Itoa(u.ID) == query ||
+			strings.Contains(strings.ToLower(u.Email), strings.ToLower(query)) ||
+			strings.Contains(strings.ToLower(u.Username), strings.ToLower(query)) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID > users[j].ID })
+
+	if offset >= len(users) {
+		return []User{}, nil
+	}
+	users = users[offset:]
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *MockRepo) UpdateRole(userID int, role string) error {
 	if m.failRepo {
 		return errors.New("mocked DB failure")
 	}
 
+	u, ok := m.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Role = role
+	m.Users[userID] = u
 	return nil
 }
 
-func (m *MockRepo) UpdateSubscriptionStatusData(userID int, status string) error {
+func (m *MockRepo) UpdateLocale(userID int, locale string) error {
 	if m.failRepo {
 		return errors.New("mocked DB failure")
 	}
 
+	u, ok := m.Users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Locale = locale
+	m.Users[userID] = u
 	return nil
 }
 
-func (m *MockRepo) HasActiveOrCancelledSubscription(email string) (bool, error) {
+func (m *MockRepo) UpdateAccountStatus(userID int, status string) error {
 	if m.failRepo {
-		return false, errors.New("mocked DB failure")
+		return errors.New("mocked DB failure")
 	}
 
-	return true, nil
+	u, ok := m.Users[userID]
+	if !ok || u.AccountStatus == AccountDeleted {
+		return ErrUserNotFound
+	}
+	u.AccountStatus = status
+	m.Users[userID] = u
+	return nil
+}
+
+func (m *MockRepo) CreateEmailChange(change *EmailChange, notices func(*EmailChange) ([]*outbox.Email, error)) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	change.ID = len(m.EmailChanges) + 1
+	emails, err := notices(change)
+	if err != nil {
+		change.ID = 0
+		return err
+	}
+	m.Emails = append(m.Emails, emails...)
+
+	for id, pending := range m.EmailChanges {
+		if pending.UserID == change.UserID && pending.ConfirmedAt == nil && pending.CancelledAt == nil {
+			pending.CancelledAt = &change.CreatedAt
+			m.EmailChanges[id] = pending
+		}
+	}
+	m.EmailChanges[change.ID] = *change
+	return nil
+}
+
+func (m *MockRepo) CompleteEmailChange(changeID int, at time.Time) (*EmailChange, error) {
+	if m.failRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	change, ok := m.EmailChanges[changeID]
+	if !ok || change.ConfirmedAt != nil || change.CancelledAt != nil || !change.ExpiresAt.After(at) {
+		return nil, ErrEmailChangeInvalid
+	}
+	u, ok := m.Users[change.UserID]
+	if !ok || u.Email != change.OldEmail || u.AccountStatus != AccountActive {
+		return nil, ErrEmailChangeInvalid
+	}
+	for _, other := range m.Users {
+		if other.Email == change.NewEmail {
+			return nil, ErrDuplicateEmail
+		}
+	}
+
+	u.Email = change.NewEmail
+	m.Users[u.ID] = u
+	change.ConfirmedAt = &at
+	m.EmailChanges[changeID] = change
+	return &change, nil
+}
+
+func (m *MockRepo) CancelEmailChange(changeID int, at time.Time) (*EmailChange, error) {
+	if m.failRepo {
+		return nil, errors.New("mocked DB failure")
+	}
+
+	change, ok := m.EmailChanges[changeID]
+	if !ok || change.ConfirmedAt != nil || change.CancelledAt != nil {
+		return nil, ErrEmailChangeInvalid
+	}
+	change.CancelledAt = &at
+	m.EmailChanges[changeID] = change
+	return &change, nil
+}
+
+func (m *MockRepo) GetDeletedUserID(email string) (int, error) {
+	if m.failRepo {
+		return 0, errors.New("mocked DB failure")
+	}
+
+	for _, u := range m.Users {
+		if u.AccountStatus == AccountDeleted && u.Email == fmt.Sprintf("deleted_%d_%s", u.ID, email) {
+			return u.ID, nil
+		}
+	}
+	return 0, ErrUserNotFound
+}
+
+func (m *MockRepo) RestoreDeletedUser(userID int, email string, deletedAfter, at time.Time) error {
+	if m.failRepo {
+		return errors.New("mocked DB failure")
+	}
+
+	u, ok := m.Users[userID]
+	if !ok || u.AccountStatus != AccountDeleted || u.DeletedAt == nil || !u.DeletedAt.After(deletedAfter) {
+		return ErrNotRestorable
+	}
+	for _, other := range m.Users {
+		if other.Email == email {
+			return ErrDuplicateEmail
+		}
+	}
+
+	u.AccountStatus = AccountActive
+	u.Email = email
+	u.DeletedAt = nil
+	m.Users[userID] = u
+	return nil
 }
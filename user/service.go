@@ -1,18 +1,21 @@
 package user
 
 import (
+	"database/sql"
 	"errors"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/michaelboegner/interviewer/audit"
 	"github.com/michaelboegner/interviewer/token"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func VerificationToken(email, username, password string) (string, error) {
+func VerificationToken(email, username, password, referralCode, locale string) (string, error) {
 	passwordHashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
 	if err != nil {
 		return "", err
@@ -23,18 +26,17 @@ func VerificationToken(email, username, password string) (string, error) {
 		"username":      username,
 		"password_hash": string(passwordHashed),
 		"purpose":       "verify_email",
+		"referral_code": referralCode,
+		"locale":        locale,
 		"exp":           time.Now().Add(15 * time.Minute).Unix(),
 	}
 
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
-		SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return token.Sign(token.PurposeEmailVerification, claims)
 }
 
 func CreateUser(repo UserRepo, tokenStr string) (*User, error) {
 	claims := &EmailClaims{}
-	tkn, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	tkn, err := token.Parse(token.PurposeEmailVerification, tokenStr, claims)
 	if err != nil || !tkn.Valid {
 		return nil, errors.New("invalid or expired token")
 	}
@@ -50,6 +52,8 @@ func CreateUser(repo UserRepo, tokenStr string) (*User, error) {
 		IndividualCredits: 1,
 		CreatedAt:         time.Now().UTC(),
 		UpdatedAt:         time.Now().UTC(),
+		ReferredByCode:    claims.ReferralCode,
+		Locale:            claims.Locale,
 	}
 
 	id, err := repo.CreateUser(user)
@@ -61,33 +65,56 @@ func CreateUser(repo UserRepo, tokenStr string) (*User, error) {
 }
 
 func LoginUser(repo UserRepo, email, password string) (string, string, int, error) {
-	userID, hashedPassword, err := repo.GetPasswordandID(email)
+	user, err := Authenticate(repo, email, password)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	jwToken, err := token.CreateJWT(strconv.Itoa(user.ID), 0)
 	if err != nil {
+		log.Printf("JWT creation failed: %v", err)
 		return "", "", 0, err
 	}
 
+	return jwToken, user.Username, user.ID, nil
+}
+
+// Authenticate checks an email and password without issuing any tokens, for
+// flows that need a further step before the user is logged in.
+func Authenticate(repo UserRepo, email, password string) (*User, error) {
+	userID, hashedPassword, err := repo.GetPasswordandID(email)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := repo.GetUser(userID)
 	if err != nil {
 		log.Printf("repo.GetUser failed: %v", err)
-		return "", "", 0, err
+		return nil, err
 	}
 
 	if user.AccountStatus != "active" {
-		return "", "", 0, ErrAccountDeleted
+		return nil, ErrAccountDeleted
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err != nil {
-		return "", "", 0, err
+		return nil, err
 	}
 
-	jwToken, err := token.CreateJWT(strconv.Itoa(userID), 0)
+	user.ID = userID
+	return user, nil
+}
+
+// CheckPassword re-authenticates a signed-in user before a sensitive change.
+func CheckPassword(repo UserRepo, userID int, password string) error {
+	user, err := repo.GetUser(userID)
 	if err != nil {
-		log.Printf("JWT creation failed: %v", err)
-		return "", "", 0, err
+		return err
 	}
 
-	return jwToken, user.Username, userID, nil
+	_, err = Authenticate(repo, user.Email, password)
+	return err
 }
 
 func GetUser(repo UserRepo, userID int) (*User, error) {
@@ -99,13 +126,32 @@ func GetUser(repo UserRepo, userID int) (*User, error) {
 	return userReturned, nil
 }
 
-func MarkUserDeleted(repo UserRepo, userId int) error {
+const defaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+// DeletionGracePeriod is how long a deleted account is kept before its data
+// is purged, from ACCOUNT_DELETION_GRACE_PERIOD (a Go duration such as
+// "720h").
+func DeletionGracePeriod() time.Duration {
+	value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if value == "" {
+		return defaultDeletionGracePeriod
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("invalid ACCOUNT_DELETION_GRACE_PERIOD %q, using %s", value, defaultDeletionGracePeriod)
+		return defaultDeletionGracePeriod
+	}
+	return duration
+}
+
+func MarkUserDeleted(repo UserRepo, auditRepo audit.AuditRepo, userId int, origin audit.Origin) error {
 	err := repo.MarkUserDeleted(userId)
 	if err != nil {
 		log.Printf("repo.DeleteUser failed: %v", err)
 		return err
 	}
 
+	audit.RecordUserAction(auditRepo, origin, audit.ActionAccountDeleted, userId, nil)
 	return nil
 }
 
@@ -119,23 +165,30 @@ func GetUserByEmail(repo UserRepo, email string) error {
 	return nil
 }
 
-func RequestPasswordReset(repo UserRepo, email string) (string, error) {
+func RequestPasswordReset(repo UserRepo, auditRepo audit.AuditRepo, email string, origin audit.Origin) (string, error) {
 	user, err := repo.GetUserByEmail(email)
 	if err != nil {
 		log.Printf("GetUserByEmail failed: %v", err)
 		return "", err
 	}
 
-	resetJWT, err := token.CreateJWT(user.Email, 900)
+	now := time.Now().UTC()
+	resetJWT, err := token.Sign(token.PurposePasswordReset, jwt.RegisteredClaims{
+		Issuer:    "interviewer",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(15 * time.Minute)),
+		Subject:   user.Email,
+	})
 	if err != nil {
-		log.Printf("CreateJWT failed: %v", err)
+		log.Printf("token.Sign failed: %v", err)
 		return "", err
 	}
 
+	audit.RecordUserAction(auditRepo, origin, audit.ActionPasswordResetRequested, user.ID, nil)
 	return resetJWT, nil
 }
 
-func ResetPassword(repo UserRepo, newPassword string, resetJWT string) error {
+func ResetPassword(repo UserRepo, auditRepo audit.AuditRepo, newPassword string, resetJWT string, origin audit.Origin) error {
 	email, err := verifyResetToken(resetJWT)
 	if err != nil {
 		return err
@@ -153,40 +206,32 @@ func ResetPassword(repo UserRepo, newPassword string, resetJWT string) error {
 		return err
 	}
 
+	if account, err := repo.GetUserByEmail(email); err == nil {
+		audit.RecordUserAction(auditRepo, origin, audit.ActionPasswordResetCompleted, account.ID, nil)
+	}
 	return nil
 }
 
 func verifyResetToken(tokenString string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Printf("JWT secret is not set")
-		err := errors.New("jwt secret is not set")
-		return "", err
-	}
-
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+	resetToken, err := token.Parse(token.PurposePasswordReset, tokenString, &jwt.RegisteredClaims{})
 	if err != nil {
 		return "", err
 	}
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
+	if claims, ok := resetToken.Claims.(*jwt.RegisteredClaims); ok && resetToken.Valid {
 		return claims.Subject, nil
 	} else {
 		return "", errors.New("invalid token")
 	}
 }
 
-func GetOrCreateByEmail(repo UserRepo, email, username string) (*User, error) {
-	user, err := repo.GetUserByEmail(email)
-	if err == nil {
-		return user, nil
-	}
-
+// CreateExternalUser creates an account for someone signing in through an
+// external identity provider. It has no usable password until one is set
+// through the reset flow.
+func CreateExternalUser(repo UserRepo, email, username string) (*User, error) {
 	newUser := &User{
 		Email:             email,
 		Username:          username,
-		Password:          []byte("github_login"),
+		Password:          []byte("external_login"),
 		AccountStatus:     "active",
 		IndividualCredits: 1,
 		CreatedAt:         time.Now().UTC(),
@@ -202,3 +247,78 @@ func GetOrCreateByEmail(repo UserRepo, email, username string) (*User, error) {
 	newUser.ID = id
 	return newUser, nil
 }
+
+// HasPassword reports whether the user can sign in with a password, as
+// opposed to an account created through an external provider.
+func HasPassword(repo UserRepo, userID int) (bool, error) {
+	user, err := repo.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	_, hashedPassword, err := repo.GetPasswordandID(user.Email)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = bcrypt.Cost([]byte(hashedPassword))
+	return err == nil, nil
+}
+
+// Can reports whether the user's role grants the permission. Unknown roles
+// grant nothing.
+func (u *User) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[u.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func SearchUsers(repo UserRepo, query string, limit, offset int) ([]User, error) {
+	users, err := repo.SearchUsers(strings.TrimSpace(query), limit, offset)
+	if err != nil {
+		log.Printf("repo.SearchUsers failed: %v", err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func ChangeRole(repo UserRepo, userID int, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	return repo.UpdateRole(userID, role)
+}
+
+// Deactivate suspends an account. A suspended user cannot log in or use
+// existing tokens until the account is reactivated.
+func Deactivate(repo UserRepo, userID int) error {
+	return setAccountStatus(repo, userID, AccountSuspended)
+}
+
+func Reactivate(repo UserRepo, userID int) error {
+	return setAccountStatus(repo, userID, AccountActive)
+}
+
+func setAccountStatus(repo UserRepo, userID int, status string) error {
+	account, err := repo.GetUser(userID)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if account.AccountStatus == AccountDeleted {
+		return ErrAccountDeleted
+	}
+
+	return repo.UpdateAccountStatus(userID, status)
+}
@@ -1,23 +1,30 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/michaelboegner/interviewer/audit"
+	"github.com/michaelboegner/interviewer/outbox"
 	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCreateUser(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
 	tests := []struct {
 		name        string
 		username    string
 		email       string
 		password    string
+		locale      string
 		user        *User
 		failRepo    bool
 		expectError bool
@@ -35,6 +42,21 @@ func TestCreateUser(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name:     "CreateUser_KeepsLocale",
+			username: "test",
+			email:    "test@test.com",
+			password: "test",
+			locale:   "es",
+			user: &User{
+				ID:                1,
+				Username:          "test",
+				Email:             "test@test.com",
+				IndividualCredits: 1,
+				Locale:            "es",
+			},
+			expectError: false,
+		},
 		{
 			name:        "CreateUser_RepoError",
 			username:    "test",
@@ -55,7 +77,7 @@ func TestCreateUser(t *testing.T) {
 			repo := NewMockRepo()
 			repo.failRepo = tc.failRepo
 
-			jwt, err := VerificationToken(tc.email, tc.username, tc.password)
+			jwt, err := VerificationToken(tc.email, tc.username, tc.password, "", tc.locale)
 			if err != nil {
 				t.Fatalf("VerificationToken failed: %v", err)
 			}
@@ -87,6 +109,8 @@ func TestCreateUser(t *testing.T) {
 }
 
 func TestLoginUser(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
 	tests := []struct {
 		name        string
 		email       string
@@ -260,9 +284,410 @@ func TestUpdateSubscription(t *testing.T) {
 	}
 }
 
+func TestPasswordResetAudit(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer showLogsIfFail(t, t.Name(), buf)
+
+	repo := NewMockRepo()
+	repo.Users[4] = User{ID: 4, Email: "reset@test.com", AccountStatus: AccountActive}
+	auditRepo := audit.NewMockRepo()
+	origin := audit.Origin{IPAddress: "203.0.113.9", UserAgent: "test"}
+
+	resetJWT, err := RequestPasswordReset(repo, auditRepo, "reset@test.com", origin)
+	if err != nil {
+		t.Fatalf("RequestPasswordReset failed: %v", err)
+	}
+	if err := ResetPassword(repo, auditRepo, "new-password", resetJWT, origin); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+
+	actions := []string{}
+	for _, event := range auditRepo.Events {
+		if event.TargetUserID != 4 || event.IPAddress != origin.IPAddress {
+			t.Errorf("unexpected event %+v", event)
+		}
+		actions = append(actions, event.Action)
+	}
+	expected := []string{audit.ActionPasswordResetRequested, audit.ActionPasswordResetCompleted}
+	if diff := cmp.Diff(expected, actions); diff != "" {
+		t.Errorf("Actions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		permission Permission
+		expected   bool
+	}{
+		{name: "Can_UserHasNoStaffPermissions", role: RoleUser, permission: PermUsersRead, expected: false},
+		{name: "Can_SupportReadsUsers", role: RoleSupport, permission: PermUsersRead, expected: true},
+		{name: "Can_SupportAdjustsCredits", role: RoleSupport, permission: PermCreditsAdjust, expected: true},
+		{name: "Can_SupportCannotManageUsers", role: RoleSupport, permission: PermUsersManage, expected: false},
+		{name: "Can_AdminManagesBilling", role: RoleAdmin, permission: PermBillingManage, expected: true},
+		{name: "Can_UnknownRole", role: "owner", permission: PermUsersRead, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			account := &User{Role: tc.role}
+			if got := account.Can(tc.permission); got != tc.expected {
+				t.Errorf("Can(%q) for role %q = %v, want %v", tc.permission, tc.role, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestChangeRole(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int
+		role          string
+		expectedError error
+		expectedRole  string
+	}{
+		{
+			name:         "ChangeRole_Success",
+			userID:       1,
+			role:         RoleSupport,
+			expectedRole: RoleSupport,
+		},
+		{
+			name:          "ChangeRole_InvalidRole",
+			userID:        1,
+			role:          "owner",
+			expectedError: ErrInvalidRole,
+			expectedRole:  RoleUser,
+		},
+		{
+			name:          "ChangeRole_UserNotFound",
+			userID:        2,
+			role:          RoleAdmin,
+			expectedError: ErrUserNotFound,
+			expectedRole:  RoleUser,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Users[1] = User{ID: 1, Email: "test@test.com", Role: RoleUser, AccountStatus: AccountActive}
+
+			err := ChangeRole(repo, tc.userID, tc.role)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tc.expectedRole, repo.Users[1].Role); diff != "" {
+				t.Errorf("Role mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAccountStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		initialStatus  string
+		deactivate     bool
+		expectedError  error
+		expectedStatus string
+	}{
+		{
+			name:           "Deactivate_Success",
+			initialStatus:  AccountActive,
+			deactivate:     true,
+			expectedStatus: AccountSuspended,
+		},
+		{
+			name:           "Reactivate_Success",
+			initialStatus:  AccountSuspended,
+			expectedStatus: AccountActive,
+		},
+		{
+			name:           "Reactivate_DeletedAccount",
+			initialStatus:  AccountDeleted,
+			expectedError:  ErrAccountDeleted,
+			expectedStatus: AccountDeleted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Users[1] = User{ID: 1, Email: "test@test.com", Role: RoleUser, AccountStatus: tc.initialStatus}
+
+			var err error
+			if tc.deactivate {
+				err = Deactivate(repo, 1)
+			} else {
+				err = Reactivate(repo, 1)
+			}
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+
+			if diff := cmp.Diff(tc.expectedStatus, repo.Users[1].AccountStatus); diff != "" {
+				t.Errorf("AccountStatus mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		expectedIDs []int
+	}{
+		{name: "SearchUsers_Empty", query: "", expectedIDs: []int{3, 2, 1}},
+		{name: "SearchUsers_ByEmail", query: " Example.com ", expectedIDs: []int{3, 1}},
+		{name: "SearchUsers_ByID", query: "2", expectedIDs: []int{2}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Users[1] = User{ID: 1, Username: "alice", Email: "alice@example.com"}
+			repo.Users[2] = User{ID: 2, Username: "bob", Email: "bob@test.com"}
+			repo.Users[3] = User{ID: 3, Username: "carol", Email: "carol@example.com"}
+
+			users, err := SearchUsers(repo, tc.query, 50, 0)
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+
+			ids := []int{}
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			if diff := cmp.Diff(tc.expectedIDs, ids); diff != "" {
+				t.Errorf("IDs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func showLogsIfFail(t *testing.T, name string, buf strings.Builder) {
 	log.SetOutput(os.Stderr)
 	if t.Failed() {
 		fmt.Printf("---- logs for test: %s ----\n%s\n", name, buf.String())
 	}
 }
+
+func TestEmailChange(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	tests := []struct {
+		name                 string
+		newEmail             string
+		password             string
+		cancelFirst          bool
+		expectedRequestError error
+		expectedConfirmError error
+		expectedEmail        string
+	}{
+		{
+			name:          "EmailChange_Confirmed",
+			newEmail:      "new@test.com",
+			password:      "test",
+			expectedEmail: "new@test.com",
+		},
+		{
+			name:                 "EmailChange_CancelledFromOldAddress",
+			newEmail:             "new@test.com",
+			password:             "test",
+			cancelFirst:          true,
+			expectedConfirmError: ErrEmailChangeInvalid,
+			expectedEmail:        "old@test.com",
+		},
+		{
+			name:                 "EmailChange_WrongPassword",
+			newEmail:             "new@test.com",
+			password:             "wrong",
+			expectedRequestError: ErrReauthenticateFail,
+			expectedEmail:        "old@test.com",
+		},
+		{
+			name:                 "EmailChange_SameEmail",
+			newEmail:             "OLD@test.com",
+			password:             "test",
+			expectedRequestError: ErrSameEmail,
+			expectedEmail:        "old@test.com",
+		},
+		{
+			name:                 "EmailChange_InvalidEmail",
+			newEmail:             "Someone <new@test.com>",
+			password:             "test",
+			expectedRequestError: ErrInvalidEmail,
+			expectedEmail:        "old@test.com",
+		},
+		{
+			name:                 "EmailChange_AddressTaken",
+			newEmail:             "taken@test.com",
+			password:             "test",
+			expectedRequestError: ErrDuplicateEmail,
+			expectedEmail:        "old@test.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.OnlyListedUsers = true
+			repo.Users[1] = User{ID: 1, Email: "old@test.com", AccountStatus: AccountActive}
+			repo.Users[2] = User{ID: 2, Email: "taken@test.com", AccountStatus: AccountActive}
+			auditRepo := audit.NewMockRepo()
+			origin := audit.Origin{IPAddress: "203.0.113.9"}
+
+			notices := func(change *EmailChange, confirmToken, cancelToken string) []*outbox.Email {
+				return []*outbox.Email{
+					{Recipient: change.NewEmail, Data: map[string]any{"Token": confirmToken}},
+					{Recipient: change.OldEmail, Data: map[string]any{"Token": cancelToken}},
+				}
+			}
+
+			change, confirmToken, cancelToken, err := RequestEmailChange(repo, auditRepo, 1, tc.newEmail, tc.password, origin, notices)
+			if !errors.Is(err, tc.expectedRequestError) {
+				t.Fatalf("expected request error %v, got %v", tc.expectedRequestError, err)
+			}
+
+			if err != nil && len(repo.Emails) != 0 {
+				t.Errorf("expected no emails for a refused change, got %d", len(repo.Emails))
+			}
+			if err == nil {
+				if change.OldEmail != "old@test.com" || change.NewEmail != tc.newEmail {
+					t.Errorf("unexpected change %+v", change)
+				}
+				// The links are queued with the change itself.
+				if len(repo.Emails) != 2 || repo.Emails[0].Data["Token"] != confirmToken || repo.Emails[1].Data["Token"] != cancelToken || repo.Emails[0].UserID != 1 {
+					t.Errorf("expected confirm and cancel emails for user 1, got %+v", repo.Emails)
+				}
+				// Each link only works for its own purpose.
+				if _, err := CancelEmailChange(repo, auditRepo, confirmToken, origin); !errors.Is(err, ErrEmailChangeInvalid) {
+					t.Errorf("confirm token cancelled the change: %v", err)
+				}
+				if tc.cancelFirst {
+					if _, err := CancelEmailChange(repo, auditRepo, cancelToken, origin); err != nil {
+						t.Fatalf("CancelEmailChange failed: %v", err)
+					}
+				}
+				if _, err := ConfirmEmailChange(repo, auditRepo, confirmToken, origin); !errors.Is(err, tc.expectedConfirmError) {
+					t.Errorf("expected confirm error %v, got %v", tc.expectedConfirmError, err)
+				}
+				if _, err := ConfirmEmailChange(repo, auditRepo, confirmToken, origin); !errors.Is(err, ErrEmailChangeInvalid) {
+					t.Errorf("confirm link worked twice: %v", err)
+				}
+			}
+
+			if email := repo.Users[1].Email; email != tc.expectedEmail {
+				t.Errorf("expected email %q, got %q", tc.expectedEmail, email)
+			}
+		})
+	}
+}
+
+func TestReactivateAccount(t *testing.T) {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	tests := []struct {
+		name                 string
+		email                string
+		deletedFor           time.Duration
+		emailTakenMeanwhile  bool
+		expectedRequestError error
+		expectedError        error
+	}{
+		{
+			name:  "ReactivateAccount_WithinGracePeriod",
+			email: "gone@test.com",
+		},
+		{
+			name:                 "ReactivateAccount_GracePeriodOver",
+			email:                "gone@test.com",
+			deletedFor:           31 * 24 * time.Hour,
+			expectedRequestError: ErrNotRestorable,
+		},
+		{
+			name:                 "ReactivateAccount_UnknownEmail",
+			email:                "someone@test.com",
+			expectedRequestError: ErrNotRestorable,
+		},
+		{
+			name:                "ReactivateAccount_EmailTakenMeanwhile",
+			email:               "gone@test.com",
+			emailTakenMeanwhile: true,
+			expectedError:       ErrDuplicateEmail,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			log.SetOutput(&buf)
+			defer showLogsIfFail(t, tc.name, buf)
+
+			repo := NewMockRepo()
+			repo.Users[1] = User{ID: 1, Email: "gone@test.com", AccountStatus: AccountActive}
+			auditRepo := audit.NewMockRepo()
+			origin := audit.Origin{IPAddress: "203.0.113.9"}
+
+			if err := MarkUserDeleted(repo, auditRepo, 1, origin); err != nil {
+				t.Fatalf("MarkUserDeleted failed: %v", err)
+			}
+			deleted := repo.Users[1]
+			deletedAt := deleted.DeletedAt.Add(-tc.deletedFor)
+			deleted.DeletedAt = &deletedAt
+			repo.Users[1] = deleted
+			if tc.emailTakenMeanwhile {
+				repo.Users[2] = User{ID: 2, Email: "gone@test.com", AccountStatus: AccountActive}
+			}
+
+			_, reactivationJWT, err := RequestReactivation(repo, tc.email)
+			if !errors.Is(err, tc.expectedRequestError) {
+				t.Fatalf("expected request error %v, got %v", tc.expectedRequestError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			restored, err := ReactivateAccount(repo, auditRepo, reactivationJWT, origin)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				if repo.Users[1].AccountStatus != AccountDeleted {
+					t.Errorf("account restored despite error")
+				}
+				return
+			}
+
+			if restored.AccountStatus != AccountActive || restored.Email != "gone@test.com" {
+				t.Errorf("unexpected restored account %+v", restored)
+			}
+			if _, err := ReactivateAccount(repo, auditRepo, reactivationJWT, origin); !errors.Is(err, ErrNotRestorable) {
+				t.Errorf("expected reused link to fail, got %v", err)
+			}
+		})
+	}
+}